@@ -0,0 +1,1104 @@
+// The imagefinder gRPC service, served by `imagefinder serve`. Regenerate the
+// Go code with `make proto` after changing this file.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: imagefinder.proto
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ProgressEvent_State int32
+
+const (
+	ProgressEvent_STATE_UNSPECIFIED ProgressEvent_State = 0
+	ProgressEvent_STATE_RUNNING     ProgressEvent_State = 1
+	ProgressEvent_STATE_COMPLETED   ProgressEvent_State = 2
+	ProgressEvent_STATE_FAILED      ProgressEvent_State = 3
+	ProgressEvent_STATE_INTERRUPTED ProgressEvent_State = 4
+)
+
+// Enum value maps for ProgressEvent_State.
+var (
+	ProgressEvent_State_name = map[int32]string{
+		0: "STATE_UNSPECIFIED",
+		1: "STATE_RUNNING",
+		2: "STATE_COMPLETED",
+		3: "STATE_FAILED",
+		4: "STATE_INTERRUPTED",
+	}
+	ProgressEvent_State_value = map[string]int32{
+		"STATE_UNSPECIFIED": 0,
+		"STATE_RUNNING":     1,
+		"STATE_COMPLETED":   2,
+		"STATE_FAILED":      3,
+		"STATE_INTERRUPTED": 4,
+	}
+)
+
+func (x ProgressEvent_State) Enum() *ProgressEvent_State {
+	p := new(ProgressEvent_State)
+	*p = x
+	return p
+}
+
+func (x ProgressEvent_State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ProgressEvent_State) Descriptor() protoreflect.EnumDescriptor {
+	return file_imagefinder_proto_enumTypes[0].Descriptor()
+}
+
+func (ProgressEvent_State) Type() protoreflect.EnumType {
+	return &file_imagefinder_proto_enumTypes[0]
+}
+
+func (x ProgressEvent_State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ProgressEvent_State.Descriptor instead.
+func (ProgressEvent_State) EnumDescriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{8, 0}
+}
+
+type SearchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Image         string                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`           // Query image path
+	Threshold     float64                `protobuf:"fixed64,2,opt,name=threshold,proto3" json:"threshold,omitempty"` // 0 uses the server's threshold
+	SourcePrefix  string                 `protobuf:"bytes,3,opt,name=source_prefix,json=sourcePrefix,proto3" json:"source_prefix,omitempty"`
+	Limit         int32                  `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`  // Most matches returned; 0 returns all after offset
+	After         string                 `protobuf:"bytes,5,opt,name=after,proto3" json:"after,omitempty"`   // YYYY-MM-DD or RFC3339
+	Before        string                 `protobuf:"bytes,6,opt,name=before,proto3" json:"before,omitempty"` // YYYY-MM-DD or RFC3339
+	Camera        string                 `protobuf:"bytes,7,opt,name=camera,proto3" json:"camera,omitempty"`
+	MinRating     int32                  `protobuf:"varint,8,opt,name=min_rating,json=minRating,proto3" json:"min_rating,omitempty"`
+	Label         string                 `protobuf:"bytes,9,opt,name=label,proto3" json:"label,omitempty"`
+	Keyword       string                 `protobuf:"bytes,10,opt,name=keyword,proto3" json:"keyword,omitempty"`
+	Tag           string                 `protobuf:"bytes,11,opt,name=tag,proto3" json:"tag,omitempty"`
+	Collection    string                 `protobuf:"bytes,12,opt,name=collection,proto3" json:"collection,omitempty"`
+	Strategy      string                 `protobuf:"bytes,13,opt,name=strategy,proto3" json:"strategy,omitempty"` // ahash, phash, both or any; empty weighs both
+	Metric        string                 `protobuf:"bytes,14,opt,name=metric,proto3" json:"metric,omitempty"`     // ssim, ms-ssim or absdiff; empty uses ssim
+	Mirror        bool                   `protobuf:"varint,15,opt,name=mirror,proto3" json:"mirror,omitempty"`
+	Prefilter     bool                   `protobuf:"varint,16,opt,name=prefilter,proto3" json:"prefilter,omitempty"`
+	Offset        int32                  `protobuf:"varint,17,opt,name=offset,proto3" json:"offset,omitempty"` // Best matches skipped, to page through the results
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_imagefinder_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SearchRequest) GetImage() string {
+	if x != nil {
+		return x.Image
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetThreshold() float64 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetSourcePrefix() string {
+	if x != nil {
+		return x.SourcePrefix
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetAfter() string {
+	if x != nil {
+		return x.After
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetBefore() string {
+	if x != nil {
+		return x.Before
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetCamera() string {
+	if x != nil {
+		return x.Camera
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetMinRating() int32 {
+	if x != nil {
+		return x.MinRating
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetKeyword() string {
+	if x != nil {
+		return x.Keyword
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetCollection() string {
+	if x != nil {
+		return x.Collection
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetStrategy() string {
+	if x != nil {
+		return x.Strategy
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetMetric() string {
+	if x != nil {
+		return x.Metric
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetMirror() bool {
+	if x != nil {
+		return x.Mirror
+	}
+	return false
+}
+
+func (x *SearchRequest) GetPrefilter() bool {
+	if x != nil {
+		return x.Prefilter
+	}
+	return false
+}
+
+func (x *SearchRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type Match struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	SourcePrefix  string                 `protobuf:"bytes,2,opt,name=source_prefix,json=sourcePrefix,proto3" json:"source_prefix,omitempty"`
+	Score         float64                `protobuf:"fixed64,3,opt,name=score,proto3" json:"score,omitempty"` // Verification score, or the hash score when unverified
+	HashScore     float64                `protobuf:"fixed64,4,opt,name=hash_score,json=hashScore,proto3" json:"hash_score,omitempty"`
+	Verified      bool                   `protobuf:"varint,5,opt,name=verified,proto3" json:"verified,omitempty"`
+	Mirrored      bool                   `protobuf:"varint,6,opt,name=mirrored,proto3" json:"mirrored,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Match) Reset() {
+	*x = Match{}
+	mi := &file_imagefinder_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Match) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Match) ProtoMessage() {}
+
+func (x *Match) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Match.ProtoReflect.Descriptor instead.
+func (*Match) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Match) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Match) GetSourcePrefix() string {
+	if x != nil {
+		return x.SourcePrefix
+	}
+	return ""
+}
+
+func (x *Match) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Match) GetHashScore() float64 {
+	if x != nil {
+		return x.HashScore
+	}
+	return 0
+}
+
+func (x *Match) GetVerified() bool {
+	if x != nil {
+		return x.Verified
+	}
+	return false
+}
+
+func (x *Match) GetMirrored() bool {
+	if x != nil {
+		return x.Mirrored
+	}
+	return false
+}
+
+type SearchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Matches       []*Match               `protobuf:"bytes,1,rep,name=matches,proto3" json:"matches,omitempty"`
+	Total         int32                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"` // Matches before offset and limit
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	mi := &file_imagefinder_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SearchResponse) GetMatches() []*Match {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+func (x *SearchResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type ScanFolderRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Folder         string                 `protobuf:"bytes,1,opt,name=folder,proto3" json:"folder,omitempty"` // Local path, s3://bucket/prefix or webdav(s)://host/path
+	SourcePrefix   string                 `protobuf:"bytes,2,opt,name=source_prefix,json=sourcePrefix,proto3" json:"source_prefix,omitempty"`
+	Force          bool                   `protobuf:"varint,3,opt,name=force,proto3" json:"force,omitempty"`
+	Archives       bool                   `protobuf:"varint,4,opt,name=archives,proto3" json:"archives,omitempty"`
+	Thumbnails     bool                   `protobuf:"varint,5,opt,name=thumbnails,proto3" json:"thumbnails,omitempty"`
+	ThumbnailSize  int32                  `protobuf:"varint,6,opt,name=thumbnail_size,json=thumbnailSize,proto3" json:"thumbnail_size,omitempty"` // 0 uses the default
+	MaxDepth       int32                  `protobuf:"varint,7,opt,name=max_depth,json=maxDepth,proto3" json:"max_depth,omitempty"`                // 0 scans every level
+	FollowSymlinks bool                   `protobuf:"varint,8,opt,name=follow_symlinks,json=followSymlinks,proto3" json:"follow_symlinks,omitempty"`
+	Include        []string               `protobuf:"bytes,9,rep,name=include,proto3" json:"include,omitempty"`
+	Exclude        []string               `protobuf:"bytes,10,rep,name=exclude,proto3" json:"exclude,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ScanFolderRequest) Reset() {
+	*x = ScanFolderRequest{}
+	mi := &file_imagefinder_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanFolderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanFolderRequest) ProtoMessage() {}
+
+func (x *ScanFolderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanFolderRequest.ProtoReflect.Descriptor instead.
+func (*ScanFolderRequest) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ScanFolderRequest) GetFolder() string {
+	if x != nil {
+		return x.Folder
+	}
+	return ""
+}
+
+func (x *ScanFolderRequest) GetSourcePrefix() string {
+	if x != nil {
+		return x.SourcePrefix
+	}
+	return ""
+}
+
+func (x *ScanFolderRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *ScanFolderRequest) GetArchives() bool {
+	if x != nil {
+		return x.Archives
+	}
+	return false
+}
+
+func (x *ScanFolderRequest) GetThumbnails() bool {
+	if x != nil {
+		return x.Thumbnails
+	}
+	return false
+}
+
+func (x *ScanFolderRequest) GetThumbnailSize() int32 {
+	if x != nil {
+		return x.ThumbnailSize
+	}
+	return 0
+}
+
+func (x *ScanFolderRequest) GetMaxDepth() int32 {
+	if x != nil {
+		return x.MaxDepth
+	}
+	return 0
+}
+
+func (x *ScanFolderRequest) GetFollowSymlinks() bool {
+	if x != nil {
+		return x.FollowSymlinks
+	}
+	return false
+}
+
+func (x *ScanFolderRequest) GetInclude() []string {
+	if x != nil {
+		return x.Include
+	}
+	return nil
+}
+
+func (x *ScanFolderRequest) GetExclude() []string {
+	if x != nil {
+		return x.Exclude
+	}
+	return nil
+}
+
+type ScanFolderResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ScanId        int64                  `protobuf:"varint,1,opt,name=scan_id,json=scanId,proto3" json:"scan_id,omitempty"` // The scan session, as resumed by `scan --resume`
+	Resumed       bool                   `protobuf:"varint,2,opt,name=resumed,proto3" json:"resumed,omitempty"`             // An interrupted scan of the folder was continued
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanFolderResponse) Reset() {
+	*x = ScanFolderResponse{}
+	mi := &file_imagefinder_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanFolderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanFolderResponse) ProtoMessage() {}
+
+func (x *ScanFolderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanFolderResponse.ProtoReflect.Descriptor instead.
+func (*ScanFolderResponse) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ScanFolderResponse) GetScanId() int64 {
+	if x != nil {
+		return x.ScanId
+	}
+	return 0
+}
+
+func (x *ScanFolderResponse) GetResumed() bool {
+	if x != nil {
+		return x.Resumed
+	}
+	return false
+}
+
+type GetStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SourcePrefix  string                 `protobuf:"bytes,1,opt,name=source_prefix,json=sourcePrefix,proto3" json:"source_prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_imagefinder_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetStatsRequest) GetSourcePrefix() string {
+	if x != nil {
+		return x.SourcePrefix
+	}
+	return ""
+}
+
+type GetStatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TotalImages   int64                  `protobuf:"varint,1,opt,name=total_images,json=totalImages,proto3" json:"total_images,omitempty"`
+	UniqueHashes  int64                  `protobuf:"varint,2,opt,name=unique_hashes,json=uniqueHashes,proto3" json:"unique_hashes,omitempty"`
+	Scans         []*ProgressEvent       `protobuf:"bytes,3,rep,name=scans,proto3" json:"scans,omitempty"` // Latest state of each scan started since the server started
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsResponse) Reset() {
+	*x = GetStatsResponse{}
+	mi := &file_imagefinder_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsResponse) ProtoMessage() {}
+
+func (x *GetStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsResponse.ProtoReflect.Descriptor instead.
+func (*GetStatsResponse) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetStatsResponse) GetTotalImages() int64 {
+	if x != nil {
+		return x.TotalImages
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetUniqueHashes() int64 {
+	if x != nil {
+		return x.UniqueHashes
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetScans() []*ProgressEvent {
+	if x != nil {
+		return x.Scans
+	}
+	return nil
+}
+
+type StreamProgressRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ScanId        int64                  `protobuf:"varint,1,opt,name=scan_id,json=scanId,proto3" json:"scan_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamProgressRequest) Reset() {
+	*x = StreamProgressRequest{}
+	mi := &file_imagefinder_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamProgressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamProgressRequest) ProtoMessage() {}
+
+func (x *StreamProgressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamProgressRequest.ProtoReflect.Descriptor instead.
+func (*StreamProgressRequest) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StreamProgressRequest) GetScanId() int64 {
+	if x != nil {
+		return x.ScanId
+	}
+	return 0
+}
+
+type ProgressEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ScanId        int64                  `protobuf:"varint,1,opt,name=scan_id,json=scanId,proto3" json:"scan_id,omitempty"`
+	State         ProgressEvent_State    `protobuf:"varint,2,opt,name=state,proto3,enum=imagefinder.v1.ProgressEvent_State" json:"state,omitempty"`
+	Folder        string                 `protobuf:"bytes,3,opt,name=folder,proto3" json:"folder,omitempty"`
+	Path          string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`   // File just processed, empty in state events
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"` // Why path couldn't be indexed, or why the scan failed
+	Processed     int64                  `protobuf:"varint,6,opt,name=processed,proto3" json:"processed,omitempty"`
+	Errors        int64                  `protobuf:"varint,7,opt,name=errors,proto3" json:"errors,omitempty"`
+	Total         int64                  `protobuf:"varint,8,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProgressEvent) Reset() {
+	*x = ProgressEvent{}
+	mi := &file_imagefinder_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProgressEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProgressEvent) ProtoMessage() {}
+
+func (x *ProgressEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProgressEvent.ProtoReflect.Descriptor instead.
+func (*ProgressEvent) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ProgressEvent) GetScanId() int64 {
+	if x != nil {
+		return x.ScanId
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetState() ProgressEvent_State {
+	if x != nil {
+		return x.State
+	}
+	return ProgressEvent_STATE_UNSPECIFIED
+}
+
+func (x *ProgressEvent) GetFolder() string {
+	if x != nil {
+		return x.Folder
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetProcessed() int64 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetErrors() int64 {
+	if x != nil {
+		return x.Errors
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type GetHealthRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetHealthRequest) Reset() {
+	*x = GetHealthRequest{}
+	mi := &file_imagefinder_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetHealthRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetHealthRequest) ProtoMessage() {}
+
+func (x *GetHealthRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetHealthRequest.ProtoReflect.Descriptor instead.
+func (*GetHealthRequest) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{9}
+}
+
+type GetHealthResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Goroutines     int32                  `protobuf:"varint,1,opt,name=goroutines,proto3" json:"goroutines,omitempty"`
+	HeapInUse      uint64                 `protobuf:"varint,2,opt,name=heap_in_use,json=heapInUse,proto3" json:"heap_in_use,omitempty"` // Bytes of heap in use
+	GcCycles       uint32                 `protobuf:"varint,3,opt,name=gc_cycles,json=gcCycles,proto3" json:"gc_cycles,omitempty"`      // Garbage collections completed
+	LastGcPauseNs  int64                  `protobuf:"varint,4,opt,name=last_gc_pause_ns,json=lastGcPauseNs,proto3" json:"last_gc_pause_ns,omitempty"`
+	GcPauseTotalNs int64                  `protobuf:"varint,5,opt,name=gc_pause_total_ns,json=gcPauseTotalNs,proto3" json:"gc_pause_total_ns,omitempty"`
+	TempFiles      int32                  `protobuf:"varint,6,opt,name=temp_files,json=tempFiles,proto3" json:"temp_files,omitempty"` // Files in the temp workspace of the server
+	RunningScans   int32                  `protobuf:"varint,7,opt,name=running_scans,json=runningScans,proto3" json:"running_scans,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetHealthResponse) Reset() {
+	*x = GetHealthResponse{}
+	mi := &file_imagefinder_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetHealthResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetHealthResponse) ProtoMessage() {}
+
+func (x *GetHealthResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_imagefinder_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetHealthResponse.ProtoReflect.Descriptor instead.
+func (*GetHealthResponse) Descriptor() ([]byte, []int) {
+	return file_imagefinder_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetHealthResponse) GetGoroutines() int32 {
+	if x != nil {
+		return x.Goroutines
+	}
+	return 0
+}
+
+func (x *GetHealthResponse) GetHeapInUse() uint64 {
+	if x != nil {
+		return x.HeapInUse
+	}
+	return 0
+}
+
+func (x *GetHealthResponse) GetGcCycles() uint32 {
+	if x != nil {
+		return x.GcCycles
+	}
+	return 0
+}
+
+func (x *GetHealthResponse) GetLastGcPauseNs() int64 {
+	if x != nil {
+		return x.LastGcPauseNs
+	}
+	return 0
+}
+
+func (x *GetHealthResponse) GetGcPauseTotalNs() int64 {
+	if x != nil {
+		return x.GcPauseTotalNs
+	}
+	return 0
+}
+
+func (x *GetHealthResponse) GetTempFiles() int32 {
+	if x != nil {
+		return x.TempFiles
+	}
+	return 0
+}
+
+func (x *GetHealthResponse) GetRunningScans() int32 {
+	if x != nil {
+		return x.RunningScans
+	}
+	return 0
+}
+
+var File_imagefinder_proto protoreflect.FileDescriptor
+
+const file_imagefinder_proto_rawDesc = "" +
+	"\n" +
+	"\x11imagefinder.proto\x12\x0eimagefinder.v1\"\xc7\x03\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\tR\x05image\x12\x1c\n" +
+	"\tthreshold\x18\x02 \x01(\x01R\tthreshold\x12#\n" +
+	"\rsource_prefix\x18\x03 \x01(\tR\fsourcePrefix\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\x12\x14\n" +
+	"\x05after\x18\x05 \x01(\tR\x05after\x12\x16\n" +
+	"\x06before\x18\x06 \x01(\tR\x06before\x12\x16\n" +
+	"\x06camera\x18\a \x01(\tR\x06camera\x12\x1d\n" +
+	"\n" +
+	"min_rating\x18\b \x01(\x05R\tminRating\x12\x14\n" +
+	"\x05label\x18\t \x01(\tR\x05label\x12\x18\n" +
+	"\akeyword\x18\n" +
+	" \x01(\tR\akeyword\x12\x10\n" +
+	"\x03tag\x18\v \x01(\tR\x03tag\x12\x1e\n" +
+	"\n" +
+	"collection\x18\f \x01(\tR\n" +
+	"collection\x12\x1a\n" +
+	"\bstrategy\x18\r \x01(\tR\bstrategy\x12\x16\n" +
+	"\x06metric\x18\x0e \x01(\tR\x06metric\x12\x16\n" +
+	"\x06mirror\x18\x0f \x01(\bR\x06mirror\x12\x1c\n" +
+	"\tprefilter\x18\x10 \x01(\bR\tprefilter\x12\x16\n" +
+	"\x06offset\x18\x11 \x01(\x05R\x06offset\"\xad\x01\n" +
+	"\x05Match\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12#\n" +
+	"\rsource_prefix\x18\x02 \x01(\tR\fsourcePrefix\x12\x14\n" +
+	"\x05score\x18\x03 \x01(\x01R\x05score\x12\x1d\n" +
+	"\n" +
+	"hash_score\x18\x04 \x01(\x01R\thashScore\x12\x1a\n" +
+	"\bverified\x18\x05 \x01(\bR\bverified\x12\x1a\n" +
+	"\bmirrored\x18\x06 \x01(\bR\bmirrored\"W\n" +
+	"\x0eSearchResponse\x12/\n" +
+	"\amatches\x18\x01 \x03(\v2\x15.imagefinder.v1.MatchR\amatches\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x05R\x05total\"\xc3\x02\n" +
+	"\x11ScanFolderRequest\x12\x16\n" +
+	"\x06folder\x18\x01 \x01(\tR\x06folder\x12#\n" +
+	"\rsource_prefix\x18\x02 \x01(\tR\fsourcePrefix\x12\x14\n" +
+	"\x05force\x18\x03 \x01(\bR\x05force\x12\x1a\n" +
+	"\barchives\x18\x04 \x01(\bR\barchives\x12\x1e\n" +
+	"\n" +
+	"thumbnails\x18\x05 \x01(\bR\n" +
+	"thumbnails\x12%\n" +
+	"\x0ethumbnail_size\x18\x06 \x01(\x05R\rthumbnailSize\x12\x1b\n" +
+	"\tmax_depth\x18\a \x01(\x05R\bmaxDepth\x12'\n" +
+	"\x0ffollow_symlinks\x18\b \x01(\bR\x0efollowSymlinks\x12\x18\n" +
+	"\ainclude\x18\t \x03(\tR\ainclude\x12\x18\n" +
+	"\aexclude\x18\n" +
+	" \x03(\tR\aexclude\"G\n" +
+	"\x12ScanFolderResponse\x12\x17\n" +
+	"\ascan_id\x18\x01 \x01(\x03R\x06scanId\x12\x18\n" +
+	"\aresumed\x18\x02 \x01(\bR\aresumed\"6\n" +
+	"\x0fGetStatsRequest\x12#\n" +
+	"\rsource_prefix\x18\x01 \x01(\tR\fsourcePrefix\"\x8f\x01\n" +
+	"\x10GetStatsResponse\x12!\n" +
+	"\ftotal_images\x18\x01 \x01(\x03R\vtotalImages\x12#\n" +
+	"\runique_hashes\x18\x02 \x01(\x03R\funiqueHashes\x123\n" +
+	"\x05scans\x18\x03 \x03(\v2\x1d.imagefinder.v1.ProgressEventR\x05scans\"0\n" +
+	"\x15StreamProgressRequest\x12\x17\n" +
+	"\ascan_id\x18\x01 \x01(\x03R\x06scanId\"\xe2\x02\n" +
+	"\rProgressEvent\x12\x17\n" +
+	"\ascan_id\x18\x01 \x01(\x03R\x06scanId\x129\n" +
+	"\x05state\x18\x02 \x01(\x0e2#.imagefinder.v1.ProgressEvent.StateR\x05state\x12\x16\n" +
+	"\x06folder\x18\x03 \x01(\tR\x06folder\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12\x1c\n" +
+	"\tprocessed\x18\x06 \x01(\x03R\tprocessed\x12\x16\n" +
+	"\x06errors\x18\a \x01(\x03R\x06errors\x12\x14\n" +
+	"\x05total\x18\b \x01(\x03R\x05total\"o\n" +
+	"\x05State\x12\x15\n" +
+	"\x11STATE_UNSPECIFIED\x10\x00\x12\x11\n" +
+	"\rSTATE_RUNNING\x10\x01\x12\x13\n" +
+	"\x0fSTATE_COMPLETED\x10\x02\x12\x10\n" +
+	"\fSTATE_FAILED\x10\x03\x12\x15\n" +
+	"\x11STATE_INTERRUPTED\x10\x04\"\x12\n" +
+	"\x10GetHealthRequest\"\x88\x02\n" +
+	"\x11GetHealthResponse\x12\x1e\n" +
+	"\n" +
+	"goroutines\x18\x01 \x01(\x05R\n" +
+	"goroutines\x12\x1e\n" +
+	"\vheap_in_use\x18\x02 \x01(\x04R\theapInUse\x12\x1b\n" +
+	"\tgc_cycles\x18\x03 \x01(\rR\bgcCycles\x12'\n" +
+	"\x10last_gc_pause_ns\x18\x04 \x01(\x03R\rlastGcPauseNs\x12)\n" +
+	"\x11gc_pause_total_ns\x18\x05 \x01(\x03R\x0egcPauseTotalNs\x12\x1d\n" +
+	"\n" +
+	"temp_files\x18\x06 \x01(\x05R\ttempFiles\x12#\n" +
+	"\rrunning_scans\x18\a \x01(\x05R\frunningScans2\xa6\x03\n" +
+	"\vImageFinder\x12G\n" +
+	"\x06Search\x12\x1d.imagefinder.v1.SearchRequest\x1a\x1e.imagefinder.v1.SearchResponse\x12S\n" +
+	"\n" +
+	"ScanFolder\x12!.imagefinder.v1.ScanFolderRequest\x1a\".imagefinder.v1.ScanFolderResponse\x12M\n" +
+	"\bGetStats\x12\x1f.imagefinder.v1.GetStatsRequest\x1a .imagefinder.v1.GetStatsResponse\x12X\n" +
+	"\x0eStreamProgress\x12%.imagefinder.v1.StreamProgressRequest\x1a\x1d.imagefinder.v1.ProgressEvent0\x01\x12P\n" +
+	"\tGetHealth\x12 .imagefinder.v1.GetHealthRequest\x1a!.imagefinder.v1.GetHealthResponseB\x11Z\x0fimagefinder/apib\x06proto3"
+
+var (
+	file_imagefinder_proto_rawDescOnce sync.Once
+	file_imagefinder_proto_rawDescData []byte
+)
+
+func file_imagefinder_proto_rawDescGZIP() []byte {
+	file_imagefinder_proto_rawDescOnce.Do(func() {
+		file_imagefinder_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_imagefinder_proto_rawDesc), len(file_imagefinder_proto_rawDesc)))
+	})
+	return file_imagefinder_proto_rawDescData
+}
+
+var file_imagefinder_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_imagefinder_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_imagefinder_proto_goTypes = []any{
+	(ProgressEvent_State)(0),      // 0: imagefinder.v1.ProgressEvent.State
+	(*SearchRequest)(nil),         // 1: imagefinder.v1.SearchRequest
+	(*Match)(nil),                 // 2: imagefinder.v1.Match
+	(*SearchResponse)(nil),        // 3: imagefinder.v1.SearchResponse
+	(*ScanFolderRequest)(nil),     // 4: imagefinder.v1.ScanFolderRequest
+	(*ScanFolderResponse)(nil),    // 5: imagefinder.v1.ScanFolderResponse
+	(*GetStatsRequest)(nil),       // 6: imagefinder.v1.GetStatsRequest
+	(*GetStatsResponse)(nil),      // 7: imagefinder.v1.GetStatsResponse
+	(*StreamProgressRequest)(nil), // 8: imagefinder.v1.StreamProgressRequest
+	(*ProgressEvent)(nil),         // 9: imagefinder.v1.ProgressEvent
+	(*GetHealthRequest)(nil),      // 10: imagefinder.v1.GetHealthRequest
+	(*GetHealthResponse)(nil),     // 11: imagefinder.v1.GetHealthResponse
+}
+var file_imagefinder_proto_depIdxs = []int32{
+	2,  // 0: imagefinder.v1.SearchResponse.matches:type_name -> imagefinder.v1.Match
+	9,  // 1: imagefinder.v1.GetStatsResponse.scans:type_name -> imagefinder.v1.ProgressEvent
+	0,  // 2: imagefinder.v1.ProgressEvent.state:type_name -> imagefinder.v1.ProgressEvent.State
+	1,  // 3: imagefinder.v1.ImageFinder.Search:input_type -> imagefinder.v1.SearchRequest
+	4,  // 4: imagefinder.v1.ImageFinder.ScanFolder:input_type -> imagefinder.v1.ScanFolderRequest
+	6,  // 5: imagefinder.v1.ImageFinder.GetStats:input_type -> imagefinder.v1.GetStatsRequest
+	8,  // 6: imagefinder.v1.ImageFinder.StreamProgress:input_type -> imagefinder.v1.StreamProgressRequest
+	10, // 7: imagefinder.v1.ImageFinder.GetHealth:input_type -> imagefinder.v1.GetHealthRequest
+	3,  // 8: imagefinder.v1.ImageFinder.Search:output_type -> imagefinder.v1.SearchResponse
+	5,  // 9: imagefinder.v1.ImageFinder.ScanFolder:output_type -> imagefinder.v1.ScanFolderResponse
+	7,  // 10: imagefinder.v1.ImageFinder.GetStats:output_type -> imagefinder.v1.GetStatsResponse
+	9,  // 11: imagefinder.v1.ImageFinder.StreamProgress:output_type -> imagefinder.v1.ProgressEvent
+	11, // 12: imagefinder.v1.ImageFinder.GetHealth:output_type -> imagefinder.v1.GetHealthResponse
+	8,  // [8:13] is the sub-list for method output_type
+	3,  // [3:8] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_imagefinder_proto_init() }
+func file_imagefinder_proto_init() {
+	if File_imagefinder_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_imagefinder_proto_rawDesc), len(file_imagefinder_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_imagefinder_proto_goTypes,
+		DependencyIndexes: file_imagefinder_proto_depIdxs,
+		EnumInfos:         file_imagefinder_proto_enumTypes,
+		MessageInfos:      file_imagefinder_proto_msgTypes,
+	}.Build()
+	File_imagefinder_proto = out.File
+	file_imagefinder_proto_goTypes = nil
+	file_imagefinder_proto_depIdxs = nil
+}
@@ -0,0 +1,302 @@
+// The imagefinder gRPC service, served by `imagefinder serve`. Regenerate the
+// Go code with `make proto` after changing this file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.0
+// - protoc             v5.29.3
+// source: imagefinder.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ImageFinder_Search_FullMethodName         = "/imagefinder.v1.ImageFinder/Search"
+	ImageFinder_ScanFolder_FullMethodName     = "/imagefinder.v1.ImageFinder/ScanFolder"
+	ImageFinder_GetStats_FullMethodName       = "/imagefinder.v1.ImageFinder/GetStats"
+	ImageFinder_StreamProgress_FullMethodName = "/imagefinder.v1.ImageFinder/StreamProgress"
+	ImageFinder_GetHealth_FullMethodName      = "/imagefinder.v1.ImageFinder/GetHealth"
+)
+
+// ImageFinderClient is the client API for ImageFinder service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ImageFinder searches the index and runs scans on the machine serving it.
+// Paths are paths on that machine.
+type ImageFinderClient interface {
+	// Search finds indexed images similar to a query image
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// ScanFolder starts indexing a folder in the background and returns the id
+	// to follow it with StreamProgress
+	ScanFolder(ctx context.Context, in *ScanFolderRequest, opts ...grpc.CallOption) (*ScanFolderResponse, error)
+	// GetStats returns the size of the index and the scans in progress
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error)
+	// StreamProgress sends the current state of a scan, then an event for each
+	// file processed, and ends after the event with the final state
+	StreamProgress(ctx context.Context, in *StreamProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressEvent], error)
+	// GetHealth returns a snapshot of the server process, for telling a
+	// stalled scan from a slow one
+	GetHealth(ctx context.Context, in *GetHealthRequest, opts ...grpc.CallOption) (*GetHealthResponse, error)
+}
+
+type imageFinderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewImageFinderClient(cc grpc.ClientConnInterface) ImageFinderClient {
+	return &imageFinderClient{cc}
+}
+
+func (c *imageFinderClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, ImageFinder_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageFinderClient) ScanFolder(ctx context.Context, in *ScanFolderRequest, opts ...grpc.CallOption) (*ScanFolderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScanFolderResponse)
+	err := c.cc.Invoke(ctx, ImageFinder_ScanFolder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageFinderClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatsResponse)
+	err := c.cc.Invoke(ctx, ImageFinder_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageFinderClient) StreamProgress(ctx context.Context, in *StreamProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ImageFinder_ServiceDesc.Streams[0], ImageFinder_StreamProgress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamProgressRequest, ProgressEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ImageFinder_StreamProgressClient = grpc.ServerStreamingClient[ProgressEvent]
+
+func (c *imageFinderClient) GetHealth(ctx context.Context, in *GetHealthRequest, opts ...grpc.CallOption) (*GetHealthResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetHealthResponse)
+	err := c.cc.Invoke(ctx, ImageFinder_GetHealth_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ImageFinderServer is the server API for ImageFinder service.
+// All implementations must embed UnimplementedImageFinderServer
+// for forward compatibility.
+//
+// ImageFinder searches the index and runs scans on the machine serving it.
+// Paths are paths on that machine.
+type ImageFinderServer interface {
+	// Search finds indexed images similar to a query image
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// ScanFolder starts indexing a folder in the background and returns the id
+	// to follow it with StreamProgress
+	ScanFolder(context.Context, *ScanFolderRequest) (*ScanFolderResponse, error)
+	// GetStats returns the size of the index and the scans in progress
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error)
+	// StreamProgress sends the current state of a scan, then an event for each
+	// file processed, and ends after the event with the final state
+	StreamProgress(*StreamProgressRequest, grpc.ServerStreamingServer[ProgressEvent]) error
+	// GetHealth returns a snapshot of the server process, for telling a
+	// stalled scan from a slow one
+	GetHealth(context.Context, *GetHealthRequest) (*GetHealthResponse, error)
+	mustEmbedUnimplementedImageFinderServer()
+}
+
+// UnimplementedImageFinderServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedImageFinderServer struct{}
+
+func (UnimplementedImageFinderServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedImageFinderServer) ScanFolder(context.Context, *ScanFolderRequest) (*ScanFolderResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ScanFolder not implemented")
+}
+func (UnimplementedImageFinderServer) GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedImageFinderServer) StreamProgress(*StreamProgressRequest, grpc.ServerStreamingServer[ProgressEvent]) error {
+	return status.Error(codes.Unimplemented, "method StreamProgress not implemented")
+}
+func (UnimplementedImageFinderServer) GetHealth(context.Context, *GetHealthRequest) (*GetHealthResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetHealth not implemented")
+}
+func (UnimplementedImageFinderServer) mustEmbedUnimplementedImageFinderServer() {}
+func (UnimplementedImageFinderServer) testEmbeddedByValue()                     {}
+
+// UnsafeImageFinderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ImageFinderServer will
+// result in compilation errors.
+type UnsafeImageFinderServer interface {
+	mustEmbedUnimplementedImageFinderServer()
+}
+
+func RegisterImageFinderServer(s grpc.ServiceRegistrar, srv ImageFinderServer) {
+	// If the following call panics, it indicates UnimplementedImageFinderServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ImageFinder_ServiceDesc, srv)
+}
+
+func _ImageFinder_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageFinderServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageFinder_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageFinderServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageFinder_ScanFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageFinderServer).ScanFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageFinder_ScanFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageFinderServer).ScanFolder(ctx, req.(*ScanFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageFinder_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageFinderServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageFinder_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageFinderServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageFinder_StreamProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamProgressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ImageFinderServer).StreamProgress(m, &grpc.GenericServerStream[StreamProgressRequest, ProgressEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ImageFinder_StreamProgressServer = grpc.ServerStreamingServer[ProgressEvent]
+
+func _ImageFinder_GetHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageFinderServer).GetHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageFinder_GetHealth_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageFinderServer).GetHealth(ctx, req.(*GetHealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ImageFinder_ServiceDesc is the grpc.ServiceDesc for ImageFinder service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ImageFinder_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "imagefinder.v1.ImageFinder",
+	HandlerType: (*ImageFinderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _ImageFinder_Search_Handler,
+		},
+		{
+			MethodName: "ScanFolder",
+			Handler:    _ImageFinder_ScanFolder_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _ImageFinder_GetStats_Handler,
+		},
+		{
+			MethodName: "GetHealth",
+			Handler:    _ImageFinder_GetHealth_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamProgress",
+			Handler:       _ImageFinder_StreamProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "imagefinder.proto",
+}
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/logging"
+	"imagefinder/scanner"
+	"imagefinder/source"
+	"imagefinder/types"
+	"imagefinder/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options are the settings the server applies to every request
+type Options struct {
+	DbPath       string
+	Threshold    float64 // Used by searches that don't give one
+	Workers      int     // Images processed or compared in parallel (0 uses the defaults)
+	MaxMemory    int64   // Limit on the estimated memory of images decoded at once by a scan
+	RawWorkers   int     // RAW files converted at once by a scan (0 for no separate limit)
+	IORetries    int     // Reads again of a file failing with an I/O error during a scan
+	IOSkipAfter  int     // Files in a row failing with I/O errors before a scan skips the rest (0 never skips)
+	PreviewCache *imageprocessor.PreviewCache
+	RawMode      types.RawMode // Which image of RAW files scans hash and searches decode
+}
+
+// Server implements the ImageFinder service on an open index
+type Server struct {
+	UnimplementedImageFinderServer
+
+	ctx     context.Context // Cancelled when the server shuts down, interrupting scans
+	db      *sql.DB
+	options Options
+
+	mu    sync.Mutex
+	scans map[int64]*scanJob
+	wg    sync.WaitGroup // Running scans
+}
+
+// NewServer returns a server on db. Scans run until they finish or ctx is
+// cancelled; interrupted scans can be resumed with `scan --resume`.
+func NewServer(ctx context.Context, db *sql.DB, options Options) *Server {
+	return &Server{
+		ctx:     ctx,
+		db:      db,
+		options: options,
+		scans:   make(map[int64]*scanJob),
+	}
+}
+
+// Wait blocks until every scan has finished or stopped
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Search finds indexed images similar to a query image
+func (s *Server) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	if req.GetImage() == "" {
+		return nil, status.Error(codes.InvalidArgument, "image is required")
+	}
+	if _, err := os.Stat(imageprocessor.SourceFile(req.GetImage())); err != nil {
+		return nil, status.Errorf(codes.NotFound, "query image %s: %v", req.GetImage(), err)
+	}
+
+	threshold := req.GetThreshold()
+	if threshold == 0 {
+		threshold = s.options.Threshold
+	}
+	if threshold < 0 || threshold > 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid threshold %v, expected a value from 0.0 to 1.0", threshold)
+	}
+
+	options := imageprocessor.SearchOptions{
+		QueryPath:      req.GetImage(),
+		Threshold:      threshold,
+		SourcePrefixes: sourcePrefixes(req.GetSourcePrefix()),
+		Camera:         strings.TrimSpace(req.GetCamera()),
+		MinRating:      int(req.GetMinRating()),
+		Label:          strings.TrimSpace(req.GetLabel()),
+		Keyword:        strings.TrimSpace(req.GetKeyword()),
+		Tag:            strings.TrimSpace(req.GetTag()),
+		Collection:     strings.TrimSpace(req.GetCollection()),
+		PreviewCache:   s.options.PreviewCache,
+		RawMode:        s.options.RawMode,
+		Workers:        s.options.Workers,
+		Prefilter:      req.GetPrefilter(),
+		Mirror:         req.GetMirror(),
+	}
+
+	var err error
+	if req.GetAfter() != "" {
+		if options.After, err = utils.ParseDateBound(req.GetAfter(), false); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if req.GetBefore() != "" {
+		if options.Before, err = utils.ParseDateBound(req.GetBefore(), true); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if options.Strategy, err = imageprocessor.ParseStrategy(req.GetStrategy()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if options.Metric, err = imageprocessor.ParseMetric(req.GetMetric()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.GetOffset() < 0 || req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset and limit must not be negative")
+	}
+
+	if options.Collection != "" {
+		exists, err := database.CollectionExists(ctx, s.db, options.Collection)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !exists {
+			return nil, status.Errorf(codes.NotFound, "there is no collection named '%s'", options.Collection)
+		}
+	}
+
+	matches, err := imageprocessor.FindSimilarImages(ctx, s.db, options)
+	if err != nil {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return nil, status.FromContextError(err).Err()
+		}
+		return nil, status.Errorf(codes.Internal, "error finding similar images: %v", err)
+	}
+
+	response := &SearchResponse{Total: int32(len(matches))}
+	for _, match := range utils.Page(matches, int(req.GetOffset()), int(req.GetLimit())) {
+		response.Matches = append(response.Matches, &Match{
+			Path:         match.Path,
+			SourcePrefix: match.SourcePrefix,
+			Score:        match.SSIMScore,
+			HashScore:    match.HashScore,
+			Verified:     match.Verified,
+			Mirrored:     match.Mirrored,
+		})
+	}
+	return response, nil
+}
+
+// ScanFolder starts indexing a folder in the background. Like the scan
+// command, it continues an interrupted scan of the same folder.
+func (s *Server) ScanFolder(ctx context.Context, req *ScanFolderRequest) (*ScanFolderResponse, error) {
+	if req.GetFolder() == "" {
+		return nil, status.Error(codes.InvalidArgument, "folder is required")
+	}
+	if req.GetMaxDepth() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid max depth: %d", req.GetMaxDepth())
+	}
+	if req.GetThumbnailSize() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid thumbnail size: %d", req.GetThumbnailSize())
+	}
+
+	src, err := source.Open(req.GetFolder())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot open scan source: %v", err)
+	}
+
+	session, resumed, err := database.StartScanSession(ctx, s.db, req.GetFolder(), req.GetSourcePrefix(), req.GetForce())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error starting scan session: %v", err)
+	}
+
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return nil, status.Error(codes.Unavailable, "the server is shutting down")
+	}
+	if job, ok := s.scans[session.ID]; ok && job.running() {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.AlreadyExists, "scan %d of %s is already running", session.ID, req.GetFolder())
+	}
+	job := newScanJob(session.ID, req.GetFolder())
+	s.scans[session.ID] = job
+	s.mu.Unlock()
+
+	options := scanner.ScanOptions{
+		FolderPath:     req.GetFolder(),
+		SourcePrefix:   req.GetSourcePrefix(),
+		ForceRewrite:   session.Force,
+		DbPath:         s.options.DbPath,
+		MaxWorkers:     s.options.Workers,
+		MaxMemory:      s.options.MaxMemory,
+		RawWorkers:     s.options.RawWorkers,
+		IORetries:      s.options.IORetries,
+		IOSkipAfter:    s.options.IOSkipAfter,
+		Thumbnails:     req.GetThumbnails() || req.GetThumbnailSize() > 0,
+		ThumbnailSize:  int(req.GetThumbnailSize()),
+		PreviewCache:   s.options.PreviewCache,
+		RawMode:        s.options.RawMode,
+		Archives:       req.GetArchives(),
+		Include:        req.GetInclude(),
+		Exclude:        req.GetExclude(),
+		Source:         src,
+		MaxDepth:       int(req.GetMaxDepth()),
+		FollowSymlinks: req.GetFollowSymlinks(),
+		Quiet:          true,
+		SessionID:      session.ID,
+
+		// The server's worker count is fixed only when configured
+		AdaptiveWorkers: s.options.Workers == 0,
+	}
+
+	s.wg.Add(1)
+	go s.runScan(job, options)
+
+	return &ScanFolderResponse{ScanId: session.ID, Resumed: resumed}, nil
+}
+
+// runScan runs a scan started by ScanFolder, publishing its progress
+func (s *Server) runScan(job *scanJob, options scanner.ScanOptions) {
+	defer s.wg.Done()
+
+	options.OnProgress = func(progress scanner.Progress) {
+		event := job.event(ProgressEvent_STATE_RUNNING)
+		event.Path = progress.Path
+		if progress.Error != nil {
+			event.Error = progress.Error.Error()
+		}
+		event.Processed = int64(progress.Processed)
+		event.Errors = int64(progress.Errors)
+		event.Total = int64(progress.Total)
+		job.publish(event)
+	}
+
+	err := scanner.ScanAndStoreFolder(s.ctx, s.db, options)
+
+	var final *ProgressEvent
+	switch {
+	case err == nil:
+		final = job.event(ProgressEvent_STATE_COMPLETED)
+		if err := database.FinishScanSession(s.ctx, s.db, job.id); err != nil {
+			logging.LogWarning("%v", err)
+		}
+	case s.ctx.Err() != nil && errors.Is(err, s.ctx.Err()):
+		final = job.event(ProgressEvent_STATE_INTERRUPTED)
+	default:
+		final = job.event(ProgressEvent_STATE_FAILED)
+		final.Error = err.Error()
+		logging.LogError("Error scanning %s: %v", job.folder, err)
+	}
+	job.finish(final)
+}
+
+// GetStats returns the size of the index and the state of the scans started
+// since the server started
+func (s *Server) GetStats(ctx context.Context, req *GetStatsRequest) (*GetStatsResponse, error) {
+	stats, err := database.GetScanStats(ctx, s.db, req.GetSourcePrefix())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &GetStatsResponse{
+		TotalImages:  int64(stats.TotalImages),
+		UniqueHashes: int64(stats.UniqueHashes),
+	}
+	s.mu.Lock()
+	for _, job := range s.scans {
+		response.Scans = append(response.Scans, job.latestEvent())
+	}
+	s.mu.Unlock()
+	sort.Slice(response.Scans, func(i, j int) bool { return response.Scans[i].ScanId < response.Scans[j].ScanId })
+	return response, nil
+}
+
+// GetHealth returns a snapshot of the server process and the number of
+// scans running
+func (s *Server) GetHealth(ctx context.Context, req *GetHealthRequest) (*GetHealthResponse, error) {
+	health := utils.ReadRuntimeHealth()
+	response := &GetHealthResponse{
+		Goroutines:     int32(health.Goroutines),
+		HeapInUse:      health.HeapInUse,
+		GcCycles:       health.GCCycles,
+		LastGcPauseNs:  int64(health.LastGCPause),
+		GcPauseTotalNs: int64(health.GCPauseTotal),
+		TempFiles:      int32(health.TempFiles),
+	}
+	s.mu.Lock()
+	for _, job := range s.scans {
+		if job.running() {
+			response.RunningScans++
+		}
+	}
+	s.mu.Unlock()
+	return response, nil
+}
+
+// StreamProgress sends the state of a scan and then its events until it ends
+func (s *Server) StreamProgress(req *StreamProgressRequest, stream ImageFinder_StreamProgressServer) error {
+	s.mu.Lock()
+	job, ok := s.scans[req.GetScanId()]
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "no scan %d was started since the server started", req.GetScanId())
+	}
+
+	events, latest, stop := job.watch()
+	defer stop()
+
+	sent := latest
+	if err := stream.Send(latest); err != nil {
+		return err
+	}
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			sent = event
+		case <-job.done:
+			// Events published before the scan ended are still buffered;
+			// the final one is sent even if a slow stream missed others
+		drain:
+			for {
+				select {
+				case event := <-events:
+					if err := stream.Send(event); err != nil {
+						return err
+					}
+					sent = event
+				default:
+					break drain
+				}
+			}
+			if final := job.latestEvent(); final != sent {
+				return stream.Send(final)
+			}
+			return nil
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// scanJob is a scan started by ScanFolder and the streams watching it
+type scanJob struct {
+	id     int64
+	folder string
+
+	mu       sync.Mutex
+	latest   *ProgressEvent
+	watchers map[chan *ProgressEvent]bool
+	done     chan struct{} // Closed after the final event is published
+}
+
+// watchBuffer is the number of events a stream may fall behind before
+// events are dropped for it
+const watchBuffer = 256
+
+func newScanJob(id int64, folder string) *scanJob {
+	job := &scanJob{
+		id:       id,
+		folder:   folder,
+		watchers: make(map[chan *ProgressEvent]bool),
+		done:     make(chan struct{}),
+	}
+	job.latest = job.event(ProgressEvent_STATE_RUNNING)
+	return job
+}
+
+// event returns a new event in state carrying the totals of the latest one
+func (j *scanJob) event(state ProgressEvent_State) *ProgressEvent {
+	event := &ProgressEvent{ScanId: j.id, State: state, Folder: j.folder}
+	if latest := j.latestEvent(); latest != nil {
+		event.Processed = latest.Processed
+		event.Errors = latest.Errors
+		event.Total = latest.Total
+	}
+	return event
+}
+
+// latestEvent returns the most recent event of the scan
+func (j *scanJob) latestEvent() *ProgressEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.latest
+}
+
+// running reports whether the scan hasn't ended yet
+func (j *scanJob) running() bool {
+	select {
+	case <-j.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// publish records event and passes it to the watching streams. Events are
+// never modified once published, so they are shared by the streams.
+func (j *scanJob) publish(event *ProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.latest = event
+	for events := range j.watchers {
+		select {
+		case events <- event:
+		default: // The stream fell behind; it catches up with the next event
+		}
+	}
+}
+
+// finish publishes the final event of the scan
+func (j *scanJob) finish(event *ProgressEvent) {
+	j.publish(event)
+	close(j.done)
+}
+
+// watch returns a channel receiving the events published from now on, the
+// latest event, and a function to stop watching
+func (j *scanJob) watch() (<-chan *ProgressEvent, *ProgressEvent, func()) {
+	events := make(chan *ProgressEvent, watchBuffer)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.watchers[events] = true
+	return events, j.latest, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.watchers, events)
+	}
+}
+
+// sourcePrefixes returns the prefix filter of a request, which names at most
+// one source prefix
+func sourcePrefixes(prefix string) []string {
+	if prefix == "" {
+		return nil
+	}
+	return []string{prefix}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/pkg/search"
+)
+
+// searchQueryPaths returns the query images given with --image, where -
+// stands for the one read from stdin, followed by the images in the
+// --image-dir folder, or the image pasted from the clipboard with --clipboard
+func searchQueryPaths(flags *searchFlags) []string {
+	if flags.clipboard {
+		path, err := pasteClipboardImage()
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		statusf("Searching for the image on the clipboard\n")
+		return []string{path}
+	}
+
+	paths := append([]string(nil), flags.images...)
+	stdinPath := ""
+	for i, path := range paths {
+		if path != stdinQuery {
+			continue
+		}
+		if stdinPath != "" {
+			fatalf("Error: stdin holds one query image; give --image=- once")
+		}
+		var err error
+		stdinPath, err = readStdinImage()
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		paths[i] = stdinPath
+	}
+	if flags.imageDir == "" {
+		return paths
+	}
+
+	entries, err := os.ReadDir(flags.imageDir)
+	if err != nil {
+		fatalf("Error: cannot read query folder: %v", err)
+	}
+	found := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !imageprocessor.IsImageFile(entry.Name()) {
+			continue
+		}
+		paths = append(paths, filepath.Join(flags.imageDir, entry.Name()))
+		found++
+	}
+	if found == 0 {
+		fatalf("Error: no supported images in %s", flags.imageDir)
+	}
+	return paths
+}
+
+// searchBatch searches for each query image in turn, comparing all of them
+// with candidates read from the database once, and prints the matches of each
+// query. It returns the number of queries that couldn't be searched or, with
+// --assert-match, that have no match reaching --min-score.
+func searchBatch(ctx context.Context, db *sql.DB, searcher *search.Searcher, queryPaths []string, options search.Options, flags *searchFlags) int {
+	batch, err := searcher.NewBatch(ctx, options)
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Search interrupted")
+		fatalf("Error finding similar images: %v", err)
+	}
+	statusf("Comparing %d query images with %d indexed images\n", len(queryPaths), batch.Len())
+
+	var collected []database.CollectionImage
+	saved := make(map[database.CollectionImage]bool)
+	folders := make(map[string]bool)
+	failed, unmatched := 0, 0
+	for i, queryPath := range queryPaths {
+		if flags.tmpl == nil {
+			fmt.Printf("\nQuery %d of %d: %s\n", i+1, len(queryPaths), queryPath)
+		}
+
+		matches, err := batch.Similar(ctx, queryPath)
+		if ctx.Err() != nil {
+			exitIfInterrupted(ctx, ctx.Err(), db, "Search interrupted")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding similar images: %v\n", err)
+			failed++
+			continue
+		}
+		if flags.tmpl != nil {
+			printMatchTemplate(flags.tmpl, matches, queryPath, flags.offset, flags.limit)
+		} else {
+			printMatches(ctx, db, matches, false, flags.offset, flags.limit)
+		}
+		if flags.assert && !hasMatchReaching(matches, flags.minScore) {
+			fmt.Fprintf(os.Stderr, "Assertion failed: no match for %s with a score of at least %.2f\n", queryPath, flags.minScore)
+			unmatched++
+		}
+
+		for _, match := range matches {
+			image := database.CollectionImage{Path: match.Path, SourcePrefix: match.SourcePrefix}
+			if !saved[image] {
+				saved[image] = true
+				collected = append(collected, image)
+			}
+		}
+
+		// Each query's matches get their own folder, named after the query
+		if flags.copyTo != "" || flags.linkTo != "" {
+			folder := queryFolder(queryPath, folders)
+			if flags.copyTo != "" {
+				materializeMatches(matches, filepath.Join(flags.copyTo, folder), false)
+			}
+			if flags.linkTo != "" {
+				materializeMatches(matches, filepath.Join(flags.linkTo, folder), true)
+			}
+		}
+	}
+
+	if saveTo := strings.TrimSpace(flags.saveTo); saveTo != "" {
+		saveCollection(ctx, db, saveTo, collected)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d of %d query images couldn't be searched\n", failed, len(queryPaths))
+	}
+	if unmatched > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d of %d query images have no match with a score of at least %.2f\n", unmatched, len(queryPaths), flags.minScore)
+	}
+	return failed + unmatched
+}
+
+// queryFolder returns the folder name for the matches of a query: its file
+// name without extension, numbered when another query had the same name
+func queryFolder(queryPath string, used map[string]bool) string {
+	base := strings.TrimSuffix(filepath.Base(queryPath), filepath.Ext(queryPath))
+	name := base
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+	used[name] = true
+	return name
+}
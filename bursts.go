@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+)
+
+func handleBurstsCommand(ctx context.Context, flags *burstsFlags, settings *config.Settings) {
+	dbPath := settings.Database
+	if flags.within <= 0 {
+		fatalf("Error: invalid --within %v, expected a positive number of seconds", flags.within)
+	}
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	maxDistance := clusterDistance(settings.Threshold)
+	statusf("Finding shots taken at most %vs apart whose pHashes differ in at most %d bits...\n", flags.within, maxDistance)
+
+	bursts, err := imageprocessor.FindBursts(ctx, db, imageprocessor.BurstOptions{
+		SourcePrefix: flags.prefix,
+		MaxGap:       time.Duration(flags.within * float64(time.Second)),
+		MaxDistance:  maxDistance,
+		Sharpness:    flags.sharpness,
+		PreviewCache: openPreviewCache(flags.cacheDir),
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Burst detection interrupted")
+		fatalf("Error finding bursts: %v", err)
+	}
+
+	if flags.json {
+		// One burst per line, like dedupe --json
+		writer := bufio.NewWriter(os.Stdout)
+		encoder := json.NewEncoder(writer)
+		for _, burst := range bursts {
+			if err := encoder.Encode(burst); err != nil {
+				fatalf("Error writing bursts: %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			fatalf("Error writing bursts: %v", err)
+		}
+		return
+	}
+
+	if len(bursts) == 0 {
+		fmt.Println("No bursts found.")
+		return
+	}
+
+	shots := 0
+	for i, burst := range bursts {
+		camera := ""
+		if burst.Camera != "" {
+			camera = ", " + burst.Camera
+		}
+		fmt.Printf("%d. Burst of %d shots, %s to %s%s\n", i+1, len(burst.Others)+1, burst.Start, burst.End, camera)
+		fmt.Printf("   Keep: %s%s\n", describeDuplicate(burst.Keeper.DuplicateImage), describeSharpness(burst.Keeper))
+		for _, other := range burst.Others {
+			fmt.Printf("   Other: %s%s, pHash distance %d\n", describeDuplicate(other.DuplicateImage), describeSharpness(other), other.Distance)
+		}
+		shots += len(burst.Others) + 1
+	}
+	statusf("\n%d bursts with %d shots\n", len(bursts), shots)
+}
+
+// describeSharpness renders the measured sharpness of a shot, if any
+func describeSharpness(image imageprocessor.BurstImage) string {
+	if image.Sharpness == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", sharpness %.0f", image.Sharpness)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"imagefinder/config"
+	"imagefinder/imageprocessor"
+	"imagefinder/signalhandler"
+)
+
+// calibrationGroupings lists the ways calibrate finds the images of a picture
+var calibrationGroupings = []string{"name", "folder"}
+
+// calibrationPercentiles are the rows of the score distributions
+var calibrationPercentiles = []struct {
+	label   string
+	percent float64
+}{
+	{"minimum", 0},
+	{"5th percentile", 5},
+	{"median", 50},
+	{"95th percentile", 95},
+	{"maximum", 100},
+}
+
+func handleCalibrateCommand(ctx context.Context, flags *calibrateFlags, settings *config.Settings) {
+	strategy, err := imageprocessor.ParseStrategy(flags.strategy)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	metric, err := imageprocessor.ParseMetric(flags.metric)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	groups, err := calibrationGroups(flags.folder, flags.group == "folder")
+	if err != nil {
+		fatalf("Error: cannot read %s: %v", flags.folder, err)
+	}
+	known := 0
+	for _, group := range groups {
+		if len(group) > 1 {
+			known++
+		}
+	}
+	if known == 0 {
+		fatalf("Error: no images in %s share a %s, so there are no known matching pairs", flags.folder, flags.group)
+	}
+	statusf("Scoring the images of %d pictures found by %s in %s...\n", known, flags.group, flags.folder)
+
+	calibration, err := imageprocessor.Calibrate(ctx, groups, imageprocessor.CalibrationOptions{
+		Strategy:     strategy,
+		Metric:       metric,
+		RawMode:      settings.RawMode,
+		PreviewCache: openPreviewCache(flags.cacheDir),
+		RandomPairs:  flags.random,
+		Workers:      settings.Workers,
+	})
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Calibration interrupted")
+		signalhandler.Exit(130)
+	}
+	if err != nil {
+		fatalf("Error calibrating: %v", err)
+	}
+	if len(calibration.Failed) > 0 {
+		statusf("%d images couldn't be read and were left out\n", len(calibration.Failed))
+	}
+	if len(calibration.Matching) == 0 {
+		fatalf("Error: no matching pair has two readable images")
+	}
+	if len(calibration.Random) == 0 {
+		fatalf("Error: random pairs need images of at least two pictures")
+	}
+
+	fmt.Printf("\nScored %d matching pairs and %d random pairs\n", len(calibration.Matching), len(calibration.Random))
+	printScoreDistribution("Hash score ("+string(strategy)+")", calibration, func(pair imageprocessor.PairScore) float64 { return pair.HashScore })
+	printScoreDistribution("Verification score ("+string(metric)+")", calibration, func(pair imageprocessor.PairScore) float64 { return pair.Score })
+
+	recall := flags.recall / 100
+	normal := imageprocessor.PresetNormal.Tolerances()
+	threshold := calibration.SuggestThreshold(recall)
+	matching, random := calibration.Rates(normal, threshold)
+	fmt.Printf("\nSuggested threshold: %.2f (matches %.1f%% of matching pairs and %.1f%% of random pairs)\n",
+		threshold, matching*100, random*100)
+
+	// The strictest preset finding enough of the matching pairs
+	fmt.Printf("\n%-8s %9s %9s %9s\n", "Preset", "Threshold", "Matching", "Random")
+	suggested := ""
+	for _, name := range imageprocessor.Presets {
+		tolerances := imageprocessor.Preset(name).Tolerances()
+		matching, random := calibration.Rates(tolerances, tolerances.Threshold)
+		fmt.Printf("%-8s %9.2f %8.1f%% %8.1f%%\n", name, tolerances.Threshold, matching*100, random*100)
+		if suggested == "" && matching >= recall {
+			suggested = name
+		}
+	}
+	if suggested != "" {
+		fmt.Printf("\nSuggested preset: %s\n", suggested)
+	} else {
+		fmt.Printf("\nNo preset matches %.0f%% of the matching pairs; use --threshold=%.2f\n", flags.recall, threshold)
+	}
+}
+
+// calibrationGroups lists the images under folder by picture: those with the
+// same name in the same folder, such as a RAW file and its JPEG, or with
+// byFolder those in the same folder. Pictures with one image are kept for
+// the random pairs.
+func calibrationGroups(folder string, byFolder bool) ([][]string, error) {
+	members := make(map[string][]string)
+	err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !imageprocessor.IsImageFile(entry.Name()) {
+			return nil
+		}
+		key := filepath.Dir(path)
+		if !byFolder {
+			name := entry.Name()
+			key = filepath.Join(key, strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name))))
+		}
+		members[key] = append(members[key], path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(members))
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	groups := make([][]string, len(keys))
+	for i, key := range keys {
+		groups[i] = members[key]
+	}
+	return groups, nil
+}
+
+// printScoreDistribution prints percentiles of a score of the matching and
+// of the random pairs side by side
+func printScoreDistribution(title string, calibration imageprocessor.Calibration, score func(imageprocessor.PairScore) float64) {
+	sorted := func(pairs []imageprocessor.PairScore) []float64 {
+		scores := make([]float64, len(pairs))
+		for i, pair := range pairs {
+			scores[i] = score(pair)
+		}
+		sort.Float64s(scores)
+		return scores
+	}
+	matching, random := sorted(calibration.Matching), sorted(calibration.Random)
+
+	fmt.Printf("\n%-24s %9s %9s\n", title, "Matching", "Random")
+	for _, row := range calibrationPercentiles {
+		fmt.Printf("  %-22s %9.4f %9.4f\n", row.label, percentile(matching, row.percent), percentile(random, row.percent))
+	}
+}
+
+// percentile returns the score at the given percentile of sorted scores
+func percentile(scores []float64, percent float64) float64 {
+	index := int(percent / 100 * float64(len(scores)-1))
+	return scores[index]
+}
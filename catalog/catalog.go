@@ -0,0 +1,75 @@
+// Package catalog reads the image lists of other photo managers so their
+// libraries can be indexed without starting from scratch
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"imagefinder/types"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Catalog lists the images managed by another photo manager
+type Catalog interface {
+	// RootFolders returns the folders the catalog's images are in
+	RootFolders(ctx context.Context) ([]string, error)
+	// ForEachImage calls fn with each image's path and metadata
+	ForEachImage(ctx context.Context, fn func(types.ImageInfo) error) error
+	// Close closes the catalog
+	Close() error
+}
+
+// captureTimeLayouts are the forms catalogs store capture times in, without
+// a time zone
+var captureTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseCaptureTime converts a catalog capture time to RFC 3339, or returns
+// an empty string if it can't be read. Like EXIF dates, the time is taken to
+// be in the local time zone.
+func parseCaptureTime(value string) string {
+	for _, layout := range captureTimeLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// openReadOnly opens an SQLite file without allowing writes to it
+func openReadOnly(path string) (*sql.DB, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s: %v", path, err)
+	}
+	dsn := (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath), RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// hasTables reports whether the database has all of the given tables
+func hasTables(db *sql.DB, tables ...string) (bool, error) {
+	for _, table := range tables {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count); err != nil {
+			return false, err
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,210 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"imagefinder/imageprocessor"
+	"imagefinder/types"
+)
+
+// digiKam image states; only visible images are imported, not those in the
+// trash or removed from disk
+const digiKamVisible = 1
+
+// digiKamInternalTags is the root of the tags digiKam uses for its own
+// bookkeeping, such as color and pick labels
+const digiKamInternalTags = "_Digikam_Internal_Tags_"
+
+// digiKamColorLabel prefixes the internal tags that hold color labels
+const digiKamColorLabel = "Color Label "
+
+// DigiKam is an open digiKam database (digikam4.db)
+type DigiKam struct {
+	db *sql.DB
+}
+
+// OpenDigiKam opens a digiKam SQLite database read-only
+func OpenDigiKam(path string) (*DigiKam, error) {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := hasTables(db, "AlbumRoots", "Albums", "Images", "ImageInformation")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot read catalog %s: %v", path, err)
+	}
+	if !found {
+		db.Close()
+		return nil, fmt.Errorf("%s is not a digiKam database", path)
+	}
+
+	return &DigiKam{db: db}, nil
+}
+
+// Close closes the database
+func (c *DigiKam) Close() error {
+	return c.db.Close()
+}
+
+// RootFolders returns the collection folders of the database
+func (c *DigiKam) RootFolders(ctx context.Context) ([]string, error) {
+	roots, err := c.albumRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []string
+	for _, root := range roots {
+		folders = append(folders, root)
+	}
+	sort.Strings(folders)
+	return folders, nil
+}
+
+// ForEachImage calls fn with the path, capture date, rating, color label and
+// keywords of every visible image in the database. Images on collections
+// whose folder can't be worked out are skipped.
+func (c *DigiKam) ForEachImage(ctx context.Context, fn func(types.ImageInfo) error) error {
+	roots, err := c.albumRoots(ctx)
+	if err != nil {
+		return err
+	}
+	tags, err := c.tags(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT image.id, album.albumRoot, album.relativePath, image.name,
+			COALESCE(info.creationDate, ''), COALESCE(info.rating, 0)
+		FROM Images image
+		JOIN Albums album ON album.id = image.album
+		LEFT JOIN ImageInformation info ON info.imageid = image.id
+		WHERE image.status = ?
+		ORDER BY image.id`, digiKamVisible)
+	if err != nil {
+		return fmt.Errorf("failed to query catalog images: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, rootID int64
+		var album, name, creationDate string
+		var rating int
+		if err := rows.Scan(&id, &rootID, &album, &name, &creationDate, &rating); err != nil {
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		root, ok := roots[rootID]
+		if !ok {
+			continue
+		}
+
+		info := types.ImageInfo{
+			Path:       filepath.Join(root, filepath.FromSlash(path.Join(album, name))),
+			CapturedAt: parseCaptureTime(creationDate),
+			Rating:     max(rating, 0), // -1 when not rated
+		}
+		info.Format = string(imageprocessor.GetFileFormat(info.Path))
+		for _, tag := range tags[id] {
+			if label, ok := strings.CutPrefix(tag.name, digiKamColorLabel); tag.internal && ok {
+				if label != "None" {
+					info.Label = label
+				}
+			} else if !tag.internal {
+				info.Keywords = append(info.Keywords, tag.name)
+			}
+		}
+
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// albumRoots returns the folder of each collection by id. A collection is
+// stored as a volume identifier and a path on that volume; volumes given by
+// UUID are assumed to be mounted at the file system root.
+func (c *DigiKam) albumRoots(ctx context.Context) (map[int64]string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT id, COALESCE(identifier, ''), COALESCE(specificPath, '') FROM AlbumRoots")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query album roots: %v", err)
+	}
+	defer rows.Close()
+
+	roots := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var identifier, specificPath string
+		if err := rows.Scan(&id, &identifier, &specificPath); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+
+		// Network shares and plain folders name their mount point, as in
+		// volumeid:?path=/mnt/photos
+		mountPoint := ""
+		if _, query, found := strings.Cut(identifier, "?"); found {
+			if values, err := url.ParseQuery(query); err == nil {
+				mountPoint = values.Get("path")
+				if mountPoint == "" {
+					mountPoint = values.Get("mountpath")
+				}
+			}
+		}
+		if mountPoint == "" && specificPath == "" {
+			continue
+		}
+		roots[id] = filepath.Clean(filepath.FromSlash(path.Join("/", mountPoint, specificPath)))
+	}
+	return roots, rows.Err()
+}
+
+// digiKamTag is a tag attached to an image
+type digiKamTag struct {
+	name     string
+	internal bool // Below digiKam's internal tags
+}
+
+// tags returns the tags of each image by image id
+func (c *DigiKam) tags(ctx context.Context) (map[int64][]digiKamTag, error) {
+	found, err := hasTables(c.db, "Tags", "ImageTags")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	// The internal root and every tag below it are internal
+	rows, err := c.db.QueryContext(ctx, `
+		WITH RECURSIVE internal(id) AS (
+			SELECT id FROM Tags WHERE name = ?
+			UNION SELECT Tags.id FROM Tags JOIN internal ON Tags.pid = internal.id
+		)
+		SELECT link.imageid, tag.name, tag.id IN (SELECT id FROM internal)
+		FROM ImageTags link
+		JOIN Tags tag ON tag.id = link.tagid
+		WHERE tag.name IS NOT NULL
+		ORDER BY link.imageid, tag.name`, digiKamInternalTags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog tags: %v", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]digiKamTag)
+	for rows.Next() {
+		var image int64
+		var tag digiKamTag
+		if err := rows.Scan(&image, &tag.name, &tag.internal); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		tags[image] = append(tags[image], tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,141 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"imagefinder/imageprocessor"
+	"imagefinder/types"
+)
+
+// Lightroom is an open Lightroom Classic catalog (.lrcat)
+type Lightroom struct {
+	db *sql.DB
+}
+
+// OpenLightroom opens a Lightroom catalog read-only. Lightroom may keep
+// running while the catalog is read.
+func OpenLightroom(path string) (*Lightroom, error) {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := hasTables(db, "Adobe_images", "AgLibraryFile", "AgLibraryFolder", "AgLibraryRootFolder")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot read catalog %s: %v", path, err)
+	}
+	if !found {
+		db.Close()
+		return nil, fmt.Errorf("%s is not a Lightroom catalog", path)
+	}
+
+	return &Lightroom{db: db}, nil
+}
+
+// Close closes the catalog
+func (c *Lightroom) Close() error {
+	return c.db.Close()
+}
+
+// RootFolders returns the folders the catalog's images are imported from
+func (c *Lightroom) RootFolders(ctx context.Context) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT absolutePath FROM AgLibraryRootFolder ORDER BY absolutePath")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query root folders: %v", err)
+	}
+	defer rows.Close()
+
+	var folders []string
+	for rows.Next() {
+		var folder string
+		if err := rows.Scan(&folder); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		folders = append(folders, filepath.Clean(filepath.FromSlash(folder)))
+	}
+	return folders, rows.Err()
+}
+
+// ForEachImage calls fn with the path, capture date, rating, color label and
+// keywords of every image in the catalog. Virtual copies share their master's
+// file and are left out.
+func (c *Lightroom) ForEachImage(ctx context.Context, fn func(types.ImageInfo) error) error {
+	keywords, err := c.keywords(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT image.id_local, root.absolutePath, folder.pathFromRoot, file.baseName, COALESCE(file.extension, ''),
+			COALESCE(image.captureTime, ''), COALESCE(image.rating, 0), COALESCE(image.colorLabels, '')
+		FROM Adobe_images image
+		JOIN AgLibraryFile file ON file.id_local = image.rootFile
+		JOIN AgLibraryFolder folder ON folder.id_local = file.folder
+		JOIN AgLibraryRootFolder root ON root.id_local = folder.rootFolder
+		WHERE image.masterImage IS NULL
+		ORDER BY image.id_local`)
+	if err != nil {
+		return fmt.Errorf("failed to query catalog images: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var root, folder, baseName, extension, captureTime string
+		var rating float64
+		var info types.ImageInfo
+		if err := rows.Scan(&id, &root, &folder, &baseName, &extension, &captureTime, &rating, &info.Label); err != nil {
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+
+		name := baseName
+		if extension != "" {
+			name += "." + extension
+		}
+		info.Path = filepath.Clean(filepath.FromSlash(root + folder + name))
+		info.Format = string(imageprocessor.GetFileFormat(info.Path))
+		info.CapturedAt = parseCaptureTime(captureTime)
+		info.Rating = int(rating)
+		info.Keywords = keywords[id]
+
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// keywords returns the keyword names of each image by image id
+func (c *Lightroom) keywords(ctx context.Context) (map[int64][]string, error) {
+	// Catalogs without any keywords may lack the tables
+	found, err := hasTables(c.db, "AgLibraryKeyword", "AgLibraryKeywordImage")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT link.image, keyword.name
+		FROM AgLibraryKeywordImage link
+		JOIN AgLibraryKeyword keyword ON keyword.id_local = link.tag
+		WHERE keyword.name IS NOT NULL
+		ORDER BY link.image, keyword.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog keywords: %v", err)
+	}
+	defer rows.Close()
+
+	keywords := make(map[int64][]string)
+	for rows.Next() {
+		var image int64
+		var name string
+		if err := rows.Scan(&image, &name); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		keywords[image] = append(keywords[image], name)
+	}
+	return keywords, rows.Err()
+}
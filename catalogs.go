@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"imagefinder/catalog"
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/types"
+)
+
+// handleImportCatalogCommand seeds the index with the images of another photo
+// manager's catalog and, with --scan, hashes them by scanning its folders
+func handleImportCatalogCommand(ctx context.Context, flags *importCatalogFlags, open func(string) (catalog.Catalog, error), settings *config.Settings) {
+	images, err := open(flags.catalog)
+	if err != nil {
+		fatalf("Cannot open catalog: %v", err)
+	}
+	defer images.Close()
+
+	roots, err := images.RootFolders(ctx)
+	if err != nil {
+		fatalf("Error reading catalog: %v", err)
+	}
+
+	seedImages(ctx, settings.Database, flags.prefix, images.ForEachImage)
+
+	if !flags.scan {
+		statusf("Run '%s scan --folder=PATH' on the catalog folders to hash the imported images:\n", os.Args[0])
+		for _, root := range roots {
+			statusf("  %s\n", root)
+		}
+		return
+	}
+
+	for _, root := range roots {
+		if _, err := os.Stat(root); err != nil {
+			log.Printf("Skipping catalog folder %s: %v", root, err)
+			continue
+		}
+		statusf("\nScanning %s\n", root)
+		handleScanCommand(ctx, &scanFlags{
+			folder:        root,
+			prefix:        flags.prefix,
+			thumbnailSize: imageprocessor.DefaultThumbnailSize,
+		}, settings)
+	}
+}
+
+// seedImages stores the images listed by forEach as seeded rows, to be hashed
+// by the next scan of their folders
+func seedImages(ctx context.Context, dbPath string, sourcePrefix string, forEach func(context.Context, func(types.ImageInfo) error) error) {
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+
+	// As with import, an interruption stops between images
+	storeCtx := context.WithoutCancel(ctx)
+
+	seeded, failed := 0, 0
+	err = forEach(ctx, func(info types.ImageInfo) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		info.SourcePrefix = sourcePrefix
+		if err := database.SeedImage(storeCtx, db, info); err != nil {
+			log.Printf("Warning: %v", err)
+			failed++
+			return nil
+		}
+		seeded++
+		return nil
+	})
+	exitIfInterrupted(ctx, err, db, fmt.Sprintf("Import interrupted after %d images (%d skipped)", seeded, failed))
+	if err != nil {
+		fatalf("Error reading catalog: %v", err)
+	}
+
+	statusf("Imported %d images into %s (%d skipped)\n", seeded, dbPath, failed)
+}
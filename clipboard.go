@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"imagefinder/utils"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pasteClipboardImage saves the image on the system clipboard as a PNG file in
+// the run's temp workspace and returns its path. The file is named
+// clipboard.png rather than with random digits, which the filename boost of
+// searches could match against the names of indexed images.
+func pasteClipboardImage() (string, error) {
+	dir, err := utils.MkdirTemp("clipboard-*")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "clipboard.png")
+
+	switch runtime.GOOS {
+	case "darwin":
+		err = runClipboardTool(exec.Command("osascript",
+			"-e", fmt.Sprintf("set f to open for access POSIX file %q with write permission", path),
+			"-e", "try",
+			"-e", "write (the clipboard as «class PNGf») to f",
+			"-e", "on error message",
+			"-e", "close access f",
+			"-e", "error message",
+			"-e", "end try",
+			"-e", "close access f"))
+	case "windows":
+		err = runClipboardTool(exec.Command("powershell", "-NoProfile", "-STA", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; "+
+				"$image = [System.Windows.Forms.Clipboard]::GetImage(); "+
+				"if ($image -eq $null) { exit 1 }; "+
+				"$image.Save($env:IMAGEFINDER_CLIPBOARD_FILE, [System.Drawing.Imaging.ImageFormat]::Png)"),
+			"IMAGEFINDER_CLIPBOARD_FILE="+path)
+	default:
+		err = pasteX11OrWayland(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, pngSignature) {
+		return "", fmt.Errorf("the clipboard holds no image")
+	}
+	return path, nil
+}
+
+// pasteX11OrWayland writes the clipboard image with wl-paste on Wayland and
+// xclip on X11
+func pasteX11OrWayland(path string) error {
+	var cmd *exec.Cmd
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-paste"); err == nil {
+			cmd = exec.Command("wl-paste", "--no-newline", "--type", "image/png")
+		}
+	}
+	if cmd == nil {
+		if _, err := exec.LookPath("xclip"); err != nil {
+			return fmt.Errorf("reading the clipboard needs wl-paste (Wayland) or xclip (X11)")
+		}
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-target", "image/png", "-out")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = file
+	err = runClipboardTool(cmd)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runClipboardTool runs a command reading the clipboard, with extra
+// environment variables. A tool that ran and failed found no image, and what
+// it wrote to stderr tells why.
+func runClipboardTool(cmd *exec.Cmd, env ...string) error {
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		if err != nil {
+			return fmt.Errorf("cannot read the clipboard: %v", err)
+		}
+		return nil
+	}
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("the clipboard holds no image: %s", message)
+	}
+	return fmt.Errorf("the clipboard holds no image")
+}
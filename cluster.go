@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/types"
+)
+
+// clusterDistance converts a hash similarity threshold into the largest
+// number of pHash bits in which linked images may differ
+func clusterDistance(threshold float64) int {
+	// The epsilon keeps thresholds such as 0.9375 (4 bits) from rounding down
+	return int((1-threshold)*types.HashBits + 1e-9)
+}
+
+func handleClusterCommand(ctx context.Context, flags *clusterFlags, settings *config.Settings) {
+	dbPath := settings.Database
+	if flags.minSize < 1 {
+		fatalf("Error: invalid --min-size %d, expected at least 1", flags.minSize)
+	}
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	maxDistance := clusterDistance(settings.Threshold)
+	statusf("Clustering images whose pHashes differ in at most %d bits (threshold %v)...\n", maxDistance, settings.Threshold)
+
+	clusters, err := imageprocessor.FindClusters(ctx, db, imageprocessor.ClusterOptions{
+		SourcePrefix: flags.prefix,
+		MaxDistance:  maxDistance,
+		MinSize:      flags.minSize,
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Clustering interrupted")
+		fatalf("Error clustering images: %v", err)
+	}
+
+	if flags.json {
+		// One cluster per line, like dedupe --json
+		writer := bufio.NewWriter(os.Stdout)
+		encoder := json.NewEncoder(writer)
+		for _, cluster := range clusters {
+			if err := encoder.Encode(cluster); err != nil {
+				fatalf("Error writing clusters: %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			fatalf("Error writing clusters: %v", err)
+		}
+		return
+	}
+
+	if len(clusters) == 0 {
+		fmt.Println("No clusters found.")
+		return
+	}
+
+	images := 0
+	for _, cluster := range clusters {
+		fmt.Printf("Cluster %d: %d images\n", cluster.ID, len(cluster.Images))
+		for _, image := range cluster.Images {
+			description := image.Path
+			if image.SourcePrefix != "" {
+				description += " [" + image.SourcePrefix + "]"
+			}
+			fmt.Printf("   %s\n", description)
+		}
+		images += len(cluster.Images)
+	}
+	statusf("\n%d clusters with %d images\n", len(clusters), images)
+}
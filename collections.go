@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"imagefinder/database"
+	"imagefinder/signalhandler"
+)
+
+// collectionActions are the actions of the collection command
+var collectionActions = []string{"list", "show", "delete"}
+
+// handleCollectionCommand lists, shows or deletes saved collections
+func handleCollectionCommand(ctx context.Context, action string, flags *collectionFlags, dbPath string) {
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+
+	switch action {
+	case "list":
+		collections, err := database.ListCollections(ctx, db)
+		if err != nil {
+			fatalf("Error listing collections: %v", err)
+		}
+		for _, collection := range collections {
+			fmt.Printf("%s (%d images, created %s)\n", collection.Name, collection.Images, collection.CreatedAt)
+		}
+	case "show":
+		images, found, err := database.CollectionImages(ctx, db, flags.name)
+		if err != nil {
+			fatalf("Error reading collection: %v", err)
+		}
+		if !found {
+			fatalf("There is no collection named '%s'", flags.name)
+		}
+		for _, image := range images {
+			if image.SourcePrefix != "" {
+				fmt.Printf("%s (source: %s)\n", image.Path, image.SourcePrefix)
+			} else {
+				fmt.Println(image.Path)
+			}
+		}
+	case "delete":
+		deleted, err := database.DeleteCollection(ctx, db, flags.name)
+		if err != nil {
+			fatalf("Error deleting collection: %v", err)
+		}
+		if !deleted {
+			fatalf("There is no collection named '%s'", flags.name)
+		}
+		statusf("Deleted collection %s\n", flags.name)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown collection action: %s (expected list, show or delete)\n", action)
+		signalhandler.Exit(2)
+	}
+}
+
+// saveCollection adds images to the named collection and reports how many
+// were new to it
+func saveCollection(ctx context.Context, db *sql.DB, name string, images []database.CollectionImage) {
+	added, err := database.AddToCollection(ctx, db, name, images)
+	if err != nil {
+		fatalf("Error saving collection: %v", err)
+	}
+	statusf("Added %d images to collection %s\n", added, name)
+}
@@ -0,0 +1,1143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"imagefinder/catalog"
+	"imagefinder/config"
+	"imagefinder/imageprocessor"
+	"imagefinder/signalhandler"
+	"imagefinder/types"
+	"imagefinder/utils"
+)
+
+// command is a subcommand with its own flag set
+type command struct {
+	name     string
+	synopsis string // Arguments shown in usage lines
+	summary  string
+	flags    *flag.FlagSet
+
+	// required lists flags that must be given
+	required []string
+
+	// takesArgs allows positional arguments, such as the cache action
+	takesArgs bool
+
+	// argChoices lists the accepted positional arguments for completion
+	argChoices []string
+
+	// run is called with the positional arguments once flags are parsed. ctx
+	// is cancelled on SIGINT or SIGTERM.
+	run func(ctx context.Context, positional []string, settings *config.Settings)
+}
+
+// scanFlags holds the options of the scan command
+type scanFlags struct {
+	folder         string
+	fromFile       string
+	prefix         string
+	force          bool
+	reconvert      bool
+	resume         bool
+	incremental    bool
+	archives       bool
+	svg            bool
+	includeHidden  bool
+	maxDepth       int
+	minDimension   int
+	followSymlinks bool
+	minSize        string
+	maxSize        string
+	thumbnails     bool
+	thumbnailSize  int
+	faces          optionalFlag
+	colors         bool
+	cacheDir       optionalFlag
+}
+
+// searchFlags holds the options of the search command
+type searchFlags struct {
+	images    listFlag
+	imageDir  string
+	clipboard bool
+	phash     string
+	ahash     string
+	prefixes  listFlag
+	near      string
+	radius    float64
+	after     string
+	before    string
+	camera    string
+	minRating int
+	minFaces  int
+	color     string
+	colorDiff float64
+	minSize   string
+	maxSize   string
+	label     string
+	keyword   string
+	tag       string
+	scope     string // --collection
+	saveTo    string
+	copyTo    string
+	linkTo    string
+	cacheDir  optionalFlag
+	prefilter bool
+	strategy  string
+	mirror    bool
+	metric    string
+	noSSIM    bool
+	explain   bool
+	preset    string
+	open      optionalFlag
+	openCount int // Matches --open opens
+	offset    int
+	limit     int
+	format    string
+	template  string
+	tmpl      *template.Template // Parsed --template
+	assert    bool               // --assert-match
+	minScore  float64
+}
+
+// exportFlags holds the options of the export command
+type exportFlags struct {
+	output string
+	prefix string
+}
+
+// importFlags holds the options of the import command
+type importFlags struct {
+	input string
+	force bool
+}
+
+// importCatalogFlags holds the options of the import-lightroom and
+// import-digikam commands
+type importCatalogFlags struct {
+	catalog string
+	prefix  string
+	scan    bool
+}
+
+// dedupeFlags holds the options of the dedupe command
+type dedupeFlags struct {
+	prefix      string
+	maxDistance int
+	json        bool
+	action      string
+	targetDir   string
+	dryRun      bool
+	journal     string
+	saveTo      string
+	interactive bool
+}
+
+// clusterFlags holds the options of the cluster command
+type clusterFlags struct {
+	prefix  string
+	minSize int
+	json    bool
+}
+
+// burstsFlags holds the options of the bursts command
+type burstsFlags struct {
+	prefix    string
+	within    float64
+	sharpness bool
+	json      bool
+	cacheDir  optionalFlag
+}
+
+// calibrateFlags holds the options of the calibrate command
+type calibrateFlags struct {
+	folder   string
+	group    string
+	random   int
+	recall   float64
+	strategy string
+	metric   string
+	cacheDir optionalFlag
+}
+
+// verifyFlags holds the options of the verify command
+type verifyFlags struct {
+	checksums bool
+	prefix    string
+	sample    float64
+	rate      string
+}
+
+// historyFlags holds the options of the history command
+type historyFlags struct {
+	prefix string
+	limit  int
+	json   bool
+}
+
+// statsFlags holds the options of the stats command
+type statsFlags struct {
+	prefix string
+	json   bool
+}
+
+// retryFailedFlags holds the options of the retry-failed command
+type retryFailedFlags struct {
+	prefix        string
+	prefixSet     bool
+	match         string
+	loader        string
+	dryRun        bool
+	thumbnails    bool
+	thumbnailSize int
+	cacheDir      optionalFlag
+}
+
+// rehashFlags holds the options of the rehash command
+type rehashFlags struct {
+	prefix        string
+	prefixSet     bool
+	all           bool
+	dryRun        bool
+	thumbnails    bool
+	thumbnailSize int
+	cacheDir      optionalFlag
+}
+
+// tagFlags holds the options of the tag command
+type tagFlags struct {
+	path   string
+	prefix string
+	tags   listFlag
+}
+
+// collectionFlags holds the options of the collection command
+type collectionFlags struct {
+	name string
+}
+
+// serveFlags holds the options of the serve command
+type serveFlags struct {
+	listen   string
+	http     string
+	cacheDir optionalFlag
+}
+
+// rpcFlags holds the options of the rpc and mcp commands
+type rpcFlags struct {
+	cacheDir optionalFlag
+}
+
+// versionFlags holds the options of the version command
+type versionFlags struct {
+	json bool
+}
+
+// doctorFlags holds the options of the doctor command
+type doctorFlags struct {
+	json bool
+}
+
+// cacheFlags holds the options of the cache command
+type cacheFlags struct {
+	cacheDir optionalFlag
+}
+
+// listFlag collects the values of a repeatable flag. Commands may also accept
+// comma-separated lists in each value.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// optionalFlag is a string flag whose value can be left out, as in
+// --cache-dir (use the default location) or --cache-dir=PATH
+type optionalFlag struct {
+	set   bool
+	value string
+}
+
+func (o *optionalFlag) String() string {
+	return o.value
+}
+
+func (o *optionalFlag) Set(value string) error {
+	o.set = true
+	if value != "true" {
+		o.value = value
+	}
+	return nil
+}
+
+// IsBoolFlag lets the flag be given without a value
+func (o *optionalFlag) IsBoolFlag() bool {
+	return true
+}
+
+// newCommands builds the table of subcommands
+func newCommands() []*command {
+	var commands []*command
+
+	scan := &scanFlags{}
+	scanCmd := &command{
+		name:     "scan",
+		synopsis: "--folder=PATH [options] | --from-file=PATH [options] | --resume [options]",
+		summary:  "Index the images in a folder, S3 bucket prefix or WebDAV share.",
+	}
+	scanCmd.flags = newFlagSet(scanCmd)
+	scanCmd.flags.StringVar(&scan.folder, "folder", "", "Folder to scan: a local `PATH`, s3://bucket/prefix or webdav(s)://host/path")
+	scanCmd.flags.StringVar(&scan.fromFile, "from-file", "", "Index the files listed in `PATH`, one per line, instead of a folder (- reads the list from stdin)")
+	scanCmd.flags.StringVar(&scan.prefix, "prefix", "", "Source prefix `NAME` stored with each image, e.g. the drive name")
+	scanCmd.flags.BoolVar(&scan.force, "force", false, "Force rewrite existing entries")
+	scanCmd.flags.BoolVar(&scan.reconvert, "reconvert", false, "Convert every file again instead of reusing the hashes cached for identical content")
+	scanCmd.flags.BoolVar(&scan.resume, "resume", false, "Continue the last interrupted scan, with its folder, prefix and --force")
+	scanCmd.flags.BoolVar(&scan.incremental, "incremental", false, "Skip the files of folders whose modification time hasn't changed since the last incremental scan")
+	addScanWorkerFlags(scanCmd.flags)
+	addRawWorkersFlag(scanCmd.flags)
+	addIOErrorFlags(scanCmd.flags)
+	scanCmd.flags.Var(&listFlag{}, config.KeyInclude, "Only index files whose name, folder or relative path matches `PATTERN` (repeatable, comma-separated; ** matches any folders)")
+	scanCmd.flags.Var(&listFlag{}, config.KeyExclude, "Skip files whose name, folder or relative path matches `PATTERN` (repeatable, comma-separated; ** matches any folders)")
+	scanCmd.flags.BoolVar(&scan.thumbnails, "thumbnails", false, "Store a JPEG thumbnail of each image in the database")
+	scanCmd.flags.IntVar(&scan.thumbnailSize, "thumbnail-size", imageprocessor.DefaultThumbnailSize, "Longest thumbnail edge in pixels (`PX`), implies --thumbnails")
+	scanCmd.flags.Var(&scan.faces, "faces", "Count the faces in each image, with the OpenCV frontal face cascade or the cascade (.xml) or YuNet (.onnx) model at `PATH`")
+	scanCmd.flags.BoolVar(&scan.colors, "colors", false, "Store the three dominant colors of each image for search --color")
+	scanCmd.flags.BoolVar(&scan.includeHidden, "include-hidden", false, "Also index dotfiles and the metadata, snapshot and recycle bin folders of NAS systems and Windows")
+	scanCmd.flags.BoolVar(&scan.archives, "archives", false, "Also index images inside .zip, .tar and .tar.gz archives")
+	scanCmd.flags.BoolVar(&scan.svg, "svg", false, "Also index SVG drawings, rasterized at a fixed size (needs rsvg-convert or ImageMagick)")
+	scanCmd.flags.BoolVar(&scan.followSymlinks, "follow-symlinks", false, "Descend into symlinked folders, walking each real folder once")
+	scanCmd.flags.StringVar(&scan.minSize, "min-size", "", "Skip files smaller than `SIZE`, e.g. 50KB")
+	scanCmd.flags.StringVar(&scan.maxSize, "max-size", "", "Skip files larger than `SIZE`, e.g. 500MB")
+	scanCmd.flags.IntVar(&scan.minDimension, "min-dimension", 0, "Skip images whose width or height is below `PX` pixels once decoded, e.g. 256 for icons and thumbnails")
+	scanCmd.flags.IntVar(&scan.maxDepth, "max-depth", 0, "Scan `N` folder levels, counting the folder itself as 1 (default: all levels)")
+	scanCmd.flags.Var(&scan.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(scanCmd.flags)
+	scanCmd.flags.String(config.KeyWebhook, "", "POST a JSON summary of the scan to `URL` when it finishes, fails or is interrupted")
+	addSettingsFlags(scanCmd.flags)
+	scanCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if scan.folder == "" && scan.fromFile == "" && !scan.resume {
+			exitWithUsage(scanCmd, "missing required flag --folder or --from-file")
+		}
+		if scan.folder != "" && scan.fromFile != "" {
+			exitWithUsage(scanCmd, "--from-file lists the files to scan instead of a folder; leave out --folder")
+		}
+		if (scan.folder != "" || scan.fromFile != "") && scan.resume {
+			exitWithUsage(scanCmd, "--resume takes the folder from the interrupted scan; leave out --folder and --from-file")
+		}
+		if scan.fromFile != "" && scan.incremental {
+			exitWithUsage(scanCmd, "--incremental skips unchanged folders, which --from-file doesn't scan")
+		}
+		if scan.incremental && scan.force {
+			exitWithUsage(scanCmd, "--force rehashes every file, which --incremental would skip")
+		}
+		if scan.minDimension < 0 {
+			exitWithUsage(scanCmd, "--min-dimension must be a number of pixels")
+		}
+		if isFlagSet(scanCmd.flags, "thumbnail-size") {
+			scan.thumbnails = true
+		}
+		handleScanCommand(ctx, scan, settings)
+	}
+	commands = append(commands, scanCmd)
+
+	search := &searchFlags{}
+	searchCmd := &command{
+		name:     "search",
+		synopsis: "--image=PATH... [options] | --image-dir=DIR [options] | --clipboard [options] | --phash=HEX [--ahash=HEX] [options]",
+		summary:  "Find indexed images similar to one or more query images.",
+	}
+	searchCmd.flags = newFlagSet(searchCmd)
+	searchCmd.flags.Var(&search.images, "image", "Query image `PATH` (repeatable; - reads it from stdin)")
+	searchCmd.flags.StringVar(&search.imageDir, "image-dir", "", "Search for each image in the folder `DIR`")
+	searchCmd.flags.BoolVar(&search.clipboard, "clipboard", false, "Search for the image on the system clipboard, such as a screenshot")
+	searchCmd.flags.StringVar(&search.phash, "phash", "", "Search by a perceptual hash of 16 `HEX` digits instead of a query image")
+	searchCmd.flags.StringVar(&search.ahash, "ahash", "", "Search by an average hash of 16 `HEX` digits, alone or with --phash")
+	searchCmd.flags.Float64(config.KeyThreshold, 0.8, "Similarity threshold, a `VALUE` from 0.0 to 1.0 (default: that of --preset)")
+	searchCmd.flags.StringVar(&search.preset, "preset", string(imageprocessor.PresetNormal), "Tolerances of matches: `NAME` is strict (near copies), normal or loose (edited copies, RAW vs JPEG)")
+	addSearchWorkersFlag(searchCmd.flags)
+	searchCmd.flags.Var(&search.prefixes, "prefix", "Only search images with source prefix `NAME` (repeatable, comma-separated)")
+	searchCmd.flags.StringVar(&search.near, "near", "", "Only search geotagged images near `LAT,LON` (decimal degrees)")
+	searchCmd.flags.Float64Var(&search.radius, "radius", utils.DefaultRadiusKm, "Search radius in kilometers (`KM`) around --near")
+	searchCmd.flags.StringVar(&search.after, "after", "", "Only search images captured on or after `DATE` (YYYY-MM-DD or RFC3339)")
+	searchCmd.flags.StringVar(&search.before, "before", "", "Only search images captured on or before `DATE` (YYYY-MM-DD or RFC3339)")
+	searchCmd.flags.StringVar(&search.camera, "camera", "", "Only search images from cameras whose model contains `MODEL`")
+	searchCmd.flags.StringVar(&search.strategy, "strategy", string(imageprocessor.StrategyBoth), "Hashes that decide a match: `NAME` is ahash, phash, both (weighted) or any (closer hash)")
+	searchCmd.flags.StringVar(&search.metric, "metric", string(imageprocessor.MetricSSIM), "How matches are verified against the query: `NAME` is ssim, ms-ssim or absdiff")
+	searchCmd.flags.BoolVar(&search.noSSIM, "no-ssim", false, "Rank matches by hash score only, without reading the matched images (for offline or slow drives)")
+	searchCmd.flags.BoolVar(&search.mirror, "mirror", false, "Also find mirror images of the query (flipped scans and slides)")
+	searchCmd.flags.IntVar(&search.limit, "limit", 5, "Print at most `N` matches (0 prints all)")
+	searchCmd.flags.IntVar(&search.offset, "offset", 0, "Skip the first `N` matches, to page through the results with --limit")
+	searchCmd.flags.StringVar(&search.format, "format", "text", "Output `FORMAT`: text (described matches) or template (one line per match, shaped by --template)")
+	searchCmd.flags.StringVar(&search.template, "template", "", "Go template `TEXT` rendered for each match with --format=template, e.g. '{{.Path}}\\t{{printf \"%.3f\" .SSIMScore}}'")
+	searchCmd.flags.BoolVar(&search.explain, "explain", false, "Print the signals behind the score of each match: hash distances, filename boost and loader")
+	searchCmd.flags.BoolVar(&search.prefilter, "prefilter", false, "Only compare images sharing a pHash band with the query (faster, may miss weak matches)")
+	searchCmd.flags.StringVar(&search.minSize, "min-size", "", "Only search files of at least `SIZE`, e.g. 50KB")
+	searchCmd.flags.StringVar(&search.maxSize, "max-size", "", "Only search files of at most `SIZE`, e.g. 500MB")
+	searchCmd.flags.IntVar(&search.minRating, "min-rating", 0, "Only search images rated at least `N` stars in their XMP sidecar")
+	searchCmd.flags.IntVar(&search.minFaces, "min-faces", 0, "Only search images with at least `N` faces found by scan --faces")
+	searchCmd.flags.StringVar(&search.color, "color", "", "Only search images with a dominant color close to `#RRGGBB` (needs scan --colors)")
+	searchCmd.flags.Float64Var(&search.colorDiff, "color-tolerance", 20, "Largest color difference from --color, as CIE ΔE (`N`; 2.3 is just noticeable)")
+	searchCmd.flags.StringVar(&search.label, "label", "", "Only search images with the XMP color label `NAME`")
+	searchCmd.flags.StringVar(&search.keyword, "keyword", "", "Only search images with the XMP keyword `WORD`")
+	searchCmd.flags.StringVar(&search.tag, "tag", "", "Only search images tagged `NAME` with the tag command")
+	searchCmd.flags.StringVar(&search.scope, "collection", "", "Only search images in the collection `NAME`")
+	searchCmd.flags.BoolVar(&search.assert, "assert-match", false, "Exit with status 1 unless a match reaches --min-score, for automated checks")
+	searchCmd.flags.Float64Var(&search.minScore, "min-score", 0, "Smallest score, a `VALUE` from 0.0 to 1.0, of a match satisfying --assert-match (default: any match)")
+	searchCmd.flags.StringVar(&search.saveTo, "save-collection", "", "Add all matches to the collection `NAME`, creating it if needed")
+	searchCmd.flags.StringVar(&search.copyTo, "copy-to", "", "Copy all matches into the folder `DIR`, named by rank and score")
+	searchCmd.flags.StringVar(&search.linkTo, "link-to", "", "Symlink all matches into the folder `DIR`, named by rank and score")
+	searchCmd.flags.Var(&search.open, "open", "Open the top `N` matches in the default image viewer (default: 1)")
+	searchCmd.flags.Var(&search.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(searchCmd.flags)
+	addSettingsFlags(searchCmd.flags)
+	searchCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		byHash := search.phash != "" || search.ahash != ""
+		switch {
+		case byHash && (len(search.images) > 0 || search.imageDir != ""):
+			exitWithUsage(searchCmd, "--phash and --ahash replace the query image; leave out --image and --image-dir")
+		case search.clipboard && (byHash || len(search.images) > 0 || search.imageDir != ""):
+			exitWithUsage(searchCmd, "--clipboard replaces the query image; leave out --image, --image-dir, --phash and --ahash")
+		case byHash && search.mirror:
+			exitWithUsage(searchCmd, "--mirror needs a query image")
+		case search.noSSIM && isFlagSet(searchCmd.flags, "metric"):
+			exitWithUsage(searchCmd, "--metric chooses how matches are verified, which --no-ssim skips")
+		case !byHash && !search.clipboard && len(search.images) == 0 && search.imageDir == "":
+			exitWithUsage(searchCmd, "missing required flag --image, --image-dir, --clipboard or --phash")
+		}
+		if _, err := imageprocessor.ParsePreset(search.preset); err != nil {
+			exitWithUsage(searchCmd, err.Error())
+		}
+		if search.minScore < 0 || search.minScore > 1 {
+			exitWithUsage(searchCmd, "--min-score must be a value from 0.0 to 1.0")
+		}
+		if isFlagSet(searchCmd.flags, "min-score") && !search.assert {
+			exitWithUsage(searchCmd, "--min-score is the bar of --assert-match; add --assert-match")
+		}
+		if search.limit < 0 || search.offset < 0 {
+			exitWithUsage(searchCmd, "--limit and --offset must be a number of matches")
+		}
+		switch {
+		case !slices.Contains(searchFormats, search.format):
+			exitWithUsage(searchCmd, fmt.Sprintf("invalid format '%s', expected %s", search.format, strings.Join(searchFormats, ", ")))
+		case search.format == "template" && search.template == "":
+			exitWithUsage(searchCmd, "--format=template needs a --template")
+		case search.format != "template" && search.template != "":
+			exitWithUsage(searchCmd, "--template needs --format=template")
+		}
+		if search.template != "" {
+			tmpl, err := parseMatchTemplate(search.template)
+			if err != nil {
+				exitWithUsage(searchCmd, fmt.Sprintf("invalid --template: %v", err))
+			}
+			search.tmpl = tmpl
+		}
+		if search.open.set {
+			search.openCount = 1
+			if search.open.value != "" {
+				n, err := strconv.Atoi(search.open.value)
+				if err != nil || n < 1 {
+					exitWithUsage(searchCmd, "--open needs a number of matches of at least 1")
+				}
+				search.openCount = n
+			}
+			if len(search.images) > 1 || search.imageDir != "" {
+				exitWithUsage(searchCmd, "--open needs a single query image or hash")
+			}
+		}
+		// A single known hash decides the match unless a strategy is given
+		if byHash && !isFlagSet(searchCmd.flags, "strategy") {
+			if search.ahash == "" {
+				search.strategy = string(imageprocessor.StrategyPHash)
+			} else if search.phash == "" {
+				search.strategy = string(imageprocessor.StrategyAHash)
+			}
+		}
+		handleSearchCommand(ctx, search, isFlagSet(searchCmd.flags, "near"), settings)
+	}
+	commands = append(commands, searchCmd)
+
+	export := &exportFlags{}
+	exportCmd := &command{
+		name:     "export",
+		synopsis: "--output=PATH [options]",
+		summary:  "Write the index as JSON lines.",
+		required: []string{"output"},
+	}
+	exportCmd.flags = newFlagSet(exportCmd)
+	exportCmd.flags.StringVar(&export.output, "output", "", "`PATH` of the JSONL file to write (- for stdout)")
+	exportCmd.flags.StringVar(&export.prefix, "prefix", "", "Only export images with source prefix `NAME`")
+	addSettingsFlags(exportCmd.flags)
+	exportCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleExportCommand(ctx, export, settings.Database)
+	}
+	commands = append(commands, exportCmd)
+
+	imp := &importFlags{}
+	importCmd := &command{
+		name:     "import",
+		synopsis: "--input=PATH [options]",
+		summary:  "Load JSON lines written by export into the index.",
+		required: []string{"input"},
+	}
+	importCmd.flags = newFlagSet(importCmd)
+	importCmd.flags.StringVar(&imp.input, "input", "", "`PATH` of the JSONL file to read (- for stdin)")
+	importCmd.flags.BoolVar(&imp.force, "force", false, "Overwrite images that are already indexed")
+	addSettingsFlags(importCmd.flags)
+	importCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleImportCommand(ctx, imp, settings.Database)
+	}
+	commands = append(commands, importCmd)
+
+	lightroom := &importCatalogFlags{}
+	lightroomCmd := &command{
+		name:     "import-lightroom",
+		synopsis: "--catalog=PATH [options]",
+		summary:  "Seed the index with the images, capture dates, ratings, labels and keywords of a Lightroom catalog.",
+		required: []string{"catalog"},
+	}
+	lightroomCmd.flags = newFlagSet(lightroomCmd)
+	lightroomCmd.flags.StringVar(&lightroom.catalog, "catalog", "", "`PATH` of the Lightroom Classic catalog (.lrcat)")
+	addCatalogFlags(lightroomCmd.flags, lightroom)
+	lightroomCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleImportCatalogCommand(ctx, lightroom, func(path string) (catalog.Catalog, error) {
+			return catalog.OpenLightroom(path)
+		}, settings)
+	}
+	commands = append(commands, lightroomCmd)
+
+	digiKam := &importCatalogFlags{}
+	digiKamCmd := &command{
+		name:     "import-digikam",
+		synopsis: "--catalog=PATH [options]",
+		summary:  "Seed the index with the images, capture dates, ratings, labels and tags of a digiKam database.",
+		required: []string{"catalog"},
+	}
+	digiKamCmd.flags = newFlagSet(digiKamCmd)
+	digiKamCmd.flags.StringVar(&digiKam.catalog, "catalog", "", "`PATH` of the digiKam database (digikam4.db)")
+	addCatalogFlags(digiKamCmd.flags, digiKam)
+	digiKamCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleImportCatalogCommand(ctx, digiKam, func(path string) (catalog.Catalog, error) {
+			return catalog.OpenDigiKam(path)
+		}, settings)
+	}
+	commands = append(commands, digiKamCmd)
+
+	dedupe := &dedupeFlags{}
+	dedupeCmd := &command{
+		name:     "dedupe",
+		synopsis: "[options]",
+		summary:  "Group indexed images that look the same and suggest which copy to keep.",
+	}
+	dedupeCmd.flags = newFlagSet(dedupeCmd)
+	dedupeCmd.flags.StringVar(&dedupe.prefix, "prefix", "", "Only look for duplicates among images with source prefix `NAME`")
+	dedupeCmd.flags.IntVar(&dedupe.maxDistance, "max-distance", imageprocessor.DefaultMaxDistance, "Largest number of pHash bits (`N`) in which duplicates may differ")
+	dedupeCmd.flags.BoolVar(&dedupe.json, "json", false, "Print each group as a line of JSON")
+	dedupeCmd.flags.StringVar(&dedupe.action, "action", "", "Reclaim duplicates: `NAME` is hardlink, move or delete")
+	dedupeCmd.flags.StringVar(&dedupe.targetDir, "target-dir", "", "Directory `PATH` that --action=move moves duplicates into")
+	dedupeCmd.flags.BoolVar(&dedupe.dryRun, "dry-run", false, "Show and journal what --action would do without changing files")
+	dedupeCmd.flags.BoolVar(&dedupe.interactive, "interactive", false, "Review each group and mark the files to keep; marked files are deleted, or handled with --action, at the end")
+	dedupeCmd.flags.StringVar(&dedupe.journal, "journal", defaultJournalPath, "JSONL file `PATH` every action is appended to")
+	dedupeCmd.flags.StringVar(&dedupe.saveTo, "save-collection", "", "Add the images of all duplicate groups to the collection `NAME`")
+	addSettingsFlags(dedupeCmd.flags)
+	dedupeCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleDedupeCommand(ctx, dedupe, settings.Database)
+	}
+	commands = append(commands, dedupeCmd)
+
+	cluster := &clusterFlags{}
+	clusterCmd := &command{
+		name:     "cluster",
+		synopsis: "[--threshold=VALUE] [options]",
+		summary:  "Split the index into clusters of visually similar images and number them.",
+	}
+	clusterCmd.flags = newFlagSet(clusterCmd)
+	clusterCmd.flags.Float64(config.KeyThreshold, 0.8, "Hash similarity, a `VALUE` from 0.0 to 1.0, at which two images are linked")
+	clusterCmd.flags.StringVar(&cluster.prefix, "prefix", "", "Only cluster images with source prefix `NAME`")
+	clusterCmd.flags.IntVar(&cluster.minSize, "min-size", 2, "Smallest cluster listed, in images (`N`; 1 lists every image)")
+	clusterCmd.flags.BoolVar(&cluster.json, "json", false, "Print each cluster as a line of JSON")
+	addSettingsFlags(clusterCmd.flags)
+	clusterCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleClusterCommand(ctx, cluster, settings)
+	}
+	commands = append(commands, clusterCmd)
+
+	bursts := &burstsFlags{}
+	burstsCmd := &command{
+		name:     "bursts",
+		synopsis: "[--within=SECONDS] [options]",
+		summary:  "Group shots taken in quick succession that look alike and suggest which one to keep.",
+	}
+	burstsCmd.flags = newFlagSet(burstsCmd)
+	burstsCmd.flags.Float64Var(&bursts.within, "within", imageprocessor.DefaultBurstGap.Seconds(), "Longest pause between two shots of a burst, in `SECONDS`")
+	burstsCmd.flags.Float64(config.KeyThreshold, 0.8, "Hash similarity, a `VALUE` from 0.0 to 1.0, that consecutive shots must reach")
+	burstsCmd.flags.StringVar(&bursts.prefix, "prefix", "", "Only look for bursts among images with source prefix `NAME`")
+	burstsCmd.flags.BoolVar(&bursts.sharpness, "sharpness", false, "Read each shot and suggest the sharpest one (slower)")
+	burstsCmd.flags.BoolVar(&bursts.json, "json", false, "Print each burst as a line of JSON")
+	burstsCmd.flags.Var(&bursts.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addSettingsFlags(burstsCmd.flags)
+	burstsCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleBurstsCommand(ctx, bursts, settings)
+	}
+	commands = append(commands, burstsCmd)
+
+	calibrate := &calibrateFlags{}
+	calibrateCmd := &command{
+		name:     "calibrate",
+		synopsis: "--folder=DIR [options]",
+		summary:  "Suggest a threshold and preset from images known to show the same picture.",
+		required: []string{"folder"},
+	}
+	calibrateCmd.flags = newFlagSet(calibrateCmd)
+	calibrateCmd.flags.StringVar(&calibrate.folder, "folder", "", "Folder `DIR` of the known matching images, such as RAW files next to their JPEGs")
+	calibrateCmd.flags.StringVar(&calibrate.group, "group", "name", "What the images of a picture share: `HOW` is name (same file name, any extension) or folder (same subfolder)")
+	calibrateCmd.flags.IntVar(&calibrate.random, "random", 1000, "Number of random pairs of different pictures scored (`N`)")
+	calibrateCmd.flags.Float64Var(&calibrate.recall, "recall", 95, "Share of the matching pairs the suggestions must find, in `PERCENT`")
+	calibrateCmd.flags.StringVar(&calibrate.strategy, "strategy", string(imageprocessor.StrategyBoth), "Hashes that decide a match: `NAME` is ahash, phash, both (weighted) or any (closer hash)")
+	calibrateCmd.flags.StringVar(&calibrate.metric, "metric", string(imageprocessor.MetricSSIM), "How matches are verified: `NAME` is ssim, ms-ssim or absdiff")
+	calibrateCmd.flags.Int(config.KeyWorkers, 0, "Number of images loaded in parallel (`N`; default: number of CPUs)")
+	calibrateCmd.flags.Var(&calibrate.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(calibrateCmd.flags)
+	addSettingsFlags(calibrateCmd.flags)
+	calibrateCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		switch {
+		case !slices.Contains(calibrationGroupings, calibrate.group):
+			exitWithUsage(calibrateCmd, fmt.Sprintf("invalid --group '%s', expected %s", calibrate.group, strings.Join(calibrationGroupings, " or ")))
+		case calibrate.random < 1:
+			exitWithUsage(calibrateCmd, "--random must be at least 1")
+		case calibrate.recall <= 0 || calibrate.recall > 100:
+			exitWithUsage(calibrateCmd, "--recall must be a percentage above 0 and at most 100")
+		}
+		handleCalibrateCommand(ctx, calibrate, settings)
+	}
+	commands = append(commands, calibrateCmd)
+
+	verify := &verifyFlags{}
+	verifyCmd := &command{
+		name:     "verify",
+		synopsis: "--checksums [options]",
+		summary:  "Re-read indexed files and report those whose content no longer matches their checksum.",
+	}
+	verifyCmd.flags = newFlagSet(verifyCmd)
+	verifyCmd.flags.BoolVar(&verify.checksums, "checksums", false, "Compare each file with the SHA-256 stored when it was scanned")
+	verifyCmd.flags.StringVar(&verify.prefix, "prefix", "", "Only verify images with source prefix `NAME`")
+	verifyCmd.flags.Float64Var(&verify.sample, "sample", 100, "Verify a random `PERCENT` of the files")
+	verifyCmd.flags.StringVar(&verify.rate, "rate", "", "Read at most `SIZE` per second, e.g. 50MB (default: no limit)")
+	addSettingsFlags(verifyCmd.flags)
+	verifyCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if !verify.checksums {
+			exitWithUsage(verifyCmd, "nothing to verify; add --checksums")
+		}
+		handleVerifyCommand(ctx, verify, settings.Database)
+	}
+	commands = append(commands, verifyCmd)
+
+	retryFailed := &retryFailedFlags{}
+	retryFailedCmd := &command{
+		name:     "retry-failed",
+		synopsis: "[--match=TEXT] [--loader=NAME] [options]",
+		summary:  "Index again the files that failed in earlier scans, e.g. after installing a missing tool.",
+	}
+	retryFailedCmd.flags = newFlagSet(retryFailedCmd)
+	retryFailedCmd.flags.StringVar(&retryFailed.prefix, "prefix", "", "Only retry files with source prefix `NAME`")
+	retryFailedCmd.flags.StringVar(&retryFailed.match, "match", "", "Only retry files whose error message contains `TEXT`, e.g. dcraw (case-insensitive)")
+	retryFailedCmd.flags.StringVar(&retryFailed.loader, "loader", "", "Only retry files that failed in the loader `NAME`, as listed by stats")
+	retryFailedCmd.flags.BoolVar(&retryFailed.dryRun, "dry-run", false, "List the failed files and their errors without retrying them")
+	addScanWorkerFlags(retryFailedCmd.flags)
+	addRawWorkersFlag(retryFailedCmd.flags)
+	addIOErrorFlags(retryFailedCmd.flags)
+	retryFailedCmd.flags.BoolVar(&retryFailed.thumbnails, "thumbnails", false, "Store a JPEG thumbnail of each image in the database")
+	retryFailedCmd.flags.IntVar(&retryFailed.thumbnailSize, "thumbnail-size", imageprocessor.DefaultThumbnailSize, "Longest thumbnail edge in pixels (`PX`), implies --thumbnails")
+	retryFailedCmd.flags.Var(&retryFailed.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(retryFailedCmd.flags)
+	addSettingsFlags(retryFailedCmd.flags)
+	retryFailedCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		retryFailed.prefixSet = isFlagSet(retryFailedCmd.flags, "prefix")
+		if isFlagSet(retryFailedCmd.flags, "thumbnail-size") {
+			retryFailed.thumbnails = true
+		}
+		handleRetryFailedCommand(ctx, retryFailed, settings)
+	}
+	commands = append(commands, retryFailedCmd)
+
+	rehash := &rehashFlags{}
+	rehashCmd := &command{
+		name:     "rehash",
+		synopsis: "[--prefix=NAME] [--all] [--dry-run] [options]",
+		summary:  "Hash again the indexed files stored rotated or flipped, so they match their upright copies.",
+	}
+	rehashCmd.flags = newFlagSet(rehashCmd)
+	rehashCmd.flags.StringVar(&rehash.prefix, "prefix", "", "Only hash files with source prefix `NAME` again")
+	rehashCmd.flags.BoolVar(&rehash.all, "all", false, "Hash every indexed local file again, not only those with an EXIF orientation")
+	rehashCmd.flags.BoolVar(&rehash.dryRun, "dry-run", false, "List the files and their EXIF orientation without hashing them")
+	addScanWorkerFlags(rehashCmd.flags)
+	addRawWorkersFlag(rehashCmd.flags)
+	addIOErrorFlags(rehashCmd.flags)
+	rehashCmd.flags.BoolVar(&rehash.thumbnails, "thumbnails", false, "Store a JPEG thumbnail of each image in the database")
+	rehashCmd.flags.IntVar(&rehash.thumbnailSize, "thumbnail-size", imageprocessor.DefaultThumbnailSize, "Longest thumbnail edge in pixels (`PX`), implies --thumbnails")
+	rehashCmd.flags.Var(&rehash.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(rehashCmd.flags)
+	addSettingsFlags(rehashCmd.flags)
+	rehashCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		rehash.prefixSet = isFlagSet(rehashCmd.flags, "prefix")
+		if isFlagSet(rehashCmd.flags, "thumbnail-size") {
+			rehash.thumbnails = true
+		}
+		handleRehashCommand(ctx, rehash, settings)
+	}
+	commands = append(commands, rehashCmd)
+
+	history := &historyFlags{}
+	historyCmd := &command{
+		name:     "history",
+		synopsis: "[--limit=N] [options]",
+		summary:  "List past scans with their folder, duration, counts and settings.",
+	}
+	historyCmd.flags = newFlagSet(historyCmd)
+	historyCmd.flags.StringVar(&history.prefix, "prefix", "", "Only list scans of source prefix `NAME`")
+	historyCmd.flags.IntVar(&history.limit, "limit", 20, "List the `N` most recent scans (0 lists all)")
+	historyCmd.flags.BoolVar(&history.json, "json", false, "Print each scan as a line of JSON")
+	addSettingsFlags(historyCmd.flags)
+	historyCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if history.limit < 0 {
+			exitWithUsage(historyCmd, "--limit can't be negative")
+		}
+		handleHistoryCommand(ctx, history, settings.Database)
+	}
+	commands = append(commands, historyCmd)
+
+	stats := &statsFlags{}
+	statsCmd := &command{
+		name:     "stats",
+		synopsis: "[--prefix=NAME] [--json] [options]",
+		summary:  "Count indexed images and bytes by format and source prefix, RAW+JPEG pairs and hash collisions.",
+	}
+	statsCmd.flags = newFlagSet(statsCmd)
+	statsCmd.flags.StringVar(&stats.prefix, "prefix", "", "Only count images with source prefix `NAME`")
+	statsCmd.flags.BoolVar(&stats.json, "json", false, "Print the statistics as a JSON object")
+	addSettingsFlags(statsCmd.flags)
+	statsCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleStatsCommand(ctx, stats, settings.Database)
+	}
+	commands = append(commands, statsCmd)
+
+	tag := &tagFlags{}
+	tagCmd := &command{
+		name:       "tag",
+		synopsis:   "add|remove|list [--path=PATH] [--tag=NAME] [options]",
+		summary:    "Add or remove tags of an indexed image, or list tags and the images carrying them.",
+		takesArgs:  true,
+		argChoices: tagActions,
+	}
+	tagCmd.flags = newFlagSet(tagCmd)
+	tagCmd.flags.StringVar(&tag.path, "path", "", "`PATH` of the indexed image")
+	tagCmd.flags.StringVar(&tag.prefix, "prefix", "", "Source prefix `NAME` the image is indexed under")
+	tagCmd.flags.Var(&tag.tags, "tag", "Tag `NAME` (repeatable, comma-separated)")
+	addSettingsFlags(tagCmd.flags)
+	tagCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if len(positional) != 1 {
+			exitWithUsage(tagCmd, "expected exactly one tag action (add, remove or list)")
+		}
+		if positional[0] != "list" && (tag.path == "" || len(tag.tags) == 0) {
+			exitWithUsage(tagCmd, positional[0]+" needs --path and --tag")
+		}
+		handleTagCommand(ctx, positional[0], tag, settings.Database)
+	}
+	commands = append(commands, tagCmd)
+
+	collection := &collectionFlags{}
+	collectionCmd := &command{
+		name:       "collection",
+		synopsis:   "list|show|delete [--name=NAME] [options]",
+		summary:    "List collections saved by search or dedupe, show the images in one, or delete one.",
+		takesArgs:  true,
+		argChoices: collectionActions,
+	}
+	collectionCmd.flags = newFlagSet(collectionCmd)
+	collectionCmd.flags.StringVar(&collection.name, "name", "", "Collection `NAME` to show or delete")
+	addSettingsFlags(collectionCmd.flags)
+	collectionCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if len(positional) != 1 {
+			exitWithUsage(collectionCmd, "expected exactly one collection action (list, show or delete)")
+		}
+		if positional[0] != "list" && collection.name == "" {
+			exitWithUsage(collectionCmd, positional[0]+" needs --name")
+		}
+		handleCollectionCommand(ctx, positional[0], collection, settings.Database)
+	}
+	commands = append(commands, collectionCmd)
+
+	serve := &serveFlags{}
+	serveCmd := &command{
+		name:     "serve",
+		synopsis: "[--listen=ADDR] [--http=ADDR] [options]",
+		summary:  "Serve a gRPC API to search, run scans and stream their progress, and optionally a web UI.",
+	}
+	serveCmd.flags = newFlagSet(serveCmd)
+	serveCmd.flags.StringVar(&serve.listen, "listen", "localhost:50051", "Listen on the TCP address `ADDR` (host:port)")
+	serveCmd.flags.StringVar(&serve.http, "http", "", "Also serve the web UI for search results and duplicates on `ADDR`, e.g. localhost:8080")
+	serveCmd.flags.Float64(config.KeyThreshold, 0.8, "Similarity threshold of searches that don't give one, a `VALUE` from 0.0 to 1.0")
+	serveCmd.flags.Int(config.KeyWorkers, 0, "Number of images processed or compared in parallel (`N`; default: number of CPUs)")
+	addMaxMemoryFlag(serveCmd.flags)
+	addRawWorkersFlag(serveCmd.flags)
+	addIOErrorFlags(serveCmd.flags)
+	serveCmd.flags.Var(&serve.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(serveCmd.flags)
+	addSettingsFlags(serveCmd.flags)
+	serveCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleServeCommand(ctx, serve, settings)
+	}
+	commands = append(commands, serveCmd)
+
+	rpc := &rpcFlags{}
+	rpcCmd := &command{
+		name:     "rpc",
+		synopsis: "[options]",
+		summary:  "Answer JSON-RPC requests (search, stats, compare) read from stdin, one per line, keeping the index loaded between them.",
+	}
+	rpcCmd.flags = newFlagSet(rpcCmd)
+	rpcCmd.flags.Float64(config.KeyThreshold, 0.8, "Similarity threshold of searches that don't give one, a `VALUE` from 0.0 to 1.0")
+	addSearchWorkersFlag(rpcCmd.flags)
+	rpcCmd.flags.Var(&rpc.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(rpcCmd.flags)
+	addSettingsFlags(rpcCmd.flags)
+	rpcCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleRPCCommand(ctx, rpc, settings)
+	}
+	commands = append(commands, rpcCmd)
+
+	mcp := &rpcFlags{}
+	mcpCmd := &command{
+		name:     "mcp",
+		synopsis: "[options]",
+		summary:  "Serve search, compare and stats as Model Context Protocol tools on stdin and stdout, for AI assistants.",
+	}
+	mcpCmd.flags = newFlagSet(mcpCmd)
+	mcpCmd.flags.Float64(config.KeyThreshold, 0.8, "Similarity threshold of searches that don't give one, a `VALUE` from 0.0 to 1.0")
+	addSearchWorkersFlag(mcpCmd.flags)
+	mcpCmd.flags.Var(&mcp.cacheDir, "cache-dir", "Reuse converted RAW previews stored in `PATH` (default: user cache directory)")
+	addRawModeFlags(mcpCmd.flags)
+	addSettingsFlags(mcpCmd.flags)
+	mcpCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleMCPCommand(ctx, mcp, settings)
+	}
+	commands = append(commands, mcpCmd)
+
+	cache := &cacheFlags{}
+	cacheCmd := &command{
+		name:       "cache",
+		synopsis:   "clean [options]",
+		summary:    "Manage the RAW preview cache. clean removes all cached previews and the temporary files left by runs that crashed.",
+		takesArgs:  true,
+		argChoices: []string{"clean"},
+	}
+	cacheCmd.flags = newFlagSet(cacheCmd)
+	cacheCmd.flags.Var(&cache.cacheDir, "cache-dir", "Preview cache `PATH` (default: user cache directory)")
+	addSettingsFlags(cacheCmd.flags)
+	cacheCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if len(positional) != 1 {
+			exitWithUsage(cacheCmd, "expected exactly one cache action (clean)")
+		}
+		handleCacheCommand(positional[0], cache)
+	}
+	commands = append(commands, cacheCmd)
+
+	completionCmd := &command{
+		name:       "completion",
+		synopsis:   "bash|zsh|fish",
+		summary:    "Print a shell completion script for commands and flags.",
+		takesArgs:  true,
+		argChoices: completionShells,
+	}
+	completionCmd.flags = newFlagSet(completionCmd)
+	completionCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		if len(positional) != 1 {
+			exitWithUsage(completionCmd, "expected exactly one shell (bash, zsh or fish)")
+		}
+		handleCompletionCommand(positional[0], commands)
+	}
+	commands = append(commands, completionCmd)
+
+	versionOpts := &versionFlags{}
+	versionCmd := &command{
+		name:     "version",
+		synopsis: "[--json]",
+		summary:  "Print the version, commit, build date, OpenCV version and the external tools found.",
+	}
+	versionCmd.flags = newFlagSet(versionCmd)
+	versionCmd.flags.BoolVar(&versionOpts.json, "json", false, "Print the build information as a JSON object")
+	versionCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleVersionCommand(versionOpts.json)
+	}
+	commands = append(commands, versionCmd)
+
+	doctor := &doctorFlags{}
+	doctorCmd := &command{
+		name:     "doctor",
+		synopsis: "[--json] [options]",
+		summary:  "Check OpenCV, the external tools, the database and the temp folder, hash a test image, and print how to fix what is missing.",
+	}
+	doctorCmd.flags = newFlagSet(doctorCmd)
+	doctorCmd.flags.BoolVar(&doctor.json, "json", false, "Print the checks as a JSON array")
+	addSettingsFlags(doctorCmd.flags)
+	doctorCmd.run = func(ctx context.Context, positional []string, settings *config.Settings) {
+		handleDoctorCommand(ctx, doctor.json, settings)
+	}
+	commands = append(commands, doctorCmd)
+
+	return commands
+}
+
+// newFlagSet creates the flag set for a command, printing the command's
+// usage on --help or a bad flag
+func newFlagSet(cmd *command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() { printCommandUsage(flags.Output(), cmd) }
+	return flags
+}
+
+// addCatalogFlags adds the flags shared by the catalog import commands
+func addCatalogFlags(flags *flag.FlagSet, catalogFlags *importCatalogFlags) {
+	flags.StringVar(&catalogFlags.prefix, "prefix", "", "Source prefix `NAME` to store the images under")
+	flags.BoolVar(&catalogFlags.scan, "scan", false, "Scan the catalog's folders afterwards to hash the images")
+	addSettingsFlags(flags)
+}
+
+// addSettingsFlags adds the flags for settings shared by all commands. Their
+// values are resolved together with the environment and config file by the
+// config package.
+func addSettingsFlags(flags *flag.FlagSet) {
+	flags.String(config.KeyDatabase, "", "`PATH` of the database file (default: "+utils.GetDefaultDatabasePath()+")")
+	flags.String("db", "", "Alias for --database `PATH`")
+	flags.String("config", "", "Read settings from the config file at `PATH` instead of imagefinder.yaml")
+	flags.String(config.KeyDcraw, "", "Run the dcraw binary at `PATH` instead of the one found in PATH")
+	flags.String(config.KeyExiftool, "", "Run the exiftool binary at `PATH` instead of the one found in PATH")
+	flags.String(config.KeyTempDir, "", "Create the run's temporary files (converted RAW images, downloads) in `PATH` (default: the system temp folder)")
+	flags.String(config.KeyLogFile, "", "`PATH` of the debug log file (default: imagefinder.log)")
+	flags.String(config.KeyLogLevel, "", "Least severe messages to log (`LEVEL`: debug, info, warning or error; default: info), optionally per module as info,scanner=debug")
+	flags.String(config.KeyLogFormat, "", "Log record `FORMAT`: text or json (default: text)")
+	flags.Bool(config.KeyDebug, false, "Enable debug mode (logs detailed information)")
+	flags.Bool(config.KeyQuiet, false, "Print only errors and results, without progress or summaries")
+	flags.Bool("v", false, "Show info messages, warnings and errors on the console")
+	flags.Bool("vv", false, "Also show debug messages on the console")
+	flags.String(config.KeyPprof, "", "Serve CPU, heap and goroutine profiles at `ADDR`, such as localhost:6060, under /debug/pprof/")
+	flags.String(config.KeyTrace, "", "Write a runtime execution trace of the command to `PATH`, for go tool trace")
+}
+
+// addScanWorkerFlags adds the flags choosing how many images a scan processes
+// at once, for the commands that index files
+func addScanWorkerFlags(flags *flag.FlagSet) {
+	flags.Int(config.KeyWorkers, 0, "Number of images processed in parallel (`N`; default: adjusted during the scan)")
+	addMaxMemoryFlag(flags)
+}
+
+// addSearchWorkersFlag adds the flag choosing how many candidates a search
+// compares at once
+func addSearchWorkersFlag(flags *flag.FlagSet) {
+	flags.Int(config.KeyWorkers, 0, "Number of candidate images compared in parallel (`N`; default: number of CPUs)")
+}
+
+// addMaxMemoryFlag adds the flag limiting the memory of the images a scan
+// decodes at once
+func addMaxMemoryFlag(flags *flag.FlagSet) {
+	flags.String(config.KeyMaxMemory, "0", "Limit the estimated memory of images decoded at once by a scan to `SIZE`, e.g. 4GB (default: no limit)")
+}
+
+// addRawWorkersFlag adds the flag limiting the RAW files a scan converts at
+// once
+func addRawWorkersFlag(flags *flag.FlagSet) {
+	flags.Int(config.KeyRawWorkers, 0, "Number of RAW files converted in parallel by a scan (`N`; default: no limit besides --workers)")
+}
+
+// addIOErrorFlags adds the flags choosing how scans handle files failing with
+// I/O errors, for the commands that index files
+func addIOErrorFlags(flags *flag.FlagSet) {
+	flags.Int(config.KeyIORetries, 0, "Read a file failing with an I/O error up to `N` more times, waiting longer each time (default: 2)")
+	flags.Int(config.KeyIOSkipAfter, 0, "Skip the remaining files once `N` files in a row failed with I/O errors, as on a dead network mount (default: 10; 0 never skips)")
+}
+
+// addRawModeFlags adds the flags choosing how RAW files are decoded, for the
+// commands that hash images
+func addRawModeFlags(flags *flag.FlagSet) {
+	flags.String(config.KeyRawMode, string(types.RawModeAuto), "Image of RAW files to hash, a `MODE`: auto (first that decodes), preview (embedded preview, fast), embedded-large (largest embedded JPEG) or full-decode (demosaiced, slow)")
+	flags.Int(config.KeyDNGMinPreview, 0, "Hash DNG files from their embedded preview only when its longest edge has at least `PX` pixels, decoding the RAW data otherwise (default: 1024; 0 accepts any preview)")
+}
+
+// flagPrefix returns the dashes a flag is written with: one for the short
+// verbosity flags, two for the others
+func flagPrefix(name string) string {
+	if name == "v" || name == "vv" {
+		return "-"
+	}
+	return "--"
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// settingsArgs returns the flags given on the command line for config.Load
+func settingsArgs(flags *flag.FlagSet) map[string]string {
+	args := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		args[f.Name] = f.Value.String()
+	})
+	return args
+}
+
+// parseCommandLine parses flags that may appear before, between or after the
+// positional arguments, which are returned in order
+func parseCommandLine(flags *flag.FlagSet, arguments []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(arguments); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+
+		remaining := flags.Args()
+		if remaining[0] == "--" {
+			return append(positional, remaining[1:]...), nil
+		}
+		positional = append(positional, remaining[0])
+		arguments = remaining[1:]
+	}
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(commands []*command, name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// runCommandLine parses the command line and returns the command to run,
+// its positional arguments and the parsed flags for config.Load. It exits
+// after printing usage for --help, unknown commands and bad flags.
+func runCommandLine(commands []*command, arguments []string) (*command, []string, map[string]string) {
+	if len(arguments) == 0 {
+		printUsage(os.Stderr, commands)
+		os.Exit(2)
+	}
+
+	name := arguments[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(arguments) > 1 {
+			if cmd := findCommand(commands, arguments[1]); cmd != nil {
+				printCommandUsage(os.Stdout, cmd)
+				os.Exit(0)
+			}
+		}
+		printUsage(os.Stdout, commands)
+		os.Exit(0)
+	case "-version", "--version":
+		name = "version"
+	}
+
+	cmd := findCommand(commands, name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		printUsage(os.Stderr, commands)
+		os.Exit(2)
+	}
+
+	positional, err := parseCommandLine(cmd.flags, arguments[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		// The flag package already printed the error and the usage
+		os.Exit(2)
+	}
+
+	for _, name := range cmd.required {
+		if cmd.flags.Lookup(name).Value.String() == "" {
+			exitWithUsage(cmd, fmt.Sprintf("missing required flag --%s", name))
+		}
+	}
+	if !cmd.takesArgs && len(positional) > 0 {
+		exitWithUsage(cmd, fmt.Sprintf("unexpected argument: %s", positional[0]))
+	}
+
+	return cmd, positional, settingsArgs(cmd.flags)
+}
+
+// exitWithUsage reports a usage error for a command and exits
+func exitWithUsage(cmd *command, message string) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n\n", message)
+	printCommandUsage(os.Stderr, cmd)
+	signalhandler.Exit(2)
+}
+
+// printUsage writes the list of commands
+func printUsage(w io.Writer, commands []*command) {
+	fmt.Fprintf(w, "Usage:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s %s\n", os.Args[0], cmd.name, cmd.synopsis)
+	}
+	fmt.Fprintf(w, "\nCommands:\n")
+	width := 0
+	for _, cmd := range commands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-*s %s\n", width, cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> --help' for the options of a command.\n", os.Args[0])
+	fmt.Fprintf(w, "\nSettings can also be given as IMAGEFINDER_* environment variables (IMAGEFINDER_DB,\n")
+	fmt.Fprintf(w, "IMAGEFINDER_WORKERS, IMAGEFINDER_LOGLEVEL, ...). Flags override the environment,\n")
+	fmt.Fprintf(w, "which overrides the config file.\n")
+	fmt.Fprintf(w, "\nExamples:\n")
+	fmt.Fprintf(w, "  %s scan --folder=/path/to/images --prefix=ExternalDrive1 --debug\n", os.Args[0])
+	fmt.Fprintf(w, "  %s search --image=/path/to/query.jpg --threshold=0.85\n", os.Args[0])
+	fmt.Fprintf(w, "  %s export --output=index.jsonl --prefix=ExternalDrive1\n", os.Args[0])
+	fmt.Fprintf(w, "  %s cache clean\n", os.Args[0])
+	fmt.Fprintf(w, "  source <(%s completion bash)\n", os.Args[0])
+}
+
+// printCommandUsage writes the usage line and options of a command
+func printCommandUsage(w io.Writer, cmd *command) {
+	fmt.Fprintf(w, "Usage:\n  %s %s %s\n\n%s\n\nOptions:\n", os.Args[0], cmd.name, cmd.synopsis, cmd.summary)
+	cmd.flags.VisitAll(func(f *flag.Flag) {
+		valueName, usage := flag.UnquoteUsage(f)
+		name := flagPrefix(f.Name) + f.Name
+		if valueName != "" {
+			if _, optional := f.Value.(*optionalFlag); optional {
+				name += "[=" + valueName + "]"
+			} else {
+				name += "=" + valueName
+			}
+		}
+		if f.DefValue != "" && f.DefValue != "0" && f.DefValue != "false" && !strings.Contains(usage, "default:") {
+			usage += fmt.Sprintf(" (default: %s)", f.DefValue)
+		}
+		fmt.Fprintf(w, "  %-24s %s\n", name, usage)
+	})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"imagefinder/config"
+	"imagefinder/imageprocessor"
+	"imagefinder/signalhandler"
+	"imagefinder/types"
+)
+
+// completionShells lists the shells completion scripts are generated for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagChoices lists the accepted values of flags that take a fixed set
+var flagChoices = map[string][]string{
+	config.KeyLogLevel:  config.LogLevels,
+	config.KeyLogFormat: config.LogFormats,
+	config.KeyRawMode:   types.RawModes,
+	"strategy":          imageprocessor.Strategies,
+	"metric":            imageprocessor.Metrics,
+	"preset":            imageprocessor.Presets,
+	"group":             calibrationGroupings,
+	"action":            dedupeActions,
+	"format":            searchFormats,
+}
+
+// completionFlag describes a flag for the completion scripts
+type completionFlag struct {
+	name      string
+	usage     string
+	valueName string   // Empty for flags without a value
+	optional  bool     // The value may be left out (--cache-dir)
+	choices   []string // Fixed set of values, if any
+}
+
+// isPath reports whether the flag's value should complete file names
+func (f completionFlag) isPath() bool {
+	return f.valueName == "PATH"
+}
+
+// completionFlags returns the flags of a command in name order
+func completionFlags(cmd *command) []completionFlag {
+	var flags []completionFlag
+	cmd.flags.VisitAll(func(f *flag.Flag) {
+		valueName, usage := flag.UnquoteUsage(f)
+		_, optional := f.Value.(*optionalFlag)
+		if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() && !optional {
+			valueName = ""
+		}
+		flags = append(flags, completionFlag{
+			name:      f.Name,
+			usage:     usage,
+			valueName: valueName,
+			optional:  optional,
+			choices:   flagChoices[f.Name],
+		})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// programName returns the name the completion scripts are registered for
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// handleCompletionCommand writes the completion script for a shell
+func handleCompletionCommand(shell string, commands []*command) {
+	switch shell {
+	case "bash":
+		writeBashCompletion(os.Stdout, commands)
+	case "zsh":
+		writeZshCompletion(os.Stdout, commands)
+	case "fish":
+		writeFishCompletion(os.Stdout, commands)
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported shell: %s (expected %s)\n", shell, strings.Join(completionShells, ", "))
+		signalhandler.Exit(2)
+	}
+}
+
+// shellFunctionName turns the program name into a shell identifier
+func shellFunctionName() string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, programName())
+}
+
+func writeBashCompletion(w io.Writer, commands []*command) {
+	function := shellFunctionName()
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+
+	fmt.Fprintf(w, "# bash completion for %s\n", programName())
+	fmt.Fprintf(w, "# Load with: source <(%s completion bash)\n", programName())
+	fmt.Fprintf(w, "%s() {\n", function)
+	fmt.Fprintf(w, "    # Split the line ourselves so --flag=value stays one word\n")
+	fmt.Fprintf(w, "    local line=${COMP_LINE:0:COMP_POINT}\n")
+	fmt.Fprintf(w, "    local cur=${line##*[[:space:]]}\n")
+	fmt.Fprintf(w, "    local -a words=($line)\n")
+	fmt.Fprintf(w, "    local cword=${#words[@]}\n")
+	fmt.Fprintf(w, "    [[ -n $cur ]] && (( cword-- ))\n\n")
+	fmt.Fprintf(w, "    if (( cword == 1 )); then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    local flags=\"\" args=\"\"\n")
+	fmt.Fprintf(w, "    case \"${words[1]}\" in\n")
+	for _, cmd := range commands {
+		var flagWords []string
+		for _, f := range completionFlags(cmd) {
+			word := flagPrefix(f.name) + f.name
+			if f.valueName != "" && !f.optional {
+				word += "="
+			}
+			flagWords = append(flagWords, word)
+		}
+		fmt.Fprintf(w, "    %s)\n", cmd.name)
+		fmt.Fprintf(w, "        flags=%q\n", strings.Join(flagWords, " "))
+		if len(cmd.argChoices) > 0 {
+			fmt.Fprintf(w, "        args=%q\n", strings.Join(cmd.argChoices, " "))
+		}
+		fmt.Fprintf(w, "        ;;\n")
+	}
+	fmt.Fprintf(w, "    esac\n\n")
+
+	fmt.Fprintf(w, "    # Complete the value of --flag=VALUE; other values fall back to file names\n")
+	fmt.Fprintf(w, "    if [[ $cur == --*=* ]]; then\n")
+	fmt.Fprintf(w, "        local name=${cur%%%%=*} value=${cur#*=} prefix=\"\"\n")
+	fmt.Fprintf(w, "        [[ $COMP_WORDBREAKS == *=* ]] || prefix=\"$name=\"\n")
+	fmt.Fprintf(w, "        case \"$name\" in\n")
+	for _, name := range choiceFlagNames() {
+		fmt.Fprintf(w, "        --%s)\n", name)
+		fmt.Fprintf(w, "            COMPREPLY=($(compgen -P \"$prefix\" -W %q -- \"$value\"))\n", strings.Join(flagChoices[name], " "))
+		fmt.Fprintf(w, "            ;;\n")
+	}
+	fmt.Fprintf(w, "        esac\n")
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+
+	fmt.Fprintf(w, "    if [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "        [[ ${COMPREPLY[0]} == *= ]] && compopt -o nospace\n")
+	fmt.Fprintf(w, "    elif [[ -n $args ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"$args\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "    fi\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", function, programName())
+}
+
+func writeZshCompletion(w io.Writer, commands []*command) {
+	function := shellFunctionName()
+
+	fmt.Fprintf(w, "#compdef %s\n", programName())
+	fmt.Fprintf(w, "# zsh completion for %s\n", programName())
+	fmt.Fprintf(w, "# Load with: source <(%s completion zsh)\n\n", programName())
+	fmt.Fprintf(w, "%s() {\n", function)
+	fmt.Fprintf(w, "    local -a commands\n")
+	fmt.Fprintf(w, "    commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "        %s\n", singleQuote(cmd.name+":"+cmd.summary))
+	}
+	fmt.Fprintf(w, "    )\n\n")
+	fmt.Fprintf(w, "    if (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(w, "        _describe 'command' commands\n")
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    local command=$words[2]\n")
+	fmt.Fprintf(w, "    shift words\n")
+	fmt.Fprintf(w, "    (( CURRENT-- ))\n\n")
+	fmt.Fprintf(w, "    case $command in\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %s)\n", cmd.name)
+		fmt.Fprintf(w, "        _arguments -s")
+		for _, f := range completionFlags(cmd) {
+			fmt.Fprintf(w, " \\\n            %s", singleQuote(zshFlagSpec(f)))
+		}
+		if len(cmd.argChoices) > 0 {
+			fmt.Fprintf(w, " \\\n            %s", singleQuote("1:argument:("+strings.Join(cmd.argChoices, " ")+")"))
+		}
+		fmt.Fprintf(w, "\n        ;;\n")
+	}
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "compdef %s %s\n", function, programName())
+}
+
+// zshFlagSpec returns the _arguments spec for a flag
+func zshFlagSpec(f completionFlag) string {
+	description := "[" + zshEscape(f.usage) + "]"
+	if f.valueName == "" {
+		return flagPrefix(f.name) + f.name + description
+	}
+
+	action := " "
+	switch {
+	case len(f.choices) > 0:
+		action = "(" + strings.Join(f.choices, " ") + ")"
+	case f.isPath():
+		action = "_files"
+	}
+
+	if f.optional {
+		return "--" + f.name + "=-" + description + "::" + f.valueName + ":" + action
+	}
+	return "--" + f.name + "=" + description + ":" + f.valueName + ":" + action
+}
+
+// zshEscape escapes the characters _arguments treats specially in descriptions
+func zshEscape(text string) string {
+	replacer := strings.NewReplacer("[", "\\[", "]", "\\]", ":", "\\:")
+	return replacer.Replace(text)
+}
+
+// singleQuote single-quotes a word for zsh and fish
+func singleQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", "'\\''") + "'"
+}
+
+func writeFishCompletion(w io.Writer, commands []*command) {
+	program := programName()
+
+	fmt.Fprintf(w, "# fish completion for %s\n", program)
+	fmt.Fprintf(w, "# Load with: %s completion fish | source\n", program)
+	fmt.Fprintf(w, "complete -c %s -f\n", program)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", program, cmd.name, singleQuote(cmd.summary))
+	}
+
+	for _, cmd := range commands {
+		condition := singleQuote("__fish_seen_subcommand_from " + cmd.name)
+		for _, f := range completionFlags(cmd) {
+			option := "-l"
+			if flagPrefix(f.name) == "-" {
+				option = "-o" // Single-dash option
+			}
+			line := fmt.Sprintf("complete -c %s -n %s %s %s", program, condition, option, f.name)
+			switch {
+			case len(f.choices) > 0:
+				line += " -x -a " + singleQuote(strings.Join(f.choices, " "))
+			case f.isPath() && !f.optional:
+				line += " -r -F"
+			case f.isPath():
+				line += " -F"
+			case f.valueName != "":
+				line += " -x"
+			}
+			fmt.Fprintf(w, "%s -d %s\n", line, singleQuote(f.usage))
+		}
+		if len(cmd.argChoices) > 0 {
+			fmt.Fprintf(w, "complete -c %s -n %s -a %s\n", program, condition, singleQuote(strings.Join(cmd.argChoices, " ")))
+		}
+	}
+}
+
+// choiceFlagNames returns the flags with a fixed set of values in name order
+func choiceFlagNames() []string {
+	var names []string
+	for name := range flagChoices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
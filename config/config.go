@@ -0,0 +1,406 @@
+// Package config resolves the settings shared by all commands. Each setting
+// is taken from the first of these layers that provides it:
+//
+//  1. command-line flags (--database, --workers, ...)
+//  2. IMAGEFINDER_* environment variables
+//  3. the config file (imagefinder.yaml or imagefinder.toml)
+//  4. built-in defaults
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"imagefinder/logging"
+	"imagefinder/types"
+	"imagefinder/utils"
+)
+
+// Setting names, used as flag names and in error messages
+const (
+	KeyDatabase      = "database"
+	KeyWorkers       = "workers"
+	KeyMaxMemory     = "max-memory"
+	KeyRawWorkers    = "raw-workers"
+	KeyIORetries     = "io-retries"
+	KeyIOSkipAfter   = "io-skip-after"
+	KeyThreshold     = "threshold"
+	KeyInclude       = "include"
+	KeyExclude       = "exclude"
+	KeyRawMode       = "raw-mode"
+	KeyDNGMinPreview = "dng-min-preview"
+	KeyToolPaths     = "tool-paths"
+	KeyDcraw         = "dcraw"
+	KeyExiftool      = "exiftool"
+	KeyTempDir       = "temp-dir"
+	KeyLogFile       = "logfile"
+	KeyLogLevel      = "loglevel"
+	KeyLogFormat     = "log-format"
+	KeyDebug         = "debug"
+	KeyQuiet         = "quiet"
+	KeyVerbose       = "verbose"
+	KeyWebhook       = "webhook"
+	KeyPprof         = "pprof"
+	KeyTrace         = "trace"
+)
+
+// EnvConfigFile names the environment variable that selects the config file
+const EnvConfigFile = "IMAGEFINDER_CONFIG"
+
+// envVars maps each setting to the environment variable that can set it
+var envVars = map[string]string{
+	KeyDatabase:      "IMAGEFINDER_DB",
+	KeyWorkers:       "IMAGEFINDER_WORKERS",
+	KeyMaxMemory:     "IMAGEFINDER_MAX_MEMORY",
+	KeyRawWorkers:    "IMAGEFINDER_RAW_WORKERS",
+	KeyIORetries:     "IMAGEFINDER_IO_RETRIES",
+	KeyIOSkipAfter:   "IMAGEFINDER_IO_SKIP_AFTER",
+	KeyThreshold:     "IMAGEFINDER_THRESHOLD",
+	KeyInclude:       "IMAGEFINDER_INCLUDE",
+	KeyExclude:       "IMAGEFINDER_EXCLUDE",
+	KeyRawMode:       "IMAGEFINDER_RAW_MODE",
+	KeyDNGMinPreview: "IMAGEFINDER_DNG_MIN_PREVIEW",
+	KeyToolPaths:     "IMAGEFINDER_TOOL_PATHS",
+	KeyDcraw:         "IMAGEFINDER_DCRAW",
+	KeyExiftool:      "IMAGEFINDER_EXIFTOOL",
+	KeyTempDir:       "IMAGEFINDER_TEMP_DIR",
+	KeyLogFile:       "IMAGEFINDER_LOGFILE",
+	KeyLogLevel:      "IMAGEFINDER_LOGLEVEL",
+	KeyLogFormat:     "IMAGEFINDER_LOG_FORMAT",
+	KeyDebug:         "IMAGEFINDER_DEBUG",
+	KeyQuiet:         "IMAGEFINDER_QUIET",
+	KeyVerbose:       "IMAGEFINDER_VERBOSE",
+	KeyWebhook:       "IMAGEFINDER_WEBHOOK",
+	KeyPprof:         "IMAGEFINDER_PPROF",
+	KeyTrace:         "IMAGEFINDER_TRACE",
+}
+
+// EnvVar returns the environment variable for a setting
+func EnvVar(key string) string {
+	return envVars[key]
+}
+
+// LogLevels lists the accepted log levels from most to least verbose
+var LogLevels = []string{"debug", "info", "warning", "error"}
+
+// LogFormats lists the accepted log formats
+var LogFormats = []string{logging.FormatText, logging.FormatJSON}
+
+// Settings holds the resolved values of the shared settings
+type Settings struct {
+	Database      string
+	Workers       int   // 0 picks a worker count from the number of CPUs
+	MaxMemory     int64 // Bytes of images decoded at once while scanning; 0 for no limit
+	RawWorkers    int   // RAW files converted at once while scanning; 0 for no separate limit
+	IORetries     int   // Retries of a file that failed to read with an I/O error
+	IOSkipAfter   int   // Files in a row failing with I/O errors before the rest are skipped; 0 never skips
+	Threshold     float64
+	Include       []string
+	Exclude       []string
+	RawMode       types.RawMode // Which image of RAW files is hashed
+	DNGMinPreview int           // Smallest long edge of a DNG preview hashed instead of the RAW data
+	ToolPaths     []string
+	Dcraw         string // dcraw binary to run instead of the one in PATH
+	Exiftool      string // exiftool binary to run instead of the one in PATH
+	TempDir       string // Folder of the run's temporary files; "" for the system's
+	Webhook       string // URL a summary is POSTed to when a scan ends
+	Pprof         string // Address the pprof endpoints are served at; "" for none
+	Trace         string // File an execution trace is written to; "" for none
+	LogFile       string
+	LogLevel      string // Default level, optionally followed by module levels
+	LogFormat     string
+	Debug         bool
+	Quiet         bool // Only errors and requested output are printed
+	Verbose       int  // Console verbosity: 1 for -v, 2 for -vv
+
+	// ConfigFile is the config file that was read, if any
+	ConfigFile string
+
+	// sources records where each setting not left at its default came from
+	sources map[string]string
+}
+
+// defaults returns the built-in value of every setting
+func defaults() map[string]string {
+	return map[string]string{
+		KeyDatabase:      utils.GetDefaultDatabasePath(),
+		KeyWorkers:       "0",
+		KeyMaxMemory:     "0",
+		KeyRawWorkers:    "0",
+		KeyIORetries:     "2",
+		KeyIOSkipAfter:   "10",
+		KeyThreshold:     "0.8",
+		KeyRawMode:       string(types.RawModeAuto),
+		KeyDNGMinPreview: "1024",
+		KeyLogFile:       "imagefinder.log",
+		KeyLogLevel:      "info",
+		KeyLogFormat:     logging.FormatText,
+		KeyDebug:         "false",
+		KeyQuiet:         "false",
+		KeyVerbose:       "0",
+	}
+}
+
+// Load resolves the settings from the parsed command-line flags, the
+// environment and the config file. The config file is the one named by
+// --config, then IMAGEFINDER_CONFIG, then the first found in SearchPaths.
+func Load(args map[string]string) (*Settings, error) {
+	configPath := args["config"]
+	if configPath == "" {
+		configPath = os.Getenv(EnvConfigFile)
+	}
+	file, configPath, err := LoadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	values := defaults()
+	sources := make(map[string]string)
+	overlay := func(layer map[string]string, source func(key string) string) {
+		for key, value := range layer {
+			values[key] = value
+			sources[key] = source(key)
+		}
+	}
+
+	if file != nil {
+		overlay(file.values(), func(string) string { return configPath })
+	}
+	overlay(envValues(), EnvVar)
+	overlay(flagValues(args), flagName(args))
+
+	settings := &Settings{ConfigFile: configPath, sources: sources}
+	if err := settings.parse(values); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// envValues returns the settings given as environment variables
+func envValues() map[string]string {
+	values := make(map[string]string)
+	for key, name := range envVars {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// flagValues returns the settings given on the command line
+func flagValues(args map[string]string) map[string]string {
+	values := make(map[string]string)
+	for key := range envVars {
+		if value, ok := args[key]; ok {
+			values[key] = value
+		}
+	}
+	// --db is an alias for --database
+	if value, ok := args["db"]; ok && value != "" {
+		values[KeyDatabase] = value
+	}
+	// -v and -vv raise the console verbosity
+	for name, verbose := range map[string]string{"v": "1", "vv": "2"} {
+		if args[name] == "true" && values[KeyVerbose] < verbose {
+			values[KeyVerbose] = verbose
+		}
+	}
+	return values
+}
+
+// flagName returns the flag a setting was given with, for error messages
+func flagName(args map[string]string) func(key string) string {
+	return func(key string) string {
+		if key == KeyVerbose {
+			if args["vv"] == "true" {
+				return "-vv"
+			}
+			return "-v"
+		}
+		return "--" + key
+	}
+}
+
+// parse converts the resolved values, naming the layer a bad value came from
+func (s *Settings) parse(values map[string]string) error {
+	invalid := func(key, expected string) error {
+		return fmt.Errorf("invalid %s value '%s' from %s (expected %s)", key, values[key], s.Source(key), expected)
+	}
+
+	s.Database = values[KeyDatabase]
+	s.LogFile = values[KeyLogFile]
+
+	workers, err := strconv.Atoi(values[KeyWorkers])
+	if err != nil || workers < 0 {
+		return invalid(KeyWorkers, "a number of workers, or 0 for automatic")
+	}
+	s.Workers = workers
+
+	maxMemory, err := utils.ParseByteSize(values[KeyMaxMemory])
+	if err != nil {
+		return invalid(KeyMaxMemory, "a size such as 512MB or 4GB, or 0 for no limit")
+	}
+	s.MaxMemory = maxMemory
+
+	rawWorkers, err := strconv.Atoi(values[KeyRawWorkers])
+	if err != nil || rawWorkers < 0 {
+		return invalid(KeyRawWorkers, "a number of workers, or 0 for no separate limit")
+	}
+	s.RawWorkers = rawWorkers
+
+	ioRetries, err := strconv.Atoi(values[KeyIORetries])
+	if err != nil || ioRetries < 0 {
+		return invalid(KeyIORetries, "a number of retries, or 0 for none")
+	}
+	s.IORetries = ioRetries
+
+	ioSkipAfter, err := strconv.Atoi(values[KeyIOSkipAfter])
+	if err != nil || ioSkipAfter < 0 {
+		return invalid(KeyIOSkipAfter, "a number of files, or 0 to never skip")
+	}
+	s.IOSkipAfter = ioSkipAfter
+
+	threshold, err := strconv.ParseFloat(values[KeyThreshold], 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return invalid(KeyThreshold, "0.0-1.0")
+	}
+	s.Threshold = threshold
+
+	s.Webhook = values[KeyWebhook]
+	if s.Webhook != "" {
+		if u, err := url.Parse(s.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid(KeyWebhook, "an http:// or https:// URL")
+		}
+	}
+
+	s.Pprof = values[KeyPprof]
+	if s.Pprof != "" {
+		if _, port, err := net.SplitHostPort(s.Pprof); err != nil || port == "" {
+			return invalid(KeyPprof, "an address such as :6060 or localhost:6060")
+		}
+	}
+	s.Trace = values[KeyTrace]
+
+	s.Include = splitList(values[KeyInclude], ",")
+	s.Exclude = splitList(values[KeyExclude], ",")
+	if s.RawMode, err = types.ParseRawMode(values[KeyRawMode]); err != nil {
+		return invalid(KeyRawMode, strings.Join(types.RawModes, ", "))
+	}
+	dngMinPreview, err := strconv.Atoi(values[KeyDNGMinPreview])
+	if err != nil || dngMinPreview < 0 {
+		return invalid(KeyDNGMinPreview, "a size in pixels, or 0 to accept any preview")
+	}
+	s.DNGMinPreview = dngMinPreview
+	s.ToolPaths = splitList(values[KeyToolPaths], string(os.PathListSeparator))
+	for key, binary := range map[string]*string{KeyDcraw: &s.Dcraw, KeyExiftool: &s.Exiftool} {
+		*binary = values[key]
+		if *binary == "" {
+			continue
+		}
+		if _, err := exec.LookPath(*binary); err != nil {
+			return invalid(key, "an executable file")
+		}
+	}
+
+	s.TempDir = values[KeyTempDir]
+
+	s.LogLevel = strings.ToLower(values[KeyLogLevel])
+	levels, err := logging.ParseLevelSpec(s.LogLevel)
+	if err != nil {
+		return invalid(KeyLogLevel, strings.Join(LogLevels, ", ")+", optionally followed by module=level pairs")
+	}
+
+	s.LogFormat = strings.ToLower(values[KeyLogFormat])
+	if s.LogFormat != logging.FormatText && s.LogFormat != logging.FormatJSON {
+		return invalid(KeyLogFormat, strings.Join(LogFormats, " or "))
+	}
+
+	debug, err := strconv.ParseBool(values[KeyDebug])
+	if err != nil {
+		return invalid(KeyDebug, "true or false")
+	}
+	// The debug log level turns on debug logging, and debug mode logs
+	// everything unless a level was chosen
+	s.Debug = debug || levels.Default == logging.LevelDebug
+	if s.Debug && !s.IsSet(KeyLogLevel) {
+		s.LogLevel = "debug"
+	}
+
+	quiet, err := strconv.ParseBool(values[KeyQuiet])
+	if err != nil {
+		return invalid(KeyQuiet, "true or false")
+	}
+	s.Quiet = quiet
+
+	verbose, err := strconv.Atoi(values[KeyVerbose])
+	if err != nil || verbose < 0 || verbose > 2 {
+		return invalid(KeyVerbose, "0, 1 or 2")
+	}
+	s.Verbose = verbose
+	if s.Quiet && s.Verbose > 0 {
+		return fmt.Errorf("%s from %s conflicts with %s from %s", KeyQuiet, s.Source(KeyQuiet), KeyVerbose, s.Source(KeyVerbose))
+	}
+	// -vv shows debug messages, so log them unless a level was chosen
+	if s.Verbose >= 2 && !s.IsSet(KeyLogLevel) {
+		s.LogLevel = "debug"
+	}
+
+	return nil
+}
+
+// Source returns where a setting's value came from: a flag, an environment
+// variable, the config file, or "default"
+func (s *Settings) Source(key string) string {
+	if source, ok := s.sources[key]; ok {
+		return source
+	}
+	return "default"
+}
+
+// IsSet reports whether a setting was given rather than left at its default
+func (s *Settings) IsSet(key string) bool {
+	_, ok := s.sources[key]
+	return ok
+}
+
+// ApplyToolPaths puts the configured tool directories ahead of PATH so
+// external tools (dcraw, exiftool, ...) are found there first
+func (s *Settings) ApplyToolPaths() {
+	if len(s.ToolPaths) == 0 {
+		return
+	}
+
+	searchPath := strings.Join(s.ToolPaths, string(os.PathListSeparator))
+	if current := os.Getenv("PATH"); current != "" {
+		searchPath += string(os.PathListSeparator) + current
+	}
+	os.Setenv("PATH", searchPath)
+}
+
+// ToolBinaries returns the external tools given a binary, keyed by tool name
+func (s *Settings) ToolBinaries() map[string]string {
+	binaries := make(map[string]string)
+	if s.Dcraw != "" {
+		binaries["dcraw"] = s.Dcraw
+	}
+	if s.Exiftool != "" {
+		binaries["exiftool"] = s.Exiftool
+	}
+	return binaries
+}
+
+// splitList splits a separated list, dropping empty items
+func splitList(value string, separator string) []string {
+	var items []string
+	for _, item := range strings.Split(value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// File holds the settings read from an imagefinder.yaml (or .toml) file
+type File struct {
+	Database      string   `yaml:"database" toml:"database"`
+	Workers       int      `yaml:"workers" toml:"workers"`
+	MaxMemory     string   `yaml:"max_memory" toml:"max_memory"`
+	RawWorkers    int      `yaml:"raw_workers" toml:"raw_workers"`
+	IORetries     *int     `yaml:"io_retries" toml:"io_retries"` // nil when absent, as 0 turns retries off
+	IOSkipAfter   *int     `yaml:"io_skip_after" toml:"io_skip_after"`
+	Threshold     float64  `yaml:"threshold" toml:"threshold"`
+	Include       []string `yaml:"include" toml:"include"`
+	Exclude       []string `yaml:"exclude" toml:"exclude"`
+	RawMode       string   `yaml:"raw_mode" toml:"raw_mode"`
+	DNGMinPreview int      `yaml:"dng_min_preview" toml:"dng_min_preview"`
+	ToolPaths     []string `yaml:"tool_paths" toml:"tool_paths"`
+	Dcraw         string   `yaml:"dcraw" toml:"dcraw"`
+	Exiftool      string   `yaml:"exiftool" toml:"exiftool"`
+	TempDir       string   `yaml:"temp_dir" toml:"temp_dir"`
+	LogFile       string   `yaml:"logfile" toml:"logfile"`
+	LogLevel      string   `yaml:"loglevel" toml:"loglevel"`
+	LogFormat     string   `yaml:"log_format" toml:"log_format"`
+	Debug         bool     `yaml:"debug" toml:"debug"`
+	Quiet         bool     `yaml:"quiet" toml:"quiet"`
+	Verbose       int      `yaml:"verbose" toml:"verbose"`
+	Webhook       string   `yaml:"webhook" toml:"webhook"`
+	Pprof         string   `yaml:"pprof" toml:"pprof"`
+	Trace         string   `yaml:"trace" toml:"trace"`
+}
+
+// fileNames lists the file names looked for in each config directory
+var fileNames = []string{"imagefinder.yaml", "imagefinder.yml", "imagefinder.toml"}
+
+// SearchPaths returns the locations checked for a config file, in order:
+// the working directory, then ~/.config/imagefinder/ (or $XDG_CONFIG_HOME)
+func SearchPaths() []string {
+	dirs := []string{"."}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		dirs = append(dirs, filepath.Join(configHome, "imagefinder"))
+	}
+
+	var paths []string
+	for _, dir := range dirs {
+		for _, name := range fileNames {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths
+}
+
+// LoadFile reads the config file at path, or the first one found in
+// SearchPaths when path is empty. It returns a nil File and no error when no
+// config file exists.
+func LoadFile(path string) (*File, string, error) {
+	if path == "" {
+		for _, candidate := range SearchPaths() {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				path = candidate
+				break
+			}
+		}
+		if path == "" {
+			return nil, "", nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, path, fmt.Errorf("cannot read config file %s: %v", path, err)
+	}
+
+	file := &File{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		meta, err := toml.Decode(string(data), file)
+		if err != nil {
+			return nil, path, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, path, fmt.Errorf("unknown setting '%s' in config file %s", undecoded[0], path)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true) // Report misspelled settings instead of ignoring them
+		if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, path, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+	}
+
+	return file, path, nil
+}
+
+// values returns the settings present in the file, keyed like the flags
+func (f *File) values() map[string]string {
+	values := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			values[key] = value
+		}
+	}
+
+	set(KeyDatabase, f.Database)
+	if f.Workers != 0 {
+		set(KeyWorkers, strconv.Itoa(f.Workers))
+	}
+	if f.Threshold != 0 {
+		set(KeyThreshold, strconv.FormatFloat(f.Threshold, 'f', -1, 64))
+	}
+	set(KeyInclude, strings.Join(f.Include, ","))
+	set(KeyMaxMemory, f.MaxMemory)
+	if f.RawWorkers != 0 {
+		set(KeyRawWorkers, strconv.Itoa(f.RawWorkers))
+	}
+	if f.IORetries != nil {
+		set(KeyIORetries, strconv.Itoa(*f.IORetries))
+	}
+	if f.IOSkipAfter != nil {
+		set(KeyIOSkipAfter, strconv.Itoa(*f.IOSkipAfter))
+	}
+	set(KeyExclude, strings.Join(f.Exclude, ","))
+	set(KeyRawMode, f.RawMode)
+	if f.DNGMinPreview != 0 {
+		set(KeyDNGMinPreview, strconv.Itoa(f.DNGMinPreview))
+	}
+	set(KeyToolPaths, strings.Join(f.ToolPaths, string(os.PathListSeparator)))
+	set(KeyDcraw, f.Dcraw)
+	set(KeyExiftool, f.Exiftool)
+	set(KeyTempDir, f.TempDir)
+	set(KeyLogFile, f.LogFile)
+	set(KeyLogLevel, f.LogLevel)
+	set(KeyLogFormat, f.LogFormat)
+	if f.Debug {
+		set(KeyDebug, "true")
+	}
+	if f.Quiet {
+		set(KeyQuiet, "true")
+	}
+	if f.Verbose != 0 {
+		set(KeyVerbose, strconv.Itoa(f.Verbose))
+	}
+	set(KeyWebhook, f.Webhook)
+	set(KeyPprof, f.Pprof)
+	set(KeyTrace, f.Trace)
+	return values
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CollectionImage is an image added to a collection
+type CollectionImage struct {
+	Path         string
+	SourcePrefix string
+}
+
+// CollectionInfo is a collection and the number of images in it
+type CollectionInfo struct {
+	Name      string
+	CreatedAt string
+	Images    int
+}
+
+// createCollectionTables creates the tables of named collections. Like tags,
+// members are stored by path so they survive rescans.
+func createCollectionTables(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS collections (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		created_at TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS collection_images (
+		collection_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		source_prefix TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY(collection_id, path, source_prefix)
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating collection tables: %v", err)
+	}
+	return nil
+}
+
+// AddToCollection adds images to a collection, creating the collection if it
+// doesn't exist. Images already in it are left as they are. It returns the
+// number of images added.
+func AddToCollection(ctx context.Context, db *sql.DB, name string, images []CollectionImage) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO collections (name, created_at) VALUES (?, ?)",
+		name, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("cannot create collection %s: %v", name, err)
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM collections WHERE name = ?", name).Scan(&id); err != nil {
+		return 0, fmt.Errorf("cannot find collection %s: %v", name, err)
+	}
+
+	added := 0
+	for _, image := range images {
+		result, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO collection_images (collection_id, path, source_prefix) VALUES (?, ?, ?)",
+			id, image.Path, image.SourcePrefix)
+		if err != nil {
+			return added, fmt.Errorf("cannot add %s to collection %s: %v", image.Path, name, err)
+		}
+		if count, err := result.RowsAffected(); err == nil {
+			added += int(count)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot save collection %s: %v", name, err)
+	}
+	return added, nil
+}
+
+// ListCollections returns every collection with the number of images in it
+func ListCollections(ctx context.Context, db *sql.DB) ([]CollectionInfo, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, created_at,
+		(SELECT COUNT(*) FROM collection_images WHERE collection_id = collections.id)
+		FROM collections ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collections: %v", err)
+	}
+	defer rows.Close()
+
+	var collections []CollectionInfo
+	for rows.Next() {
+		var info CollectionInfo
+		if err := rows.Scan(&info.Name, &info.CreatedAt, &info.Images); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		collections = append(collections, info)
+	}
+	return collections, rows.Err()
+}
+
+// CollectionExists reports whether there is a collection of that name
+func CollectionExists(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM collections WHERE name = ?", name).Scan(&count); err != nil {
+		return false, fmt.Errorf("cannot find collection %s: %v", name, err)
+	}
+	return count > 0, nil
+}
+
+// CollectionImages returns the images in a collection. It reports false when
+// there is no collection of that name.
+func CollectionImages(ctx context.Context, db *sql.DB, name string) ([]CollectionImage, bool, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, "SELECT id FROM collections WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot find collection %s: %v", name, err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT path, source_prefix FROM collection_images WHERE collection_id = ? ORDER BY path, source_prefix", id)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to query collection %s: %v", name, err)
+	}
+	defer rows.Close()
+
+	var images []CollectionImage
+	for rows.Next() {
+		var image CollectionImage
+		if err := rows.Scan(&image.Path, &image.SourcePrefix); err != nil {
+			return nil, true, fmt.Errorf("error scanning row: %v", err)
+		}
+		images = append(images, image)
+	}
+	return images, true, rows.Err()
+}
+
+// DeleteCollection removes a collection, leaving its images in the index. It
+// reports false when there is no collection of that name.
+func DeleteCollection(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("cannot start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM collection_images WHERE collection_id IN (SELECT id FROM collections WHERE name = ?)", name)
+	if err != nil {
+		return false, fmt.Errorf("cannot delete collection %s: %v", name, err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM collections WHERE name = ?", name)
+	if err != nil {
+		return false, fmt.Errorf("cannot delete collection %s: %v", name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("cannot delete collection %s: %v", name, err)
+	}
+
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// collectionCondition is the SQL condition that an images row is in the named
+// collection
+const collectionCondition = `EXISTS (SELECT 1 FROM collection_images member
+	JOIN collections ON collections.id = member.collection_id
+	WHERE collections.name = ? AND member.path = images.path
+	AND member.source_prefix = COALESCE(images.source_prefix, ''))`
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"imagefinder/types"
+)
+
+// Conversion is what decoding a file produced: the size of the image and its
+// hashes. It is cached by file content and loader, so identical files found
+// under another prefix or rescanned aren't decoded again.
+type Conversion struct {
+	Width          int
+	Height         int
+	AverageHash    types.Hash
+	PerceptualHash types.Hash
+}
+
+// createConversionsTable creates the table of cached conversions, keyed by
+// the SHA-256 of the file and the identity of the loader that decoded it
+func createConversionsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS conversions (
+		checksum TEXT NOT NULL,
+		loader TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		average_hash TEXT NOT NULL,
+		perceptual_hash TEXT NOT NULL,
+		converted_at TEXT NOT NULL,
+		PRIMARY KEY(checksum, loader)
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating conversions table: %v", err)
+	}
+	return nil
+}
+
+// LookupConversion returns the cached conversion of the file with checksum
+// by loader, reporting false when there is none
+func LookupConversion(ctx context.Context, db *sql.DB, checksum string, loader string) (Conversion, bool, error) {
+	var conversion Conversion
+	var avgHash, pHash string
+	err := db.QueryRowContext(ctx, `SELECT width, height, average_hash, perceptual_hash FROM conversions
+		WHERE checksum = ? AND loader = ?`, checksum, loader).Scan(&conversion.Width, &conversion.Height, &avgHash, &pHash)
+	if err == sql.ErrNoRows {
+		return conversion, false, nil
+	}
+	if err != nil {
+		return conversion, false, fmt.Errorf("cannot read cached conversion: %v", err)
+	}
+	if conversion.AverageHash, err = types.ParseHash(avgHash); err != nil {
+		return conversion, false, nil
+	}
+	if conversion.PerceptualHash, err = types.ParseHash(pHash); err != nil {
+		return conversion, false, nil
+	}
+	return conversion, true, nil
+}
+
+// StoreConversion caches the conversion of the file with checksum by loader
+func StoreConversion(ctx context.Context, db *sql.DB, checksum string, loader string, conversion Conversion) error {
+	_, err := db.ExecContext(ctx, `INSERT OR REPLACE INTO conversions
+		(checksum, loader, width, height, average_hash, perceptual_hash, converted_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		checksum, loader, conversion.Width, conversion.Height, conversion.AverageHash.String(), conversion.PerceptualHash.String(),
+		time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("cannot cache conversion of %s: %v", checksum, err)
+	}
+	return nil
+}
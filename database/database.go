@@ -1,8 +1,12 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"imagefinder/logging"
@@ -32,7 +36,22 @@ func InitDatabase(dbPath string) (*sql.DB, error) {
 		size INTEGER,
 		average_hash TEXT,
 		perceptual_hash TEXT,
+		average_hash_bits INTEGER,
+		perceptual_hash_bits INTEGER,
+		phash_band0 INTEGER,
+		phash_band1 INTEGER,
+		phash_band2 INTEGER,
+		phash_band3 INTEGER,
 		features BLOB,
+		gps_latitude REAL,
+		gps_longitude REAL,
+		captured_at INTEGER,
+		camera_make TEXT,
+		camera_model TEXT,
+		rating INTEGER,
+		label TEXT,
+		keywords TEXT,
+		sha256 TEXT,
 		UNIQUE(path, source_prefix)
 	);
 	CREATE INDEX IF NOT EXISTS idx_path ON images(path);
@@ -81,18 +100,313 @@ func InitDatabase(dbPath string) (*sql.DB, error) {
 		logging.DebugLog("Note: To fully update schema, consider rebuilding the database.")
 	}
 
+	// Add GPS columns to databases created before geotag support
+	if _, err := ensureColumn(db, "gps_latitude", "REAL"); err != nil {
+		return nil, err
+	}
+	if _, err := ensureColumn(db, "gps_longitude", "REAL"); err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_gps ON images(gps_latitude, gps_longitude);")
+	if err != nil {
+		return nil, fmt.Errorf("error creating GPS index: %v", err)
+	}
+
+	// Add capture date column, seeding existing rows from their modification time
+	added, err := ensureColumn(db, "captured_at", "INTEGER")
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		_, err = db.Exec("UPDATE images SET captured_at = CAST(strftime('%s', modified_at) AS INTEGER) WHERE modified_at IS NOT NULL AND modified_at != '';")
+		if err != nil {
+			return nil, fmt.Errorf("error backfilling captured_at column: %v", err)
+		}
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_captured_at ON images(captured_at);")
+	if err != nil {
+		return nil, fmt.Errorf("error creating capture date index: %v", err)
+	}
+
+	// Add camera columns for filtering by the body that took the photo
+	if _, err := ensureColumn(db, "camera_make", "TEXT"); err != nil {
+		return nil, err
+	}
+	if _, err := ensureColumn(db, "camera_model", "TEXT"); err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_camera_model ON images(camera_model);")
+	if err != nil {
+		return nil, fmt.Errorf("error creating camera model index: %v", err)
+	}
+
+	// Add the columns read from XMP sidecars; keywords are a JSON array
+	for _, column := range []string{"rating INTEGER", "label TEXT", "keywords TEXT"} {
+		name, definition, _ := strings.Cut(column, " ")
+		if _, err := ensureColumn(db, name, definition); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_rating ON images(rating);")
+	if err != nil {
+		return nil, fmt.Errorf("error creating rating index: %v", err)
+	}
+
+	// Add the color profile and bit depth, to tell masters from exports
+	for _, column := range []string{"color_profile TEXT", "bit_depth INTEGER"} {
+		name, definition, _ := strings.Cut(column, " ")
+		if _, err := ensureColumn(db, name, definition); err != nil {
+			return nil, err
+		}
+	}
+
+	// Add integer hash columns so searches compare hashes without decoding hex
+	averageAdded, err := ensureColumn(db, "average_hash_bits", "INTEGER")
+	if err != nil {
+		return nil, err
+	}
+	perceptualAdded, err := ensureColumn(db, "perceptual_hash_bits", "INTEGER")
+	if err != nil {
+		return nil, err
+	}
+	if averageAdded || perceptualAdded {
+		if err := backfillHashBits(db); err != nil {
+			return nil, err
+		}
+	}
+
+	// Add the pHash band columns used to prefilter search candidates
+	bandsAdded := false
+	for band := 0; band < HashBands; band++ {
+		added, err := ensureColumn(db, bandColumn(band), "INTEGER")
+		if err != nil {
+			return nil, err
+		}
+		bandsAdded = bandsAdded || added
+	}
+	if bandsAdded {
+		var assignments []string
+		for band := 0; band < HashBands; band++ {
+			shift := types.HashBits - (band+1)*bandBits
+			assignments = append(assignments, fmt.Sprintf("%s = (perceptual_hash_bits >> %d) & %d", bandColumn(band), shift, 1<<bandBits-1))
+		}
+		_, err = db.Exec("UPDATE images SET " + strings.Join(assignments, ", ") + " WHERE perceptual_hash_bits IS NOT NULL;")
+		if err != nil {
+			return nil, fmt.Errorf("error backfilling hash band columns: %v", err)
+		}
+	}
+	for band := 0; band < HashBands; band++ {
+		_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s ON images(%s);", bandColumn(band), bandColumn(band)))
+		if err != nil {
+			return nil, fmt.Errorf("error creating hash band index: %v", err)
+		}
+	}
+
+	// Content checksums let a rescan recognize moved and renamed files
+	if _, err := ensureColumn(db, "sha256", "TEXT"); err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_sha256 ON images(sha256);")
+	if err != nil {
+		return nil, fmt.Errorf("error creating checksum index: %v", err)
+	}
+
+	// Face counts come from scan --faces; the boxes are kept in the features JSON
+	if _, err := ensureColumn(db, "face_count", "INTEGER"); err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_face_count ON images(face_count);")
+	if err != nil {
+		return nil, fmt.Errorf("error creating face count index: %v", err)
+	}
+
+	// Thumbnails live in their own table so candidate queries don't page through image data
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS thumbnails (
+		path TEXT NOT NULL,
+		source_prefix TEXT NOT NULL DEFAULT '',
+		data BLOB NOT NULL,
+		PRIMARY KEY(path, source_prefix)
+	);`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating thumbnails table: %v", err)
+	}
+
+	// Scan sessions let an interrupted scan pick up where it stopped
+	if err := createSessionTables(db); err != nil {
+		return nil, err
+	}
+
+	if err := createTagsTable(db); err != nil {
+		return nil, err
+	}
+
+	if err := createCollectionTables(db); err != nil {
+		return nil, err
+	}
+
+	// Folder times let incremental scans skip folders that didn't change
+	if err := createFoldersTable(db); err != nil {
+		return nil, err
+	}
+
+	// Failed files are kept so they aren't lost with the log
+	if err := createErrorsTable(db); err != nil {
+		return nil, err
+	}
+
+	// Conversions spare decoding files whose content was hashed before
+	if err := createConversionsTable(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// backfillHashBits fills the integer hash columns from the hex hashes of rows
+// stored before they existed. Rows with unreadable hashes are left out of
+// searches.
+func backfillHashBits(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, COALESCE(average_hash, ''), COALESCE(perceptual_hash, '') FROM images
+		WHERE average_hash_bits IS NULL OR perceptual_hash_bits IS NULL`)
+	if err != nil {
+		return fmt.Errorf("error reading hashes to backfill: %v", err)
+	}
+
+	type hashRow struct {
+		id                  int64
+		average, perceptual types.Hash
+	}
+	var pending []hashRow
+	for rows.Next() {
+		var id int64
+		var averageHex, perceptualHex string
+		if err := rows.Scan(&id, &averageHex, &perceptualHex); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		average, err := types.ParseHash(averageHex)
+		if err != nil {
+			logging.LogWarning("Skipping image %d: %v", id, err)
+			continue
+		}
+		perceptual, err := types.ParseHash(perceptualHex)
+		if err != nil {
+			logging.LogWarning("Skipping image %d: %v", id, err)
+			continue
+		}
+		pending = append(pending, hashRow{id, average, perceptual})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading hashes to backfill: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error backfilling hash columns: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, row := range pending {
+		_, err := tx.Exec("UPDATE images SET average_hash_bits = ?, perceptual_hash_bits = ? WHERE id = ?",
+			hashValue(row.average), hashValue(row.perceptual), row.id)
+		if err != nil {
+			return fmt.Errorf("error backfilling hash columns: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error backfilling hash columns: %v", err)
+	}
+	logging.DebugLog("Backfilled integer hashes for %d images", len(pending))
+	return nil
+}
+
+// hashValue returns the hash as SQLite stores it. SQLite integers are signed,
+// so the bit pattern is kept and hashes above 2^63 read back as negative.
+func hashValue(hash types.Hash) int64 {
+	return int64(hash)
+}
+
+// HashBands is the number of pHash bands stored for prefiltering. Two hashes
+// that differ in fewer bits than there are bands always share a band.
+const HashBands = 4
+
+// bandBits is the width of each band
+const bandBits = types.HashBits / HashBands
+
+// bandColumn returns the name of the column holding a pHash band
+func bandColumn(band int) string {
+	return fmt.Sprintf("phash_band%d", band)
+}
+
+// HashBand returns one band of a hash, counting from the most significant bits
+func HashBand(hash types.Hash, band int) int64 {
+	shift := types.HashBits - (band+1)*bandBits
+	return int64(hash>>shift) & (1<<bandBits - 1)
+}
+
+// SharesBand reports whether two hashes are equal in at least one band, the
+// check the SQL prefilter makes with the band indexes
+func SharesBand(a, b types.Hash) bool {
+	for band := 0; band < HashBands; band++ {
+		if HashBand(a, band) == HashBand(b, band) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashBands splits a hash into bands as query arguments
+func hashBands(hash types.Hash) []interface{} {
+	bands := make([]interface{}, HashBands)
+	for band := range bands {
+		bands[band] = HashBand(hash, band)
+	}
+	return bands
+}
+
+// ensureColumn adds a column to the images table if it doesn't exist yet.
+// It reports whether the column had to be added.
+func ensureColumn(db *sql.DB, column string, definition string) (bool, error) {
+	return ensureTableColumn(db, "images", column, definition)
+}
+
+// ensureTableColumn adds a column to a table if it doesn't exist yet. It
+// reports whether the column had to be added.
+func ensureTableColumn(db *sql.DB, table string, column string, definition string) (bool, error) {
+	var hasColumn bool
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column).Scan(&hasColumn)
+	if err != nil {
+		return false, fmt.Errorf("error checking for %s column: %v", column, err)
+	}
+
+	if hasColumn {
+		return false, nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("error adding %s column: %v", column, err)
+	}
+	logging.DebugLog("Added '%s' column to existing %s table", column, table)
+
+	return true, nil
+}
+
 // OpenDatabase opens an existing database connection
 func OpenDatabase(dbPath string) (*sql.DB, error) {
 	return sql.Open("sqlite3", dbPath)
 }
 
 // CheckImageExists checks if an image already exists in the database
-func CheckImageExists(db *sql.DB, path string, sourcePrefix string) (bool, string, error) {
+func CheckImageExists(ctx context.Context, db *sql.DB, path string, sourcePrefix string) (bool, string, error) {
 	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM images WHERE path = ? AND source_prefix = ?", path, sourcePrefix).Scan(&count)
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM images WHERE path = ? AND source_prefix = ?", path, sourcePrefix).Scan(&count)
 	if err != nil {
 		return false, "", fmt.Errorf("database error for %s: %v", path, err)
 	}
@@ -103,7 +417,7 @@ func CheckImageExists(db *sql.DB, path string, sourcePrefix string) (bool, strin
 
 	// Get the stored modification time
 	var storedModTime string
-	err = db.QueryRow("SELECT modified_at FROM images WHERE path = ? AND source_prefix = ?", path, sourcePrefix).Scan(&storedModTime)
+	err = db.QueryRowContext(ctx, "SELECT modified_at FROM images WHERE path = ? AND source_prefix = ?", path, sourcePrefix).Scan(&storedModTime)
 	if err != nil {
 		return true, "", fmt.Errorf("cannot get modified time for %s: %v", path, err)
 	}
@@ -112,27 +426,83 @@ func CheckImageExists(db *sql.DB, path string, sourcePrefix string) (bool, strin
 }
 
 // StoreImageInfo stores image information in the database
-func StoreImageInfo(db *sql.DB, imageInfo types.ImageInfo, forceRewrite bool) error {
-	// Store in database
-	now := time.Now().Format(time.RFC3339)
+func StoreImageInfo(ctx context.Context, db *sql.DB, imageInfo types.ImageInfo, forceRewrite bool) error {
+	return storeImageInfo(ctx, db, imageInfo, time.Now().Format(time.RFC3339), forceRewrite)
+}
+
+// ImportImageInfo stores an image record read from an export, keeping its original created_at
+func ImportImageInfo(ctx context.Context, db *sql.DB, imageInfo types.ImageInfo, forceRewrite bool) error {
+	var err error
+	if imageInfo.ModifiedAt == "" {
+		// Exported images that were seeded but never scanned have no hashes to import
+		err = SeedImage(ctx, db, imageInfo)
+	} else {
+		createdAt := imageInfo.CreatedAt
+		if createdAt == "" {
+			createdAt = time.Now().Format(time.RFC3339)
+		}
+		err = storeImageInfo(ctx, db, imageInfo, createdAt, forceRewrite)
+	}
+	if err != nil {
+		return err
+	}
 
+	if imageInfo.FaceCount != nil && imageInfo.ModifiedAt != "" {
+		if err := StoreFaces(ctx, db, imageInfo.Path, imageInfo.SourcePrefix, imageInfo.Faces); err != nil {
+			return err
+		}
+	}
+	if len(imageInfo.Colors) > 0 && imageInfo.ModifiedAt != "" {
+		if err := StoreColors(ctx, db, imageInfo.Path, imageInfo.SourcePrefix, imageInfo.Colors); err != nil {
+			return err
+		}
+	}
+
+	return AddTags(ctx, db, imageInfo.Path, imageInfo.SourcePrefix, imageInfo.Tags)
+}
+
+// storeImageInfo inserts the image row using the given creation timestamp
+func storeImageInfo(ctx context.Context, db *sql.DB, imageInfo types.ImageInfo, createdAt string, forceRewrite bool) error {
 	// Prepare statement to avoid SQL injection
 	var stmt *sql.Stmt
 	var insertErr error
 
 	if forceRewrite {
 		// Always use INSERT OR REPLACE when force rewrite is enabled
-		stmt, insertErr = db.Prepare(`
+		stmt, insertErr = db.PrepareContext(ctx, `
 			INSERT OR REPLACE INTO images (
-				path, source_prefix, format, width, height, created_at, modified_at, size, average_hash, perceptual_hash
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				path, source_prefix, format, width, height, created_at, modified_at, size, average_hash, perceptual_hash,
+				average_hash_bits, perceptual_hash_bits, phash_band0, phash_band1, phash_band2, phash_band3,
+				gps_latitude, gps_longitude, captured_at, camera_make, camera_model, rating, label, keywords, sha256,
+				color_profile, bit_depth
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`)
 	} else {
-		// Use conditional insert or update
-		stmt, insertErr = db.Prepare(`
-			INSERT OR IGNORE INTO images (
-				path, source_prefix, format, width, height, created_at, modified_at, size, average_hash, perceptual_hash
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		// Insert new images and fill in seeded ones that have no hashes yet,
+		// keeping the metadata they were seeded with
+		stmt, insertErr = db.PrepareContext(ctx, `
+			INSERT INTO images (
+				path, source_prefix, format, width, height, created_at, modified_at, size, average_hash, perceptual_hash,
+				average_hash_bits, perceptual_hash_bits, phash_band0, phash_band1, phash_band2, phash_band3,
+				gps_latitude, gps_longitude, captured_at, camera_make, camera_model, rating, label, keywords, sha256,
+				color_profile, bit_depth
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(path, source_prefix) DO UPDATE SET
+				format = excluded.format, width = excluded.width, height = excluded.height,
+				modified_at = excluded.modified_at, size = excluded.size,
+				average_hash = excluded.average_hash, perceptual_hash = excluded.perceptual_hash,
+				average_hash_bits = excluded.average_hash_bits, perceptual_hash_bits = excluded.perceptual_hash_bits,
+				phash_band0 = excluded.phash_band0, phash_band1 = excluded.phash_band1,
+				phash_band2 = excluded.phash_band2, phash_band3 = excluded.phash_band3,
+				gps_latitude = excluded.gps_latitude, gps_longitude = excluded.gps_longitude,
+				captured_at = COALESCE(images.captured_at, excluded.captured_at),
+				camera_make = excluded.camera_make, camera_model = excluded.camera_model,
+				rating = COALESCE(images.rating, excluded.rating),
+				label = COALESCE(NULLIF(images.label, ''), excluded.label),
+				keywords = COALESCE(images.keywords, excluded.keywords),
+				sha256 = excluded.sha256,
+				color_profile = excluded.color_profile, bit_depth = excluded.bit_depth
+			WHERE images.average_hash_bits IS NULL
 		`)
 	}
 
@@ -141,19 +511,37 @@ func StoreImageInfo(db *sql.DB, imageInfo types.ImageInfo, forceRewrite bool) er
 	}
 	defer stmt.Close()
 
-	_, err := stmt.Exec(
+	args := []interface{}{
 		imageInfo.Path,
 		imageInfo.SourcePrefix,
 		imageInfo.Format,
 		imageInfo.Width,
 		imageInfo.Height,
-		now,
+		createdAt,
 		imageInfo.ModifiedAt,
 		imageInfo.Size,
-		imageInfo.AverageHash,
-		imageInfo.PerceptualHash,
+		imageInfo.AverageHash.String(),
+		imageInfo.PerceptualHash.String(),
+		hashValue(imageInfo.AverageHash),
+		hashValue(imageInfo.PerceptualHash),
+	}
+	args = append(args, hashBands(imageInfo.PerceptualHash)...)
+	args = append(args,
+		imageInfo.Latitude,
+		imageInfo.Longitude,
+		captureTimestamp(imageInfo),
+		imageInfo.CameraMake,
+		imageInfo.CameraModel,
+		nullIfZero(imageInfo.Rating),
+		imageInfo.Label,
+		keywordsValue(imageInfo.Keywords),
+		nullIfEmpty(imageInfo.Checksum),
+		nullIfEmpty(imageInfo.ColorProfile),
+		nullIfZero(imageInfo.BitDepth),
 	)
 
+	_, err := stmt.ExecContext(ctx, args...)
+
 	if err != nil {
 		return fmt.Errorf("cannot insert data for %s: %v", imageInfo.Path, err)
 	}
@@ -161,33 +549,538 @@ func StoreImageInfo(db *sql.DB, imageInfo types.ImageInfo, forceRewrite bool) er
 	return nil
 }
 
-// QueryPotentialMatches retrieves potential image matches based on source prefix
-func QueryPotentialMatches(db *sql.DB, sourcePrefix string) (*sql.Rows, error) {
-	var query string
+// SeedImage records an image known from another catalog before it is
+// scanned. The row has no hashes and an empty modified_at, so the next scan
+// of its folder hashes it. Seeding an image that is already indexed only
+// updates its capture date, rating, label and keywords.
+func SeedImage(ctx context.Context, db *sql.DB, imageInfo types.ImageInfo) error {
+	var capturedAt interface{}
+	if t, err := time.Parse(time.RFC3339, imageInfo.CapturedAt); err == nil {
+		capturedAt = t.Unix()
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO images (path, source_prefix, format, created_at, modified_at, captured_at, rating, label, keywords)
+		VALUES (?, ?, ?, ?, '', ?, ?, ?, ?)
+		ON CONFLICT(path, source_prefix) DO UPDATE SET
+			captured_at = COALESCE(excluded.captured_at, images.captured_at),
+			rating = COALESCE(excluded.rating, images.rating),
+			label = COALESCE(NULLIF(excluded.label, ''), images.label),
+			keywords = COALESCE(excluded.keywords, images.keywords)
+	`, imageInfo.Path, imageInfo.SourcePrefix, imageInfo.Format, time.Now().Format(time.RFC3339),
+		capturedAt, nullIfZero(imageInfo.Rating), imageInfo.Label, keywordsValue(imageInfo.Keywords))
+	if err != nil {
+		return fmt.Errorf("cannot seed %s: %v", imageInfo.Path, err)
+	}
+	return nil
+}
+
+// nullIfZero stores an unset number as NULL
+func nullIfZero(value int) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// nullIfEmpty stores an unset string as NULL
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// keywordsValue stores keywords as a JSON array, or NULL when there are none
+func keywordsValue(keywords []string) interface{} {
+	if len(keywords) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(keywords)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
+// captureTimestamp returns the capture time as Unix seconds, falling back to the
+// file modification time when the image carries no capture date
+func captureTimestamp(imageInfo types.ImageInfo) interface{} {
+	for _, value := range []string{imageInfo.CapturedAt, imageInfo.ModifiedAt} {
+		if value == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t.Unix()
+		}
+	}
+	return nil
+}
+
+// DeleteImage removes an image and its thumbnail from the index
+func DeleteImage(ctx context.Context, db *sql.DB, path string, sourcePrefix string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM images WHERE path = ? AND COALESCE(source_prefix, '') = ?", path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot remove %s from the index: %v", path, err)
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM thumbnails WHERE path = ? AND source_prefix = ?", path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot remove thumbnail of %s: %v", path, err)
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM tags WHERE path = ? AND source_prefix = ?", path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot remove tags of %s: %v", path, err)
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM collection_images WHERE path = ? AND source_prefix = ?", path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot remove %s from collections: %v", path, err)
+	}
+	return nil
+}
+
+// ImagesWithChecksum returns the paths of the indexed images whose file content
+// has the given SHA-256
+func ImagesWithChecksum(ctx context.Context, db *sql.DB, checksum string, sourcePrefix string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT path FROM images WHERE sha256 = ? AND COALESCE(source_prefix, '') = ? ORDER BY path",
+		checksum, sourcePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checksum %s: %v", checksum, err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// MoveImage changes the path of an indexed image whose file was moved or
+// renamed, taking its thumbnail, tags and collections along. The hashes and
+// metadata are kept; the modification time and size are those of the file at
+// its new path. It reports false when oldPath is no longer indexed.
+func MoveImage(ctx context.Context, db *sql.DB, oldPath string, newPath string, sourcePrefix string, modifiedAt string, size int64) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("cannot start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE images SET path = ?, modified_at = ?, size = ? WHERE path = ? AND COALESCE(source_prefix, '') = ?",
+		newPath, modifiedAt, size, oldPath, sourcePrefix)
+	if err != nil {
+		return false, fmt.Errorf("cannot move %s to %s: %v", oldPath, newPath, err)
+	}
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return false, err
+	}
+
+	for _, table := range []string{"thumbnails", "tags", "collection_images"} {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE OR REPLACE %s SET path = ? WHERE path = ? AND source_prefix = ?", table),
+			newPath, oldPath, sourcePrefix)
+		if err != nil {
+			return false, fmt.Errorf("cannot move %s of %s: %v", table, oldPath, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("cannot move %s to %s: %v", oldPath, newPath, err)
+	}
+	return true, nil
+}
+
+// StoreThumbnail saves the JPEG thumbnail for an indexed image, replacing any previous one
+func StoreThumbnail(ctx context.Context, db *sql.DB, path string, sourcePrefix string, data []byte) error {
+	_, err := db.ExecContext(ctx, "INSERT OR REPLACE INTO thumbnails (path, source_prefix, data) VALUES (?, ?, ?)",
+		path, sourcePrefix, data)
+	if err != nil {
+		return fmt.Errorf("cannot store thumbnail for %s: %v", path, err)
+	}
+	return nil
+}
+
+// StoreFaces records the faces detected in an indexed image, replacing any
+// found before
+func StoreFaces(ctx context.Context, db *sql.DB, path string, sourcePrefix string, faces []types.Face) error {
+	if faces == nil {
+		faces = []types.Face{}
+	}
+	boxes, err := json.Marshal(faces)
+	if err != nil {
+		return fmt.Errorf("cannot encode faces for %s: %v", path, err)
+	}
+	_, err = db.ExecContext(ctx, `UPDATE images SET face_count = ?,
+		features = json_set(CASE WHEN json_valid(features) THEN features ELSE '{}' END, '$.faces', json(?))
+		WHERE path = ? AND COALESCE(source_prefix, '') = ?`, len(faces), string(boxes), path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot store faces for %s: %v", path, err)
+	}
+	return nil
+}
+
+// storedColor is a dominant color as kept in the features JSON, with its
+// L*a*b* coordinates for color searches
+type storedColor struct {
+	types.DominantColor
+	Lab [3]float64 `json:"lab"`
+}
+
+// StoreColors records the dominant colors of an indexed image, replacing any
+// found before
+func StoreColors(ctx context.Context, db *sql.DB, path string, sourcePrefix string, colors []types.DominantColor) error {
+	stored := make([]storedColor, len(colors))
+	for i, color := range colors {
+		l, a, b := color.Color.Lab()
+		stored[i] = storedColor{DominantColor: color, Lab: [3]float64{l, a, b}}
+	}
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("cannot encode colors for %s: %v", path, err)
+	}
+	_, err = db.ExecContext(ctx, `UPDATE images SET
+		features = json_set(CASE WHEN json_valid(features) THEN features ELSE '{}' END, '$.colors', json(?))
+		WHERE path = ? AND COALESCE(source_prefix, '') = ?`, string(value), path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot store colors for %s: %v", path, err)
+	}
+	return nil
+}
+
+// GetThumbnail returns the stored JPEG thumbnail for an image, or nil if none exists
+func GetThumbnail(ctx context.Context, db *sql.DB, path string, sourcePrefix string) ([]byte, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, "SELECT data FROM thumbnails WHERE path = ? AND source_prefix = ?",
+		path, sourcePrefix).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read thumbnail for %s: %v", path, err)
+	}
+	return data, nil
+}
+
+// GetColorInfo returns the color profile and bit depth stored for an image,
+// empty and 0 when unknown
+func GetColorInfo(ctx context.Context, db *sql.DB, path string, sourcePrefix string) (string, int, error) {
+	var profile string
+	var bitDepth int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(color_profile, ''), COALESCE(bit_depth, 0) FROM images
+		WHERE path = ? AND COALESCE(source_prefix, '') = ?`, path, sourcePrefix).Scan(&profile, &bitDepth)
+	if err != nil && err != sql.ErrNoRows {
+		return "", 0, fmt.Errorf("cannot read color profile of %s: %v", path, err)
+	}
+	return profile, bitDepth, nil
+}
+
+// CandidateFilter narrows the images considered as potential matches
+type CandidateFilter struct {
+	SourcePrefixes []string // Any of these source prefixes, empty for all
+	Location       *LocationFilter
+	CapturedAfter  time.Time    // Inclusive lower bound, ignored when zero
+	CapturedBefore time.Time    // Exclusive upper bound, ignored when zero
+	CameraModel    string       // Case-insensitive substring of the camera make or model
+	SharesBand     []types.Hash // Only rows sharing a pHash band with one of these hashes, empty for all rows
+	MinRating      int          // Lowest XMP rating, ignored when zero
+	MinFaces       int          // Lowest number of detected faces, ignored when zero
+	Color          *types.Color // Only images with a dominant color within ColorTolerance of this one
+	ColorTolerance float64      // Largest CIE76 difference (ΔE) from Color
+	MinSize        int64        // Smallest file size in bytes, ignored when zero
+	MaxSize        int64        // Largest file size in bytes, ignored when zero
+	Label          string       // XMP color label, case-insensitive
+	Keyword        string       // XMP keyword, case-insensitive
+	Tag            string       // Tag added with the tag command, case-insensitive
+	Collection     string       // Name of a collection, case-insensitive
+}
+
+// LocationFilter restricts candidates to a radius around a GPS position
+type LocationFilter struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+// Candidate is a potential match read from the database
+type Candidate struct {
+	Path           string
+	SourcePrefix   string
+	AverageHash    types.Hash
+	PerceptualHash types.Hash
+	Latitude       sql.NullFloat64
+	Longitude      sql.NullFloat64
+}
+
+// QueryPotentialMatches retrieves potential image matches based on the candidate filter
+func QueryPotentialMatches(ctx context.Context, db *sql.DB, filter CandidateFilter) (*sql.Rows, error) {
+	query := `SELECT path, COALESCE(source_prefix, ''), average_hash_bits, perceptual_hash_bits,
+		gps_latitude, gps_longitude FROM images`
+	conditions := []string{"average_hash_bits IS NOT NULL", "perceptual_hash_bits IS NOT NULL"}
 	var args []interface{}
 
-	if sourcePrefix != "" {
+	if len(filter.SourcePrefixes) > 0 {
 		// Filter by source prefix if specified
-		query = `SELECT path, source_prefix, average_hash, perceptual_hash FROM images WHERE source_prefix = ?`
-		args = []interface{}{sourcePrefix}
-	} else {
-		// No source prefix filter
-		query = `SELECT path, source_prefix, average_hash, perceptual_hash FROM images`
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.SourcePrefixes)), ", ")
+		conditions = append(conditions, "source_prefix IN ("+placeholders+")")
+		for _, prefix := range filter.SourcePrefixes {
+			args = append(args, prefix)
+		}
 	}
 
+	if filter.Location != nil {
+		// Use an indexed bounding box here; Matches applies the exact radius
+		minLat, maxLat, minLon, maxLon := filter.Location.boundingBox()
+		conditions = append(conditions, "gps_latitude BETWEEN ? AND ?", "gps_longitude BETWEEN ? AND ?")
+		args = append(args, minLat, maxLat, minLon, maxLon)
+	}
+
+	if !filter.CapturedAfter.IsZero() {
+		conditions = append(conditions, "captured_at >= ?")
+		args = append(args, filter.CapturedAfter.Unix())
+	}
+
+	if !filter.CapturedBefore.IsZero() {
+		conditions = append(conditions, "captured_at < ?")
+		args = append(args, filter.CapturedBefore.Unix())
+	}
+
+	if filter.CameraModel != "" {
+		conditions = append(conditions, "(camera_model LIKE ? ESCAPE '\\' OR (camera_make || ' ' || camera_model) LIKE ? ESCAPE '\\')")
+		pattern := "%" + escapeLike(filter.CameraModel) + "%"
+		args = append(args, pattern, pattern)
+	}
+
+	if filter.MinRating != 0 {
+		conditions = append(conditions, "rating >= ?")
+		args = append(args, filter.MinRating)
+	}
+
+	if filter.MinFaces != 0 {
+		conditions = append(conditions, "face_count >= ?")
+		args = append(args, filter.MinFaces)
+	}
+
+	if filter.MinSize != 0 {
+		conditions = append(conditions, "size >= ?")
+		args = append(args, filter.MinSize)
+	}
+	if filter.MaxSize != 0 {
+		conditions = append(conditions, "size <= ?")
+		args = append(args, filter.MaxSize)
+	}
+
+	if filter.Color != nil {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM (SELECT json_extract(value, '$.lab[0]') - ? AS dl,
+			json_extract(value, '$.lab[1]') - ? AS da, json_extract(value, '$.lab[2]') - ? AS db
+			FROM json_each(CASE WHEN json_valid(images.features) THEN images.features END, '$.colors'))
+			WHERE dl*dl + da*da + db*db <= ?)`)
+		l, a, b := filter.Color.Lab()
+		args = append(args, l, a, b, filter.ColorTolerance*filter.ColorTolerance)
+	}
+
+	if filter.Label != "" {
+		conditions = append(conditions, "label = ? COLLATE NOCASE")
+		args = append(args, filter.Label)
+	}
+
+	if filter.Keyword != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM json_each(images.keywords) WHERE value = ? COLLATE NOCASE)")
+		args = append(args, filter.Keyword)
+	}
+
+	if filter.Tag != "" {
+		conditions = append(conditions, tagsCondition)
+		args = append(args, filter.Tag)
+	}
+
+	if filter.Collection != "" {
+		conditions = append(conditions, collectionCondition)
+		args = append(args, filter.Collection)
+	}
+
+	if len(filter.SharesBand) > 0 {
+		// Each band has its own index, so SQLite reads only the rows in the query's buckets
+		var bandConditions []string
+		for _, hash := range filter.SharesBand {
+			for band := 0; band < HashBands; band++ {
+				bandConditions = append(bandConditions, bandColumn(band)+" = ?")
+			}
+			args = append(args, hashBands(hash)...)
+		}
+		conditions = append(conditions, "("+strings.Join(bandConditions, " OR ")+")")
+	}
+
+	query += " WHERE " + strings.Join(conditions, " AND ")
+
 	// Query database for potential matches
-	return db.Query(query, args...)
+	return db.QueryContext(ctx, query, args...)
+}
+
+// escapeLike escapes the LIKE wildcards in a user-supplied pattern
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+	return replacer.Replace(value)
+}
+
+// ScanCandidate reads the current row returned by QueryPotentialMatches
+func ScanCandidate(rows *sql.Rows) (Candidate, error) {
+	var candidate Candidate
+	var averageHash, perceptualHash int64
+	err := rows.Scan(&candidate.Path, &candidate.SourcePrefix, &averageHash, &perceptualHash,
+		&candidate.Latitude, &candidate.Longitude)
+	candidate.AverageHash, candidate.PerceptualHash = types.Hash(averageHash), types.Hash(perceptualHash)
+	return candidate, err
+}
+
+// Matches applies the checks that can't be expressed in the SQL query
+func (f CandidateFilter) Matches(candidate Candidate) bool {
+	if f.Location != nil {
+		if !candidate.Latitude.Valid || !candidate.Longitude.Valid {
+			return false
+		}
+		if f.Location.DistanceKm(candidate.Latitude.Float64, candidate.Longitude.Float64) > f.Location.RadiusKm {
+			return false
+		}
+	}
+
+	return true
+}
+
+// earthRadiusKm is the mean Earth radius used for distance calculations
+const earthRadiusKm = 6371.0
+
+// DistanceKm returns the great-circle distance from the filter center to the given position
+func (l *LocationFilter) DistanceKm(lat, lon float64) float64 {
+	lat1 := l.Latitude * math.Pi / 180
+	lat2 := lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (lon - l.Longitude) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// boundingBox returns the latitude/longitude ranges that enclose the search radius
+func (l *LocationFilter) boundingBox() (minLat, maxLat, minLon, maxLon float64) {
+	latDelta := l.RadiusKm / earthRadiusKm * 180 / math.Pi
+	minLat = math.Max(l.Latitude-latDelta, -90)
+	maxLat = math.Min(l.Latitude+latDelta, 90)
+
+	// Longitude degrees shrink towards the poles; give up on narrowing near them
+	cosLat := math.Cos(l.Latitude * math.Pi / 180)
+	if cosLat < 0.01 || minLat == -90 || maxLat == 90 {
+		return minLat, maxLat, -180, 180
+	}
+
+	lonDelta := latDelta / cosLat
+	if lonDelta >= 180 {
+		return minLat, maxLat, -180, 180
+	}
+
+	minLon, maxLon = l.Longitude-lonDelta, l.Longitude+lonDelta
+	if minLon < -180 || maxLon > 180 {
+		// The box wraps around the antimeridian; skip longitude narrowing
+		return minLat, maxLat, -180, 180
+	}
+
+	return minLat, maxLat, minLon, maxLon
+}
+
+// ForEachImage calls fn for every indexed image, optionally filtered by source prefix
+func ForEachImage(ctx context.Context, db *sql.DB, sourcePrefix string, fn func(types.ImageInfo) error) error {
+	query := `SELECT id, path, COALESCE(source_prefix, ''), COALESCE(format, ''), COALESCE(width, 0),
+		COALESCE(height, 0), COALESCE(created_at, ''), COALESCE(modified_at, ''), COALESCE(size, 0),
+		COALESCE(average_hash_bits, 0), COALESCE(perceptual_hash_bits, 0), gps_latitude, gps_longitude, captured_at,
+		COALESCE(camera_make, ''), COALESCE(camera_model, ''), COALESCE(rating, 0), COALESCE(label, ''),
+		COALESCE(keywords, ''), COALESCE(sha256, ''), COALESCE(color_profile, ''), COALESCE(bit_depth, 0), (SELECT json_group_array(tag) FROM (SELECT tag FROM tags WHERE tags.path = images.path
+		AND tags.source_prefix = COALESCE(images.source_prefix, '') ORDER BY tag)), face_count,
+		CASE WHEN json_valid(features) THEN json_extract(features, '$.faces') END,
+		CASE WHEN json_valid(features) THEN json_extract(features, '$.colors') END FROM images`
+	var args []interface{}
+
+	if sourcePrefix != "" {
+		query += " WHERE source_prefix = ?"
+		args = append(args, sourcePrefix)
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query images: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var info types.ImageInfo
+		var lat, lon sql.NullFloat64
+		var capturedAt sql.NullInt64
+		var averageHash, perceptualHash int64
+		var keywords, tags string
+		var faceCount sql.NullInt64
+		var faces, colors sql.NullString
+		if err := rows.Scan(&info.ID, &info.Path, &info.SourcePrefix, &info.Format, &info.Width,
+			&info.Height, &info.CreatedAt, &info.ModifiedAt, &info.Size,
+			&averageHash, &perceptualHash, &lat, &lon, &capturedAt,
+			&info.CameraMake, &info.CameraModel, &info.Rating, &info.Label, &keywords, &info.Checksum,
+			&info.ColorProfile, &info.BitDepth, &tags,
+			&faceCount, &faces, &colors); err != nil {
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		info.AverageHash, info.PerceptualHash = types.Hash(averageHash), types.Hash(perceptualHash)
+		if keywords != "" {
+			if err := json.Unmarshal([]byte(keywords), &info.Keywords); err != nil {
+				return fmt.Errorf("invalid keywords for %s: %v", info.Path, err)
+			}
+		}
+		if err := json.Unmarshal([]byte(tags), &info.Tags); err != nil {
+			return fmt.Errorf("invalid tags for %s: %v", info.Path, err)
+		}
+		if len(info.Tags) == 0 {
+			info.Tags = nil
+		}
+		if faceCount.Valid {
+			count := int(faceCount.Int64)
+			info.FaceCount = &count
+			if faces.Valid {
+				if err := json.Unmarshal([]byte(faces.String), &info.Faces); err != nil {
+					return fmt.Errorf("invalid faces for %s: %v", info.Path, err)
+				}
+			}
+		}
+		if colors.Valid {
+			if err := json.Unmarshal([]byte(colors.String), &info.Colors); err != nil {
+				return fmt.Errorf("invalid colors for %s: %v", info.Path, err)
+			}
+		}
+
+		if capturedAt.Valid {
+			info.CapturedAt = time.Unix(capturedAt.Int64, 0).Format(time.RFC3339)
+		}
+
+		if lat.Valid && lon.Valid {
+			info.Latitude, info.Longitude = &lat.Float64, &lon.Float64
+		}
+
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
 }
 
 // ScanStats contains statistics from a scan operation
 type ScanStats struct {
-	TotalImages  int
-	ErrorCount   int
-	UniqueHashes int
+	TotalImages  int `json:"total_images"`
+	ErrorCount   int `json:"errors"` // Files whose last attempt to index failed
+	UniqueHashes int `json:"unique_hashes"`
 }
 
 // GetScanStats retrieves statistics about scanned images
-func GetScanStats(db *sql.DB, sourcePrefix string) (*ScanStats, error) {
+func GetScanStats(ctx context.Context, db *sql.DB, sourcePrefix string) (*ScanStats, error) {
 	var stats ScanStats
 	var err error
 
@@ -202,7 +1095,7 @@ func GetScanStats(db *sql.DB, sourcePrefix string) (*ScanStats, error) {
 		totalQuery = "SELECT COUNT(*) FROM images"
 	}
 
-	err = db.QueryRow(totalQuery, args...).Scan(&stats.TotalImages)
+	err = db.QueryRowContext(ctx, totalQuery, args...).Scan(&stats.TotalImages)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total images: %v", err)
 	}
@@ -210,19 +1103,26 @@ func GetScanStats(db *sql.DB, sourcePrefix string) (*ScanStats, error) {
 	// Count unique hashes
 	var hashQuery string
 	if sourcePrefix != "" {
-		hashQuery = "SELECT COUNT(DISTINCT average_hash) FROM images WHERE source_prefix = ?"
+		hashQuery = "SELECT COUNT(DISTINCT average_hash_bits) FROM images WHERE source_prefix = ?"
 	} else {
-		hashQuery = "SELECT COUNT(DISTINCT average_hash) FROM images"
+		hashQuery = "SELECT COUNT(DISTINCT average_hash_bits) FROM images"
 	}
 
-	err = db.QueryRow(hashQuery, args...).Scan(&stats.UniqueHashes)
+	err = db.QueryRowContext(ctx, hashQuery, args...).Scan(&stats.UniqueHashes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get unique hashes: %v", err)
 	}
 
-	// Count errors (assuming you track errors in the database)
-	// If you don't have an explicit error field, this can be omitted or adapted
-	stats.ErrorCount = 0
+	// Count files that failed to index
+	errorQuery := "SELECT COUNT(*) FROM errors"
+	if sourcePrefix != "" {
+		errorQuery += " WHERE source_prefix = ?"
+	}
+
+	err = db.QueryRowContext(ctx, errorQuery, args...).Scan(&stats.ErrorCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count errors: %v", err)
+	}
 
 	return &stats, nil
 }
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImageError is a file that a scan failed to index
+type ImageError struct {
+	Path         string `json:"path"`
+	SourcePrefix string `json:"source_prefix,omitempty"`
+	Error        string `json:"error"`
+	Loader       string `json:"loader,omitempty"` // Loader the file was read with, if it got that far
+	FailedAt     string `json:"failed_at"`
+	Attempts     int    `json:"attempts"` // Scans that failed on the file in a row
+}
+
+// createErrorsTable creates the table of files that failed to index. A row
+// is removed once its file is indexed.
+func createErrorsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS errors (
+		path TEXT NOT NULL,
+		source_prefix TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL,
+		loader TEXT NOT NULL DEFAULT '',
+		failed_at TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY(path, source_prefix)
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating errors table: %v", err)
+	}
+	return nil
+}
+
+// StoreImageError records that a file failed to index, counting the attempt
+// if it failed before
+func StoreImageError(ctx context.Context, db *sql.DB, path string, sourcePrefix string, message string, loader string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO errors (path, source_prefix, error, loader, failed_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(path, source_prefix) DO UPDATE SET error = excluded.error, loader = excluded.loader,
+		failed_at = excluded.failed_at, attempts = attempts + 1`,
+		path, sourcePrefix, message, loader, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("cannot record error of %s: %v", path, err)
+	}
+	return nil
+}
+
+// ClearImageError forgets the failure of a file that has since been indexed
+func ClearImageError(ctx context.Context, db *sql.DB, path string, sourcePrefix string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM errors WHERE path = ? AND source_prefix = ?", path, sourcePrefix)
+	if err != nil {
+		return fmt.Errorf("cannot clear error of %s: %v", path, err)
+	}
+	return nil
+}
+
+// FailedPaths returns the paths with a recorded error under a source prefix
+func FailedPaths(ctx context.Context, db *sql.DB, sourcePrefix string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT path FROM errors WHERE source_prefix = ?", sourcePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read failed files: %v", err)
+	}
+	defer rows.Close()
+
+	failed := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		failed[path] = true
+	}
+	return failed, rows.Err()
+}
+
+// ErrorFilter selects recorded errors
+type ErrorFilter struct {
+	SourcePrefixes []string // Empty matches every prefix
+	Match          string   // Case-insensitive text the error message contains
+	Loader         string   // Case-insensitive loader name
+}
+
+// ImageErrors returns the recorded errors matching a filter, ordered by
+// prefix and path
+func ImageErrors(ctx context.Context, db *sql.DB, filter ErrorFilter) ([]ImageError, error) {
+	query := "SELECT path, source_prefix, error, loader, failed_at, attempts FROM errors WHERE 1=1"
+	var args []interface{}
+	if len(filter.SourcePrefixes) > 0 {
+		query += " AND source_prefix IN (?" + strings.Repeat(", ?", len(filter.SourcePrefixes)-1) + ")"
+		for _, prefix := range filter.SourcePrefixes {
+			args = append(args, prefix)
+		}
+	}
+	if filter.Match != "" {
+		query += " AND instr(lower(error), lower(?)) > 0"
+		args = append(args, filter.Match)
+	}
+	if filter.Loader != "" {
+		query += " AND loader = ? COLLATE NOCASE"
+		args = append(args, filter.Loader)
+	}
+	query += " ORDER BY source_prefix, path"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read failed files: %v", err)
+	}
+	defer rows.Close()
+
+	var imageErrors []ImageError
+	for rows.Next() {
+		var imageError ImageError
+		err := rows.Scan(&imageError.Path, &imageError.SourcePrefix, &imageError.Error, &imageError.Loader,
+			&imageError.FailedAt, &imageError.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		imageErrors = append(imageErrors, imageError)
+	}
+	return imageErrors, rows.Err()
+}
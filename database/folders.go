@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// createFoldersTable creates the table of folder modification times recorded
+// by incremental scans
+func createFoldersTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS folders (
+		path TEXT NOT NULL,
+		source_prefix TEXT NOT NULL DEFAULT '',
+		modified_at INTEGER NOT NULL,
+		PRIMARY KEY(path, source_prefix)
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating folders table: %v", err)
+	}
+	return nil
+}
+
+// FolderTimes returns the modification times, in Unix nanoseconds, of the
+// folders recorded for a source prefix
+func FolderTimes(ctx context.Context, db *sql.DB, sourcePrefix string) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT path, modified_at FROM folders WHERE source_prefix = ?", sourcePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read folder times: %v", err)
+	}
+	defer rows.Close()
+
+	times := make(map[string]int64)
+	for rows.Next() {
+		var path string
+		var modifiedAt int64
+		if err := rows.Scan(&path, &modifiedAt); err != nil {
+			return nil, fmt.Errorf("cannot read folder times: %v", err)
+		}
+		times[path] = modifiedAt
+	}
+	return times, rows.Err()
+}
+
+// StoreFolderTimes records the modification times of scanned folders,
+// replacing the times recorded before
+func StoreFolderTimes(ctx context.Context, db *sql.DB, sourcePrefix string, times map[string]int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot store folder times: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO folders (path, source_prefix, modified_at) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("cannot store folder times: %v", err)
+	}
+	defer stmt.Close()
+
+	for path, modifiedAt := range times {
+		if _, err := stmt.ExecContext(ctx, path, sourcePrefix, modifiedAt); err != nil {
+			return fmt.Errorf("cannot store time of folder %s: %v", path, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot store folder times: %v", err)
+	}
+	return nil
+}
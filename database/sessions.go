@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ScanSession is a scan run, recorded so that an interrupted scan can be
+// resumed without redoing the files it already indexed, and kept as the
+// history of past scans
+type ScanSession struct {
+	ID           int64  `json:"id"`
+	Folder       string `json:"folder"`
+	SourcePrefix string `json:"source_prefix,omitempty"`
+	Force        bool   `json:"force"`
+	StartedAt    string `json:"started_at"`
+	FinishedAt   string `json:"finished_at,omitempty"` // Empty while the scan is incomplete
+	StoppedAt    string `json:"stopped_at,omitempty"`  // When a run of the session last ended, complete or not
+
+	// Counts summed over the runs of the session
+	Total     int `json:"total"` // Files found by the last run
+	Processed int `json:"processed"`
+	Errors    int `json:"errors"`
+
+	Settings json.RawMessage `json:"settings,omitempty"` // Scan options of the last run, as a JSON object
+}
+
+// sessionColumns are the columns added to scan_sessions after it was created
+var sessionColumns = []struct{ name, definition string }{
+	{"stopped_at", "TEXT"},
+	{"total", "INTEGER NOT NULL DEFAULT 0"},
+	{"processed", "INTEGER NOT NULL DEFAULT 0"},
+	{"errors", "INTEGER NOT NULL DEFAULT 0"},
+	{"settings", "TEXT"},
+}
+
+// createSessionTables creates the tables that track scan sessions and the
+// files each one has completed
+func createSessionTables(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS scan_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		folder TEXT NOT NULL,
+		source_prefix TEXT NOT NULL DEFAULT '',
+		force INTEGER NOT NULL DEFAULT 0,
+		started_at TEXT NOT NULL,
+		finished_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS scan_session_files (
+		session_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		PRIMARY KEY(session_id, path)
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating scan session tables: %v", err)
+	}
+
+	for _, column := range sessionColumns {
+		if _, err := ensureTableColumn(db, "scan_sessions", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartScanSession continues the latest incomplete session for the same folder
+// and source prefix, or starts a new one. It reports whether a session was
+// resumed.
+func StartScanSession(ctx context.Context, db *sql.DB, folder string, sourcePrefix string, force bool) (*ScanSession, bool, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, folder, source_prefix, force, started_at FROM scan_sessions
+		WHERE folder = ? AND source_prefix = ? AND finished_at IS NULL ORDER BY id DESC LIMIT 1`,
+		folder, sourcePrefix)
+	session, err := scanSession(row)
+	if err != nil {
+		return nil, false, err
+	}
+	if session != nil {
+		return session, true, nil
+	}
+
+	session = &ScanSession{
+		Folder:       folder,
+		SourcePrefix: sourcePrefix,
+		Force:        force,
+		StartedAt:    time.Now().Format(time.RFC3339),
+	}
+	result, err := db.ExecContext(ctx, "INSERT INTO scan_sessions (folder, source_prefix, force, started_at) VALUES (?, ?, ?, ?)",
+		session.Folder, session.SourcePrefix, session.Force, session.StartedAt)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot record scan session: %v", err)
+	}
+	session.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot record scan session: %v", err)
+	}
+	return session, false, nil
+}
+
+// LastIncompleteSession returns the most recently started scan session that
+// didn't finish, or nil if every session finished
+func LastIncompleteSession(ctx context.Context, db *sql.DB) (*ScanSession, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, folder, source_prefix, force, started_at FROM scan_sessions
+		WHERE finished_at IS NULL ORDER BY id DESC LIMIT 1`)
+	return scanSession(row)
+}
+
+// scanSession reads an incomplete session row, returning nil if there is none
+func scanSession(row *sql.Row) (*ScanSession, error) {
+	var session ScanSession
+	err := row.Scan(&session.ID, &session.Folder, &session.SourcePrefix, &session.Force, &session.StartedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read scan session: %v", err)
+	}
+	return &session, nil
+}
+
+// CompletedSessionFiles returns the paths a session has already indexed
+func CompletedSessionFiles(ctx context.Context, db *sql.DB, sessionID int64) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT path FROM scan_session_files WHERE session_id = ?", sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read completed files of scan session %d: %v", sessionID, err)
+	}
+	defer rows.Close()
+
+	completed := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		completed[path] = true
+	}
+	return completed, rows.Err()
+}
+
+// MarkSessionFileCompleted records that a session has indexed a file
+func MarkSessionFileCompleted(ctx context.Context, db *sql.DB, sessionID int64, path string) error {
+	_, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO scan_session_files (session_id, path) VALUES (?, ?)",
+		sessionID, path)
+	if err != nil {
+		return fmt.Errorf("cannot record completion of %s: %v", path, err)
+	}
+	return nil
+}
+
+// FinishScanSession marks a session as complete. Its per-file records are
+// only needed for resuming, so they are removed.
+func FinishScanSession(ctx context.Context, db *sql.DB, sessionID int64) error {
+	_, err := db.ExecContext(ctx, "UPDATE scan_sessions SET finished_at = ? WHERE id = ?",
+		time.Now().Format(time.RFC3339), sessionID)
+	if err != nil {
+		return fmt.Errorf("cannot finish scan session %d: %v", sessionID, err)
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM scan_session_files WHERE session_id = ?", sessionID)
+	if err != nil {
+		return fmt.Errorf("cannot clear completed files of scan session %d: %v", sessionID, err)
+	}
+	return nil
+}
+
+// RecordSessionSettings stores the scan options a run of a session uses
+func RecordSessionSettings(ctx context.Context, db *sql.DB, sessionID int64, settings interface{}) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("cannot encode settings of scan session %d: %v", sessionID, err)
+	}
+	_, err = db.ExecContext(ctx, "UPDATE scan_sessions SET settings = ? WHERE id = ?", string(data), sessionID)
+	if err != nil {
+		return fmt.Errorf("cannot record settings of scan session %d: %v", sessionID, err)
+	}
+	return nil
+}
+
+// RecordSessionCounts adds the counts of a run that ended to its session
+func RecordSessionCounts(ctx context.Context, db *sql.DB, sessionID int64, total int, processed int, errors int) error {
+	_, err := db.ExecContext(ctx, `UPDATE scan_sessions SET stopped_at = ?, total = ?,
+		processed = processed + ?, errors = errors + ? WHERE id = ?`,
+		time.Now().Format(time.RFC3339), total, processed, errors, sessionID)
+	if err != nil {
+		return fmt.Errorf("cannot record counts of scan session %d: %v", sessionID, err)
+	}
+	return nil
+}
+
+// ScanHistory returns the most recent scan sessions, newest first, optionally
+// only those of one source prefix. A limit of 0 returns every session.
+func ScanHistory(ctx context.Context, db *sql.DB, sourcePrefix string, limit int) ([]ScanSession, error) {
+	query := `SELECT id, folder, source_prefix, force, started_at, COALESCE(finished_at, ''), COALESCE(stopped_at, ''),
+		total, processed, errors, COALESCE(settings, '') FROM scan_sessions`
+	var args []interface{}
+	if sourcePrefix != "" {
+		query += " WHERE source_prefix = ?"
+		args = append(args, sourcePrefix)
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read scan history: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []ScanSession
+	for rows.Next() {
+		var session ScanSession
+		var settings string
+		err := rows.Scan(&session.ID, &session.Folder, &session.SourcePrefix, &session.Force, &session.StartedAt,
+			&session.FinishedAt, &session.StoppedAt, &session.Total, &session.Processed, &session.Errors, &settings)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		if json.Valid([]byte(settings)) {
+			session.Settings = json.RawMessage(settings)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
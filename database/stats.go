@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// IndexStats breaks the index down by format and source prefix, on top of
+// the totals of ScanStats
+type IndexStats struct {
+	ScanStats
+	TotalBytes int64 `json:"total_bytes"`
+
+	Formats  []GroupStats `json:"formats"`
+	Prefixes []GroupStats `json:"prefixes"`
+
+	// RAW images with a JPEG of the same name in the same folder, as written
+	// by cameras shooting RAW+JPEG
+	RawImages   int `json:"raw_images"`
+	RawWithJPEG int `json:"raw_with_jpeg"`
+
+	PerceptualCollisions HashCollisions `json:"perceptual_hash_collisions"`
+	AverageCollisions    HashCollisions `json:"average_hash_collisions"`
+
+	ErrorsByLoader map[string]int `json:"errors_by_loader,omitempty"` // Failed files by the loader that read them
+}
+
+// GroupStats counts the images of one format or source prefix
+type GroupStats struct {
+	Name   string `json:"name"`
+	Images int    `json:"images"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// HashCollisions counts the hash values shared by more than one image and
+// the images sharing them
+type HashCollisions struct {
+	Hashes int `json:"hashes"`
+	Images int `json:"images"`
+}
+
+// GetIndexStats extends GetScanStats with per-format and per-prefix counts,
+// RAW+JPEG pair coverage and hash collisions. rawFormats are the format
+// names of RAW files. An empty sourcePrefix covers the whole index.
+func GetIndexStats(ctx context.Context, db *sql.DB, sourcePrefix string, rawFormats []string) (*IndexStats, error) {
+	scanStats, err := GetScanStats(ctx, db, sourcePrefix)
+	if err != nil {
+		return nil, err
+	}
+	stats := &IndexStats{ScanStats: *scanStats}
+
+	where := ""
+	var args []interface{}
+	if sourcePrefix != "" {
+		where = " WHERE source_prefix = ?"
+		args = append(args, sourcePrefix)
+	}
+
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM images"+where, args...).Scan(&stats.TotalBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get total size: %v", err)
+	}
+
+	stats.Formats, err = groupStats(ctx, db, "COALESCE(NULLIF(format, ''), 'unknown')", where, args)
+	if err != nil {
+		return nil, err
+	}
+	stats.Prefixes, err = groupStats(ctx, db, "COALESCE(source_prefix, '')", where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := countRawJPEGPairs(ctx, db, stats, where, args, rawFormats); err != nil {
+		return nil, err
+	}
+
+	stats.PerceptualCollisions, err = hashCollisions(ctx, db, "perceptual_hash_bits", where, args)
+	if err != nil {
+		return nil, err
+	}
+	stats.AverageCollisions, err = hashCollisions(ctx, db, "average_hash_bits", where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ErrorsByLoader, err = errorsByLoader(ctx, db, where, args)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// errorsByLoader counts the failed files by loader. Files that failed before
+// a loader was chosen are counted under "none".
+func errorsByLoader(ctx context.Context, db *sql.DB, where string, args []interface{}) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT COALESCE(NULLIF(loader, ''), 'none'), COUNT(*) FROM errors"+where+" GROUP BY 1", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count errors: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var loader string
+		var count int
+		if err := rows.Scan(&loader, &count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		counts[loader] = count
+	}
+	return counts, rows.Err()
+}
+
+// groupStats counts the images and bytes for each value of an expression,
+// largest groups first
+func groupStats(ctx context.Context, db *sql.DB, expression string, where string, args []interface{}) ([]GroupStats, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT %s AS name, COUNT(*), COALESCE(SUM(size), 0) FROM images%s
+		GROUP BY name ORDER BY COUNT(*) DESC, name`, expression, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count images: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []GroupStats
+	for rows.Next() {
+		var group GroupStats
+		if err := rows.Scan(&group.Name, &group.Images, &group.Bytes); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// countRawJPEGPairs counts the RAW images and those indexed with a JPEG of
+// the same name, whatever the case of its extension
+func countRawJPEGPairs(ctx context.Context, db *sql.DB, stats *IndexStats, where string, args []interface{}, rawFormats []string) error {
+	isRaw := make(map[string]bool, len(rawFormats))
+	for _, format := range rawFormats {
+		isRaw[format] = true
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT path, COALESCE(source_prefix, ''), COALESCE(format, '') FROM images"+where, args...)
+	if err != nil {
+		return fmt.Errorf("failed to read image formats: %v", err)
+	}
+	defer rows.Close()
+
+	// Stems are keyed by prefix as the same path may be indexed under several
+	raws := make(map[string]int)
+	jpegs := make(map[string]bool)
+	for rows.Next() {
+		var path, prefix, format string
+		if err := rows.Scan(&path, &prefix, &format); err != nil {
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		stem := prefix + "\x00" + strings.TrimSuffix(path, filepath.Ext(path))
+		switch {
+		case isRaw[format]:
+			raws[stem]++
+			stats.RawImages++
+		case format == "jpeg":
+			jpegs[stem] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read image formats: %v", err)
+	}
+
+	for stem, count := range raws {
+		if jpegs[stem] {
+			stats.RawWithJPEG += count
+		}
+	}
+	return nil
+}
+
+// hashCollisions counts the non-null values of a column shared by more than
+// one image
+func hashCollisions(ctx context.Context, db *sql.DB, column string, where string, args []interface{}) (HashCollisions, error) {
+	condition := " WHERE "
+	if where != "" {
+		condition = where + " AND "
+	}
+	var collisions HashCollisions
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*), COALESCE(SUM(images), 0) FROM (
+		SELECT COUNT(*) AS images FROM images%s%s IS NOT NULL GROUP BY %s HAVING COUNT(*) > 1)`,
+		condition, column, column), args...).Scan(&collisions.Hashes, &collisions.Images)
+	if err != nil {
+		return collisions, fmt.Errorf("failed to count shared values of %s: %v", column, err)
+	}
+	return collisions, nil
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// TagCount is a tag and the number of images that carry it
+type TagCount struct {
+	Tag    string
+	Images int
+}
+
+// createTagsTable creates the table of user tags. Tags are keyed by path
+// rather than image id so they survive rescans, which may replace the image
+// row.
+func createTagsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS tags (
+		path TEXT NOT NULL,
+		source_prefix TEXT NOT NULL DEFAULT '',
+		tag TEXT NOT NULL COLLATE NOCASE,
+		PRIMARY KEY(path, source_prefix, tag)
+	);
+	CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(tag);`)
+	if err != nil {
+		return fmt.Errorf("error creating tags table: %v", err)
+	}
+	return nil
+}
+
+// AddTags attaches tags to an image. Tags it already has are left as they
+// are; tags differing only in case are the same tag.
+func AddTags(ctx context.Context, db *sql.DB, path string, sourcePrefix string, tags []string) error {
+	for _, tag := range normalizeTags(tags) {
+		_, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO tags (path, source_prefix, tag) VALUES (?, ?, ?)",
+			path, sourcePrefix, tag)
+		if err != nil {
+			return fmt.Errorf("cannot tag %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// RemoveTags detaches tags from an image and returns how many it had
+func RemoveTags(ctx context.Context, db *sql.DB, path string, sourcePrefix string, tags []string) (int, error) {
+	removed := 0
+	for _, tag := range normalizeTags(tags) {
+		result, err := db.ExecContext(ctx, "DELETE FROM tags WHERE path = ? AND source_prefix = ? AND tag = ?",
+			path, sourcePrefix, tag)
+		if err != nil {
+			return removed, fmt.Errorf("cannot untag %s: %v", path, err)
+		}
+		if count, err := result.RowsAffected(); err == nil {
+			removed += int(count)
+		}
+	}
+	return removed, nil
+}
+
+// ImageTags returns the tags of an image in alphabetical order
+func ImageTags(ctx context.Context, db *sql.DB, path string, sourcePrefix string) ([]string, error) {
+	return queryStrings(ctx, db, "SELECT tag FROM tags WHERE path = ? AND source_prefix = ? ORDER BY tag",
+		path, sourcePrefix)
+}
+
+// TaggedImages returns the paths of the images carrying a tag
+func TaggedImages(ctx context.Context, db *sql.DB, tag string, sourcePrefix string) ([]string, error) {
+	query := "SELECT path FROM tags WHERE tag = ?"
+	args := []interface{}{tag}
+	if sourcePrefix != "" {
+		query += " AND source_prefix = ?"
+		args = append(args, sourcePrefix)
+	}
+	return queryStrings(ctx, db, query+" ORDER BY path", args...)
+}
+
+// CountTags returns every tag with the number of images carrying it
+func CountTags(ctx context.Context, db *sql.DB, sourcePrefix string) ([]TagCount, error) {
+	query := "SELECT tag, COUNT(*) FROM tags"
+	var args []interface{}
+	if sourcePrefix != "" {
+		query += " WHERE source_prefix = ?"
+		args = append(args, sourcePrefix)
+	}
+	rows, err := db.QueryContext(ctx, query+" GROUP BY tag ORDER BY tag", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %v", err)
+	}
+	defer rows.Close()
+
+	var counts []TagCount
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Tag, &count.Images); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// IndexedPath returns the path under which an image is indexed, trying each
+// of the given spellings in turn, or an empty string if none is indexed
+func IndexedPath(ctx context.Context, db *sql.DB, sourcePrefix string, paths ...string) (string, error) {
+	for _, path := range paths {
+		var count int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM images WHERE path = ? AND COALESCE(source_prefix, '') = ?",
+			path, sourcePrefix).Scan(&count)
+		if err != nil {
+			return "", fmt.Errorf("database error for %s: %v", path, err)
+		}
+		if count > 0 {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// queryStrings returns the single text column of a query's rows
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %v", err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// tagsCondition is the SQL condition that an images row carries a tag
+const tagsCondition = `EXISTS (SELECT 1 FROM tags WHERE tags.path = images.path
+	AND tags.source_prefix = COALESCE(images.source_prefix, '') AND tags.tag = ?)`
+
+// normalizeTags trims tags and drops empty ones
+func normalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
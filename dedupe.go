@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/utils"
+)
+
+// dedupeActions lists the accepted --action values
+var dedupeActions = []string{"hardlink", "move", "delete"}
+
+// defaultJournalPath is where dedupe actions are recorded unless --journal is given
+const defaultJournalPath = "dedupe-journal.jsonl"
+
+// journalEntry records one dedupe action, or why it was skipped
+type journalEntry struct {
+	Time   string `json:"time"`
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Keeper string `json:"keeper"`
+	Target string `json:"target,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+	Result string `json:"result"` // done, planned, skipped or failed
+	Reason string `json:"reason,omitempty"`
+}
+
+func handleDedupeCommand(ctx context.Context, flags *dedupeFlags, dbPath string) {
+	if flags.maxDistance < 0 || flags.maxDistance > 64 {
+		fatalf("Error: invalid --max-distance %d, expected 0 to 64 bits", flags.maxDistance)
+	}
+
+	if flags.action != "" {
+		valid := false
+		for _, action := range dedupeActions {
+			valid = valid || flags.action == action
+		}
+		if !valid {
+			fatalf("Error: invalid --action '%s', expected %s", flags.action, strings.Join(dedupeActions, ", "))
+		}
+		if flags.action == "move" && flags.targetDir == "" {
+			fatalf("Error: --action=move requires --target-dir")
+		}
+		if flags.json {
+			fatalf("Error: --json lists duplicates and can't be combined with --action")
+		}
+	}
+	if flags.interactive && flags.json {
+		fatalf("Error: --json lists duplicates and can't be combined with --interactive")
+	}
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	groups, err := imageprocessor.FindDuplicateGroups(ctx, db, imageprocessor.DuplicateOptions{
+		SourcePrefix: flags.prefix,
+		MaxDistance:  flags.maxDistance,
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Dedupe interrupted")
+		fatalf("Error finding duplicates: %v", err)
+	}
+
+	if saveTo := strings.TrimSpace(flags.saveTo); saveTo != "" && len(groups) > 0 {
+		var images []database.CollectionImage
+		for _, group := range groups {
+			for _, image := range append([]imageprocessor.DuplicateImage{group.Keeper}, group.Duplicates...) {
+				images = append(images, database.CollectionImage{Path: image.Path, SourcePrefix: image.SourcePrefix})
+			}
+		}
+		saveCollection(ctx, db, saveTo, images)
+	}
+
+	if flags.json {
+		// One group per line, like export, so scripts can stream the groups
+		writer := bufio.NewWriter(os.Stdout)
+		encoder := json.NewEncoder(writer)
+		for _, group := range groups {
+			if err := encoder.Encode(group); err != nil {
+				fatalf("Error writing duplicate groups: %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			fatalf("Error writing duplicate groups: %v", err)
+		}
+		return
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found.")
+		return
+	}
+
+	// Files marked in the review are deleted unless --action says otherwise
+	if flags.interactive {
+		if flags.action == "" {
+			flags.action = "delete"
+		}
+		reviewed, confirmed := reviewDuplicates(ctx, groups, flags.action, flags.dryRun, os.Stdin)
+		if ctx.Err() != nil {
+			exitIfInterrupted(ctx, ctx.Err(), db, "Review interrupted, nothing was changed")
+		}
+		if confirmed {
+			applyDedupeAction(ctx, db, flags, reviewed)
+		}
+		return
+	}
+
+	if flags.action != "" {
+		applyDedupeAction(ctx, db, flags, groups)
+		return
+	}
+
+	duplicates := 0
+	var reclaimable int64
+	for i, group := range groups {
+		fmt.Printf("%d. Keep: %s\n", i+1, describeDuplicate(group.Keeper))
+		for _, duplicate := range group.Duplicates {
+			fmt.Printf("   Duplicate: %s, pHash distance %d\n", describeDuplicate(duplicate), duplicate.Distance)
+			duplicates++
+			reclaimable += duplicate.Size
+		}
+	}
+	statusf("\n%d duplicate groups, %d duplicates using %.1f MB\n", len(groups), duplicates, float64(reclaimable)/(1024*1024))
+}
+
+// describeDuplicate renders an image of a duplicate group on one line
+func describeDuplicate(image imageprocessor.DuplicateImage) string {
+	description := image.Path
+	if image.SourcePrefix != "" {
+		description += " [" + image.SourcePrefix + "]"
+	}
+	details := fmt.Sprintf("%s, %dx%d", image.Format, image.Width, image.Height)
+	if color := describeColor(image.ColorProfile, image.BitDepth); color != "" {
+		details += ", " + color
+	}
+	return fmt.Sprintf("%s (%s, %.1f MB)", description, details, float64(image.Size)/(1024*1024))
+}
+
+// applyDedupeAction applies --action to every duplicate, recording each step
+// in the journal. Files whose size or modification time changed since they
+// were indexed are left alone, as the index no longer describes them.
+func applyDedupeAction(ctx context.Context, db *sql.DB, flags *dedupeFlags, groups []imageprocessor.DuplicateGroup) {
+	journal, err := os.OpenFile(flags.journal, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		fatalf("Cannot open journal: %v", err)
+	}
+	defer journal.Close()
+	encoder := json.NewEncoder(journal)
+
+	counts := make(map[string]int)
+	for _, group := range groups {
+		for _, duplicate := range group.Duplicates {
+			if ctx.Err() != nil {
+				exitIfInterrupted(ctx, ctx.Err(), db, "Dedupe interrupted")
+			}
+
+			entry := journalEntry{
+				Time:   time.Now().Format(time.RFC3339),
+				Action: flags.action,
+				Path:   duplicate.Path,
+				Keeper: group.Keeper.Path,
+				DryRun: flags.dryRun,
+			}
+			if flags.action == "move" {
+				entry.Target = moveTarget(flags.targetDir, duplicate.Path)
+			}
+
+			if reason := checkDedupeAction(flags.action, group.Keeper, duplicate, entry.Target, flags.maxDistance); reason != "" {
+				entry.Result, entry.Reason = "skipped", reason
+			} else if flags.dryRun {
+				entry.Result = "planned"
+			} else if err := runDedupeAction(flags.action, group.Keeper.Path, duplicate.Path, entry.Target); err != nil {
+				entry.Result, entry.Reason = "failed", err.Error()
+			} else {
+				entry.Result = "done"
+				// Moved and deleted files are no longer where the index says
+				if flags.action != "hardlink" {
+					if err := database.DeleteImage(context.WithoutCancel(ctx), db, duplicate.Path, duplicate.SourcePrefix); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+					}
+				}
+			}
+
+			if err := encoder.Encode(entry); err != nil {
+				fatalf("Cannot write journal: %v", err)
+			}
+			counts[entry.Result]++
+			printDedupeEntry(entry)
+		}
+	}
+
+	if flags.dryRun {
+		statusf("\nDry run: %d planned, %d skipped. Journal: %s\n", counts["planned"], counts["skipped"], flags.journal)
+	} else {
+		statusf("\n%d done, %d skipped, %d failed. Journal: %s\n", counts["done"], counts["skipped"], counts["failed"], flags.journal)
+	}
+}
+
+// printDedupeEntry reports the outcome of one action
+func printDedupeEntry(entry journalEntry) {
+	verbs := map[string]string{"hardlink": "Linked", "move": "Moved", "delete": "Deleted"}
+	switch entry.Result {
+	case "planned":
+		fmt.Printf("Would %s %s\n", entry.Action, describeAction(entry))
+	case "done":
+		fmt.Printf("%s %s\n", verbs[entry.Action], describeAction(entry))
+	default:
+		fmt.Printf("Skipped %s: %s\n", entry.Path, entry.Reason)
+		if entry.Result == "failed" {
+			fmt.Fprintf(os.Stderr, "Error: cannot %s %s: %s\n", entry.Action, entry.Path, entry.Reason)
+		}
+	}
+}
+
+// describeAction names the files an action involves
+func describeAction(entry journalEntry) string {
+	switch entry.Action {
+	case "hardlink":
+		return entry.Path + " to " + entry.Keeper
+	case "move":
+		return entry.Path + " to " + entry.Target
+	default:
+		return entry.Path
+	}
+}
+
+// checkDedupeAction returns why an action can't be applied safely, or an
+// empty string if it can. Groups chain close images, so a duplicate may be
+// further than maxDistance from its keeper, close only to another duplicate;
+// such files are not moved or deleted.
+func checkDedupeAction(action string, keeper, duplicate imageprocessor.DuplicateImage, target string, maxDistance int) string {
+	if distance := keeper.DistanceTo(duplicate); action != "hardlink" && distance > maxDistance {
+		return fmt.Sprintf("%d pHash bits from %s, more than --max-distance", distance, keeper.Path)
+	}
+
+	for _, image := range []imageprocessor.DuplicateImage{keeper, duplicate} {
+		if !isLocalFile(image.Path) {
+			return image.Path + " is not a local file"
+		}
+		if reason := changedSinceIndexing(image); reason != "" {
+			return reason
+		}
+	}
+
+	// The same file indexed under two source prefixes is not a duplicate of itself
+	keeperPath, _ := filepath.Abs(keeper.Path)
+	duplicatePath, _ := filepath.Abs(duplicate.Path)
+	if keeperPath == duplicatePath {
+		return "same file as the keeper"
+	}
+
+	switch action {
+	case "hardlink":
+		keeperInfo, _ := os.Stat(keeper.Path)
+		duplicateInfo, _ := os.Stat(duplicate.Path)
+		if os.SameFile(keeperInfo, duplicateInfo) {
+			return "already linked to " + keeper.Path
+		}
+		// A link makes both paths show the keeper, so only identical files are linked
+		same, err := sameContent(keeper.Path, duplicate.Path)
+		if err != nil {
+			return err.Error()
+		}
+		if !same {
+			return "content differs from " + keeper.Path + ", only identical files are linked"
+		}
+	case "move":
+		if _, err := os.Lstat(target); err == nil {
+			return target + " already exists"
+		}
+	}
+	return ""
+}
+
+// isLocalFile reports whether an indexed path is a file on a local disk
+// rather than an archive entry, PDF page or remote object
+func isLocalFile(path string) bool {
+	return !strings.Contains(path, "://") && imageprocessor.SourceFile(path) == path
+}
+
+// changedSinceIndexing compares a file with its index entry by size and
+// modification time, then by the checksum stored when it was indexed, as a
+// file rewritten within the same second keeps its size and time
+func changedSinceIndexing(image imageprocessor.DuplicateImage) string {
+	info, err := os.Stat(image.Path)
+	if err != nil {
+		return fmt.Sprintf("cannot read %s: %v", image.Path, err)
+	}
+	if info.Size() != image.Size || info.ModTime().Format(time.RFC3339) != image.ModifiedAt {
+		return image.Path + " changed since it was indexed, rescan first"
+	}
+	if image.Checksum == "" {
+		return image.Path + " has no checksum in the index, rescan with --force first"
+	}
+	checksum, err := utils.FileChecksum(image.Path)
+	if err != nil {
+		return fmt.Sprintf("cannot read %s: %v", image.Path, err)
+	}
+	if checksum != image.Checksum {
+		return image.Path + " changed since it was indexed, rescan first"
+	}
+	return ""
+}
+
+// sameContent reports whether two files hold the same bytes
+func sameContent(pathA, pathB string) (bool, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		nA, errA := io.ReadFull(fileA, bufA)
+		nB, errB := io.ReadFull(fileB, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// moveTarget returns where --action=move puts a file: its absolute path
+// recreated below the target directory, so files with the same name don't
+// collide
+func moveTarget(targetDir string, path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		absolute = path
+	}
+	absolute = strings.TrimPrefix(absolute, filepath.VolumeName(absolute))
+	return filepath.Join(targetDir, absolute)
+}
+
+// runDedupeAction links, moves or deletes a duplicate
+func runDedupeAction(action string, keeper string, path string, target string) error {
+	switch action {
+	case "hardlink":
+		// Link under a temporary name first so the duplicate is replaced in one step
+		temp := path + ".imagefinder-link"
+		if err := os.Link(keeper, temp); err != nil {
+			return err
+		}
+		if err := os.Rename(temp, path); err != nil {
+			os.Remove(temp)
+			return err
+		}
+		return nil
+	case "move":
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, target); err == nil {
+			return nil
+		}
+		// Rename fails across file systems, so copy and remove instead
+		if err := copyFile(path, target); err != nil {
+			os.Remove(target)
+			return err
+		}
+		return os.Remove(path)
+	default:
+		return os.Remove(path)
+	}
+}
+
+// copyFile copies a file, keeping its permissions and modification time
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
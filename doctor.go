@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/scanner/processor"
+	"imagefinder/signalhandler"
+	"imagefinder/types"
+	"imagefinder/utils"
+)
+
+// Outcomes of a doctor check
+const (
+	checkOK      = "ok"
+	checkWarning = "warning"
+	checkError   = "error"
+)
+
+// doctorCheck is the result of one environment check
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"` // What to do about a warning or error
+}
+
+// capability is a feature that works when any of its external tools is found
+type capability struct {
+	name    string
+	tools   []string
+	missing string // Consequence of none being found
+	install string
+}
+
+// capabilities lists the features backed by external tools, in the order
+// doctor reports them
+var capabilities = []capability{
+	{"RAW previews", []string{"exiftool"},
+		"RAW previews, CR3 files and EXIF metadata (dates, GPS, camera) are unavailable; DNG, NEF, CR2, ARW and PEF fall back to the pure-Go decoder",
+		"install exiftool (brew install exiftool, apt install libimage-exiftool-perl) or set --exiftool=PATH"},
+	{"RAW conversion", []string{"dcraw", "rawtherapee-cli"},
+		"RAW files without a usable embedded preview fail, and --raw-mode=full-decode is unavailable",
+		"install dcraw (brew install dcraw, apt install dcraw) or set --dcraw=PATH"},
+	{"HEIC/HEIF", []string{"heif-dec", "heif-convert", "sips", "magick", "convert"},
+		"HEIC/HEIF photos fail unless OpenCV was built with HEIF support",
+		"install libheif (brew install libheif, apt install libheif-examples)"},
+	{"JPEG XL", []string{"djxl"},
+		"JPEG XL images fail unless OpenCV was built with JXL support",
+		"install libjxl (brew install jpeg-xl, apt install libjxl-tools)"},
+	{"TIFF conversion", []string{"magick", "convert", "vips", "gdal_translate"},
+		"TIFF files OpenCV can't read (JPEG-in-TIFF, unusual bit depths) fail",
+		"install ImageMagick (brew install imagemagick, apt install imagemagick)"},
+	{"PDF pages", []string{"pdftoppm", "mutool"},
+		"PDF documents are skipped",
+		"install poppler (brew install poppler, apt install poppler-utils)"},
+}
+
+// handleDoctorCommand checks the environment and prints what is missing and
+// how to fix it. It exits with status 1 when a check fails.
+func handleDoctorCommand(ctx context.Context, jsonOutput bool, settings *config.Settings) {
+	var checks []doctorCheck
+	checks = append(checks, checkConfigFile(settings))
+	checks = append(checks, checkOpenCV())
+	checks = append(checks, checkTools()...)
+	checks = append(checks, checkDatabase(settings.Database))
+	tempCheck, tempDir := checkTempDir()
+	checks = append(checks, tempCheck)
+	if tempDir != "" {
+		checks = append(checks, checkEndToEnd(ctx, tempDir))
+		os.RemoveAll(tempDir)
+	}
+
+	failed := false
+	for _, check := range checks {
+		failed = failed || check.Status == checkError
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(checks); err != nil {
+			fatalf("Error writing checks: %v", err)
+		}
+	} else {
+		printDoctorChecks(checks)
+	}
+
+	if failed {
+		signalhandler.Exit(1)
+	}
+}
+
+// printDoctorChecks prints one line per check, followed by its fix
+func printDoctorChecks(checks []doctorCheck) {
+	labels := map[string]string{checkOK: "[ OK ]", checkWarning: "[WARN]", checkError: "[FAIL]"}
+	width := 0
+	for _, check := range checks {
+		width = max(width, len(check.Name))
+	}
+
+	warnings, errors := 0, 0
+	for _, check := range checks {
+		fmt.Printf("%s %-*s  %s\n", labels[check.Status], width, check.Name, check.Detail)
+		if check.Fix != "" {
+			fmt.Printf("       %-*s  -> %s\n", width, "", check.Fix)
+		}
+		switch check.Status {
+		case checkWarning:
+			warnings++
+		case checkError:
+			errors++
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case errors > 0:
+		fmt.Printf("%d problem(s) and %d warning(s) found.\n", errors, warnings)
+	case warnings > 0:
+		fmt.Printf("No problems found, %d warning(s) about optional features.\n", warnings)
+	default:
+		fmt.Println("No problems found.")
+	}
+}
+
+// checkConfigFile reports which config file the settings came from
+func checkConfigFile(settings *config.Settings) doctorCheck {
+	check := doctorCheck{Name: "Config file", Status: checkOK, Detail: "none found, using defaults"}
+	if settings.ConfigFile != "" {
+		check.Detail = settings.ConfigFile
+	}
+	return check
+}
+
+// checkOpenCV reports the OpenCV library the binary is linked against
+func checkOpenCV() (check doctorCheck) {
+	check = doctorCheck{Name: "OpenCV"}
+	defer func() {
+		if r := recover(); r != nil {
+			check.Status = checkError
+			check.Detail = fmt.Sprintf("OpenCV is not usable: %v", r)
+			check.Fix = "install OpenCV 4 (brew install opencv, apt install libopencv-dev) and rebuild"
+		}
+	}()
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("%s (gocv %s)", imageprocessor.OpenCVVersion(), imageprocessor.GoCVVersion())
+	return check
+}
+
+// checkTools reports, for each feature backed by external tools, which of
+// them were found
+func checkTools() []doctorCheck {
+	var checks []doctorCheck
+	for _, capability := range capabilities {
+		check := doctorCheck{Name: capability.name}
+		var found []string
+		for _, tool := range imageprocessor.ExternalTools {
+			for _, name := range capability.tools {
+				if tool.Name == name {
+					if path := tool.Path(); path != "" {
+						found = append(found, fmt.Sprintf("%s (%s)", name, path))
+					}
+				}
+			}
+		}
+		if len(found) > 0 {
+			check.Status = checkOK
+			check.Detail = strings.Join(found, ", ")
+		} else {
+			check.Status = checkWarning
+			check.Detail = fmt.Sprintf("%s not found: %s", strings.Join(capability.tools, ", "), capability.missing)
+			check.Fix = capability.install
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkDatabase checks that the database can be read and written, or
+// created when it doesn't exist yet. The database isn't modified.
+func checkDatabase(dbPath string) doctorCheck {
+	check := doctorCheck{Name: "Database"}
+
+	info, err := os.Stat(dbPath)
+	if os.IsNotExist(err) {
+		dir := filepath.Dir(dbPath)
+		if err := checkWritableDir(dir); err != nil {
+			check.Status = checkError
+			check.Detail = fmt.Sprintf("%s doesn't exist and can't be created: %v", dbPath, err)
+			check.Fix = "choose a writable location with --database=PATH or IMAGEFINDER_DB"
+			return check
+		}
+		check.Status = checkOK
+		check.Detail = fmt.Sprintf("%s doesn't exist yet; the first scan creates it", dbPath)
+		return check
+	}
+	if err != nil {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("can't access %s: %v", dbPath, err)
+		check.Fix = "check the permissions of the database and its folder"
+		return check
+	}
+	if info.IsDir() {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("%s is a directory", dbPath)
+		check.Fix = "point --database at a file, e.g. " + filepath.Join(dbPath, "images.db")
+		return check
+	}
+
+	db, err := database.OpenDatabase("file:" + dbPath + "?mode=ro")
+	if err != nil {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("can't open %s: %v", dbPath, err)
+		return check
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images").Scan(&count); err != nil {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("can't read %s: %v", dbPath, err)
+		check.Fix = "make sure the file is an imagefinder database; move it away to start a new index"
+		return check
+	}
+
+	file, err := os.OpenFile(dbPath, os.O_WRONLY, 0)
+	if err != nil {
+		check.Status = checkWarning
+		check.Detail = fmt.Sprintf("%s (%d images) is read-only: searches work, scans will fail", dbPath, count)
+		check.Fix = "make the database and its folder writable to scan"
+		return check
+	}
+	file.Close()
+
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("%s (%d images)", dbPath, count)
+	return check
+}
+
+// checkWritableDir checks that files can be created in dir
+func checkWritableDir(dir string) error {
+	file, err := os.CreateTemp(dir, ".imagefinder-doctor-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkTempDir checks that converted images can be written to the system
+// temp folder, returning a scratch folder for the end-to-end test
+func checkTempDir() (doctorCheck, string) {
+	check := doctorCheck{Name: "Temp folder"}
+	dir, err := utils.MkdirTemp("doctor-")
+	if err != nil {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("can't write to %s: %v", utils.TempBase(), err)
+		check.Fix = "free up space or choose a writable folder with --temp-dir=PATH; RAW and HEIC conversions need it"
+		return check, ""
+	}
+	check.Status = checkOK
+	check.Detail = utils.TempBase()
+	return check, dir
+}
+
+// checkEndToEnd loads and hashes a small generated image with the scan
+// pipeline, as a JPEG and as a PNG, and compares the hashes
+func checkEndToEnd(ctx context.Context, dir string) (check doctorCheck) {
+	check = doctorCheck{Name: "Hash test"}
+	defer func() {
+		if r := recover(); r != nil {
+			check.Status = checkError
+			check.Detail = fmt.Sprintf("crashed while hashing a test image: %v", r)
+			check.Fix = "the OpenCV installation is likely broken; reinstall it and rebuild"
+		}
+	}()
+
+	img := doctorTestImage()
+	jpegPath := filepath.Join(dir, "test.jpg")
+	pngPath := filepath.Join(dir, "test.png")
+	if err := writeTestImage(jpegPath, func(f *os.File) error { return jpeg.Encode(f, img, &jpeg.Options{Quality: 90}) }); err != nil {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("can't write a test image: %v", err)
+		return check
+	}
+	if err := writeTestImage(pngPath, func(f *os.File) error { return png.Encode(f, img) }); err != nil {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("can't write a test image: %v", err)
+		return check
+	}
+
+	imgProcessor := processor.NewImageProcessor(false)
+	defer imgProcessor.Close()
+
+	var pHashes []types.Hash
+	for _, path := range []string{jpegPath, pngPath} {
+		mat, err := imgProcessor.ProcessImage(ctx, path, false, false)
+		if err != nil || mat.Empty() {
+			check.Status = checkError
+			check.Detail = fmt.Sprintf("OpenCV can't decode a %s test image: %v", strings.ToUpper(filepath.Ext(path)[1:]), err)
+			check.Fix = "reinstall OpenCV with its image codecs (imgcodecs) and rebuild"
+			return check
+		}
+		hashes, err := imgProcessor.ComputeImageHashes(mat, path, filepath.Ext(path), false, false)
+		mat.Close()
+		if err != nil {
+			check.Status = checkError
+			check.Detail = fmt.Sprintf("can't hash the test image: %v", err)
+			return check
+		}
+		pHashes = append(pHashes, hashes.PHash)
+	}
+
+	similarity := pHashes[0].Similarity(pHashes[1])
+	if similarity < 0.9 {
+		check.Status = checkError
+		check.Detail = fmt.Sprintf("the JPEG and PNG copies of the test image hash differently (%.0f%% similar)", similarity*100)
+		check.Fix = "the OpenCV build decodes images inconsistently; reinstall it and rebuild"
+		return check
+	}
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("JPEG and PNG decoded and hashed, %.0f%% similar", similarity*100)
+	return check
+}
+
+// doctorTestImage draws a gradient with a few shapes, so its hashes aren't
+// trivially uniform
+func doctorTestImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			c := color.RGBA{R: uint8(x * 2), G: uint8(y * 2), B: 128, A: 255}
+			if (x-40)*(x-40)+(y-48)*(y-48) < 400 || (x > 80 && x < 112 && y > 20 && y < 76) {
+				c = color.RGBA{R: 240, G: 240, B: 240, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// writeTestImage writes an image to path with encode
+func writeTestImage(path string, encode func(*os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"imagefinder/database"
+)
+
+// handleHistoryCommand lists past scans, newest first
+func handleHistoryCommand(ctx context.Context, flags *historyFlags, dbPath string) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	sessions, err := database.ScanHistory(ctx, db, flags.prefix, flags.limit)
+	if err != nil {
+		fatalf("Error reading scan history: %v", err)
+	}
+
+	if flags.json {
+		// One scan per line, like dedupe --json
+		writer := bufio.NewWriter(os.Stdout)
+		encoder := json.NewEncoder(writer)
+		for _, session := range sessions {
+			if err := encoder.Encode(session); err != nil {
+				fatalf("Error writing scan history: %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			fatalf("Error writing scan history: %v", err)
+		}
+		return
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("No scans recorded.")
+		return
+	}
+
+	for _, session := range sessions {
+		folder := session.Folder
+		if session.SourcePrefix != "" {
+			folder += " [" + session.SourcePrefix + "]"
+		}
+		fmt.Printf("#%d %s %s: %s\n", session.ID, session.StartedAt, folder, sessionState(session))
+		fmt.Printf("    Processed %d of %d files, %d errors\n", session.Processed, session.Total, session.Errors)
+		if settings := describeSettings(session); settings != "" {
+			fmt.Printf("    Settings: %s\n", settings)
+		}
+	}
+}
+
+// sessionState describes whether a scan completed and how long it ran
+func sessionState(session database.ScanSession) string {
+	switch {
+	case session.FinishedAt != "":
+		return "completed in " + sessionDuration(session.StartedAt, session.FinishedAt)
+	case session.StoppedAt != "":
+		return "stopped after " + sessionDuration(session.StartedAt, session.StoppedAt) + " without completing"
+	default:
+		// Killed before it could record its counts, or still running
+		return "not finished"
+	}
+}
+
+// sessionDuration returns the time between two RFC 3339 times recorded for a
+// session. Resumed sessions include the time between their runs.
+func sessionDuration(from string, to string) string {
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return "?"
+	}
+	end, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		return "?"
+	}
+	return end.Sub(start).String()
+}
+
+// describeSettings lists the recorded options of a scan as name=value pairs,
+// and flags that were set by name alone
+func describeSettings(session database.ScanSession) string {
+	var parts []string
+	if session.Force {
+		parts = append(parts, "force")
+	}
+
+	var settings map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(session.Settings))
+	decoder.UseNumber()
+	if len(session.Settings) > 0 && decoder.Decode(&settings) == nil {
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			switch value := settings[name].(type) {
+			case bool:
+				if value {
+					parts = append(parts, name)
+				}
+			case []interface{}:
+				values := make([]string, len(value))
+				for i, item := range value {
+					values[i] = fmt.Sprint(item)
+				}
+				parts = append(parts, name+"="+strings.Join(values, ","))
+			default:
+				parts = append(parts, fmt.Sprintf("%s=%v", name, value))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
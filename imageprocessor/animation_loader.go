@@ -0,0 +1,317 @@
+package imageprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"imagefinder/logging"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
+)
+
+// AnimationFrameSeparator joins the path of an animated GIF or WebP file and
+// a frame number into the path stored for that frame, e.g.
+// "clips/wave.gif#frame=12"
+const AnimationFrameSeparator = "#frame="
+
+// AnimationFramePath returns the index path for a frame of an animation
+// (frames start at 1)
+func AnimationFramePath(path string, frame int) string {
+	return fmt.Sprintf("%s%s%d", path, AnimationFrameSeparator, frame)
+}
+
+// SplitAnimationFramePath splits an animation frame path into the file and
+// frame number
+func SplitAnimationFramePath(path string) (string, int, bool) {
+	index := strings.LastIndex(path, AnimationFrameSeparator)
+	if index < 0 {
+		return path, 0, false
+	}
+	switch strings.ToLower(filepath.Ext(path[:index])) {
+	case ".gif", ".webp":
+	default:
+		return path, 0, false
+	}
+
+	frame, err := strconv.Atoi(path[index+len(AnimationFrameSeparator):])
+	if err != nil || frame < 1 {
+		return path, 0, false
+	}
+	return path[:index], frame, true
+}
+
+// IsAnimationFormat checks if a path refers to a GIF or WebP file, which may
+// be animated, or one of its frames
+func IsAnimationFormat(path string) bool {
+	format := GetFileFormat(path)
+	return format == FormatGIF || format == FormatWEBP
+}
+
+// AnimationFrames returns the paths indexed for a GIF or WebP file: the file
+// itself, hashed from its first frame, and for animations also their middle
+// frame, so a still taken from the animation matches it
+func AnimationFrames(path string) []string {
+	frames, err := CountAnimationFrames(path)
+	if err != nil {
+		logging.DebugLog("Indexing only the first frame of %s: %v", path, err)
+		return []string{path}
+	}
+	if frames < 2 {
+		return []string{path}
+	}
+	return []string{path, AnimationFramePath(path, frames/2+1)}
+}
+
+// CountAnimationFrames returns the number of frames of a GIF or WebP file,
+// 1 for still images, without decoding them
+func CountAnimationFrames(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if GetFileFormat(path) == FormatGIF {
+		return countGIFFrames(bufio.NewReader(file))
+	}
+	return countWebPFrames(file)
+}
+
+// countGIFFrames counts the image descriptors of a GIF stream by skipping
+// over its blocks
+func countGIFFrames(r *bufio.Reader) (int, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if !bytes.HasPrefix(header, []byte("GIF8")) {
+		return 0, errors.New("not a GIF file")
+	}
+	// A global color table of 3 * 2^(n+1) bytes follows when its flag is set
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << (header[10]&0x07 + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			return frames, err
+		}
+		switch introducer {
+		case 0x3B: // Trailer
+			return frames, nil
+		case 0x21: // Extension: a label, then data sub-blocks
+			if _, err := r.ReadByte(); err != nil {
+				return frames, err
+			}
+		case 0x2C: // Image descriptor, optional local color table, LZW code size
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return frames, err
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, err := r.Discard(3 << (descriptor[8]&0x07 + 1)); err != nil {
+					return frames, err
+				}
+			}
+			if _, err := r.ReadByte(); err != nil {
+				return frames, err
+			}
+			frames++
+		default:
+			return frames, fmt.Errorf("invalid GIF block 0x%02X", introducer)
+		}
+		if err := skipGIFSubBlocks(r); err != nil {
+			return frames, err
+		}
+	}
+}
+
+// skipGIFSubBlocks skips data sub-blocks up to the empty one ending them
+func skipGIFSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil || size == 0 {
+			return err
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// countWebPFrames counts the ANMF chunks of an animated WebP file; files
+// without the animation flag have one frame
+func countWebPFrames(r io.ReaderAt) (int, error) {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return 0, errors.New("not a WebP file")
+	}
+	end := int64(binary.LittleEndian.Uint32(header[4:])) + 8
+
+	frames := 0
+	animated := false
+	chunk := make([]byte, 9)
+	for offset := int64(12); offset+8 <= end; {
+		if _, err := r.ReadAt(chunk, offset); err != nil {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch string(chunk[:4]) {
+		case "VP8X":
+			animated = chunk[8]&0x02 != 0
+		case "ANMF":
+			frames++
+		}
+		// Chunks are padded to an even size
+		offset += 8 + size + size%2
+	}
+	if !animated || frames == 0 {
+		return 1, nil
+	}
+	return frames, nil
+}
+
+// AnimationImageLoader decodes single frames of animated GIF and WebP files.
+// Frames are drawn over the ones before them as the animation would show
+// them, since later frames often only hold the pixels that changed.
+type AnimationImageLoader struct {
+	TempDir string
+}
+
+// NewAnimationImageLoader creates a new loader for GIF and WebP frames
+func NewAnimationImageLoader() *AnimationImageLoader {
+	return &AnimationImageLoader{
+		TempDir: utils.TempDir(),
+	}
+}
+
+// CanLoad checks if the path is a GIF or WebP file or frame whose file exists
+func (l *AnimationImageLoader) CanLoad(path string) bool {
+	return IsAnimationFormat(path) && fileExists(SourceFile(path))
+}
+
+// LoadImage decodes the frame named by path ("clip.gif#frame=N"); a plain
+// path decodes the first frame
+func (l *AnimationImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	file, frame, ok := SplitAnimationFramePath(path)
+	if !ok {
+		// OpenCV reads the first frame of GIFs and of still WebP files
+		img := readGray(ctx, path)
+		if !img.Empty() {
+			return img, nil
+		}
+		img.Close()
+		frame = 1
+	}
+
+	if GetFileFormat(file) == FormatGIF {
+		return loadGIFFrame(ctx, file, frame)
+	}
+	return l.loadWebPFrame(ctx, file, frame)
+}
+
+// loadGIFFrame decodes a frame of a GIF file with Go's GIF decoder
+func loadGIFFrame(ctx context.Context, path string, frame int) (gocv.Mat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	animation, err := gif.DecodeAll(bufio.NewReader(file))
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to decode GIF %s: %v", path, err)
+	}
+	if frame > len(animation.Image) {
+		return gocv.NewMat(), fmt.Errorf("GIF %s has %d frames, not %d", path, len(animation.Image), frame)
+	}
+	return grayFromGoImage(ctx, composeGIFFrame(animation, frame-1), path)
+}
+
+// composeGIFFrame draws the frames of a GIF up to index on its canvas,
+// disposing of each as its disposal method says
+func composeGIFFrame(animation *gif.GIF, index int) *image.RGBA {
+	bounds := image.Rect(0, 0, animation.Config.Width, animation.Config.Height)
+	if bounds.Empty() {
+		bounds = animation.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+
+	for i := 0; i <= index; i++ {
+		frame := animation.Image[i]
+		disposal := byte(0)
+		if i < len(animation.Disposal) {
+			disposal = animation.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious && i < index {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == index {
+			break
+		}
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return canvas
+}
+
+// loadWebPFrame decodes a frame of an animated WebP file with ImageMagick,
+// which composes the frames before it
+func (l *AnimationImageLoader) loadWebPFrame(ctx context.Context, path string, frame int) (gocv.Mat, error) {
+	tool := imageMagick()
+	if tool == "" {
+		return gocv.NewMat(), newImageLoadError("failed to decode animated WebP (install ImageMagick)", path)
+	}
+
+	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("webp_frame_%d.png", time.Now().UnixNano()))
+	defer os.Remove(tempFilename)
+
+	// -coalesce turns the frames into full images; all but the last are then
+	// deleted
+	args := []string{fmt.Sprintf("%s[0-%d]", path, frame-1), "-coalesce"}
+	if frame > 1 {
+		args = append(args, "-delete", "0--2")
+	}
+	cmd := toolCommand(ctx, tool, append(args, tempFilename)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return gocv.NewMat(), fmt.Errorf("%s failed to extract frame %d of %s: %v, stderr: %s", tool, frame, path, err, stderr.String())
+	}
+
+	img := readGray(ctx, tempFilename)
+	if img.Empty() {
+		img.Close()
+		return gocv.NewMat(), newImageLoadError(fmt.Sprintf("failed to decode frame %d of animated WebP", frame), path)
+	}
+	return img, nil
+}
@@ -0,0 +1,213 @@
+package imageprocessor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/utils"
+)
+
+// ArchiveSeparator joins an archive path and the name of an entry inside it
+// into the path stored for that entry, e.g. "backup.zip!/photos/img.jpg"
+const ArchiveSeparator = "!/"
+
+// archiveExtensions lists the archive types that can be scanned as folders
+var archiveExtensions = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+// IsArchiveFile checks if a file is a ZIP or TAR archive based on its name
+func IsArchiveFile(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveEntryPath returns the index path for an entry inside an archive
+func ArchiveEntryPath(archivePath, entry string) string {
+	return archivePath + ArchiveSeparator + entry
+}
+
+// SplitArchivePath splits an archive entry path into the archive file and the
+// entry name. It reports false for paths that don't point inside an archive.
+func SplitArchivePath(entryPath string) (string, string, bool) {
+	offset := 0
+	for {
+		index := strings.Index(entryPath[offset:], ArchiveSeparator)
+		if index < 0 {
+			return entryPath, "", false
+		}
+		index += offset
+
+		archivePath := entryPath[:index]
+		if IsArchiveFile(archivePath) {
+			return archivePath, entryPath[index+len(ArchiveSeparator):], true
+		}
+		offset = index + len(ArchiveSeparator)
+	}
+}
+
+// isArchiveJunk reports entries that never hold real images, such as the
+// resource forks macOS adds to ZIP files
+func isArchiveJunk(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._")
+}
+
+// ListArchiveImages returns the index paths of all image entries in an archive
+func ListArchiveImages(archivePath string) ([]string, error) {
+	var paths []string
+	err := walkArchive(archivePath, func(name string, info os.FileInfo, _ func() (io.Reader, error)) (bool, error) {
+		if info.Mode().IsRegular() && IsImageFile(name) && !isArchiveJunk(name) {
+			paths = append(paths, ArchiveEntryPath(archivePath, name))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// ExtractArchiveEntry streams an archive entry into a temporary file that
+// keeps the entry's extension so format detection works. The caller removes
+// the returned file.
+func ExtractArchiveEntry(entryPath string) (string, error) {
+	archivePath, entry, ok := SplitArchivePath(entryPath)
+	if !ok {
+		return "", fmt.Errorf("not an archive entry: %s", entryPath)
+	}
+
+	tempPath := ""
+	err := walkArchive(archivePath, func(name string, info os.FileInfo, open func() (io.Reader, error)) (bool, error) {
+		if name != entry {
+			return false, nil
+		}
+
+		content, err := open()
+		if err != nil {
+			return true, fmt.Errorf("cannot read %s: %v", entryPath, err)
+		}
+
+		tempFile, err := utils.CreateTemp("archive_entry_*" + strings.ToLower(filepath.Ext(entry)))
+		if err != nil {
+			return true, fmt.Errorf("failed to create temp file for %s: %v", entryPath, err)
+		}
+		defer tempFile.Close()
+
+		if _, err := io.Copy(tempFile, content); err != nil {
+			os.Remove(tempFile.Name())
+			return true, fmt.Errorf("failed to extract %s: %v", entryPath, err)
+		}
+		tempPath = tempFile.Name()
+		return true, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if tempPath == "" {
+		return "", fmt.Errorf("entry not found in archive: %s", entryPath)
+	}
+	return tempPath, nil
+}
+
+// LocalCopy returns a path the image loaders can open directly. Archive
+// entries are extracted to a temporary file, which cleanup removes; other
+// paths are returned unchanged.
+func LocalCopy(imagePath string) (string, func(), error) {
+	if _, _, ok := SplitArchivePath(imagePath); !ok {
+		return imagePath, func() {}, nil
+	}
+
+	tempPath, err := ExtractArchiveEntry(imagePath)
+	if err != nil {
+		return "", func() {}, err
+	}
+	return tempPath, func() { os.Remove(tempPath) }, nil
+}
+
+// archiveVisitor is called for each archive entry. open returns the entry's
+// content and is only valid during the call; returning true stops the walk.
+type archiveVisitor func(name string, info os.FileInfo, open func() (io.Reader, error)) (bool, error)
+
+// walkArchive calls fn for each entry of a ZIP or TAR archive until fn
+// reports that it is done
+func walkArchive(archivePath string, fn archiveVisitor) error {
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		return walkZipArchive(archivePath, fn)
+	}
+	return walkTarArchive(archivePath, fn)
+}
+
+func walkZipArchive(archivePath string, fn archiveVisitor) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("cannot open zip archive %s: %v", archivePath, err)
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		var opened io.ReadCloser
+		open := func() (io.Reader, error) {
+			content, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			opened = content
+			return content, nil
+		}
+
+		done, err := fn(file.Name, file.FileInfo(), open)
+		if opened != nil {
+			opened.Close()
+		}
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTarArchive(archivePath string, fn archiveVisitor) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("cannot open tar archive %s: %v", archivePath, err)
+	}
+	defer file.Close()
+
+	var stream io.Reader = file
+	lower := strings.ToLower(archivePath)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("cannot decompress %s: %v", archivePath, err)
+		}
+		defer gzipReader.Close()
+		stream = gzipReader
+	}
+
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read tar archive %s: %v", archivePath, err)
+		}
+
+		open := func() (io.Reader, error) { return reader, nil }
+		done, err := fn(header.Name, header.FileInfo(), open)
+		if err != nil || done {
+			return err
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package imageprocessor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"imagefinder/database"
+)
+
+// CandidateSet holds the indexed images that searches compare their query
+// with. Reading them from the database is the largest part of a search, so a
+// batch of queries reads them once and searches each query in memory.
+type CandidateSet struct {
+	candidates []database.Candidate
+}
+
+// LoadCandidates reads the images passing the filters of options. The
+// pHash band prefilter depends on the query, so FindSimilarImagesIn applies
+// it to each query instead.
+func LoadCandidates(ctx context.Context, db *sql.DB, options SearchOptions) (*CandidateSet, error) {
+	filter := candidateFilter(options)
+	rows, err := database.QueryPotentialMatches(ctx, db, filter)
+	if err != nil {
+		return nil, fmt.Errorf("database query failed: %v", err)
+	}
+	defer rows.Close()
+
+	set := &CandidateSet{}
+	for rows.Next() {
+		candidate, err := database.ScanCandidate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		if filter.Matches(candidate) {
+			set.candidates = append(set.candidates, candidate)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows: %v", err)
+	}
+	return set, nil
+}
+
+// Len returns the number of candidates in the set
+func (s *CandidateSet) Len() int {
+	return len(s.candidates)
+}
+
+// FindSimilarImagesIn is FindSimilarImages for candidates loaded beforehand
+// with LoadCandidates. The filters of options were applied by then, except
+// for Prefilter.
+func FindSimilarImagesIn(ctx context.Context, db *sql.DB, set *CandidateSet, options SearchOptions) ([]ImageMatch, error) {
+	query, err := loadSearchQuery(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	defer query.close()
+
+	matches := query.score(options, func(candidates chan<- database.Candidate) {
+		for _, candidate := range set.candidates {
+			if ctx.Err() != nil {
+				return
+			}
+			if options.Prefilter && !query.sharesBand(candidate) {
+				continue
+			}
+			candidates <- candidate
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return query.finish(ctx, db, matches, options)
+}
+
+// sharesBand reports whether the candidate shares a pHash band with the
+// query or its mirror image, as the prefilter of FindSimilarImages requires
+func (q *searchQuery) sharesBand(candidate database.Candidate) bool {
+	for _, hashes := range q.hashes {
+		if database.SharesBand(hashes.pHash, candidate.PerceptualHash) {
+			return true
+		}
+	}
+	return false
+}
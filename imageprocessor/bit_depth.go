@@ -0,0 +1,148 @@
+package imageprocessor
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"slices"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+)
+
+// Percentiles of the values mapped to black and white when an image of more
+// than 8 bits is reduced for hashing, so a few hot or dead pixels don't
+// squeeze the rest of the image into a few levels
+const (
+	depthLowPercentile  = 0.1
+	depthHighPercentile = 99.9
+)
+
+// depthSampleSize is the most pixels read to find the percentiles
+const depthSampleSize = 1 << 18
+
+// matDepthMask selects the depth of a MatType, leaving out its channels
+const matDepthMask = 7
+
+// readGrayAnyDepth decodes an image file in grayscale at its own bit depth
+// and reduces it to 8 bits with normalizeDepth, keeping a color copy for the
+// capture of ctx, if any
+func readGrayAnyDepth(ctx context.Context, path string) gocv.Mat {
+	img := gocv.IMRead(path, grayReadFlags|gocv.IMReadAnyDepth)
+	if img.Empty() {
+		return img
+	}
+	img = normalizeDepth(img, path)
+	if capture, ok := ctx.Value(colorCaptureKey{}).(*ColorCapture); ok {
+		capture.read(path)
+	}
+	return img
+}
+
+// normalizeDepth reduces a grayscale image of 16-bit or floating-point
+// values to 8 bits. OpenCV would keep the top 8 bits of 16-bit values, which
+// leaves scans and astronomy images using part of the range nearly black,
+// and clip floating-point values to 0-255. Instead the values between the
+// low and high percentiles are stretched over the 8-bit range, so the image
+// hashes like an 8-bit export of it. 8-bit images are returned as is, others
+// are closed and replaced.
+func normalizeDepth(img gocv.Mat, path string) gocv.Mat {
+	depth := img.Type() & matDepthMask
+	if depth == gocv.MatTypeCV8U || img.Channels() != 1 {
+		return img
+	}
+
+	values := gocv.NewMat()
+	defer values.Close()
+	if err := img.ConvertTo(&values, gocv.MatTypeCV32F); err != nil {
+		logging.LogWarning("Failed to read the values of %s: %v", path, err)
+		return img
+	}
+	data, err := values.DataPtrFloat32()
+	if err != nil {
+		logging.LogWarning("Failed to read the values of %s: %v", path, err)
+		return img
+	}
+
+	low, high, ok := percentileRange(data)
+	var reduced gocv.Mat
+	if ok {
+		scale := 255 / (high - low)
+		reduced = gocv.NewMat()
+		if err := values.ConvertToWithParams(&reduced, gocv.MatTypeCV8U, float32(scale), float32(-low*scale)); err != nil {
+			logging.LogWarning("Failed to reduce %s to 8 bits: %v", path, err)
+			reduced.Close()
+			return img
+		}
+	} else {
+		// A flat image has no range to stretch
+		reduced = gocv.Zeros(img.Rows(), img.Cols(), gocv.MatTypeCV8UC1)
+	}
+
+	logging.DebugLog("Reduced %s from depth %d to 8 bits, mapping %g-%g to 0-255", path, depth, low, high)
+	img.Close()
+	return reduced
+}
+
+// percentileRange returns the values at the low and high percentiles of a
+// sample of data, ignoring NaN and infinite values. When they are equal, the
+// smallest and largest values are used; false is reported when those are
+// equal too.
+func percentileRange(data []float32) (float64, float64, bool) {
+	step := max(1, len(data)/depthSampleSize)
+	sample := make([]float32, 0, len(data)/step+1)
+	for i := 0; i < len(data); i += step {
+		value := float64(data[i])
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			sample = append(sample, data[i])
+		}
+	}
+	if len(sample) == 0 {
+		return 0, 0, false
+	}
+	slices.Sort(sample)
+
+	last := len(sample) - 1
+	low := float64(sample[int(float64(last)*depthLowPercentile/100)])
+	high := float64(sample[int(float64(last)*depthHighPercentile/100)])
+	if high <= low {
+		low, high = float64(sample[0]), float64(sample[last])
+	}
+	return low, high, high > low
+}
+
+// isDeepImage reports whether a decoded image has 16 bits per channel
+func isDeepImage(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return true
+	}
+	return false
+}
+
+// gray16FromGoImage converts a decoded image to a 16-bit grayscale Mat of
+// its luminance
+func gray16FromGoImage(img image.Image) (gocv.Mat, error) {
+	bounds := img.Bounds()
+	data := make([]byte, 0, bounds.Dx()*bounds.Dy()*2)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+			data = binary.NativeEndian.AppendUint16(data, gray.Y)
+		}
+	}
+	// The Mat refers to data rather than copying it, so it is copied while
+	// data is kept alive
+	shared, err := gocv.NewMatFromBytes(bounds.Dy(), bounds.Dx(), gocv.MatTypeCV16UC1, data)
+	if err != nil {
+		return gocv.NewMat(), err
+	}
+	defer shared.Close()
+	gray := shared.Clone()
+	runtime.KeepAlive(data)
+	return gray, nil
+}
@@ -0,0 +1,249 @@
+package imageprocessor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"imagefinder/database"
+	"imagefinder/logging"
+	"imagefinder/types"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultBurstGap is the longest pause between two shots of a burst unless
+// set otherwise
+const DefaultBurstGap = 2 * time.Second
+
+// sharpnessSize is the longest edge images are scaled to before their
+// sharpness is measured, so shots of different resolutions compare fairly
+const sharpnessSize = 1024
+
+// BurstOptions defines the options for finding bursts
+type BurstOptions struct {
+	SourcePrefix string
+	MaxGap       time.Duration // Longest pause between consecutive shots
+	MaxDistance  int           // Largest pHash Hamming distance between consecutive shots
+	Sharpness    bool          // Suggest the sharpest shot, reading every file
+	PreviewCache *PreviewCache // Optional cache for converted RAW previews
+}
+
+// BurstImage is a shot of a burst
+type BurstImage struct {
+	DuplicateImage
+	Sharpness float64 `json:"sharpness,omitempty"` // Variance of the Laplacian, with BurstOptions.Sharpness
+}
+
+// Burst is a sequence of similar shots taken in quick succession by one
+// camera, with the shot suggested to keep
+type Burst struct {
+	Camera string       `json:"camera,omitempty"`
+	Start  string       `json:"start"`
+	End    string       `json:"end"`
+	Keeper BurstImage   `json:"keeper"`
+	Others []BurstImage `json:"others"` // In capture order
+}
+
+// burstShot is an image with its parsed capture time and camera
+type burstShot struct {
+	image      BurstImage
+	capturedAt time.Time
+	camera     string
+}
+
+// FindBursts finds sequences of shots by the same camera, each taken at most
+// MaxGap after the previous one and with a pHash within MaxDistance bits of
+// it. The keeper is the highest rated shot, then the largest, like the keeper
+// of a duplicate group; with Sharpness the sharpest shot comes before the
+// largest. Images indexed without a capture date are placed at their
+// modification time, which the index stores instead.
+func FindBursts(ctx context.Context, db *sql.DB, options BurstOptions) ([]Burst, error) {
+	var shots []burstShot
+	err := database.ForEachImage(ctx, db, options.SourcePrefix, func(info types.ImageInfo) error {
+		// Seeded images are not hashed until they are scanned
+		if info.ModifiedAt == "" || info.CapturedAt == "" {
+			return nil
+		}
+		capturedAt, err := time.Parse(time.RFC3339, info.CapturedAt)
+		if err != nil {
+			return nil
+		}
+		shots = append(shots, burstShot{
+			image: BurstImage{DuplicateImage: DuplicateImage{
+				Path:         info.Path,
+				SourcePrefix: info.SourcePrefix,
+				Format:       info.Format,
+				Width:        info.Width,
+				Height:       info.Height,
+				Size:         info.Size,
+				ModifiedAt:   info.ModifiedAt,
+				CapturedAt:   info.CapturedAt,
+				Rating:       info.Rating,
+				hash:         info.PerceptualHash,
+			}},
+			capturedAt: capturedAt,
+			camera:     strings.TrimSpace(info.CameraMake + " " + info.CameraModel),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Shots in the same second keep their file order, which follows the
+	// camera's numbering
+	sort.Slice(shots, func(i, j int) bool {
+		a, b := shots[i], shots[j]
+		if a.camera != b.camera {
+			return a.camera < b.camera
+		}
+		if !a.capturedAt.Equal(b.capturedAt) {
+			return a.capturedAt.Before(b.capturedAt)
+		}
+		if a.image.Path != b.image.Path {
+			return a.image.Path < b.image.Path
+		}
+		return a.image.SourcePrefix < b.image.SourcePrefix
+	})
+
+	var sequences [][]burstShot
+	start := 0
+	for i := 1; i <= len(shots); i++ {
+		if i < len(shots) {
+			previous, shot := shots[i-1], shots[i]
+			if shot.camera == previous.camera && shot.capturedAt.Sub(previous.capturedAt) <= options.MaxGap &&
+				shot.image.hash.Distance(previous.image.hash) <= options.MaxDistance {
+				continue
+			}
+		}
+		if i-start > 1 {
+			sequences = append(sequences, shots[start:i])
+		}
+		start = i
+	}
+
+	var bursts []Burst
+	for _, sequence := range sequences {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		bursts = append(bursts, newBurst(ctx, sequence, options))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return bursts, nil
+}
+
+// newBurst picks the keeper of a sequence of shots
+func newBurst(ctx context.Context, sequence []burstShot, options BurstOptions) Burst {
+	images := make([]BurstImage, len(sequence))
+	for i, shot := range sequence {
+		images[i] = shot.image
+		if options.Sharpness {
+			sharpness, err := measureSharpness(ctx, shot.image.Path, options.PreviewCache)
+			if err != nil {
+				logging.LogWarning("Cannot measure the sharpness of %s: %v", shot.image.Path, err)
+			}
+			images[i].Sharpness = sharpness
+		}
+	}
+
+	keeper := 0
+	for i, image := range images {
+		if i == keeper {
+			continue
+		}
+		best := images[keeper]
+		if image.Rating != best.Rating {
+			if image.Rating > best.Rating {
+				keeper = i
+			}
+			continue
+		}
+		if image.Sharpness != best.Sharpness {
+			if image.Sharpness > best.Sharpness {
+				keeper = i
+			}
+			continue
+		}
+		if betterKeeper(image.DuplicateImage, best.DuplicateImage) {
+			keeper = i
+		}
+	}
+
+	burst := Burst{
+		Camera: sequence[0].camera,
+		Start:  sequence[0].image.CapturedAt,
+		End:    sequence[len(sequence)-1].image.CapturedAt,
+		Keeper: images[keeper],
+	}
+	for i, image := range images {
+		if i != keeper {
+			image.Distance = burst.Keeper.hash.Distance(image.hash)
+			burst.Others = append(burst.Others, image)
+		}
+	}
+	return burst
+}
+
+// measureSharpness returns the variance of the Laplacian of the image, a
+// measure of its fine detail that is higher for sharper shots
+func measureSharpness(ctx context.Context, path string, cache *PreviewCache) (float64, error) {
+	if _, err := os.Stat(SourceFile(path)); err != nil {
+		return 0, err
+	}
+	img, err := loadSearchImage(ctx, path, cache, types.RawModeAuto)
+	if err != nil {
+		return 0, err
+	}
+	defer img.Close()
+	if img.Empty() {
+		return 0, fmt.Errorf("empty image")
+	}
+
+	gray := workMats.Get()
+	defer workMats.Put(gray)
+	if img.Channels() != 1 {
+		if err := gocv.CvtColor(img, &gray, gocv.ColorBGRToGray); err != nil {
+			return 0, err
+		}
+	} else {
+		img.CopyTo(&gray)
+	}
+
+	scaled := workMats.Get()
+	defer workMats.Put(scaled)
+	width, height := gray.Cols(), gray.Rows()
+	if longest := max(width, height); longest > sharpnessSize {
+		scale := float64(sharpnessSize) / float64(longest)
+		size := image.Point{X: max(1, int(float64(width)*scale)), Y: max(1, int(float64(height)*scale))}
+		if err := gocv.Resize(gray, &scaled, size, 0, 0, gocv.InterpolationArea); err != nil {
+			return 0, err
+		}
+	} else {
+		gray.CopyTo(&scaled)
+	}
+
+	laplacian := workMats.Get()
+	defer workMats.Put(laplacian)
+	if err := gocv.Laplacian(scaled, &laplacian, gocv.MatTypeCV64F, 3, 1, 0, gocv.BorderDefault); err != nil {
+		return 0, err
+	}
+
+	mean := gocv.NewMat()
+	defer mean.Close()
+	stdDev := gocv.NewMat()
+	defer stdDev.Close()
+	if err := gocv.MeanStdDev(laplacian, &mean, &stdDev); err != nil {
+		return 0, err
+	}
+	deviation := stdDev.GetDoubleAt(0, 0)
+	return deviation * deviation, nil
+}
@@ -0,0 +1,275 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"sync"
+
+	"imagefinder/logging"
+	"imagefinder/types"
+)
+
+// CalibrationOptions are the options of Calibrate
+type CalibrationOptions struct {
+	Strategy     Strategy      // How the hash similarities are combined (empty weighs both)
+	Metric       Metric        // How the images are compared (empty uses SSIM)
+	RawMode      types.RawMode // Which image of RAW files is decoded (empty for auto)
+	PreviewCache *PreviewCache // Optional cache for converted RAW previews
+	RandomPairs  int           // Pairs of unrelated images scored
+	Workers      int           // Images loaded in parallel (0 uses one per CPU)
+}
+
+// PairScore is how alike the two images of a pair are
+type PairScore struct {
+	A, B         string
+	HashScore    float64 // Hash similarity under the strategy, without the filename boost
+	HashDistance int     // Bits in which the hashes deciding a match differ
+	Score        float64 // Similarity measured by the metric
+	Mixed        bool    // Only one of the images is a RAW file
+}
+
+// Calibration holds the scores of pairs of images known to show the same
+// picture and of random pairs of images that don't
+type Calibration struct {
+	Matching []PairScore
+	Random   []PairScore
+	Failed   []string // Images that couldn't be read, left out of every pair
+}
+
+// calibrationImage is what the pairs of an image are scored with
+type calibrationImage struct {
+	hashes queryHashes
+	plane  plane
+	raw    bool
+}
+
+// Calibrate scores every pair of images within each group, which are known
+// to show the same picture, and random pairs of images from different
+// groups, loading each image once as searches load their query
+func Calibrate(ctx context.Context, groups [][]string, options CalibrationOptions) (Calibration, error) {
+	var paths []string
+	for _, group := range groups {
+		paths = append(paths, group...)
+	}
+	images, failed, err := loadCalibrationImages(ctx, paths, options)
+	if err != nil {
+		return Calibration{}, err
+	}
+
+	// Images that couldn't be read drop out of their group
+	readable := make([][]string, 0, len(groups))
+	for _, group := range groups {
+		var kept []string
+		for _, path := range group {
+			if _, ok := images[path]; ok {
+				kept = append(kept, path)
+			}
+		}
+		if len(kept) > 0 {
+			readable = append(readable, kept)
+		}
+	}
+
+	calibration := Calibration{Failed: failed}
+	score := func(a, b string) PairScore {
+		imageA, imageB := images[a], images[b]
+		avgHashDistance := imageA.hashes.avgHash.Distance(imageB.hashes.avgHash)
+		pHashDistance := imageA.hashes.pHash.Distance(imageB.hashes.pHash)
+		return PairScore{
+			A: a,
+			B: b,
+			HashScore: options.Strategy.combine(imageA.hashes.avgHash.Similarity(imageB.hashes.avgHash),
+				imageA.hashes.pHash.Similarity(imageB.hashes.pHash)),
+			HashDistance: options.Strategy.distance(avgHashDistance, pHashDistance),
+			Score:        comparePlanes(imageA.plane, imageB.plane, options.Metric),
+			Mixed:        imageA.raw != imageB.raw,
+		}
+	}
+
+	for _, group := range readable {
+		for i := range group {
+			for j := i + 1; j < len(group); j++ {
+				calibration.Matching = append(calibration.Matching, score(group[i], group[j]))
+			}
+		}
+	}
+	for _, pair := range randomPairs(readable, options.RandomPairs) {
+		if err := ctx.Err(); err != nil {
+			return calibration, err
+		}
+		calibration.Random = append(calibration.Random, score(pair[0], pair[1]))
+	}
+	return calibration, nil
+}
+
+// loadCalibrationImages hashes the images at paths in parallel and scales
+// them to the comparison size, returning those that couldn't be read apart
+func loadCalibrationImages(ctx context.Context, paths []string, options CalibrationOptions) (map[string]calibrationImage, []string, error) {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	images := make(map[string]calibrationImage, len(paths))
+	var failed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	for i := 0; i < min(workers, len(paths)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				path := paths[index]
+				image, err := loadCalibrationImage(ctx, path, options)
+				mu.Lock()
+				if err != nil {
+					if ctx.Err() == nil {
+						logging.LogWarning("Cannot read %s, leaving it out: %v", path, err)
+					}
+					failed = append(failed, path)
+				} else {
+					images[path] = image
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	sort.Strings(failed)
+	return images, failed, ctx.Err()
+}
+
+// loadCalibrationImage loads an image as the query of a search
+func loadCalibrationImage(ctx context.Context, path string, options CalibrationOptions) (calibrationImage, error) {
+	img, err := loadSearchImage(ctx, path, options.PreviewCache, options.RawMode)
+	if err != nil {
+		return calibrationImage{}, err
+	}
+	defer img.Close()
+
+	hashes, err := hashForComparison(img)
+	if err != nil {
+		return calibrationImage{}, err
+	}
+	plane, err := comparisonPlane(img)
+	if err != nil {
+		return calibrationImage{}, fmt.Errorf("failed to scale %s: %v", path, err)
+	}
+	return calibrationImage{hashes: hashes, plane: plane, raw: IsRawFormat(path)}, nil
+}
+
+// randomPairs picks up to count distinct pairs of images from different
+// groups, all of them when there are no more. The pairs are the same on
+// every run, so calibrations of the same folder can be compared.
+func randomPairs(groups [][]string, count int) [][2]string {
+	type member struct{ group, index int }
+	var members []member
+	for g, group := range groups {
+		for i := range group {
+			members = append(members, member{g, i})
+		}
+	}
+	path := func(m member) string { return groups[m.group][m.index] }
+
+	// Pairs of images from different groups
+	total := 0
+	for _, group := range groups {
+		total += len(group) * (len(members) - len(group))
+	}
+	total /= 2
+
+	var pairs [][2]string
+	if total <= count {
+		for i, a := range members {
+			for _, b := range members[i+1:] {
+				if a.group != b.group {
+					pairs = append(pairs, [2]string{path(a), path(b)})
+				}
+			}
+		}
+		return pairs
+	}
+
+	random := rand.New(rand.NewPCG(1, 2))
+	seen := make(map[[2]int]bool, count)
+	for len(pairs) < count {
+		i, j := random.IntN(len(members)), random.IntN(len(members))
+		if members[i].group == members[j].group {
+			continue
+		}
+		key := [2]int{min(i, j), max(i, j)}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		pairs = append(pairs, [2]string{path(members[key[0]]), path(members[key[1]])})
+	}
+	return pairs
+}
+
+// Accepts reports whether a search with the tolerances and threshold would
+// match the pair, leaving out the filename boost
+func (t Tolerances) Accepts(pair PairScore, threshold float64) bool {
+	if pair.Mixed {
+		threshold -= t.RawLeniency
+	}
+	if pair.HashScore < threshold {
+		return false
+	}
+	if t.MaxHashDistance > 0 && pair.HashDistance > t.MaxHashDistance {
+		return false
+	}
+	return t.MinScore == 0 || pair.Score >= t.MinScore
+}
+
+// Rates returns the shares of the matching and of the random pairs that a
+// search with the tolerances and threshold would match
+func (c Calibration) Rates(tolerances Tolerances, threshold float64) (matching, random float64) {
+	share := func(pairs []PairScore) float64 {
+		if len(pairs) == 0 {
+			return 0
+		}
+		accepted := 0
+		for _, pair := range pairs {
+			if tolerances.Accepts(pair, threshold) {
+				accepted++
+			}
+		}
+		return float64(accepted) / float64(len(pairs))
+	}
+	return share(c.Matching), share(c.Random)
+}
+
+// SuggestThreshold returns the highest threshold, in hundredths, at which
+// the hash scores of at least the given share of the matching pairs reach it
+func (c Calibration) SuggestThreshold(recall float64) float64 {
+	if len(c.Matching) == 0 {
+		return 0
+	}
+	scores := make([]float64, len(c.Matching))
+	for i, pair := range c.Matching {
+		scores[i] = pair.HashScore
+	}
+	sort.Float64s(scores)
+
+	// The lowest score that must still reach the threshold
+	kept := int(math.Ceil(float64(len(scores)) * recall))
+	kept = min(max(kept, 1), len(scores))
+	lowest := scores[len(scores)-kept]
+	// The epsilon keeps scores such as 0.85 from rounding down to 0.84
+	return float64(int(lowest*100+1e-9)) / 100
+}
@@ -0,0 +1,198 @@
+package imageprocessor
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"sort"
+	"sync"
+
+	"imagefinder/database"
+	"imagefinder/types"
+)
+
+// ClusterOptions defines the options for clustering the index
+type ClusterOptions struct {
+	SourcePrefix string
+	MaxDistance  int // Largest pHash Hamming distance linking two images
+	MinSize      int // Smallest cluster returned (0 or 1 returns every image)
+}
+
+// ClusterImage is an image in a cluster
+type ClusterImage struct {
+	Path           string     `json:"path"`
+	SourcePrefix   string     `json:"source_prefix,omitempty"`
+	CapturedAt     string     `json:"captured_at,omitempty"`
+	PerceptualHash types.Hash `json:"perceptual_hash"`
+}
+
+// Cluster is a set of images linked by chains of similar hashes
+type Cluster struct {
+	ID     int            `json:"id"`
+	Images []ClusterImage `json:"images"`
+}
+
+// FindClusters splits the indexed images into clusters: the connected
+// components of the graph joining images whose pHashes are within
+// MaxDistance bits. Clusters are numbered from 1, largest first, and their
+// images are ordered by capture date, then path, so bursts stay in sequence.
+func FindClusters(ctx context.Context, db *sql.DB, options ClusterOptions) ([]Cluster, error) {
+	var images []ClusterImage
+	err := database.ForEachImage(ctx, db, options.SourcePrefix, func(info types.ImageInfo) error {
+		// Seeded images are not hashed until they are scanned
+		if info.ModifiedAt == "" {
+			return nil
+		}
+		images = append(images, ClusterImage{
+			Path:           info.Path,
+			SourcePrefix:   info.SourcePrefix,
+			CapturedAt:     info.CapturedAt,
+			PerceptualHash: info.PerceptualHash,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]types.Hash, len(images))
+	for i, image := range images {
+		hashes[i] = image.PerceptualHash
+	}
+	components, err := connectHashes(ctx, hashes, options.MaxDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[int][]ClusterImage)
+	for i, image := range images {
+		members[components[i]] = append(members[components[i]], image)
+	}
+
+	var clusters []Cluster
+	for _, cluster := range members {
+		if len(cluster) < options.MinSize {
+			continue
+		}
+		sort.Slice(cluster, func(i, j int) bool {
+			a, b := cluster[i], cluster[j]
+			if a.CapturedAt != b.CapturedAt {
+				return a.CapturedAt < b.CapturedAt
+			}
+			if a.Path != b.Path {
+				return a.Path < b.Path
+			}
+			return a.SourcePrefix < b.SourcePrefix
+		})
+		clusters = append(clusters, Cluster{Images: cluster})
+	}
+
+	// Largest clusters first, then by first image so the IDs are stable
+	sort.Slice(clusters, func(i, j int) bool {
+		a, b := clusters[i].Images, clusters[j].Images
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		if a[0].Path != b[0].Path {
+			return a[0].Path < b[0].Path
+		}
+		return a[0].SourcePrefix < b[0].SourcePrefix
+	})
+	for i := range clusters {
+		clusters[i].ID = i + 1
+	}
+
+	return clusters, nil
+}
+
+// connectHashes links every two hashes within maxDistance bits and returns
+// the component of each hash, identified by one of its members. Components
+// are transitive: two hashes end up together when a chain of close hashes
+// links them.
+func connectHashes(ctx context.Context, hashes []types.Hash, maxDistance int) ([]int, error) {
+	components := newUnionFind(len(hashes))
+
+	if maxDistance < database.HashBands {
+		// Close hashes share a band, so only hashes in the same bucket are compared
+		buckets := make(map[[2]int64][]int)
+		for i, hash := range hashes {
+			for band := 0; band < database.HashBands; band++ {
+				key := [2]int64{int64(band), database.HashBand(hash, band)}
+				buckets[key] = append(buckets[key], i)
+			}
+		}
+		for _, bucket := range buckets {
+			for a := 0; a < len(bucket); a++ {
+				for b := a + 1; b < len(bucket); b++ {
+					if hashes[bucket[a]].Distance(hashes[bucket[b]]) <= maxDistance {
+						components.union(bucket[a], bucket[b])
+					}
+				}
+			}
+		}
+	} else {
+		// Every pair is compared. Each worker links the rows it is given in
+		// its own union-find, and those are merged at the end.
+		workers := runtime.NumCPU()
+		partial := make([]unionFind, workers)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			partial[w] = newUnionFind(len(hashes))
+			wg.Add(1)
+			go func(own unionFind, first int) {
+				defer wg.Done()
+				for i := first; i < len(hashes); i += workers {
+					if ctx.Err() != nil {
+						return
+					}
+					for j := i + 1; j < len(hashes); j++ {
+						if hashes[i].Distance(hashes[j]) <= maxDistance {
+							own.union(i, j)
+						}
+					}
+				}
+			}(partial[w], w)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, own := range partial {
+			for i := range hashes {
+				components.union(i, own.find(i))
+			}
+		}
+	}
+
+	roots := make([]int, len(hashes))
+	for i := range roots {
+		roots[i] = components.find(i)
+	}
+	return roots, nil
+}
+
+// unionFind tracks the components of a graph as a parent per node
+type unionFind []int
+
+func newUnionFind(size int) unionFind {
+	parent := make(unionFind, size)
+	for i := range parent {
+		parent[i] = i
+	}
+	return parent
+}
+
+// find returns the root of a node's component, halving the path to it
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(i, j int) {
+	if rootI, rootJ := u.find(i), u.find(j); rootI != rootJ {
+		u[rootI] = rootJ
+	}
+}
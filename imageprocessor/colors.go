@@ -0,0 +1,207 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sort"
+
+	"imagefinder/types"
+
+	"gocv.io/x/gocv"
+)
+
+// DominantColorCount is the number of main colors kept per image
+const DominantColorCount = 3
+
+// colorSampleSize is the longest edge images are scaled to before their
+// colors are counted
+const colorSampleSize = 128
+
+// colorLevels is the number of levels per channel that colors are grouped
+// into, so shades of the same color count together
+const colorLevels = 4
+
+// colorCaptureKey is the context key of a ColorCapture
+type colorCaptureKey struct{}
+
+// ColorCapture receives a color copy of the image decoded by a loader. The
+// loaders decode in grayscale for hashing; with a capture in their context
+// they also read the decoded file in color, at a quarter of its size.
+type ColorCapture struct {
+	img      gocv.Mat
+	captured bool
+}
+
+// CaptureColor returns a context in which loaders keep a color copy of the
+// image they decode, and the capture that receives it. Close the capture when
+// done.
+func CaptureColor(ctx context.Context) (context.Context, *ColorCapture) {
+	capture := &ColorCapture{}
+	return context.WithValue(ctx, colorCaptureKey{}, capture), capture
+}
+
+// capturingColor reports whether ctx asks loaders for a color copy
+func capturingColor(ctx context.Context) bool {
+	_, ok := ctx.Value(colorCaptureKey{}).(*ColorCapture)
+	return ok
+}
+
+// grayReadFlags decode images in grayscale with their pixels as stored;
+// OrientImage applies the EXIF orientation once the image is loaded
+const grayReadFlags = gocv.IMReadGrayScale | gocv.IMReadIgnoreOrientation
+
+// readGray decodes an image file in grayscale, keeping a color copy for the
+// capture of ctx, if any
+func readGray(ctx context.Context, path string) gocv.Mat {
+	img := gocv.IMRead(path, grayReadFlags)
+	if img.Empty() {
+		return img
+	}
+	if capture, ok := ctx.Value(colorCaptureKey{}).(*ColorCapture); ok {
+		capture.read(path)
+	}
+	return img
+}
+
+// decodeGray decodes an encoded image, such as a JPEG preview embedded in a
+// RAW file, in grayscale, keeping a color copy for the capture of ctx, if any
+func decodeGray(ctx context.Context, data []byte) gocv.Mat {
+	img, err := gocv.IMDecode(data, grayReadFlags)
+	if err != nil || img.Empty() {
+		return img
+	}
+	if capture, ok := ctx.Value(colorCaptureKey{}).(*ColorCapture); ok {
+		color, err := gocv.IMDecode(data, gocv.IMReadReducedColor4)
+		if err == nil {
+			capture.keep(color)
+		}
+	}
+	return img
+}
+
+// grayFromGoImage converts an image decoded from path by Go's image packages
+// to grayscale, keeping a color copy for the capture of ctx, if any
+func grayFromGoImage(ctx context.Context, decoded image.Image, path string) (gocv.Mat, error) {
+	img, err := gocv.ImageToMatRGB(decoded)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to convert decoded image: %v", err)
+	}
+	defer img.Close()
+
+	if capture, ok := ctx.Value(colorCaptureKey{}).(*ColorCapture); ok {
+		color := gocv.NewMat()
+		size := image.Point{X: max(1, img.Cols()/4), Y: max(1, img.Rows()/4)}
+		if err := gocv.Resize(img, &color, size, 0, 0, gocv.InterpolationArea); err == nil {
+			capture.keep(color)
+		} else {
+			color.Close()
+		}
+	}
+
+	if isDeepImage(decoded) {
+		// Reduced from all 16 bits rather than the top 8 ImageToMatRGB keeps
+		gray, err := gray16FromGoImage(decoded)
+		if err != nil {
+			return gocv.NewMat(), fmt.Errorf("failed to convert decoded image to grayscale: %v", err)
+		}
+		return normalizeDepth(gray, path), nil
+	}
+
+	gray := gocv.NewMat()
+	if err := gocv.CvtColor(img, &gray, gocv.ColorBGRToGray); err != nil {
+		gray.Close()
+		return gocv.NewMat(), fmt.Errorf("failed to convert decoded image to grayscale: %v", err)
+	}
+	return gray, nil
+}
+
+// read keeps the color copy of a decoded file, replacing one kept by an
+// earlier conversion attempt
+func (c *ColorCapture) read(path string) {
+	c.keep(gocv.IMRead(path, gocv.IMReadReducedColor4))
+}
+
+// keep takes ownership of a quarter-size color copy, replacing one kept by an
+// earlier conversion attempt
+func (c *ColorCapture) keep(img gocv.Mat) {
+	if img.Empty() || img.Channels() != 3 {
+		img.Close()
+		return
+	}
+	if c.captured {
+		c.img.Close()
+	}
+	c.img, c.captured = img, true
+}
+
+// DominantColors returns the main colors of the captured image
+func (c *ColorCapture) DominantColors() ([]types.DominantColor, error) {
+	if !c.captured {
+		return nil, fmt.Errorf("no color copy of the image was decoded")
+	}
+	return DominantColors(c.img)
+}
+
+// Close releases the captured image
+func (c *ColorCapture) Close() {
+	if c.captured {
+		c.img.Close()
+		c.captured = false
+	}
+}
+
+// DominantColors returns the DominantColorCount most common colors of a BGR
+// image, most common first. Pixels are grouped by rounding each channel to
+// one of colorLevels levels; each color is the average of its group.
+func DominantColors(img gocv.Mat) ([]types.DominantColor, error) {
+	if img.Empty() || img.Channels() != 3 {
+		return nil, fmt.Errorf("dominant colors need a color image")
+	}
+
+	sample := workMats.Get()
+	defer workMats.Put(sample)
+	width, height := img.Cols(), img.Rows()
+	if longest := max(width, height); longest > colorSampleSize {
+		scale := float64(colorSampleSize) / float64(longest)
+		size := image.Point{X: max(1, int(float64(width)*scale)), Y: max(1, int(float64(height)*scale))}
+		if err := gocv.Resize(img, &sample, size, 0, 0, gocv.InterpolationArea); err != nil {
+			return nil, fmt.Errorf("failed to resize image for color analysis: %v", err)
+		}
+	} else {
+		img.CopyTo(&sample)
+	}
+
+	type group struct {
+		red, green, blue, count int
+	}
+	groups := make([]group, colorLevels*colorLevels*colorLevels)
+	pixels := sample.ToBytes()
+	for i := 0; i+2 < len(pixels); i += 3 {
+		blue, green, red := int(pixels[i]), int(pixels[i+1]), int(pixels[i+2])
+		index := (red*colorLevels/256*colorLevels+green*colorLevels/256)*colorLevels + blue*colorLevels/256
+		groups[index].red += red
+		groups[index].green += green
+		groups[index].blue += blue
+		groups[index].count++
+	}
+	total := len(pixels) / 3
+	if total == 0 {
+		return nil, fmt.Errorf("dominant colors need a color image")
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].count > groups[j].count
+	})
+	var colors []types.DominantColor
+	for _, g := range groups[:DominantColorCount] {
+		if g.count == 0 {
+			break
+		}
+		colors = append(colors, types.DominantColor{
+			Color:    types.RGBColor(uint8(g.red/g.count), uint8(g.green/g.count), uint8(g.blue/g.count)),
+			Fraction: float64(g.count) / float64(total),
+		})
+	}
+	return colors, nil
+}
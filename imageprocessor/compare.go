@@ -0,0 +1,59 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"gocv.io/x/gocv"
+)
+
+// ImageComparison is how alike two images are, by their hashes and by the
+// metric that verifies search matches
+type ImageComparison struct {
+	AverageHash    float64 // Similarity of the average hashes, from 0 to 1
+	PerceptualHash float64 // Similarity of the perceptual hashes, from 0 to 1
+	HashScore      float64 // The hash similarities combined by the strategy
+	Score          float64 // Similarity measured by the metric
+}
+
+// CompareFiles loads two images as searches load their query and compares
+// them with the strategy and metric of options. The file names are not
+// compared.
+func CompareFiles(ctx context.Context, pathA, pathB string, options SearchOptions) (ImageComparison, error) {
+	a, err := loadSearchImage(ctx, pathA, options.PreviewCache, options.RawMode)
+	if err != nil {
+		return ImageComparison{}, fmt.Errorf("failed to load %s: %v", pathA, err)
+	}
+	defer a.Close()
+	b, err := loadSearchImage(ctx, pathB, options.PreviewCache, options.RawMode)
+	if err != nil {
+		return ImageComparison{}, fmt.Errorf("failed to load %s: %v", pathB, err)
+	}
+	defer b.Close()
+
+	hashesA, err := hashForComparison(a)
+	if err != nil {
+		return ImageComparison{}, err
+	}
+	hashesB, err := hashForComparison(b)
+	if err != nil {
+		return ImageComparison{}, err
+	}
+
+	comparison := ImageComparison{
+		AverageHash:    hashesA.avgHash.Similarity(hashesB.avgHash),
+		PerceptualHash: hashesA.pHash.Similarity(hashesB.pHash),
+	}
+	comparison.HashScore = options.Strategy.combine(comparison.AverageHash, comparison.PerceptualHash)
+	if comparison.Score, err = CompareImages(a, b, options.Metric); err != nil {
+		return comparison, fmt.Errorf("failed to compare %s with %s: %v", pathA, pathB, err)
+	}
+	return comparison, nil
+}
+
+// hashForComparison hashes an image preprocessed as search queries are
+func hashForComparison(img gocv.Mat) (queryHashes, error) {
+	processed := preprocessImageForHashing(img)
+	defer processed.Close()
+	return computeQueryHashes(processed, false)
+}
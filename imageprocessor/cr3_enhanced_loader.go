@@ -2,16 +2,17 @@ package imageprocessor
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"imagefinder/logging"
+	"imagefinder/utils"
 
 	"gocv.io/x/gocv"
 )
@@ -60,7 +61,7 @@ func readBox(r io.Reader) (*EnhancedBox, error) {
 
 // NewEnhancedCR3ImageLoader creates a new enhanced loader for CR3 files
 func NewEnhancedCR3ImageLoader() *EnhancedCR3ImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &EnhancedCR3ImageLoader{
 		TempDir: tempDir,
 	}
@@ -72,7 +73,7 @@ func (l *EnhancedCR3ImageLoader) CanLoad(path string) bool {
 }
 
 // LoadImage loads a CR3 image with enhanced methods
-func (l *EnhancedCR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *EnhancedCR3ImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	logging.LogInfo("Loading CR3 image with enhanced loader: %s", path)
 
 	// Create a unique temporary filename for the converted image
@@ -96,9 +97,9 @@ func (l *EnhancedCR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	for _, tag := range previewTags {
 		logging.LogInfo("Trying to extract CR3 %s", tag)
-		if err := l.extractWithExiftool(path, tempFilename, tag); err == nil {
+		if err := l.extractWithExiftool(ctx, path, tempFilename, tag); err == nil {
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					logging.LogInfo("Successfully extracted CR3 %s", tag)
 					return img, nil
@@ -109,23 +110,23 @@ func (l *EnhancedCR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// Try CR3 native parser (pure Go implementation)
 	logging.LogInfo("Trying CR3 native parser")
-	if success, img := l.tryCR3NativeParser(path, tempFilename); success {
+	if success, img := l.tryCR3NativeParser(ctx, path, tempFilename); success {
 		logging.LogInfo("Successfully loaded CR3 using native parser")
 		return img, nil
 	}
 
 	// Try with libheif (CR3 can contain HEIF/HEIC images)
 	logging.LogInfo("Trying CR3 with libheif")
-	if success, img := l.tryLibheif(path, tempFilename); success {
+	if success, img := l.tryLibheif(ctx, path, tempFilename); success {
 		logging.LogInfo("Successfully loaded CR3 using libheif")
 		return img, nil
 	}
 
 	// Try with rawtherapee as fallback
 	logging.LogInfo("Trying CR3 with rawtherapee")
-	if err := convertWithRawtherapee(path, tempFilename); err == nil {
+	if err := convertWithRawtherapee(ctx, path, tempFilename); err == nil {
 		if hasFileContent(tempFilename) {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				logging.LogInfo("Successfully loaded CR3 using rawtherapee")
 				return img, nil
@@ -135,7 +136,7 @@ func (l *EnhancedCR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// If all else fails, try direct load (unlikely to work)
 	logging.LogInfo("All CR3 methods failed, attempting direct load as last resort")
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Try with standard Go image packages as last resort
 		if goImg, err := tryGoImagePackages(path); err == nil {
@@ -150,12 +151,12 @@ func (l *EnhancedCR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 }
 
 // Extract specific preview with exiftool
-func (l *EnhancedCR3ImageLoader) extractWithExiftool(path string, outputPath string, tag string) error {
+func (l *EnhancedCR3ImageLoader) extractWithExiftool(ctx context.Context, path string, outputPath string, tag string) error {
 	if !hasExiftool() {
 		return fmt.Errorf("exiftool not found")
 	}
 
-	cmd := exec.Command("exiftool", "-b", "-"+tag, path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-"+tag, path)
 
 	outFile, err := os.Create(outputPath)
 	if err != nil {
@@ -183,7 +184,7 @@ func (l *EnhancedCR3ImageLoader) extractWithExiftool(path string, outputPath str
 }
 
 // tryCR3NativeParser implements a basic pure Go CR3 parser to extract embedded JPEG
-func (l *EnhancedCR3ImageLoader) tryCR3NativeParser(path string, outputPath string) (bool, gocv.Mat) {
+func (l *EnhancedCR3ImageLoader) tryCR3NativeParser(ctx context.Context, path string, outputPath string) (bool, gocv.Mat) {
 	// Open the CR3 file
 	file, err := os.Open(path)
 	if err != nil {
@@ -272,11 +273,11 @@ func (l *EnhancedCR3ImageLoader) tryCR3NativeParser(path string, outputPath stri
 	// Check if we have a valid JPEG now
 	if !isValidJpeg(outputPath) {
 		// Try to fix the JPEG if needed
-		fixJpeg(outputPath)
+		fixJpeg(ctx, outputPath)
 	}
 
 	// Load the extracted image
-	img := gocv.IMRead(outputPath, gocv.IMReadGrayScale)
+	img := readGray(ctx, outputPath)
 	if !img.Empty() {
 		return true, img
 	}
@@ -295,27 +296,26 @@ func (l *EnhancedCR3ImageLoader) tryGoExiftool(path string, outputPath string) (
 }
 
 // tryLibheif attempts to use libheif to extract HEIF/HEIC images from CR3
-func (l *EnhancedCR3ImageLoader) tryLibheif(path string, outputPath string) (bool, gocv.Mat) {
+func (l *EnhancedCR3ImageLoader) tryLibheif(ctx context.Context, path string, outputPath string) (bool, gocv.Mat) {
 	// Check if heif-convert tool is available
-	_, err := exec.LookPath("heif-convert")
-	if err != nil {
+	if !hasTool("heif-convert") {
 		return false, gocv.NewMat()
 	}
 
 	// Try to convert using heif-convert
-	cmd := exec.Command("heif-convert", path, outputPath)
+	cmd := toolCommand(ctx, "heif-convert", path, outputPath)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		logging.LogWarning("heif-convert failed: %v, stderr: %s", err, stderr.String())
 		return false, gocv.NewMat()
 	}
 
 	// Check if we got a valid image
-	img := gocv.IMRead(outputPath, gocv.IMReadGrayScale)
+	img := readGray(ctx, outputPath)
 	if !img.Empty() {
 		return true, img
 	}
@@ -341,17 +341,16 @@ func isValidJpeg(path string) bool {
 }
 
 // fixJpeg attempts to repair a corrupted JPEG
-func fixJpeg(path string) error {
+func fixJpeg(ctx context.Context, path string) error {
 	// Check if jpegtran is available
-	_, err := exec.LookPath("jpegtran")
-	if err != nil {
-		return err
+	if !hasTool("jpegtran") {
+		return os.ErrNotExist
 	}
 
 	tempFile := path + ".fixed"
 
-	cmd := exec.Command("jpegtran", "-copy", "none", "-outfile", tempFile, path)
-	err = cmd.Run()
+	cmd := toolCommand(ctx, "jpegtran", "-copy", "none", "-outfile", tempFile, path)
+	err := cmd.Run()
 
 	if err != nil {
 		return err
@@ -1,12 +1,13 @@
 package imageprocessor
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"imagefinder/logging"
+	"imagefinder/utils"
 
 	"github.com/barasher/go-exiftool"
 
@@ -20,7 +21,7 @@ type CR3ExiftoolLoader struct {
 
 // NewCR3ExiftoolLoader creates a new CR3 loader that uses go-exiftool
 func NewCR3ExiftoolLoader() *CR3ExiftoolLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &CR3ExiftoolLoader{
 		TempDir: tempDir,
 	}
@@ -31,7 +32,7 @@ func (l *CR3ExiftoolLoader) CanLoad(path string) bool {
 	return ext == ".cr3" && fileExists(path)
 }
 
-func (l *CR3ExiftoolLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *CR3ExiftoolLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	logging.LogInfo("Loading CR3 image with go-exiftool: %s", path)
 
 	// Initialize exiftool
@@ -70,14 +71,17 @@ func (l *CR3ExiftoolLoader) LoadImage(path string) (gocv.Mat, error) {
 
 		// Try to extract preview using exiftool command line
 		// Since go-exiftool doesn't directly support binary extraction
-		if err := l.extractPreview(path, tempFilename, tag); err == nil {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+		err := l.extractPreview(ctx, path, tempFilename, tag)
+		if err == nil {
+			img := readGray(ctx, tempFilename)
 			os.Remove(tempFilename) // Clean up
 
 			if !img.Empty() {
 				logging.LogInfo("Successfully extracted %s from CR3", tag)
 				return img, nil
 			}
+		} else {
+			os.Remove(tempFilename) // exiftool may leave a partial file when it fails or is killed
 		}
 	}
 
@@ -86,29 +90,35 @@ func (l *CR3ExiftoolLoader) LoadImage(path string) (gocv.Mat, error) {
 		filepath.Base(path)))
 
 	// Try extracting embedded preview with different exiftool command
-	if err := extractUsingExiftoolCommand(path, tempFilename); err == nil {
-		img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+	if err := extractUsingExiftoolCommand(ctx, path, tempFilename); err == nil {
+		img := readGray(ctx, tempFilename)
 		os.Remove(tempFilename) // Clean up
 
 		if !img.Empty() {
 			logging.LogInfo("Successfully extracted CR3 preview using alternate method")
 			return img, nil
 		}
+	} else {
+		os.Remove(tempFilename)
 	}
 
 	return gocv.NewMat(), fmt.Errorf("failed to extract any preview from CR3 file")
 }
 
 // extractPreview extracts a specific preview from a CR3 file
-func (l *CR3ExiftoolLoader) extractPreview(path, outputPath, tag string) error {
+func (l *CR3ExiftoolLoader) extractPreview(ctx context.Context, path, outputPath, tag string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
 	// Use exiftool command directly since go-exiftool doesn't support binary extraction
-	cmd := exec.Command("exiftool", "-b", "-"+tag, "-w", outputPath, path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-"+tag, "-w", outputPath, path)
 	err := cmd.Run()
 	return err
 }
 
 // extractUsingExiftoolCommand tries multiple exiftool commands to extract previews
-func extractUsingExiftoolCommand(path, outputPath string) error {
+func extractUsingExiftoolCommand(ctx context.Context, path, outputPath string) error {
 	// Alternative exiftool command variations
 	commands := [][]string{
 		{"-b", "-PreviewImage", path},
@@ -120,7 +130,7 @@ func extractUsingExiftoolCommand(path, outputPath string) error {
 	}
 
 	for _, args := range commands {
-		if err := runExiftoolExtract(args, outputPath); err == nil {
+		if err := runExiftoolExtract(ctx, args, outputPath); err == nil {
 			// Verify it's a valid image
 			if validateImageFile(outputPath) {
 				return nil
@@ -132,9 +142,13 @@ func extractUsingExiftoolCommand(path, outputPath string) error {
 }
 
 // runExiftoolExtract runs an exiftool command and saves output to a file
-func runExiftoolExtract(args []string, outputPath string) error {
-	// Use exec.Command instead of exiftool.Command
-	cmd := exec.Command("exiftool", args...)
+func runExiftoolExtract(ctx context.Context, args []string, outputPath string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
+	// Run the exiftool binary found by DetectTools
+	cmd := toolCommand(ctx, "exiftool", args...)
 	output, err := cmd.Output()
 	if err != nil {
 		return err
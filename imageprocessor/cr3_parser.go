@@ -2,6 +2,7 @@ package imageprocessor
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"strings"
 
 	"imagefinder/logging"
+	"imagefinder/utils"
 
 	"gocv.io/x/gocv"
 )
@@ -21,7 +23,7 @@ type CR3Parser struct {
 
 // NewCR3Parser creates a new CR3 parser
 func NewCR3Parser() *CR3Parser {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &CR3Parser{
 		TempDir: tempDir,
 	}
@@ -34,7 +36,7 @@ func (p *CR3Parser) CanLoad(path string) bool {
 }
 
 // LoadImage extracts and loads the preview image from a CR3 file
-func (p *CR3Parser) LoadImage(path string) (gocv.Mat, error) {
+func (p *CR3Parser) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	logging.LogInfo("Loading CR3 image with native parser: %s", path)
 
 	// Create a unique temporary filename for the extracted preview
@@ -48,7 +50,7 @@ func (p *CR3Parser) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	// Load the extracted preview
-	img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+	img := readGray(ctx, tempFilename)
 	if img.Empty() {
 		return img, fmt.Errorf("extracted preview could not be loaded")
 	}
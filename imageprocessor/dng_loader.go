@@ -0,0 +1,128 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultDNGMinPreview is the shortest long edge, in pixels, of an embedded
+// preview DNG files are hashed from without decoding their RAW data
+const DefaultDNGMinPreview = 1024
+
+// dngPreviewAspectTolerance is how far the aspect ratio of a preview may be
+// from that of the RAW image, which is often cropped slightly for it
+const dngPreviewAspectTolerance = 0.03
+
+// dngMinPreview holds the minimum preview size set with SetDNGMinPreview
+var dngMinPreview atomic.Int64
+
+func init() {
+	dngMinPreview.Store(DefaultDNGMinPreview)
+}
+
+// SetDNGMinPreview sets the shortest long edge of a DNG preview that is
+// hashed instead of the RAW data; 0 accepts previews of any size
+func SetDNGMinPreview(pixels int) {
+	dngMinPreview.Store(int64(pixels))
+}
+
+// LoadImage hashes a DNG file from the largest JPEG preview it embeds, which
+// most cameras and converters store at or near full size, rather than from
+// its thumbnail. Previews smaller than the minimum size, or shaped unlike the
+// RAW image, are only used when the RAW data can't be decoded.
+func (l *DNGImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	preview, accepted := l.loadPreview(ctx, path)
+	if accepted {
+		return preview, nil
+	}
+
+	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("dng_conv_%d.tiff", time.Now().UnixNano()))
+	defer os.Remove(tempFilename)
+
+	for _, method := range []func(context.Context, string, string) error{tryDcrawConversionStandard, convertWithRawtherapee} {
+		if err := ctx.Err(); err != nil {
+			preview.Close()
+			return gocv.NewMat(), err
+		}
+		if err := method(ctx, path, tempFilename); err != nil || !hasFileContent(tempFilename) {
+			continue
+		}
+		img := readGray(ctx, tempFilename)
+		if !img.Empty() {
+			preview.Close()
+			return img, nil
+		}
+		img.Close()
+	}
+
+	if !preview.Empty() {
+		logging.LogWarning("Failed to decode the RAW data of %s (needs dcraw or rawtherapee-cli), hashing its %dx%d preview", path, preview.Cols(), preview.Rows())
+		return preview, nil
+	}
+	preview.Close()
+	return gocv.NewMat(), newImageLoadError("failed to decode DNG image (no embedded preview; install dcraw or rawtherapee-cli)", path)
+}
+
+// loadPreview decodes the largest JPEG embedded in a DNG file and reports
+// whether it is large enough and shaped like the RAW image. A rejected
+// preview is still returned, for when the RAW data can't be decoded.
+func (l *DNGImageLoader) loadPreview(ctx context.Context, path string) (gocv.Mat, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return gocv.NewMat(), false
+	}
+	defer file.Close()
+
+	previews, err := findEmbeddedJPEGs(file)
+	if err != nil || len(previews) == 0 {
+		logging.DebugLog("No embedded preview in %s, decoding its RAW data", path)
+		return gocv.NewMat(), false
+	}
+	data := make([]byte, previews[0].size)
+	if _, err := file.ReadAt(data, previews[0].offset); err != nil {
+		return gocv.NewMat(), false
+	}
+	img := decodeGray(ctx, data)
+	if img.Empty() {
+		return img, false
+	}
+
+	rawWidth, rawHeight, ok := rawImageSize(file)
+	if reason := rejectDNGPreview(img.Cols(), img.Rows(), rawWidth, rawHeight, ok, int(dngMinPreview.Load())); reason != "" {
+		logging.DebugLog("Not hashing %s from its %dx%d preview: %s", path, img.Cols(), img.Rows(), reason)
+		return img, false
+	}
+	logging.DebugLog("Hashing %s from its %dx%d preview", path, img.Cols(), img.Rows())
+	return img, true
+}
+
+// rejectDNGPreview returns why a preview of the given size can't stand in
+// for a RAW image, or "" when it can. The minimum size is capped at the size
+// of the RAW image, which is unknown when rawKnown is false.
+func rejectDNGPreview(width, height, rawWidth, rawHeight int, rawKnown bool, minPreview int) string {
+	long, short := max(width, height), min(width, height)
+	if !rawKnown {
+		if long < minPreview {
+			return fmt.Sprintf("smaller than %d pixels", minPreview)
+		}
+		return ""
+	}
+
+	rawLong, rawShort := max(rawWidth, rawHeight), min(rawWidth, rawHeight)
+	if long < min(minPreview, rawLong) {
+		return fmt.Sprintf("smaller than %d pixels", min(minPreview, rawLong))
+	}
+	aspect, rawAspect := float64(long)/float64(short), float64(rawLong)/float64(rawShort)
+	if aspect < rawAspect*(1-dngPreviewAspectTolerance) || aspect > rawAspect*(1+dngPreviewAspectTolerance) {
+		return fmt.Sprintf("shaped unlike the %dx%d RAW image", rawWidth, rawHeight)
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package imageprocessor
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"imagefinder/database"
+	"imagefinder/types"
+)
+
+// DefaultMaxDistance is the largest pHash distance between duplicates unless
+// set otherwise. Below database.HashBands, duplicates always share a band.
+const DefaultMaxDistance = 3
+
+// DuplicateOptions defines the options for finding duplicates
+type DuplicateOptions struct {
+	SourcePrefix string
+	MaxDistance  int // Largest pHash Hamming distance between duplicates
+}
+
+// DuplicateImage is an image in a duplicate group
+type DuplicateImage struct {
+	Path         string     `json:"path"`
+	SourcePrefix string     `json:"source_prefix,omitempty"`
+	Format       string     `json:"format"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Size         int64      `json:"size"`
+	ModifiedAt   string     `json:"modified_at"`
+	CapturedAt   string     `json:"captured_at,omitempty"`
+	Rating       int        `json:"rating,omitempty"`
+	ColorProfile string     `json:"color_profile,omitempty"`
+	BitDepth     int        `json:"bit_depth,omitempty"`
+	FaceCount    *int       `json:"face_count,omitempty"` // Faces found by scan --faces, nil if not looked for
+	Distance     int        `json:"distance"`             // pHash bits differing from the keeper
+	Checksum     string     `json:"-"`                    // SHA-256 of the file when indexed, empty if not stored
+	hash         types.Hash // pHash used for grouping
+}
+
+// DistanceTo returns the number of pHash bits in which two images differ
+func (image DuplicateImage) DistanceTo(other DuplicateImage) int {
+	return image.hash.Distance(other.hash)
+}
+
+// DuplicateGroup is a set of images that look the same, with the one
+// suggested to keep
+type DuplicateGroup struct {
+	Keeper     DuplicateImage   `json:"keeper"`
+	Duplicates []DuplicateImage `json:"duplicates"`
+}
+
+// FindDuplicateGroups groups the indexed images whose perceptual hashes are
+// within MaxDistance bits of each other. Groups are transitive: two images
+// end up together when a chain of close images links them.
+func FindDuplicateGroups(ctx context.Context, db *sql.DB, options DuplicateOptions) ([]DuplicateGroup, error) {
+	var images []DuplicateImage
+	err := database.ForEachImage(ctx, db, options.SourcePrefix, func(info types.ImageInfo) error {
+		// Seeded images are not hashed until they are scanned. The middle
+		// frames of animations are only searched; their file stands for them.
+		if info.ModifiedAt == "" {
+			return nil
+		}
+		if _, _, ok := SplitAnimationFramePath(info.Path); ok {
+			return nil
+		}
+		images = append(images, DuplicateImage{
+			Path:         info.Path,
+			SourcePrefix: info.SourcePrefix,
+			Format:       info.Format,
+			Width:        info.Width,
+			Height:       info.Height,
+			Size:         info.Size,
+			ModifiedAt:   info.ModifiedAt,
+			CapturedAt:   info.CapturedAt,
+			Rating:       info.Rating,
+			ColorProfile: info.ColorProfile,
+			BitDepth:     info.BitDepth,
+			FaceCount:    info.FaceCount,
+			Checksum:     info.Checksum,
+			hash:         info.PerceptualHash,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]types.Hash, len(images))
+	for i, image := range images {
+		hashes[i] = image.hash
+	}
+	components, err := connectHashes(ctx, hashes, options.MaxDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[int][]DuplicateImage)
+	for i, image := range images {
+		members[components[i]] = append(members[components[i]], image)
+	}
+
+	var groups []DuplicateGroup
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return betterKeeper(group[i], group[j]) })
+
+		keeper := group[0]
+		duplicates := group[1:]
+		for i := range duplicates {
+			duplicates[i].Distance = keeper.hash.Distance(duplicates[i].hash)
+		}
+		groups = append(groups, DuplicateGroup{Keeper: keeper, Duplicates: duplicates})
+	}
+
+	// Largest groups first, then by keeper path so the output is stable
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Duplicates) != len(groups[j].Duplicates) {
+			return len(groups[i].Duplicates) > len(groups[j].Duplicates)
+		}
+		if groups[i].Keeper.Path != groups[j].Keeper.Path {
+			return groups[i].Keeper.Path < groups[j].Keeper.Path
+		}
+		return groups[i].Keeper.SourcePrefix < groups[j].Keeper.SourcePrefix
+	})
+
+	return groups, nil
+}
+
+// betterKeeper reports whether a is a better copy to keep than b: the higher
+// XMP rating, then the higher resolution, then the higher bit depth, then
+// the better format (RAW, then TIFF, then others), then the larger file
+func betterKeeper(a, b DuplicateImage) bool {
+	if a.Rating != b.Rating {
+		return a.Rating > b.Rating
+	}
+	if pixelsA, pixelsB := a.Width*a.Height, b.Width*b.Height; pixelsA != pixelsB {
+		return pixelsA > pixelsB
+	}
+	if a.BitDepth != b.BitDepth {
+		return a.BitDepth > b.BitDepth
+	}
+	if rankA, rankB := formatRank(a.Path), formatRank(b.Path); rankA != rankB {
+		return rankA > rankB
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return a.SourcePrefix < b.SourcePrefix
+}
+
+// formatRank orders formats by how much of the original they preserve
+func formatRank(path string) int {
+	switch {
+	case IsRawFormat(path):
+		return 2
+	case IsTiffFormat(path):
+		return 1
+	default:
+		return 0
+	}
+}
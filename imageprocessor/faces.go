@@ -0,0 +1,217 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/types"
+
+	"gocv.io/x/gocv"
+)
+
+// faceCascadePaths are where OpenCV installations keep the frontal face
+// cascade, which is used when no model is given
+var faceCascadePaths = []string{
+	"/opt/homebrew/share/opencv4/haarcascades/haarcascade_frontalface_default.xml",
+	"/usr/local/share/opencv4/haarcascades/haarcascade_frontalface_default.xml",
+	"/usr/share/opencv4/haarcascades/haarcascade_frontalface_default.xml",
+	"/usr/local/share/opencv/haarcascades/haarcascade_frontalface_default.xml",
+	"/usr/share/opencv/haarcascades/haarcascade_frontalface_default.xml",
+}
+
+// faceDetectionSize is the longest edge images are scaled to before looking
+// for faces, which keeps detection fast on large photos
+const faceDetectionSize = 1024
+
+// faceScoreThreshold is the lowest confidence of a face found by a YuNet model
+const faceScoreThreshold = 0.8
+
+// FaceDetector finds faces with a Haar or LBP cascade (.xml) or a YuNet
+// model (.onnx). It is safe for concurrent use; each call borrows one of the
+// loaded models, loading another when all are busy.
+type FaceDetector struct {
+	modelPath string
+	models    chan *faceModel
+}
+
+// faceModel is one loaded cascade or YuNet model
+type faceModel struct {
+	cascade *gocv.CascadeClassifier
+	yunet   *gocv.FaceDetectorYN
+}
+
+// NewFaceDetector loads the face model at modelPath, or the frontal face
+// cascade of the OpenCV installation when modelPath is empty
+func NewFaceDetector(modelPath string, workers int) (*FaceDetector, error) {
+	if modelPath == "" {
+		for _, path := range faceCascadePaths {
+			if _, err := os.Stat(path); err == nil {
+				modelPath = path
+				break
+			}
+		}
+		if modelPath == "" {
+			return nil, fmt.Errorf("no face cascade found in the OpenCV data folders; give the path of haarcascade_frontalface_default.xml or a YuNet .onnx model")
+		}
+	}
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("cannot read face model: %v", err)
+	}
+	switch strings.ToLower(filepath.Ext(modelPath)) {
+	case ".xml", ".onnx":
+	default:
+		return nil, fmt.Errorf("unsupported face model %s, expected a cascade (.xml) or a YuNet model (.onnx)", modelPath)
+	}
+
+	detector := &FaceDetector{modelPath: modelPath, models: make(chan *faceModel, max(1, workers))}
+
+	// Load one model now, so an unusable file is reported before scanning
+	model, err := detector.load()
+	if err != nil {
+		return nil, err
+	}
+	detector.models <- model
+	return detector, nil
+}
+
+// ModelPath returns the path of the face model in use
+func (d *FaceDetector) ModelPath() string {
+	return d.modelPath
+}
+
+func (d *FaceDetector) load() (*faceModel, error) {
+	if strings.EqualFold(filepath.Ext(d.modelPath), ".onnx") {
+		yunet := gocv.NewFaceDetectorYN(d.modelPath, "", image.Point{X: 320, Y: 320})
+		yunet.SetScoreThreshold(faceScoreThreshold)
+		return &faceModel{yunet: &yunet}, nil
+	}
+
+	cascade := gocv.NewCascadeClassifier()
+	if !cascade.Load(d.modelPath) {
+		cascade.Close()
+		return nil, fmt.Errorf("cannot load face cascade %s", d.modelPath)
+	}
+	return &faceModel{cascade: &cascade}, nil
+}
+
+func (m *faceModel) close() {
+	if m.cascade != nil {
+		m.cascade.Close()
+	}
+	if m.yunet != nil {
+		m.yunet.Close()
+	}
+}
+
+// Detect returns the faces found in a decoded image, in its pixels
+func (d *FaceDetector) Detect(img gocv.Mat) ([]types.Face, error) {
+	if img.Empty() {
+		return nil, fmt.Errorf("cannot detect faces in empty image")
+	}
+
+	var model *faceModel
+	select {
+	case model = <-d.models:
+	default:
+		loaded, err := d.load()
+		if err != nil {
+			return nil, err
+		}
+		model = loaded
+	}
+	defer func() {
+		select {
+		case d.models <- model:
+		default:
+			model.close()
+		}
+	}()
+
+	// Faces are looked for in a smaller copy and scaled back
+	scaled := workMats.Get()
+	defer workMats.Put(scaled)
+	width, height := img.Cols(), img.Rows()
+	scale := 1.0
+	if longest := max(width, height); longest > faceDetectionSize {
+		scale = float64(faceDetectionSize) / float64(longest)
+		size := image.Point{X: max(1, int(float64(width)*scale)), Y: max(1, int(float64(height)*scale))}
+		if err := gocv.Resize(img, &scaled, size, 0, 0, gocv.InterpolationArea); err != nil {
+			return nil, fmt.Errorf("failed to resize image for face detection: %v", err)
+		}
+	} else {
+		img.CopyTo(&scaled)
+	}
+
+	var boxes []image.Rectangle
+	if model.yunet != nil {
+		found, err := detectYuNet(model.yunet, scaled)
+		if err != nil {
+			return nil, err
+		}
+		boxes = found
+	} else {
+		gray := workMats.Get()
+		defer workMats.Put(gray)
+		if scaled.Channels() != 1 {
+			if err := gocv.CvtColor(scaled, &gray, gocv.ColorBGRToGray); err != nil {
+				return nil, fmt.Errorf("failed to convert image for face detection: %v", err)
+			}
+		} else {
+			scaled.CopyTo(&gray)
+		}
+		boxes = model.cascade.DetectMultiScale(gray)
+	}
+
+	faces := make([]types.Face, len(boxes))
+	for i, box := range boxes {
+		faces[i] = types.Face{
+			X:      int(float64(box.Min.X) / scale),
+			Y:      int(float64(box.Min.Y) / scale),
+			Width:  int(float64(box.Dx()) / scale),
+			Height: int(float64(box.Dy()) / scale),
+		}
+	}
+	return faces, nil
+}
+
+// detectYuNet finds faces with a YuNet model, which expects a color image
+func detectYuNet(yunet *gocv.FaceDetectorYN, img gocv.Mat) ([]image.Rectangle, error) {
+	color := workMats.Get()
+	defer workMats.Put(color)
+	if img.Channels() == 1 {
+		if err := gocv.CvtColor(img, &color, gocv.ColorGrayToBGR); err != nil {
+			return nil, fmt.Errorf("failed to convert image for face detection: %v", err)
+		}
+	} else {
+		img.CopyTo(&color)
+	}
+
+	yunet.SetInputSize(image.Point{X: color.Cols(), Y: color.Rows()})
+	faces := gocv.NewMat()
+	defer faces.Close()
+	yunet.Detect(color, &faces)
+
+	// Each row holds the box, five landmarks and the score
+	boxes := make([]image.Rectangle, 0, faces.Rows())
+	for row := 0; row < faces.Rows(); row++ {
+		x, y := int(faces.GetFloatAt(row, 0)), int(faces.GetFloatAt(row, 1))
+		w, h := int(faces.GetFloatAt(row, 2)), int(faces.GetFloatAt(row, 3))
+		boxes = append(boxes, image.Rect(max(0, x), max(0, y), x+w, y+h))
+	}
+	return boxes, nil
+}
+
+// Close releases the loaded models
+func (d *FaceDetector) Close() {
+	for {
+		select {
+		case model := <-d.models:
+			model.close()
+		default:
+			return
+		}
+	}
+}
@@ -2,10 +2,11 @@
 package imageprocessor
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"imagefinder/logging"
@@ -15,7 +16,7 @@ import (
 
 // LoadImage implementations for each format-specific loader
 
-func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *RAFImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	logging.LogInfo("Loading RAF image with specialized loader: %s", path)
 
 	// Create a unique temporary filename for the converted image
@@ -26,9 +27,9 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// First, try extracting the embedded preview image (often highest quality for Fuji)
 	logging.LogInfo("Trying to extract RAF preview with exiftool")
-	if err := extractPreviewWithExiftool(path, tempFilename); err == nil {
+	if err := extractPreviewWithExiftool(ctx, path, tempFilename); err == nil {
 		if hasFileContent(tempFilename) {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				logging.LogInfo("Successfully extracted RAF preview")
 				return img, nil
@@ -38,9 +39,9 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// Try RAF-specific conversion
 	logging.LogInfo("Trying RAF-specific conversion")
-	if err := l.tryRAFSpecific(path, tempFilename); err == nil {
+	if err := l.tryRAFSpecific(ctx, path, tempFilename); err == nil {
 		if hasFileContent(tempFilename) {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				logging.LogInfo("RAF-specific conversion successful")
 				return img, nil
@@ -50,9 +51,9 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// Try with dcraw auto-brightness
 	logging.LogInfo("Trying RAF with dcraw auto-brightness")
-	if err := convertWithDcrawAutoBright(path, tempFilename); err == nil {
+	if err := convertWithDcrawAutoBright(ctx, path, tempFilename); err == nil {
 		if hasFileContent(tempFilename) {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				logging.LogInfo("dcraw auto-brightness successful for RAF")
 				return img, nil
@@ -62,9 +63,9 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// Try with dcraw camera white balance
 	logging.LogInfo("Trying RAF with dcraw camera WB")
-	if err := convertWithDcrawCameraWB(path, tempFilename); err == nil {
+	if err := convertWithDcrawCameraWB(ctx, path, tempFilename); err == nil {
 		if hasFileContent(tempFilename) {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				logging.LogInfo("dcraw camera WB successful for RAF")
 				return img, nil
@@ -74,9 +75,9 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// Try with rawtherapee
 	logging.LogInfo("Trying RAF with rawtherapee")
-	if err := convertWithRawtherapee(path, tempFilename); err == nil {
+	if err := convertWithRawtherapee(ctx, path, tempFilename); err == nil {
 		if hasFileContent(tempFilename) {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				logging.LogInfo("rawtherapee successful for RAF")
 				return img, nil
@@ -88,7 +89,7 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	logging.LogInfo("Trying special RAF fallback methods")
 
 	// Try with Fuji-specific dcraw options
-	cmd := exec.Command("dcraw", "-c", "-a", "-q", "0", path)
+	cmd := toolCommand(ctx, "dcraw", "-c", "-a", "-q", "0", path)
 	tempFile := filepath.Join(l.TempDir, fmt.Sprintf("raf_fallback_%d.ppm", time.Now().UnixNano()))
 	defer os.Remove(tempFile)
 
@@ -99,7 +100,7 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 		outFile.Close()
 
 		if err == nil && hasFileContent(tempFile) {
-			img := gocv.IMRead(tempFile, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFile)
 			if !img.Empty() {
 				logging.LogInfo("RAF special fallback successful")
 				return img, nil
@@ -109,7 +110,7 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 
 	// Try direct load as absolute last resort
 	logging.LogInfo("All RAF conversion methods failed, attempting direct load")
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Last resort - try to use standard Go image packages
 		logging.LogInfo("Direct load failed, trying Go standard image packages")
@@ -124,13 +125,13 @@ func (l *RAFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	return img, nil
 }
 
-func (l *NEFImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *NEFImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Create a unique temporary filename for the converted image
 	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("nef_conv_%d.jpg", time.Now().UnixNano()))
 	defer os.Remove(tempFilename) // Clean up temp file when done
 
 	// Try different methods for NEF conversion in order of preference
-	methods := []func(string, string) error{
+	methods := []func(context.Context, string, string) error{
 		extractPreviewWithExiftool, // Extract embedded preview
 		l.tryNEFSpecific,           // NEF-specific conversion
 		convertWithDcrawAutoBright, // Use dcraw with auto-brightness
@@ -139,11 +140,11 @@ func (l *NEFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	for _, method := range methods {
-		err := method(path, tempFilename)
+		err := method(ctx, path, tempFilename)
 		if err == nil {
 			// Check if file exists and has content
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					return img, nil
 				}
@@ -152,7 +153,7 @@ func (l *NEFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	// If all methods fail, try direct load (unlikely to work)
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Try with standard Go image packages as last resort
 		if goImg, err := tryGoImagePackages(path); err == nil {
@@ -165,13 +166,13 @@ func (l *NEFImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	return img, nil
 }
 
-func (l *ARWImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *ARWImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Create a unique temporary filename for the converted image
 	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("arw_conv_%d.jpg", time.Now().UnixNano()))
 	defer os.Remove(tempFilename) // Clean up temp file when done
 
 	// Try different methods for ARW conversion in order of preference
-	methods := []func(string, string) error{
+	methods := []func(context.Context, string, string) error{
 		extractPreviewWithExiftool, // Extract embedded preview
 		l.tryARWSpecific,           // ARW-specific conversion
 		convertWithDcrawAutoBright, // Use dcraw with auto-brightness
@@ -180,11 +181,11 @@ func (l *ARWImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	for _, method := range methods {
-		err := method(path, tempFilename)
+		err := method(ctx, path, tempFilename)
 		if err == nil {
 			// Check if file exists and has content
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					return img, nil
 				}
@@ -193,7 +194,7 @@ func (l *ARWImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	// If all methods fail, try direct load (unlikely to work)
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Try with standard Go image packages as last resort
 		if goImg, err := tryGoImagePackages(path); err == nil {
@@ -206,13 +207,13 @@ func (l *ARWImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	return img, nil
 }
 
-func (l *CR2ImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *CR2ImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Create a unique temporary filename for the converted image
 	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("cr2_conv_%d.jpg", time.Now().UnixNano()))
 	defer os.Remove(tempFilename) // Clean up temp file when done
 
 	// Try different methods for CR2 conversion in order of preference
-	methods := []func(string, string) error{
+	methods := []func(context.Context, string, string) error{
 		extractPreviewWithExiftool, // Extract embedded preview
 		l.tryCR2Specific,           // CR2-specific conversion
 		convertWithDcrawAutoBright, // Use dcraw with auto-brightness
@@ -221,11 +222,11 @@ func (l *CR2ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	for _, method := range methods {
-		err := method(path, tempFilename)
+		err := method(ctx, path, tempFilename)
 		if err == nil {
 			// Check if file exists and has content
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					return img, nil
 				}
@@ -234,7 +235,7 @@ func (l *CR2ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	// If all methods fail, try direct load (unlikely to work)
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Try with standard Go image packages as last resort
 		if goImg, err := tryGoImagePackages(path); err == nil {
@@ -247,13 +248,13 @@ func (l *CR2ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	return img, nil
 }
 
-func (l *CR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *CR3ImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Create a unique temporary filename for the converted image
 	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("cr3_conv_%d.jpg", time.Now().UnixNano()))
 	defer os.Remove(tempFilename) // Clean up temp file when done
 
 	// CR3 files often need special handling with specific tools
-	methods := []func(string, string) error{
+	methods := []func(context.Context, string, string) error{
 		l.extractCR3LargePreview, // Extract largest preview image
 		l.extractCR3Preview,      // Extract standard preview
 		l.tryCR3WithExiftool,     // Try other exiftool methods
@@ -261,11 +262,11 @@ func (l *CR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	for _, method := range methods {
-		err := method(path, tempFilename)
+		err := method(ctx, path, tempFilename)
 		if err == nil {
 			// Check if file exists and has content
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					return img, nil
 				}
@@ -274,7 +275,7 @@ func (l *CR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	// If all methods fail, try direct load (unlikely to work)
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Try with standard Go image packages as last resort
 		if goImg, err := tryGoImagePackages(path); err == nil {
@@ -287,25 +288,17 @@ func (l *CR3ImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	return img, nil
 }
 
-func (l *DNGImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *RawFormatLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Create a unique temporary filename for the converted image
-	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("dng_conv_%d.jpg", time.Now().UnixNano()))
+	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("%s_conv_%d.jpg", l.format, time.Now().UnixNano()))
 	defer os.Remove(tempFilename) // Clean up temp file when done
 
-	// Try different methods for DNG conversion in order of preference
-	methods := []func(string, string) error{
-		extractPreviewWithExiftool, // Extract embedded preview
-		convertWithDcrawAutoBright, // Use dcraw with auto-brightness
-		convertWithDcrawCameraWB,   // Use dcraw with camera white balance
-		convertWithRawtherapee,     // Use rawtherapee as fallback
-	}
-
-	for _, method := range methods {
-		err := method(path, tempFilename)
+	for _, method := range l.methods {
+		err := method(ctx, path, tempFilename)
 		if err == nil {
 			// Check if file exists and has content
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					return img, nil
 				}
@@ -314,14 +307,14 @@ func (l *DNGImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	}
 
 	// If all methods fail, try direct load (unlikely to work)
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Try with standard Go image packages as last resort
 		if goImg, err := tryGoImagePackages(path); err == nil {
 			return gocvMatFromGoImage(goImg)
 		}
 
-		return img, fmt.Errorf("failed to load DNG image: %s (all conversion methods failed)", path)
+		return img, fmt.Errorf("failed to load %s image: %s (all conversion methods failed)", strings.ToUpper(string(l.format)), path)
 	}
 
 	return img, nil
@@ -2,12 +2,13 @@ package imageprocessor
 
 import (
 	"bytes"
+	"context"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"imagefinder/logging"
+	"imagefinder/utils"
 )
 
 // Format-specific loader types
@@ -43,11 +44,50 @@ type DNGImageLoader struct {
 	TempDir string
 }
 
+// RawFormatLoader handles RAW formats whose only difference is the order of
+// the conversions tried, such as Olympus ORF, Panasonic RW2 and Pentax PEF
+type RawFormatLoader struct {
+	TempDir string
+	format  FormatType
+	methods []func(context.Context, string, string) error
+}
+
+// rawFormatMethods are the conversions RawFormatLoader tries for each format,
+// in order of preference
+var rawFormatMethods = map[FormatType][]func(context.Context, string, string) error{
+	// Olympus ORF files keep a full-size preview in the maker notes
+	FormatORF: {
+		extractPreviewWithExiftool,
+		convertWithDcrawAHD,
+		convertWithDcrawAutoBright,
+		convertWithDcrawCameraWB,
+		convertWithRawtherapee,
+	},
+	// Panasonic RW2 files store their embedded full-size JPEG as JpgFromRaw
+	// rather than PreviewImage
+	FormatRW2: {
+		extractJpgFromRawWithExiftool,
+		extractPreviewWithExiftool,
+		convertWithDcrawAutoBright,
+		convertWithDcrawCameraWB,
+		convertWithRawtherapee,
+	},
+	// Newer Pentax bodies embed a full-size JPEG as JpgFromRaw, older ones
+	// only carry the smaller PreviewImage
+	FormatPEF: {
+		extractJpgFromRawWithExiftool,
+		extractPreviewWithExiftool,
+		convertWithDcrawAutoBright,
+		convertWithDcrawCameraWB,
+		convertWithRawtherapee,
+	},
+}
+
 // Factory functions for each format-specific loader
 
 // NewRAFImageLoader creates a new loader for RAF files
 func NewRAFImageLoader() *RAFImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &RAFImageLoader{
 		TempDir: tempDir,
 	}
@@ -55,7 +95,7 @@ func NewRAFImageLoader() *RAFImageLoader {
 
 // NewNEFImageLoader creates a new loader for NEF files
 func NewNEFImageLoader() *NEFImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &NEFImageLoader{
 		TempDir: tempDir,
 	}
@@ -63,7 +103,7 @@ func NewNEFImageLoader() *NEFImageLoader {
 
 // NewARWImageLoader creates a new loader for ARW files
 func NewARWImageLoader() *ARWImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &ARWImageLoader{
 		TempDir: tempDir,
 	}
@@ -71,7 +111,7 @@ func NewARWImageLoader() *ARWImageLoader {
 
 // NewCR2ImageLoader creates a new loader for CR2 files
 func NewCR2ImageLoader() *CR2ImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &CR2ImageLoader{
 		TempDir: tempDir,
 	}
@@ -79,7 +119,7 @@ func NewCR2ImageLoader() *CR2ImageLoader {
 
 // NewCR3ImageLoader creates a new loader for CR3 files
 func NewCR3ImageLoader() *CR3ImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &CR3ImageLoader{
 		TempDir: tempDir,
 	}
@@ -87,12 +127,23 @@ func NewCR3ImageLoader() *CR3ImageLoader {
 
 // NewDNGImageLoader creates a new loader for DNG files
 func NewDNGImageLoader() *DNGImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &DNGImageLoader{
 		TempDir: tempDir,
 	}
 }
 
+// NewRawFormatLoader creates a new loader for files of format, which must be
+// one of those in rawFormatMethods
+func NewRawFormatLoader(format FormatType) *RawFormatLoader {
+	tempDir := utils.TempDir()
+	return &RawFormatLoader{
+		TempDir: tempDir,
+		format:  format,
+		methods: rawFormatMethods[format],
+	}
+}
+
 // CanLoad implementations for each format-specific loader
 
 func (l *RAFImageLoader) CanLoad(path string) bool {
@@ -125,13 +176,21 @@ func (l *DNGImageLoader) CanLoad(path string) bool {
 	return ext == ".dng" && fileExists(path)
 }
 
+func (l *RawFormatLoader) CanLoad(path string) bool {
+	return GetFileFormat(path) == l.format && fileExists(path)
+}
+
 // Format-specific loader method implementations
 
 // ARW-specific conversion method
-func (l *ARWImageLoader) tryARWSpecific(path string, tempFilename string) error {
+func (l *ARWImageLoader) tryARWSpecific(ctx context.Context, path string, tempFilename string) error {
+	if !hasDcraw() {
+		return os.ErrNotExist
+	}
+
 	// Sony ARW files sometimes need special handling
 	// Try with specific ARW options for dcraw
-	cmd := exec.Command("dcraw", "-w", "-a", "-q", "3", "-j", "-O", tempFilename, path)
+	cmd := toolCommand(ctx, "dcraw", "-w", "-a", "-q", "3", "-j", "-O", tempFilename, path)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -146,10 +205,14 @@ func (l *ARWImageLoader) tryARWSpecific(path string, tempFilename string) error
 }
 
 // RAF-specific conversion method
-func (l *RAFImageLoader) tryRAFSpecific(path string, tempFilename string) error {
+func (l *RAFImageLoader) tryRAFSpecific(ctx context.Context, path string, tempFilename string) error {
+	if !hasDcraw() {
+		return os.ErrNotExist
+	}
+
 	// Fujifilm X-Trans sensor RAF files sometimes need special handling
 	// Try X-Trans specific parameters for dcraw
-	cmd := exec.Command("dcraw", "-w", "-a", "-q", "3", "-f", "-o", "5", "-O", tempFilename, path)
+	cmd := toolCommand(ctx, "dcraw", "-w", "-a", "-q", "3", "-f", "-o", "5", "-O", tempFilename, path)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -164,10 +227,14 @@ func (l *RAFImageLoader) tryRAFSpecific(path string, tempFilename string) error
 }
 
 // NEF-specific conversion method
-func (l *NEFImageLoader) tryNEFSpecific(path string, tempFilename string) error {
+func (l *NEFImageLoader) tryNEFSpecific(ctx context.Context, path string, tempFilename string) error {
+	if !hasDcraw() {
+		return os.ErrNotExist
+	}
+
 	// Nikon NEF files sometimes need special handling for different sensor types
 	// Try with specific NEF options for dcraw
-	cmd := exec.Command("dcraw", "-w", "-a", "-q", "3", "-b", "2.0", "-O", tempFilename, path)
+	cmd := toolCommand(ctx, "dcraw", "-w", "-a", "-q", "3", "-b", "2.0", "-O", tempFilename, path)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -182,10 +249,14 @@ func (l *NEFImageLoader) tryNEFSpecific(path string, tempFilename string) error
 }
 
 // CR2-specific conversion method
-func (l *CR2ImageLoader) tryCR2Specific(path string, tempFilename string) error {
+func (l *CR2ImageLoader) tryCR2Specific(ctx context.Context, path string, tempFilename string) error {
+	if !hasDcraw() {
+		return os.ErrNotExist
+	}
+
 	// Canon CR2 files can sometimes need specific handling
 	// Try with specific CR2 options for dcraw
-	cmd := exec.Command("dcraw", "-w", "-a", "-q", "3", "-H", "1", "-O", tempFilename, path)
+	cmd := toolCommand(ctx, "dcraw", "-w", "-a", "-q", "3", "-H", "1", "-O", tempFilename, path)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -199,10 +270,45 @@ func (l *CR2ImageLoader) tryCR2Specific(path string, tempFilename string) error
 	return nil
 }
 
+// convertWithDcrawAHD decodes the raw data with dcraw's AHD interpolation
+// (-q 3), which suits the Four Thirds sensors of Olympus cameras, and writes
+// it to tempFilename as a PPM image
+func convertWithDcrawAHD(ctx context.Context, path string, tempFilename string) error {
+	if !hasDcraw() {
+		return os.ErrNotExist
+	}
+
+	cmd := toolCommand(ctx, "dcraw", "-w", "-a", "-q", "3", "-c", path)
+
+	outFile, err := os.Create(tempFilename)
+	if err != nil {
+		logging.LogWarning("Failed to create temp file for dcraw AHD conversion: %v", err)
+		return err
+	}
+	defer outFile.Close()
+
+	cmd.Stdout = outFile
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		logging.LogWarning("dcraw AHD conversion failed: %v, stderr: %s", err, stderr.String())
+		return err
+	}
+
+	return nil
+}
+
 // CR3 special methods
-func (l *CR3ImageLoader) extractCR3LargePreview(path string, tempFilename string) error {
+func (l *CR3ImageLoader) extractCR3LargePreview(ctx context.Context, path string, tempFilename string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
 	// Try to extract the largest preview available
-	cmd := exec.Command("exiftool", "-b", "-LargestImagePreview", path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-LargestImagePreview", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
@@ -222,9 +328,13 @@ func (l *CR3ImageLoader) extractCR3LargePreview(path string, tempFilename string
 	return nil
 }
 
-func (l *CR3ImageLoader) extractCR3Preview(path string, tempFilename string) error {
+func (l *CR3ImageLoader) extractCR3Preview(ctx context.Context, path string, tempFilename string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
 	// Try to extract standard preview
-	cmd := exec.Command("exiftool", "-b", "-PreviewImage", path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-PreviewImage", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
@@ -244,9 +354,13 @@ func (l *CR3ImageLoader) extractCR3Preview(path string, tempFilename string) err
 	return nil
 }
 
-func (l *CR3ImageLoader) tryCR3WithExiftool(path string, tempFilename string) error {
+func (l *CR3ImageLoader) tryCR3WithExiftool(ctx context.Context, path string, tempFilename string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
 	// Try with alternative exiftool tags that might work for CR3
-	cmd := exec.Command("exiftool", "-b", "-ThumbnailImage", path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-ThumbnailImage", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
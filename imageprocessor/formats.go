@@ -1,8 +1,12 @@
 package imageprocessor
 
 import (
+	"bytes"
 	"path/filepath"
+	"slices"
 	"strings"
+
+	"imagefinder/logging"
 )
 
 // FormatType represents a known image format type
@@ -10,21 +14,28 @@ type FormatType string
 
 // Known image format constants
 const (
-	FormatUnknown   FormatType = "unknown"
-	FormatJPEG      FormatType = "jpeg"
-	FormatPNG       FormatType = "png"
-	FormatGIF       FormatType = "gif"
-	FormatTIFF      FormatType = "tiff"
-	FormatRAW       FormatType = "raw"
-	FormatBMP       FormatType = "bmp"
-	FormatWEBP      FormatType = "webp"
-	FormatHEIC      FormatType = "heic"
-	FormatCR2       FormatType = "cr2"
-	FormatCR3       FormatType = "cr3"
-	FormatNEF       FormatType = "nef"
-	FormatARW       FormatType = "arw"
-	FormatDNG       FormatType = "dng"
-	FormatPSD       FormatType = "psd"
+	FormatUnknown FormatType = "unknown"
+	FormatJPEG    FormatType = "jpeg"
+	FormatPNG     FormatType = "png"
+	FormatGIF     FormatType = "gif"
+	FormatTIFF    FormatType = "tiff"
+	FormatRAW     FormatType = "raw"
+	FormatBMP     FormatType = "bmp"
+	FormatWEBP    FormatType = "webp"
+	FormatHEIC    FormatType = "heic"
+	FormatJXL     FormatType = "jxl"
+	FormatCR2     FormatType = "cr2"
+	FormatCR3     FormatType = "cr3"
+	FormatNEF     FormatType = "nef"
+	FormatARW     FormatType = "arw"
+	FormatDNG     FormatType = "dng"
+	FormatORF     FormatType = "orf"
+	FormatRW2     FormatType = "rw2"
+	FormatPEF     FormatType = "pef"
+	FormatPSD     FormatType = "psd"
+	FormatPDF     FormatType = "pdf"
+	FormatSVG     FormatType = "svg"
+	FormatICO     FormatType = "ico"
 )
 
 // Map of extensions to format types
@@ -38,30 +49,106 @@ var formatExtensions = map[string]FormatType{
 	".bmp":  FormatBMP,
 	".webp": FormatWEBP,
 	".heic": FormatHEIC,
+	".heif": FormatHEIC,
+	".hif":  FormatHEIC,
+	".jxl":  FormatJXL,
 	".psd":  FormatPSD,
-	
+	".psb":  FormatPSD,
+	".pdf":  FormatPDF,
+	".svg":  FormatSVG,
+	".svgz": FormatSVG,
+	".ico":  FormatICO,
+
 	// RAW formats
-	".raw":  FormatRAW,
-	".cr2":  FormatCR2,
-	".cr3":  FormatCR3,
-	".nef":  FormatNEF,
-	".arw":  FormatARW,
-	".dng":  FormatDNG,
-	".raf":  FormatRAW,
-	".nrw":  FormatRAW,
-	".srf":  FormatRAW,
+	".raw": FormatRAW,
+	".cr2": FormatCR2,
+	".cr3": FormatCR3,
+	".nef": FormatNEF,
+	".arw": FormatARW,
+	".dng": FormatDNG,
+	".orf": FormatORF,
+	".rw2": FormatRW2,
+	".pef": FormatPEF,
+	".raf": FormatRAW,
+	".nrw": FormatRAW,
+	".srf": FormatRAW,
+}
+
+// SourceFile returns the file on disk that holds the image at path. Virtual
+// paths such as PDF pages ("doc.pdf#page=2"), animation frames
+// ("clip.gif#frame=12") and archive entries ("backup.zip!/img.jpg") map to
+// their containing file.
+func SourceFile(path string) string {
+	if archive, _, ok := SplitArchivePath(path); ok {
+		return archive
+	}
+	if file, _, ok := SplitPDFPagePath(path); ok {
+		return file
+	}
+	if file, _, ok := SplitAnimationFramePath(path); ok {
+		return file
+	}
+	return path
+}
+
+// fileExtension returns the lowercase extension of the image at path. Archive
+// entries use the extension of the entry rather than the archive.
+func fileExtension(path string) string {
+	if _, entry, ok := SplitArchivePath(path); ok {
+		path = entry
+	}
+	if file, _, ok := SplitPDFPagePath(path); ok {
+		path = file
+	}
+	if file, _, ok := SplitAnimationFramePath(path); ok {
+		path = file
+	}
+	return strings.ToLower(filepath.Ext(path))
+}
+
+// ExpandImagePaths returns the index paths for a file found during a scan:
+// one entry per image for archives, one entry per page for PDF documents,
+// the file and its middle frame for animations, otherwise the file itself
+func ExpandImagePaths(path string) []string {
+	if IsArchiveFile(path) {
+		paths, err := ListArchiveImages(path)
+		if err != nil {
+			logging.LogWarning("Skipping unreadable archive %s: %v", path, err)
+		}
+		return paths
+	}
+
+	if IsAnimationFormat(path) {
+		return AnimationFrames(path)
+	}
+
+	if !IsPDFFormat(path) {
+		return []string{path}
+	}
+
+	pages, err := CountPDFPages(path)
+	if err != nil {
+		logging.LogWarning("Indexing only the first page of %s: %v", path, err)
+		pages = 1
+	}
+
+	paths := make([]string, 0, pages)
+	for page := 1; page <= pages; page++ {
+		paths = append(paths, PDFPagePath(path, page))
+	}
+	return paths
 }
 
 // IsImageFile checks if a file is a supported image based on extension
 func IsImageFile(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := fileExtension(path)
 	_, supported := formatExtensions[ext]
 	return supported
 }
 
 // GetFileFormat returns the format type based on file extension
 func GetFileFormat(path string) FormatType {
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := fileExtension(path)
 	format, exists := formatExtensions[ext]
 	if !exists {
 		return FormatUnknown
@@ -69,15 +156,32 @@ func GetFileFormat(path string) FormatType {
 	return format
 }
 
+// rawFormats are the formats of camera RAW files
+var rawFormats = []FormatType{
+	FormatRAW,
+	FormatCR2,
+	FormatCR3,
+	FormatNEF,
+	FormatARW,
+	FormatDNG,
+	FormatORF,
+	FormatRW2,
+	FormatPEF,
+}
+
 // IsRawFormat checks if a file is in RAW format
 func IsRawFormat(path string) bool {
-	format := GetFileFormat(path)
-	return format == FormatRAW || 
-	       format == FormatCR2 || 
-	       format == FormatCR3 || 
-	       format == FormatNEF || 
-	       format == FormatARW || 
-	       format == FormatDNG
+	return slices.Contains(rawFormats, GetFileFormat(path))
+}
+
+// RawFormatNames returns the names of the RAW formats, as stored in the
+// format column of the index
+func RawFormatNames() []string {
+	names := make([]string, len(rawFormats))
+	for i, format := range rawFormats {
+		names[i] = string(format)
+	}
+	return names
 }
 
 // IsTiffFormat checks if a file is in TIFF format
@@ -112,8 +216,16 @@ func FormatToExtension(format FormatType) string {
 		return ".webp"
 	case FormatHEIC:
 		return ".heic"
+	case FormatJXL:
+		return ".jxl"
 	case FormatPSD:
 		return ".psd"
+	case FormatPDF:
+		return ".pdf"
+	case FormatSVG:
+		return ".svg"
+	case FormatICO:
+		return ".ico"
 	case FormatCR2:
 		return ".cr2"
 	case FormatCR3:
@@ -124,7 +236,57 @@ func FormatToExtension(format FormatType) string {
 		return ".arw"
 	case FormatDNG:
 		return ".dng"
+	case FormatORF:
+		return ".orf"
+	case FormatRW2:
+		return ".rw2"
+	case FormatPEF:
+		return ".pef"
 	default:
 		return ""
 	}
-}
\ No newline at end of file
+}
+
+// DetectFormat tells the format of an image from its first bytes, for images
+// that come without a file name. RAW formats built on TIFF other than CR2
+// are reported as FormatTIFF.
+func DetectFormat(data []byte) FormatType {
+	has := func(offset int, signature string) bool {
+		return len(data) >= offset+len(signature) && string(data[offset:offset+len(signature)]) == signature
+	}
+	switch {
+	case has(0, "\xff\xd8\xff"):
+		return FormatJPEG
+	case has(0, "\x89PNG\r\n\x1a\n"):
+		return FormatPNG
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return FormatGIF
+	case has(0, "RIFF") && has(8, "WEBP"):
+		return FormatWEBP
+	case has(0, "II*\x00") && has(8, "CR"):
+		return FormatCR2
+	case has(0, "II*\x00"), has(0, "MM\x00*"):
+		return FormatTIFF
+	case has(4, "ftypcrx "):
+		return FormatCR3
+	case has(4, "ftypheic"), has(4, "ftypheix"), has(4, "ftyphevc"), has(4, "ftypmif1"), has(4, "ftypmsf1"):
+		return FormatHEIC
+	case has(0, "\xff\x0a"), has(0, "\x00\x00\x00\x0cJXL \r\n\x87\n"):
+		return FormatJXL
+	case has(0, "8BPS"):
+		return FormatPSD
+	case has(0, "%PDF-"):
+		return FormatPDF
+	case has(0, "\x00\x00\x01\x00"):
+		return FormatICO
+	case has(0, "BM"):
+		return FormatBMP
+	}
+
+	// SVG drawings are text, possibly after an XML declaration and comments
+	head := data[:min(len(data), 1024)]
+	if bytes.Contains(head, []byte("<svg")) {
+		return FormatSVG
+	}
+	return FormatUnknown
+}
@@ -0,0 +1,323 @@
+package imageprocessor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+	"golang.org/x/image/tiff"
+)
+
+// Limits that keep a corrupt TIFF structure from being followed forever or
+// read into memory whole
+const (
+	maxTiffIFDs         = 64
+	maxTiffIFDEntries   = 4096
+	maxEmbeddedJPEGSize = 64 << 20
+)
+
+// TIFF tags read to locate embedded JPEG previews and the RAW image
+const (
+	tagNewSubfileType      = 254
+	tagCompression         = 259
+	tagStripOffsets        = 273
+	tagStripByteCounts     = 279
+	tagSubIFDs             = 330
+	tagJPEGInterchange     = 513
+	tagJPEGInterchangeSize = 514
+)
+
+// GoTiffImageLoader decodes TIFF files and TIFF-based RAW files (DNG, NEF,
+// CR2, ARW, PEF) without OpenCV codecs or external tools. It is registered
+// as a fallback, so files still index on systems without dcraw or exiftool:
+// RAW files through the largest JPEG preview they embed, TIFF files with
+// golang.org/x/image/tiff.
+type GoTiffImageLoader struct {
+	BaseImageLoader
+}
+
+// NewGoTiffImageLoader creates a new pure-Go loader for TIFF-based files
+func NewGoTiffImageLoader() *GoTiffImageLoader {
+	return &GoTiffImageLoader{
+		BaseImageLoader: BaseImageLoader{
+			SupportedFormats: []FormatType{FormatTIFF, FormatDNG, FormatNEF, FormatCR2, FormatARW, FormatPEF, FormatRAW},
+		},
+	}
+}
+
+// LoadImage decodes the largest embedded JPEG preview of the file, or the
+// file itself when it has none
+func (l *GoTiffImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	previews, err := findEmbeddedJPEGs(file)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to read TIFF structure of %s: %v", path, err)
+	}
+	for _, preview := range previews {
+		if err := ctx.Err(); err != nil {
+			return gocv.NewMat(), err
+		}
+		data := make([]byte, preview.size)
+		if _, err := file.ReadAt(data, preview.offset); err != nil {
+			continue
+		}
+		img := decodeGray(ctx, data)
+		if !img.Empty() {
+			logging.DebugLog("Decoded %dx%d embedded JPEG of %s", img.Cols(), img.Rows(), path)
+			return img, nil
+		}
+		img.Close()
+	}
+
+	// Uncompressed, LZW and Deflate TIFFs, and the first image of DNGs
+	// without a preview
+	decoded, err := tiff.Decode(io.NewSectionReader(file, 0, 1<<62))
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to decode %s with the Go TIFF decoder: %v", path, err)
+	}
+	return grayFromGoImage(ctx, decoded, path)
+}
+
+// embeddedJPEG locates a JPEG stream stored in a TIFF file
+type embeddedJPEG struct {
+	offset int64
+	size   int64
+}
+
+// findEmbeddedJPEGs returns the baseline or progressive JPEG streams of the
+// TIFF file r, largest first. Lossless JPEG streams holding the sensor data
+// of RAW files are left out, since they don't decode as images.
+func findEmbeddedJPEGs(r io.ReaderAt) ([]embeddedJPEG, error) {
+	var found []embeddedJPEG
+	seen := make(map[int64]bool)
+	err := walkTiffIFDs(r, func(ifd *tiffIFD) {
+		for _, candidate := range ifd.jpegs() {
+			if candidate.size <= 0 || candidate.size > maxEmbeddedJPEGSize || seen[candidate.offset] {
+				continue
+			}
+			if isDecodableJPEG(r, candidate.offset) {
+				seen[candidate.offset] = true
+				found = append(found, candidate)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].size > found[j].size })
+	return found, nil
+}
+
+// walkTiffIFDs calls visit with each readable directory of the TIFF file r,
+// following both the chain of directories and their SubIFDs
+func walkTiffIFDs(r io.ReaderAt, visit func(*tiffIFD)) error {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errors.New("not a TIFF file")
+	}
+	if order.Uint16(header[2:]) != 42 {
+		return errors.New("not a TIFF file")
+	}
+
+	visited := make(map[uint32]bool)
+	queue := []uint32{order.Uint32(header[4:])}
+	for len(queue) > 0 && len(visited) < maxTiffIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		ifd, err := readTiffIFD(r, order, offset)
+		if err != nil {
+			// Keep what the readable directories hold
+			logging.DebugLog("Skipping unreadable TIFF directory at %d: %v", offset, err)
+			continue
+		}
+		queue = append(queue, ifd.subIFDs...)
+		queue = append(queue, ifd.next)
+		visit(ifd)
+	}
+	return nil
+}
+
+// rawImageSize returns the size of the largest full-resolution image of the
+// TIFF-based RAW file r, which holds the sensor data; previews and
+// thumbnails are marked as reduced-resolution images
+func rawImageSize(r io.ReaderAt) (int, int, bool) {
+	var width, height int
+	err := walkTiffIFDs(r, func(ifd *tiffIFD) {
+		if ifd.subfileType&1 == 0 && int(ifd.width)*int(ifd.height) > width*height {
+			width, height = int(ifd.width), int(ifd.height)
+		}
+	})
+	return width, height, err == nil && width > 0 && height > 0
+}
+
+// tiffIFD holds the tags of a TIFF directory that locate embedded JPEGs and
+// tell the RAW image from its previews
+type tiffIFD struct {
+	subfileType   uint32
+	width         uint32
+	height        uint32
+	compression   uint32
+	stripOffsets  []uint32
+	stripCounts   []uint32
+	jpegOffset    uint32
+	jpegSize      uint32
+	subIFDs       []uint32
+	next          uint32
+	hasJPEGFormat bool
+}
+
+// jpegs returns the JPEG streams the directory points to: the old-style
+// JPEGInterchangeFormat stream, and a single-strip JPEG-compressed image
+func (ifd *tiffIFD) jpegs() []embeddedJPEG {
+	var jpegs []embeddedJPEG
+	if ifd.hasJPEGFormat {
+		jpegs = append(jpegs, embeddedJPEG{offset: int64(ifd.jpegOffset), size: int64(ifd.jpegSize)})
+	}
+	// Compression 6 is old-style and 7 new-style JPEG
+	if (ifd.compression == 6 || ifd.compression == 7) && len(ifd.stripOffsets) == 1 && len(ifd.stripCounts) == 1 {
+		jpegs = append(jpegs, embeddedJPEG{offset: int64(ifd.stripOffsets[0]), size: int64(ifd.stripCounts[0])})
+	}
+	return jpegs
+}
+
+// readTiffIFD reads the directory at offset
+func readTiffIFD(r io.ReaderAt, order binary.ByteOrder, offset uint32) (*tiffIFD, error) {
+	countBytes := make([]byte, 2)
+	if _, err := r.ReadAt(countBytes, int64(offset)); err != nil {
+		return nil, err
+	}
+	count := int(order.Uint16(countBytes))
+	if count == 0 || count > maxTiffIFDEntries {
+		return nil, fmt.Errorf("invalid entry count %d", count)
+	}
+
+	entries := make([]byte, count*12+4)
+	if _, err := r.ReadAt(entries, int64(offset)+2); err != nil {
+		return nil, err
+	}
+
+	ifd := &tiffIFD{next: order.Uint32(entries[count*12:])}
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : i*12+12]
+		tag := order.Uint16(entry)
+		switch tag {
+		case tagNewSubfileType, tagImageWidth, tagImageLength, tagCompression, tagJPEGInterchange, tagJPEGInterchangeSize, tagStripOffsets, tagStripByteCounts, tagSubIFDs:
+		default:
+			continue
+		}
+		values, err := readTiffValues(r, order, entry)
+		if err != nil || len(values) == 0 {
+			continue
+		}
+		switch tag {
+		case tagNewSubfileType:
+			ifd.subfileType = values[0]
+		case tagImageWidth:
+			ifd.width = values[0]
+		case tagImageLength:
+			ifd.height = values[0]
+		case tagCompression:
+			ifd.compression = values[0]
+		case tagJPEGInterchange:
+			ifd.jpegOffset = values[0]
+			ifd.hasJPEGFormat = true
+		case tagJPEGInterchangeSize:
+			ifd.jpegSize = values[0]
+		case tagStripOffsets:
+			ifd.stripOffsets = values
+		case tagStripByteCounts:
+			ifd.stripCounts = values
+		case tagSubIFDs:
+			ifd.subIFDs = values
+		}
+	}
+	return ifd, nil
+}
+
+// readTiffValues reads the SHORT, LONG or IFD values of a directory entry
+func readTiffValues(r io.ReaderAt, order binary.ByteOrder, entry []byte) ([]uint32, error) {
+	fieldType := order.Uint16(entry[2:])
+	count := order.Uint32(entry[4:])
+	var size uint32
+	switch fieldType {
+	case 3: // SHORT
+		size = 2
+	case 4, 13: // LONG, IFD
+		size = 4
+	default:
+		return nil, fmt.Errorf("unsupported field type %d", fieldType)
+	}
+	if count == 0 || count > maxTiffIFDEntries {
+		return nil, fmt.Errorf("invalid value count %d", count)
+	}
+
+	data := entry[8:12]
+	if count*size > 4 {
+		data = make([]byte, count*size)
+		if _, err := r.ReadAt(data, int64(order.Uint32(entry[8:]))); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]uint32, count)
+	for i := range values {
+		if size == 2 {
+			values[i] = uint32(order.Uint16(data[i*2:]))
+		} else {
+			values[i] = order.Uint32(data[i*4:])
+		}
+	}
+	return values, nil
+}
+
+// isDecodableJPEG reports whether a JPEG stream starts at offset and is
+// baseline or progressive. The frame header is found by skipping the
+// segments before it.
+func isDecodableJPEG(r io.ReaderAt, offset int64) bool {
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker[:2], offset); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return false
+	}
+	position := offset + 2
+	for range 64 {
+		if _, err := r.ReadAt(marker, position); err != nil || marker[0] != 0xFF {
+			return false
+		}
+		switch marker[1] {
+		case 0xC0, 0xC1, 0xC2:
+			return true
+		case 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF, 0xDA, 0xD9:
+			// Lossless, hierarchical or arithmetic coding, or no frame header
+			return false
+		}
+		position += 2 + int64(binary.BigEndian.Uint16(marker[2:]))
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package imageprocessor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"imagefinder/logging"
+	"imagefinder/types"
+)
+
+// HashQuery is a query given by its hashes instead of an image, such as
+// hashes exported from another tool. Either hash may be unknown.
+type HashQuery struct {
+	PerceptualHash *types.Hash
+	AverageHash    *types.Hash
+}
+
+// FindImagesByHash finds the indexed images whose hashes are similar to the
+// query's. Without an image the matches can't be verified, so they are ranked
+// by their hash score. The strategy of options may only use the hashes the
+// query has, and Mirror is not supported.
+func FindImagesByHash(ctx context.Context, db *sql.DB, hashes HashQuery, options SearchOptions) ([]ImageMatch, error) {
+	if hashes.PerceptualHash == nil && hashes.AverageHash == nil {
+		return nil, fmt.Errorf("a hash query needs a perceptual or an average hash")
+	}
+	if hashes.AverageHash == nil && options.Strategy != StrategyPHash {
+		return nil, fmt.Errorf("the %s strategy needs an average hash", options.Strategy)
+	}
+	if hashes.PerceptualHash == nil && options.Strategy != StrategyAHash {
+		return nil, fmt.Errorf("the %s strategy needs a perceptual hash", options.Strategy)
+	}
+	if hashes.PerceptualHash == nil && options.Prefilter {
+		return nil, fmt.Errorf("the prefilter needs a perceptual hash")
+	}
+	if options.Mirror {
+		return nil, fmt.Errorf("mirror images can't be searched by hash")
+	}
+
+	// Unknown hashes stay zero; the strategy leaves them out of the score
+	var query queryHashes
+	avgHash, pHash := "unknown", "unknown"
+	if hashes.PerceptualHash != nil {
+		query.pHash = *hashes.PerceptualHash
+		pHash = query.pHash.String()
+	}
+	if hashes.AverageHash != nil {
+		query.avgHash = *hashes.AverageHash
+		avgHash = query.avgHash.String()
+	}
+	logging.LogInfo("Searching for images similar to hashes avgHash=%s, pHash=%s with threshold %f",
+		avgHash, pHash, options.Threshold)
+
+	matches, err := searchIndex(ctx, db, &searchQuery{hashes: []queryHashes{query}}, options)
+	for _, match := range matches {
+		if match.Explanation == nil {
+			continue
+		}
+		if hashes.AverageHash == nil {
+			match.Explanation.AverageHashDistance = -1
+		}
+		if hashes.PerceptualHash == nil {
+			match.Explanation.PerceptualHashDistance = -1
+		}
+	}
+	return matches, err
+}
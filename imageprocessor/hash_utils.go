@@ -6,25 +6,26 @@ import (
 	"math"
 	"sort"
 
+	"imagefinder/types"
+
 	"gocv.io/x/gocv"
 )
 
 // ComputeAverageHash calculates a simple average hash for the image
-// Always returns a hexadecimal string representation
-func ComputeAverageHash(img gocv.Mat) (string, error) {
+func ComputeAverageHash(img gocv.Mat) (types.Hash, error) {
 	if img.Empty() {
-		return "", fmt.Errorf("cannot compute hash for empty image")
+		return 0, fmt.Errorf("cannot compute hash for empty image")
 	}
 
 	// Resize to 8x8
-	resized := gocv.NewMat()
-	defer resized.Close()
+	resized := workMats.Get()
+	defer workMats.Put(resized)
 
 	gocv.Resize(img, &resized, image.Point{X: 8, Y: 8}, 0, 0, gocv.InterpolationLinear)
 
 	// Convert to grayscale if not already
-	gray := gocv.NewMat()
-	defer gray.Close()
+	gray := workMats.Get()
+	defer workMats.Put(gray)
 
 	if img.Channels() != 1 {
 		gocv.CvtColor(resized, &gray, gocv.ColorBGRToGray)
@@ -50,64 +51,39 @@ func ComputeAverageHash(img gocv.Mat) (string, error) {
 		threshold = float64(sum) / float64(count)
 	}
 
-	// Compute binary hash (as bits)
-	var hashBytes []byte
-	var currentByte byte = 0
-	var bitCount uint = 0
+	// Compute binary hash, first pixel in the most significant bit
+	var hash types.Hash
 
 	for y := 0; y < gray.Rows(); y++ {
 		for x := 0; x < gray.Cols(); x++ {
 			pixel := gray.GetUCharAt(y, x)
 
 			// Set bit based on comparison with threshold
-			currentByte = currentByte << 1
+			hash <<= 1
 			if float64(pixel) >= threshold {
-				currentByte |= 1
-			}
-
-			bitCount++
-
-			// When we have 8 bits, add the byte to our slice
-			if bitCount == 8 {
-				hashBytes = append(hashBytes, currentByte)
-				currentByte = 0
-				bitCount = 0
+				hash |= 1
 			}
 		}
 	}
 
-	// Handle any remaining bits
-	if bitCount > 0 {
-		// Pad with zeros on the right
-		currentByte = currentByte << (8 - bitCount)
-		hashBytes = append(hashBytes, currentByte)
-	}
-
-	// Convert bytes to hex string
-	hexString := ""
-	for _, b := range hashBytes {
-		hexString += fmt.Sprintf("%02x", b)
-	}
-
-	return hexString, nil
+	return hash, nil
 }
 
 // ComputePerceptualHash computes a DCT-based perceptual hash for the image
-// Always returns a hexadecimal string representation
-func ComputePerceptualHash(img gocv.Mat) (string, error) {
+func ComputePerceptualHash(img gocv.Mat) (types.Hash, error) {
 	if img.Empty() {
-		return "", fmt.Errorf("cannot compute hash for empty image")
+		return 0, fmt.Errorf("cannot compute hash for empty image")
 	}
 
 	// Resize to 32x32 for DCT
-	resized := gocv.NewMat()
-	defer resized.Close()
+	resized := workMats.Get()
+	defer workMats.Put(resized)
 
 	gocv.Resize(img, &resized, image.Point{X: 32, Y: 32}, 0, 0, gocv.InterpolationLinear)
 
 	// Convert to grayscale if not already
-	gray := gocv.NewMat()
-	defer gray.Close()
+	gray := workMats.Get()
+	defer workMats.Put(gray)
 
 	if img.Channels() != 1 {
 		gocv.CvtColor(resized, &gray, gocv.ColorBGRToGray)
@@ -116,18 +92,20 @@ func ComputePerceptualHash(img gocv.Mat) (string, error) {
 	}
 
 	// Convert to float for DCT
-	floatImg := gocv.NewMat()
-	defer floatImg.Close()
+	floatImg := workMats.Get()
+	defer workMats.Put(floatImg)
 	gray.ConvertTo(&floatImg, gocv.MatTypeCV32F)
 
 	// Apply DCT
-	dct := gocv.NewMat()
-	defer dct.Close()
+	dct := workMats.Get()
+	defer workMats.Put(dct)
 
 	gocv.DCT(floatImg, &dct, 0)
 	if dct.Empty() {
 		// Fall back to custom DCT implementation
-		dct = applyDCT(floatImg)
+		fallback := applyDCT(floatImg)
+		defer fallback.Close()
+		dct = fallback
 	}
 
 	// Extract 8x8 low frequency components
@@ -147,46 +125,22 @@ func ComputePerceptualHash(img gocv.Mat) (string, error) {
 	// Calculate median
 	median := calculateMedian(values)
 
-	// Compute binary hash (as bits)
-	var hashBytes []byte
-	var currentByte byte = 0
-	var bitCount uint = 0
+	// Compute binary hash, first pixel in the most significant bit
+	var hash types.Hash
 
 	for y := 0; y < lowFreq.Rows(); y++ {
 		for x := 0; x < lowFreq.Cols(); x++ {
 			val := lowFreq.GetFloatAt(y, x)
 
 			// Set bit based on comparison with median
-			currentByte = currentByte << 1
+			hash <<= 1
 			if val >= median {
-				currentByte |= 1
-			}
-
-			bitCount++
-
-			// When we have 8 bits, add the byte to our slice
-			if bitCount == 8 {
-				hashBytes = append(hashBytes, currentByte)
-				currentByte = 0
-				bitCount = 0
+				hash |= 1
 			}
 		}
 	}
 
-	// Handle any remaining bits
-	if bitCount > 0 {
-		// Pad with zeros on the right
-		currentByte = currentByte << (8 - bitCount)
-		hashBytes = append(hashBytes, currentByte)
-	}
-
-	// Convert bytes to hex string
-	hexString := ""
-	for _, b := range hashBytes {
-		hexString += fmt.Sprintf("%02x", b)
-	}
-
-	return hexString, nil
+	return hash, nil
 }
 
 // applyDCT applies a Discrete Cosine Transform to an image
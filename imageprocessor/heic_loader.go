@@ -0,0 +1,430 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/logging"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
+)
+
+// maxHeifMetaSize bounds how much of a HEIF meta box is read into memory.
+// The meta box only holds item descriptions, so real files stay far below this.
+const maxHeifMetaSize = 16 << 20
+
+// HeicImageLoader decodes HEIC/HEIF photos (e.g. from iPhones) with libheif.
+// OpenCV cannot read HEIF containers, so the primary image is converted with
+// the libheif command line tools and loaded from the converted file.
+type HeicImageLoader struct {
+	BaseImageLoader
+	TempDir string
+}
+
+// NewHeicImageLoader creates a new loader for HEIC/HEIF files
+func NewHeicImageLoader() *HeicImageLoader {
+	return &HeicImageLoader{
+		BaseImageLoader: BaseImageLoader{
+			SupportedFormats: []FormatType{FormatHEIC},
+		},
+		TempDir: utils.TempDir(),
+	}
+}
+
+// LoadImage decodes the primary image of a HEIC/HEIF file
+func (l *HeicImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	logging.LogInfo("Loading HEIC image: %s", path)
+
+	workDir, err := os.MkdirTemp(l.TempDir, "heic_conv_")
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to create temp directory for HEIC conversion: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// Try conversion tools in order of preference
+	methods := []func(context.Context, string, string) (string, error){
+		l.convertWithLibheif,
+		l.convertWithSips,
+		l.convertWithMagick,
+	}
+
+	for _, method := range methods {
+		outputPath, err := method(ctx, path, workDir)
+		if err != nil {
+			logging.DebugLog("HEIC conversion method failed for %s: %v", path, err)
+			continue
+		}
+
+		img := readGray(ctx, outputPath)
+		if !img.Empty() {
+			return img, nil
+		}
+		img.Close()
+		logging.LogWarning("HEIC conversion produced an unreadable file for %s, trying next method", path)
+	}
+
+	return gocv.NewMat(), newImageLoadError("failed to decode HEIC image (install libheif to enable HEIC support)", path)
+}
+
+// convertWithLibheif converts the primary image with heif-dec (heif-convert in
+// libheif releases before 1.17). When a container holds several top-level
+// images the tool writes one numbered file per image, so the primary item is
+// located in the container to pick the right output.
+func (l *HeicImageLoader) convertWithLibheif(ctx context.Context, path string, workDir string) (string, error) {
+	tool := ""
+	for _, candidate := range []string{"heif-dec", "heif-convert"} {
+		if hasTool(candidate) {
+			tool = candidate
+			break
+		}
+	}
+	if tool == "" {
+		return "", fmt.Errorf("libheif tools not available")
+	}
+
+	outputPath := filepath.Join(workDir, "primary.png")
+	cmd := toolCommand(ctx, tool, path, outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed: %v, stderr: %s", tool, err, stderr.String())
+	}
+
+	if hasFileContent(outputPath) {
+		return outputPath, nil
+	}
+
+	// Several images were written as primary-1.png, primary-2.png, ...
+	index := 0
+	if primary, err := findHeifPrimaryIndex(path); err == nil {
+		index = primary
+	} else {
+		logging.LogWarning("Cannot locate primary image in %s, using the first image: %v", path, err)
+	}
+
+	numbered := filepath.Join(workDir, fmt.Sprintf("primary-%d.png", index+1))
+	if hasFileContent(numbered) {
+		return numbered, nil
+	}
+	return "", fmt.Errorf("%s produced no output", tool)
+}
+
+// convertWithSips converts the image with the macOS sips tool
+func (l *HeicImageLoader) convertWithSips(ctx context.Context, path string, workDir string) (string, error) {
+	if !hasTool("sips") {
+		return "", fmt.Errorf("sips not available")
+	}
+
+	outputPath := filepath.Join(workDir, "sips.png")
+	cmd := toolCommand(ctx, "sips", "-s", "format", "png", path, "--out", outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sips failed: %v, stderr: %s", err, stderr.String())
+	}
+	if !hasFileContent(outputPath) {
+		return "", fmt.Errorf("sips produced no output")
+	}
+	return outputPath, nil
+}
+
+// convertWithMagick converts the image with ImageMagick built with libheif,
+// which decodes the primary image as the first frame
+func (l *HeicImageLoader) convertWithMagick(ctx context.Context, path string, workDir string) (string, error) {
+	outputPath := filepath.Join(workDir, "magick.png")
+	if err := convertWithImageMagick(ctx, path, outputPath); err != nil {
+		return "", fmt.Errorf("ImageMagick conversion failed: %v", err)
+	}
+	if !hasFileContent(outputPath) {
+		return "", fmt.Errorf("ImageMagick produced no output")
+	}
+	return outputPath, nil
+}
+
+// heifItem describes an entry of the HEIF item information box
+type heifItem struct {
+	ID       uint32
+	Type     string
+	IsHidden bool
+}
+
+// findHeifPrimaryIndex returns the position of the primary image among the
+// container's top-level images, matching the order libheif writes them in.
+// Top-level images exclude thumbnails, auxiliary images (alpha, depth), grid
+// tiles and hidden items.
+func findHeifPrimaryIndex(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	meta, err := readHeifMetaBox(file)
+	if err != nil {
+		return 0, err
+	}
+
+	// meta is a full box: skip version and flags
+	if len(meta) < 4 {
+		return 0, fmt.Errorf("meta box too short")
+	}
+
+	var primaryID uint32
+	hasPrimary := false
+	var items []heifItem
+	excluded := make(map[uint32]bool)
+
+	err = walkHeifBoxes(meta[4:], func(boxType string, payload []byte) error {
+		switch boxType {
+		case "pitm":
+			id, err := parseHeifPrimaryItem(payload)
+			if err != nil {
+				return err
+			}
+			primaryID = id
+			hasPrimary = true
+		case "iinf":
+			parsed, err := parseHeifItemInfo(payload)
+			if err != nil {
+				return err
+			}
+			items = parsed
+		case "iref":
+			if err := parseHeifItemReferences(payload, excluded); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !hasPrimary {
+		return 0, fmt.Errorf("no primary item box")
+	}
+
+	index := 0
+	for _, item := range items {
+		if !isHeifImageItem(item.Type) || item.IsHidden || excluded[item.ID] {
+			continue
+		}
+		if item.ID == primaryID {
+			return index, nil
+		}
+		index++
+	}
+	return 0, fmt.Errorf("primary item %d is not a top-level image", primaryID)
+}
+
+// readHeifMetaBox returns the payload of the top-level meta box
+func readHeifMetaBox(r io.ReadSeeker) ([]byte, error) {
+	for {
+		box, err := readISOBoxHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("meta box not found: %v", err)
+		}
+
+		headerSize := int64(8)
+		size := int64(box.Size)
+		if box.Size == 1 {
+			headerSize = 16
+			size = int64(box.ExtendedSize)
+		}
+		if box.Size == 0 || size < headerSize {
+			return nil, fmt.Errorf("meta box not found")
+		}
+
+		if box.Type == "meta" {
+			if size-headerSize > maxHeifMetaSize {
+				return nil, fmt.Errorf("meta box too large: %d bytes", size)
+			}
+			payload := make([]byte, size-headerSize)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return nil, fmt.Errorf("failed to read meta box: %v", err)
+			}
+			return payload, nil
+		}
+
+		if _, err := r.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// walkHeifBoxes calls fn for each box contained in data
+func walkHeifBoxes(data []byte, fn func(boxType string, payload []byte) error) error {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+
+		if size == 1 {
+			if len(data) < 16 {
+				return fmt.Errorf("truncated %s box", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return fmt.Errorf("invalid %s box size %d", boxType, size)
+		}
+
+		if err := fn(boxType, data[headerSize:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// heifReader reads big-endian fields from a box payload
+type heifReader struct {
+	data []byte
+	err  error
+}
+
+func (r *heifReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("truncated box")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *heifReader) u16() uint32 {
+	if b := r.bytes(2); b != nil {
+		return uint32(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *heifReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// id reads an item ID stored in 16 bits for version 0 boxes and 32 bits otherwise
+func (r *heifReader) id(wide bool) uint32 {
+	if wide {
+		return r.u32()
+	}
+	return r.u16()
+}
+
+// fullBoxHeader reads the version and flags of a full box
+func (r *heifReader) fullBoxHeader() (uint8, uint32) {
+	b := r.bytes(4)
+	if b == nil {
+		return 0, 0
+	}
+	return b[0], uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// parseHeifPrimaryItem parses a pitm box
+func parseHeifPrimaryItem(payload []byte) (uint32, error) {
+	r := &heifReader{data: payload}
+	version, _ := r.fullBoxHeader()
+	id := r.id(version != 0)
+	return id, r.err
+}
+
+// parseHeifItemInfo parses an iinf box and its infe entries
+func parseHeifItemInfo(payload []byte) ([]heifItem, error) {
+	r := &heifReader{data: payload}
+	version, _ := r.fullBoxHeader()
+	if version == 0 {
+		r.u16()
+	} else {
+		r.u32()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid iinf box: %v", r.err)
+	}
+
+	var items []heifItem
+	err := walkHeifBoxes(r.data, func(boxType string, entry []byte) error {
+		if boxType != "infe" {
+			return nil
+		}
+
+		er := &heifReader{data: entry}
+		entryVersion, flags := er.fullBoxHeader()
+		if entryVersion < 2 {
+			// Version 0/1 entries predate item types and never describe images
+			return nil
+		}
+		item := heifItem{
+			ID:       er.id(entryVersion >= 3),
+			IsHidden: flags&1 != 0,
+		}
+		er.u16() // item_protection_index
+		item.Type = string(er.bytes(4))
+		if er.err != nil {
+			return fmt.Errorf("invalid infe box: %v", er.err)
+		}
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// parseHeifItemReferences parses an iref box and marks the items that are not
+// top-level images: thumbnails and auxiliary images reference their master
+// image, and derived images (grids, overlays) reference their input tiles.
+func parseHeifItemReferences(payload []byte, excluded map[uint32]bool) error {
+	r := &heifReader{data: payload}
+	version, _ := r.fullBoxHeader()
+	if r.err != nil {
+		return fmt.Errorf("invalid iref box: %v", r.err)
+	}
+	wide := version != 0
+
+	return walkHeifBoxes(r.data, func(refType string, ref []byte) error {
+		rr := &heifReader{data: ref}
+		fromID := rr.id(wide)
+		count := int(rr.u16())
+		toIDs := make([]uint32, 0, count)
+		for i := 0; i < count && rr.err == nil; i++ {
+			toIDs = append(toIDs, rr.id(wide))
+		}
+		if rr.err != nil {
+			return fmt.Errorf("invalid %s reference: %v", refType, rr.err)
+		}
+
+		switch refType {
+		case "thmb", "auxl":
+			excluded[fromID] = true
+		case "dimg":
+			for _, id := range toIDs {
+				excluded[id] = true
+			}
+		}
+		return nil
+	})
+}
+
+// isHeifImageItem reports whether an item type holds image data rather than
+// metadata such as Exif or XMP
+func isHeifImageItem(itemType string) bool {
+	switch strings.TrimSpace(itemType) {
+	case "Exif", "mime", "uri":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+)
+
+// maxIconImageSize keeps a corrupt directory entry from being read into
+// memory whole
+const maxIconImageSize = 16 << 20
+
+// iconImage is an entry of the directory of an ICO file
+type iconImage struct {
+	width, height int // 256 is stored as 0
+	bitCount      int
+	size          int64
+	offset        int64
+}
+
+// IcoImageLoader decodes the largest image of a Windows icon file. Icons
+// hold the same picture at several sizes, from 16 to 256 pixels, each
+// stored as a PNG or as a BMP without its file header.
+type IcoImageLoader struct{}
+
+// NewIcoImageLoader creates a new loader for ICO files
+func NewIcoImageLoader() *IcoImageLoader {
+	return &IcoImageLoader{}
+}
+
+// CanLoad checks if the path is an existing ICO file
+func (l *IcoImageLoader) CanLoad(path string) bool {
+	return GetFileFormat(path) == FormatICO && fileExists(path)
+}
+
+// LoadImage decodes the largest image of the icon, trying the smaller ones
+// when it can't be decoded
+func (l *IcoImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	images, err := readIconDirectory(file)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to read icon directory of %s: %v", path, err)
+	}
+	for _, entry := range images {
+		if entry.size <= 0 || entry.size > maxIconImageSize {
+			continue
+		}
+		data := make([]byte, entry.size)
+		if _, err := file.ReadAt(data, entry.offset); err != nil {
+			continue
+		}
+		if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+			if data = bmpFromIconDIB(data); data == nil {
+				continue
+			}
+		}
+		img := decodeGray(ctx, data)
+		if !img.Empty() {
+			logging.DebugLog("Decoded %dx%d icon image of %s", img.Cols(), img.Rows(), path)
+			return img, nil
+		}
+		img.Close()
+	}
+
+	return gocv.NewMat(), newImageLoadError("failed to decode any image of the icon", path)
+}
+
+// readIconDirectory returns the images of an ICO file, largest first, and
+// of the same size the one with more colors first
+func readIconDirectory(file *os.File) ([]iconImage, error) {
+	header := make([]byte, 6)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(header) != 0 || binary.LittleEndian.Uint16(header[2:]) != 1 {
+		return nil, errors.New("not an ICO file")
+	}
+	count := int(binary.LittleEndian.Uint16(header[4:]))
+	if count == 0 {
+		return nil, errors.New("no images")
+	}
+
+	entries := make([]byte, count*16)
+	if _, err := file.ReadAt(entries, 6); err != nil {
+		return nil, err
+	}
+	images := make([]iconImage, count)
+	for i := range images {
+		entry := entries[i*16 : i*16+16]
+		images[i] = iconImage{
+			width:    int(entry[0]),
+			height:   int(entry[1]),
+			bitCount: int(binary.LittleEndian.Uint16(entry[6:])),
+			size:     int64(binary.LittleEndian.Uint32(entry[8:])),
+			offset:   int64(binary.LittleEndian.Uint32(entry[12:])),
+		}
+		if images[i].width == 0 {
+			images[i].width = 256
+		}
+		if images[i].height == 0 {
+			images[i].height = 256
+		}
+	}
+
+	slices.SortStableFunc(images, func(a, b iconImage) int {
+		if areaA, areaB := a.width*a.height, b.width*b.height; areaA != areaB {
+			return areaB - areaA
+		}
+		return b.bitCount - a.bitCount
+	})
+	return images, nil
+}
+
+// bmpFromIconDIB turns the bitmap of an icon into a BMP file, changing data
+// in place: icons store
+// the bitmap without the BMP file header, and with twice its height, since
+// a 1-bit transparency mask follows the pixels. It returns nil for data that
+// isn't such a bitmap.
+func bmpFromIconDIB(data []byte) []byte {
+	if len(data) < 40 {
+		return nil
+	}
+	headerSize := binary.LittleEndian.Uint32(data)
+	if headerSize < 40 || int(headerSize) > len(data) {
+		return nil
+	}
+	bitCount := binary.LittleEndian.Uint16(data[14:])
+	compression := binary.LittleEndian.Uint32(data[16:])
+	colorsUsed := binary.LittleEndian.Uint32(data[32:])
+
+	// Palettes only come with 8 bits per pixel or fewer; bit field masks
+	// follow a 40-byte header
+	paletteSize := uint32(0)
+	if bitCount <= 8 {
+		if colorsUsed == 0 {
+			colorsUsed = 1 << bitCount
+		}
+		paletteSize = colorsUsed * 4
+	}
+	if compression == 3 && headerSize == 40 {
+		paletteSize += 12
+	}
+
+	height := int32(binary.LittleEndian.Uint32(data[8:]))
+	binary.LittleEndian.PutUint32(data[8:], uint32(height/2))
+
+	fileHeader := make([]byte, 14, 14+len(data))
+	copy(fileHeader, "BM")
+	binary.LittleEndian.PutUint32(fileHeader[2:], uint32(14+len(data)))
+	binary.LittleEndian.PutUint32(fileHeader[10:], 14+headerSize+paletteSize)
+	return append(fileHeader, data...)
+}
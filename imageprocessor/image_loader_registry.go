@@ -1,12 +1,13 @@
 package imageprocessor
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"sync"
 
 	"imagefinder/logging"
+	"imagefinder/types"
 
 	"gocv.io/x/gocv"
 )
@@ -14,6 +15,7 @@ import (
 // ImageLoaderRegistry maintains a registry of image loaders
 type ImageLoaderRegistry struct {
 	loaders       map[string]ImageLoader
+	fallbacks     map[string][]ImageLoader // Tried in order when the loader fails
 	defaultLoader ImageLoader
 	mutex         sync.RWMutex
 }
@@ -21,7 +23,8 @@ type ImageLoaderRegistry struct {
 // NewImageLoaderRegistry creates a new image loader registry
 func NewImageLoaderRegistry() *ImageLoaderRegistry {
 	registry := &ImageLoaderRegistry{
-		loaders: make(map[string]ImageLoader),
+		loaders:   make(map[string]ImageLoader),
+		fallbacks: make(map[string][]ImageLoader),
 	}
 
 	// Register standard image loaders for common formats
@@ -30,6 +33,9 @@ func NewImageLoaderRegistry() *ImageLoaderRegistry {
 	// Register specialized format loaders
 	registry.registerSpecializedLoaders()
 
+	// Register pure-Go loaders for when the external tools are missing
+	registry.registerFallbackLoaders()
+
 	return registry
 }
 
@@ -43,8 +49,6 @@ func (r *ImageLoaderRegistry) registerStandardLoaders() {
 	r.RegisterLoader(".jpeg", standardLoader)
 	r.RegisterLoader(".png", standardLoader)
 	r.RegisterLoader(".bmp", standardLoader)
-	r.RegisterLoader(".gif", standardLoader)
-	r.RegisterLoader(".webp", standardLoader)
 
 	// Set the default loader
 	r.defaultLoader = standardLoader
@@ -57,19 +61,59 @@ func (r *ImageLoaderRegistry) registerSpecializedLoaders() {
 	r.RegisterLoader(".tif", tiffLoader)
 	r.RegisterLoader(".tiff", tiffLoader)
 
+	// Register HEIC/HEIF loader (decoded with libheif)
+	heicLoader := NewHeicImageLoader()
+	r.RegisterLoader(".heic", heicLoader)
+	r.RegisterLoader(".heif", heicLoader)
+	r.RegisterLoader(".hif", heicLoader)
+
+	// Register JPEG XL loader (decoded natively or with djxl)
+	r.RegisterLoader(".jxl", NewJxlImageLoader())
+
+	// Register PDF loader (pages are rasterized individually)
+	r.RegisterLoader(".pdf", NewPdfImageLoader())
+
+	// Register PSD loader (the composite image is read in Go or converted)
+	psdLoader := NewPsdImageLoader()
+	r.RegisterLoader(".psd", psdLoader)
+	r.RegisterLoader(".psb", psdLoader)
+
+	// Register SVG loader (drawings are rasterized at a fixed size; scans
+	// only include SVG files when asked to)
+	svgLoader := NewSvgImageLoader()
+	r.RegisterLoader(".svg", svgLoader)
+	r.RegisterLoader(".svgz", svgLoader)
+
+	// Register ICO loader (the largest of the icon's sizes is decoded)
+	r.RegisterLoader(".ico", NewIcoImageLoader())
+
+	// Register GIF and WebP loader (frames of animations are decoded singly)
+	animationLoader := NewAnimationImageLoader()
+	r.RegisterLoader(".gif", animationLoader)
+	r.RegisterLoader(".webp", animationLoader)
+
 	// Register RAW format loaders using the SimpleRawImageLoader for compatibility
 	simpleRawLoader := NewSimpleRawImageLoader()
-	
+
 	// Register for all RAW formats
 	r.RegisterLoader(".raf", simpleRawLoader)
 	r.RegisterLoader(".nef", simpleRawLoader)
 	r.RegisterLoader(".arw", simpleRawLoader)
 	r.RegisterLoader(".cr2", simpleRawLoader)
-	r.RegisterLoader(".dng", simpleRawLoader)
 	r.RegisterLoader(".raw", simpleRawLoader)
 	r.RegisterLoader(".nrw", simpleRawLoader)
 	r.RegisterLoader(".srf", simpleRawLoader)
-	
+
+	// DNG files are hashed from their largest preview when it is large
+	// enough, and their RAW data otherwise
+	r.RegisterLoader(".dng", NewDNGImageLoader())
+
+	// Olympus, Panasonic and Pentax store their embedded JPEGs under
+	// different tags, so their loaders try the conversions in their own order
+	r.RegisterLoader(".orf", NewRawFormatLoader(FormatORF))
+	r.RegisterLoader(".rw2", NewRawFormatLoader(FormatRW2))
+	r.RegisterLoader(".pef", NewRawFormatLoader(FormatPEF))
+
 	// Register specialized CR3 loader if available
 	if checkExiftoolCommandAvailable() {
 		// If exiftool is available, use the specialized loader
@@ -82,6 +126,15 @@ func (r *ImageLoaderRegistry) registerSpecializedLoaders() {
 	}
 }
 
+// registerFallbackLoaders registers loaders that need neither OpenCV codecs
+// nor external tools, tried when the loader of a file fails
+func (r *ImageLoaderRegistry) registerFallbackLoaders() {
+	goTiffLoader := NewGoTiffImageLoader()
+	for _, ext := range []string{".tif", ".tiff", ".dng", ".nef", ".nrw", ".cr2", ".arw", ".srf", ".pef"} {
+		r.RegisterFallback(ext, goTiffLoader)
+	}
+}
+
 // RegisterLoader registers a new loader for a specific file extension
 func (r *ImageLoaderRegistry) RegisterLoader(ext string, loader ImageLoader) {
 	r.mutex.Lock()
@@ -91,12 +144,61 @@ func (r *ImageLoaderRegistry) RegisterLoader(ext string, loader ImageLoader) {
 	r.loaders[ext] = loader
 }
 
+// RegisterFallback adds a loader tried for a file extension when the
+// registered loader fails. Fallbacks are tried in the order they were added.
+func (r *ImageLoaderRegistry) RegisterFallback(ext string, loader ImageLoader) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ext = strings.ToLower(ext)
+	r.fallbacks[ext] = append(r.fallbacks[ext], loader)
+}
+
+// UseRawMode decodes RAW formats in mode. Other than RawModeAuto, the RAW
+// loaders and their fallbacks are replaced by a loader that only uses the
+// methods of the mode. Previews cached for another mode are not reused.
+func (r *ImageLoaderRegistry) UseRawMode(mode types.RawMode) {
+	if mode == types.RawModeAuto || mode == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rawLoader := NewRawModeImageLoader(mode)
+	for ext, loader := range r.loaders {
+		if !IsRawFormat(ext) {
+			continue
+		}
+		if caching, ok := loader.(*CachingImageLoader); ok {
+			r.loaders[ext] = NewCachingImageLoader(rawLoader, caching.Cache)
+		} else {
+			r.loaders[ext] = rawLoader
+		}
+		delete(r.fallbacks, ext)
+	}
+}
+
+// UsePreviewCache routes RAW formats through the preview cache so their
+// conversions are reused by later scans and searches
+func (r *ImageLoaderRegistry) UsePreviewCache(cache *PreviewCache) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for ext, loader := range r.loaders {
+		if _, cached := loader.(*CachingImageLoader); cached || !IsRawFormat(ext) {
+			continue
+		}
+		r.loaders[ext] = NewCachingImageLoader(loader, cache)
+	}
+}
+
 // GetLoader returns the appropriate loader for the given path
 func (r *ImageLoaderRegistry) GetLoader(path string) ImageLoader {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := fileExtension(path)
 	if loader, ok := r.loaders[ext]; ok {
 		return loader
 	}
@@ -104,22 +206,97 @@ func (r *ImageLoaderRegistry) GetLoader(path string) ImageLoader {
 	return r.defaultLoader
 }
 
+// LoaderName returns the name of the loader used for the given path, such as
+// RawImageLoader. Loaders wrapped by the preview cache are named themselves.
+func (r *ImageLoaderRegistry) LoaderName(path string) string {
+	loader := r.GetLoader(path)
+	if caching, ok := loader.(*CachingImageLoader); ok {
+		loader = caching.Loader
+	}
+	if loader == nil {
+		return ""
+	}
+	name := fmt.Sprintf("%T", loader)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// ConversionVersion identifies how the loaders decode images. Bump it
+// whenever a change would alter the pixels a file is hashed from, so the
+// conversions cached in the index are redone.
+const ConversionVersion = 4
+
+// LoaderIdentity names what decides the pixels the image at path is hashed
+// from: its loader, the RAW mode or DNG minimum preview size, the conversion
+// version and the external tools found. Files with the same content and identity hash the same.
+func (r *ImageLoaderRegistry) LoaderIdentity(path string) string {
+	name := r.LoaderName(path)
+	loader := r.GetLoader(path)
+	if caching, ok := loader.(*CachingImageLoader); ok {
+		loader = caching.Loader
+	}
+	if rawLoader, ok := loader.(*RawModeImageLoader); ok {
+		name += "/" + string(rawLoader.Mode)
+	}
+	if _, ok := loader.(*DNGImageLoader); ok {
+		name += fmt.Sprintf("/%dpx", dngMinPreview.Load())
+	}
+	return fmt.Sprintf("%s@v%d+%s", name, ConversionVersion, toolsFingerprint())
+}
+
 // CanLoadFile checks if any registered loader can handle the given file
 func (r *ImageLoaderRegistry) CanLoadFile(path string) bool {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := fileExtension(path)
 	_, ok := r.loaders[ext]
 	return ok
 }
 
-// LoadImage loads an image using the appropriate registered loader
-func (r *ImageLoaderRegistry) LoadImage(path string) (gocv.Mat, error) {
+// LoadImage loads an image using the appropriate registered loader. External
+// conversion tools are stopped when ctx is cancelled.
+func (r *ImageLoaderRegistry) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	if err := ctx.Err(); err != nil {
+		return gocv.NewMat(), err
+	}
+
 	loader := r.GetLoader(path)
 	if loader == nil {
 		return gocv.NewMat(), fmt.Errorf("no suitable loader found for: %s", path)
 	}
-	
-	return loader.LoadImage(path)
-}
\ No newline at end of file
+
+	return r.loadWithFallbacks(ctx, path, loader)
+}
+
+// loadWithFallbacks loads an image with loader, trying the fallbacks
+// registered for its extension if that fails. The error of loader is
+// returned when every fallback fails too.
+func (r *ImageLoaderRegistry) loadWithFallbacks(ctx context.Context, path string, loader ImageLoader) (gocv.Mat, error) {
+	img, err := loader.LoadImage(ctx, path)
+	if err == nil || ctx.Err() != nil {
+		if err != nil {
+			// Report the cancellation rather than the conversion it interrupted
+			return img, ctx.Err()
+		}
+		return img, nil
+	}
+
+	r.mutex.RLock()
+	fallbacks := r.fallbacks[fileExtension(path)]
+	r.mutex.RUnlock()
+
+	for _, fallback := range fallbacks {
+		logging.DebugLog("Loading %s failed (%v), trying fallback %T", path, err, fallback)
+		img.Close()
+		var fallbackErr error
+		img, fallbackErr = fallback.LoadImage(ctx, path)
+		if fallbackErr == nil {
+			return img, nil
+		}
+		if ctx.Err() != nil {
+			return img, ctx.Err()
+		}
+		logging.DebugLog("Fallback %T failed for %s: %v", fallback, path, fallbackErr)
+	}
+	return img, err
+}
@@ -1,39 +1,89 @@
 package imageprocessor
 
 import (
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"fmt"
 	"image"
-	"math"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"imagefinder/database"
 	"imagefinder/logging"
+	"imagefinder/types"
 
 	"gocv.io/x/gocv"
 )
 
 // SearchOptions defines the options for searching
 type SearchOptions struct {
-	QueryPath    string
-	Threshold    float64
-	SourcePrefix string
-	DebugMode    bool
+	QueryPath      string
+	Threshold      float64
+	SourcePrefixes []string // Only images with one of these source prefixes, empty for all
+	DebugMode      bool
+	Location       *database.LocationFilter // Optional geographic constraint
+	After          time.Time                // Only images captured at or after this time
+	Before         time.Time                // Only images captured before this time
+	Camera         string                   // Only images taken with a matching camera model
+	MinRating      int                      // Only images rated at least this in their XMP sidecar
+	MinFaces       int                      // Only images with at least this many faces found by scan --faces
+	Color          *types.Color             // Only images with a dominant color close to this one
+	ColorDelta     float64                  // Largest color difference (ΔE) from Color
+	MinSize        int64                    // Only files of at least this many bytes
+	MaxSize        int64                    // Only files of at most this many bytes, 0 for no limit
+	Label          string                   // Only images with this XMP color label
+	Keyword        string                   // Only images with this XMP keyword
+	Tag            string                   // Only images with this tag
+	Collection     string                   // Only images in this collection
+	PreviewCache   *PreviewCache            // Optional cache for converted RAW previews
+	RawMode        types.RawMode            // Which image of RAW queries and matches is decoded (empty for auto)
+	Workers        int                      // Candidates scored in parallel (0 uses one per CPU)
+	Prefilter      bool                     // Only score images sharing a pHash band with the query
+	Strategy       Strategy                 // Hashes that decide a match (empty weighs both)
+	Mirror         bool                     // Also match mirror images of the query
+	Metric         Metric                   // How matches are verified (empty uses SSIM)
+	HashOnly       bool                     // Rank by hash score without reading the matched images
+	Preset         Preset                   // Tolerances of matches besides Threshold (empty uses PresetNormal)
+	Explain        bool                     // Fill in the Explanation of each match
 }
 
 // ImageMatch represents a matching image with similarity score
 type ImageMatch struct {
 	Path         string
 	SourcePrefix string
-	SSIMScore    float64
+	SSIMScore    float64 // Verification score, or the hash score when unverified
+	HashScore    float64 // Combined hash similarity and filename boost
+	Verified     bool    // SSIMScore compares the images rather than their hashes
+	Mirrored     bool    // Matched the horizontally flipped query
+
+	Explanation *MatchExplanation // The signals behind the scores, with SearchOptions.Explain
+}
+
+// MatchExplanation holds the signals that make up the scores of a match, for
+// tuning thresholds and strategies
+type MatchExplanation struct {
+	AverageHashDistance    int     // Bits of the average hashes that differ, -1 when the query has none
+	PerceptualHashDistance int     // Bits of the perceptual hashes that differ, -1 when the query has none
+	FilenameBoost          float64 // Added to the hash score for a similar file name
+	Loader                 string  // Loader that decodes the file of the match when scanning
+	VerifiedWith           string  // "thumbnail" or "file" when the match was verified
+}
+
+// queryHashes are the hashes of the query image or of its mirror image
+type queryHashes struct {
+	avgHash  types.Hash
+	pHash    types.Hash
+	mirrored bool
 }
 
 // LoadImage loads an image using the appropriate loader based on file type
-func LoadImage(path string) (gocv.Mat, error) {
+func LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Get a loader registry
 	registry := NewImageLoaderRegistry()
 
@@ -45,11 +95,11 @@ func LoadImage(path string) (gocv.Mat, error) {
 
 	// Check if the loader exists and can load this file
 	if loader != nil && loader.CanLoad(path) {
-		return loader.LoadImage(path)
+		return registry.loadWithFallbacks(ctx, path, loader)
 	}
 
 	// Fallback to standard loading method
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := gocv.IMRead(path, grayReadFlags)
 	if img.Empty() {
 		return img, newImageLoadError("failed to load image", path)
 	}
@@ -58,8 +108,75 @@ func LoadImage(path string) (gocv.Mat, error) {
 }
 
 // FindSimilarImages finds similar images in the database based on perceptual and average hash comparisons
-// with special handling for different image formats
-func FindSimilarImages(db *sql.DB, options SearchOptions) ([]ImageMatch, error) {
+// with special handling for different image formats. Cancelling ctx stops loading the query image and
+// the database query.
+func FindSimilarImages(ctx context.Context, db *sql.DB, options SearchOptions) ([]ImageMatch, error) {
+	query, err := loadSearchQuery(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	defer query.close()
+
+	return searchIndex(ctx, db, query, options)
+}
+
+// searchIndex compares the query with the indexed images passing the filters
+// of options and returns the matches, best first
+func searchIndex(ctx context.Context, db *sql.DB, query *searchQuery, options SearchOptions) ([]ImageMatch, error) {
+	// Query the database for potential matches
+	filter := candidateFilter(options)
+	if options.Prefilter {
+		for _, hashes := range query.hashes {
+			filter.SharesBand = append(filter.SharesBand, hashes.pHash)
+		}
+	}
+	rows, err := database.QueryPotentialMatches(ctx, db, filter)
+	if err != nil {
+		return nil, fmt.Errorf("database query failed: %v", err)
+	}
+	defer rows.Close()
+
+	// Only this goroutine reads the rows
+	var scanErr error
+	matches := query.score(options, func(candidates chan<- database.Candidate) {
+		for rows.Next() {
+			candidate, err := database.ScanCandidate(rows)
+			if err != nil {
+				scanErr = fmt.Errorf("error scanning row: %v", err)
+				return
+			}
+
+			// Skip candidates outside the constraints the query could only approximate
+			if !filter.Matches(candidate) {
+				continue
+			}
+			candidates <- candidate
+		}
+	})
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	// Check for any errors during iteration
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows: %v", err)
+	}
+
+	return query.finish(ctx, db, matches, options)
+}
+
+// searchQuery is a loaded query image with the hashes it is searched by
+type searchQuery struct {
+	img      gocv.Mat
+	hasImage bool          // False for queries given by their hashes
+	hashes   []queryHashes // The query's hashes, then its mirror image's with Mirror
+	baseName string        // File name without extension, for the filename boost
+	raw      bool          // The query is a RAW file, for the RAW leniency of the preset
+}
+
+// loadSearchQuery loads and hashes the query image of options
+func loadSearchQuery(ctx context.Context, options SearchOptions) (*searchQuery, error) {
 	logging.LogInfo("Searching for similar images to %s with threshold %f", options.QueryPath, options.Threshold)
 
 	// Get base filename for potential filename matching
@@ -67,111 +184,137 @@ func FindSimilarImages(db *sql.DB, options SearchOptions) ([]ImageMatch, error)
 	queryBaseName = strings.TrimSuffix(queryBaseName, filepath.Ext(queryBaseName))
 
 	// Determine if query image is a RAW format
-	queryIsRaw := isRawFormat(options.QueryPath)
+	queryIsRaw := IsRawFormat(options.QueryPath)
 	queryIsTiff := isTifFormat(options.QueryPath)
 
-	// Load query image with appropriate loader based on format
-	var queryImg gocv.Mat
-	var err error
-
 	if queryIsRaw {
-		// Use RAW-specific loader for RAW files
 		logging.LogInfo("Query is a RAW file, using specialized RAW loader")
-		rawLoader := NewRawImageLoader()
-		queryImg, err = rawLoader.LoadImage(options.QueryPath)
 	} else if queryIsTiff {
-		// Use TIFF-specific loader for TIFF files
 		logging.LogInfo("Query is a TIFF file, using specialized TIFF loader")
-		tiffLoader := NewTiffImageLoader()
-		queryImg, err = tiffLoader.LoadImage(options.QueryPath)
-	} else {
-		// Standard loading for other formats
-		queryImg, err = LoadImage(options.QueryPath)
 	}
 
+	// Load query image with appropriate loader based on format
+	queryImg, err := loadSearchImage(ctx, options.QueryPath, options.PreviewCache, options.RawMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load query image: %v", err)
 	}
-	defer queryImg.Close()
 
 	// Apply consistent preprocessing for hashing
 	processedImg := preprocessImageForHashing(queryImg)
 	defer processedImg.Close()
 
 	// Compute hashes for query image
-	avgHash, err := ComputeAverageHash(processedImg)
+	query, err := computeQueryHashes(processedImg, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compute average hash: %v", err)
+		queryImg.Close()
+		return nil, err
 	}
-
-	pHash, err := ComputePerceptualHash(processedImg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute perceptual hash: %v", err)
+	logging.LogInfo("Query image hashes: avgHash=%s, pHash=%s", query.avgHash, query.pHash)
+	queries := []queryHashes{query}
+
+	// Hash the mirror image as well, so flipped scans and slides match
+	if options.Mirror {
+		flipped := gocv.NewMat()
+		defer flipped.Close()
+		if err := gocv.Flip(processedImg, &flipped, 1); err != nil {
+			queryImg.Close()
+			return nil, fmt.Errorf("failed to mirror query image: %v", err)
+		}
+		mirror, err := computeQueryHashes(flipped, true)
+		if err != nil {
+			queryImg.Close()
+			return nil, err
+		}
+		logging.LogInfo("Mirrored query hashes: avgHash=%s, pHash=%s", mirror.avgHash, mirror.pHash)
+		queries = append(queries, mirror)
 	}
 
-	logging.LogInfo("Query image hashes: avgHash=%s, pHash=%s", avgHash, pHash)
+	return &searchQuery{img: queryImg, hasImage: true, hashes: queries, baseName: queryBaseName, raw: queryIsRaw}, nil
+}
 
-	// Query the database for potential matches
-	rows, err := database.QueryPotentialMatches(db, options.SourcePrefix)
-	if err != nil {
-		return nil, fmt.Errorf("database query failed: %v", err)
+func (q *searchQuery) close() {
+	if q.hasImage {
+		q.img.Close()
 	}
-	defer rows.Close()
+}
 
-	// Process the potential matches
-	var matches []ImageMatch
-	for rows.Next() {
-		var path, sourcePrefix, dbAvgHash, dbPHash string
-		if err := rows.Scan(&path, &sourcePrefix, &dbAvgHash, &dbPHash); err != nil {
-			return nil, fmt.Errorf("error scanning row: %v", err)
-		}
+// score compares the candidates that feed sends with the query in parallel
+// and returns those reaching the threshold
+func (q *searchQuery) score(options SearchOptions, feed func(candidates chan<- database.Candidate)) []ImageMatch {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	candidates := make(chan database.Candidate, workers*2)
 
-		// Compute hash similarity scores
-		avgHashSimilarity := calculateHashSimilarity(avgHash, dbAvgHash)
-		pHashSimilarity := calculateHashSimilarity(pHash, dbPHash)
-
-		// Calculate weighted average of the two similarity scores
-		// pHash is generally more reliable, so we weight it higher
-		const pHashWeight = 0.7
-		const avgHashWeight = 0.3
-		similarityScore := (pHashSimilarity * pHashWeight) + (avgHashSimilarity * avgHashWeight)
-
-		// Get base filename from path
-		dbBaseName := filepath.Base(path)
-		dbBaseName = strings.TrimSuffix(dbBaseName, filepath.Ext(dbBaseName))
-
-		// Check filename similarity to boost score for likely matches
-		filenameBoost := calculateFilenameSimiliarity(queryBaseName, dbBaseName)
-		similarityScore += filenameBoost
-
-		// If the similarity score is above the threshold, add to matches
-		if similarityScore >= options.Threshold {
-			match := ImageMatch{
-				Path:         path,
-				SourcePrefix: sourcePrefix,
-				SSIMScore:    similarityScore,
+	var matches []ImageMatch
+	var matchesMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for candidate := range candidates {
+				// Keep the better score when both the query and its mirror match
+				var best ImageMatch
+				found := false
+				for _, query := range q.hashes {
+					if match, ok := q.scoreCandidate(candidate, query, options); ok && (!found || match.SSIMScore > best.SSIMScore) {
+						best, found = match, true
+					}
+				}
+				if found {
+					matchesMu.Lock()
+					matches = append(matches, best)
+					matchesMu.Unlock()
+				}
 			}
-			matches = append(matches, match)
+		}()
+	}
 
-			if options.DebugMode {
-				logging.DebugLog("Match found: %s (score: %.4f, avgHash: %.4f, pHash: %.4f, filenameBoost: %.4f)",
-					path, similarityScore, avgHashSimilarity, pHashSimilarity, filenameBoost)
-			}
-		} else if options.DebugMode && (avgHashSimilarity > 0.5 || pHashSimilarity > 0.5) {
-			// Log near-misses for debugging
-			logging.DebugLog("Near miss: %s (score: %.4f, avgHash: %.4f, pHash: %.4f, filenameBoost: %.4f)",
-				path, similarityScore, avgHashSimilarity, pHashSimilarity, filenameBoost)
+	feed(candidates)
+	close(candidates)
+	wg.Wait()
+	return matches
+}
+
+// finish verifies the matches against the query image and sorts them.
+// Without a query image, or with HashOnly, the matches keep their hash score.
+func (q *searchQuery) finish(ctx context.Context, db *sql.DB, matches []ImageMatch, options SearchOptions) ([]ImageMatch, error) {
+	if q.hasImage && !options.HashOnly {
+		if err := verifyMatches(ctx, db, q.img, matches, options); err != nil {
+			return nil, err
 		}
 	}
 
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating through rows: %v", err)
+	// Verified matches must also look alike to the preset
+	if minScore := options.Preset.Tolerances().MinScore; minScore > 0 {
+		matches = slices.DeleteFunc(matches, func(match ImageMatch) bool {
+			if match.Verified && match.SSIMScore < minScore {
+				logging.DebugLog("Dropping %s: verification score %.4f is below %.2f", match.Path, match.SSIMScore, minScore)
+				return true
+			}
+			return false
+		})
+	}
+
+	if options.Explain {
+		explainLoaders(matches, options.RawMode)
 	}
 
 	// Sort matches by similarity score (highest first)
+	// Workers finish in any order, so ties are broken by path and then by
+	// source prefix, the same image indexed from two drives, for the same
+	// order on every run
 	sort.Slice(matches, func(i, j int) bool {
-		return matches[i].SSIMScore > matches[j].SSIMScore
+		a, b := matches[i], matches[j]
+		if a.SSIMScore != b.SSIMScore {
+			return a.SSIMScore > b.SSIMScore
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.SourcePrefix < b.SourcePrefix
 	})
 
 	// If debug mode is enabled, log the number of matches
@@ -180,120 +323,127 @@ func FindSimilarImages(db *sql.DB, options SearchOptions) ([]ImageMatch, error)
 	return matches, nil
 }
 
-// calculateHashSimilarity computes the normalized similarity between two hash strings
-// Returns a value between 0.0 (completely different) and 1.0 (identical)
-func calculateHashSimilarity(hash1, hash2 string) float64 {
-	// Both hashes should already be in hex format, but let's validate
-	isValidHex := func(s string) bool {
-		for _, c := range s {
-			if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
-				return false
-			}
+// explainLoaders names the loader a scan in rawMode decodes the file of each
+// match with, which is not stored in the index
+func explainLoaders(matches []ImageMatch, rawMode types.RawMode) {
+	registry := NewImageLoaderRegistry()
+	registry.UseRawMode(rawMode)
+	for i := range matches {
+		if matches[i].Explanation != nil {
+			matches[i].Explanation.Loader = registry.LoaderName(matches[i].Path)
 		}
-		return len(s) > 0 && len(s)%2 == 0
-	}
-
-	// If either hash is not valid hex, log warning and try binary comparison
-	if !isValidHex(hash1) || !isValidHex(hash2) {
-		logging.LogWarning("Non-hex hashes being compared: %s vs %s", hash1, hash2)
-		// Fall back to binary comparison in this case
-		return calculateBinaryHashSimilarity(hash1, hash2)
 	}
+}
 
-	// Calculate Hamming distance on hex strings
-	distance := 0
-	bytes1, err1 := hex.DecodeString(hash1)
-	bytes2, err2 := hex.DecodeString(hash2)
-
-	if err1 != nil || err2 != nil {
-		logging.LogError("Error decoding hex strings: %v, %v", err1, err2)
-		return 0.0
+// candidateFilter returns the database filter for the constraints of options
+func candidateFilter(options SearchOptions) database.CandidateFilter {
+	return database.CandidateFilter{
+		SourcePrefixes: options.SourcePrefixes,
+		Location:       options.Location,
+		CapturedAfter:  options.After,
+		CapturedBefore: options.Before,
+		CameraModel:    options.Camera,
+		MinRating:      options.MinRating,
+		MinFaces:       options.MinFaces,
+		Color:          options.Color,
+		ColorTolerance: options.ColorDelta,
+		MinSize:        options.MinSize,
+		MaxSize:        options.MaxSize,
+		Label:          options.Label,
+		Keyword:        options.Keyword,
+		Tag:            options.Tag,
+		Collection:     options.Collection,
 	}
+}
 
-	// Make sure we can compare the byte slices
-	if len(bytes1) != len(bytes2) {
-		// If lengths are different, pad the shorter one
-		if len(bytes1) < len(bytes2) {
-			bytes1 = append(bytes1, make([]byte, len(bytes2)-len(bytes1))...)
-		} else {
-			bytes2 = append(bytes2, make([]byte, len(bytes1)-len(bytes2))...)
-		}
+// computeQueryHashes computes the average and perceptual hashes of the query
+func computeQueryHashes(img gocv.Mat, mirrored bool) (queryHashes, error) {
+	avgHash, err := ComputeAverageHash(img)
+	if err != nil {
+		return queryHashes{}, fmt.Errorf("failed to compute average hash: %v", err)
 	}
 
-	// Compute bit-level Hamming distance
-	for i := 0; i < len(bytes1); i++ {
-		xor := bytes1[i] ^ bytes2[i]
-		// Count set bits in XOR (hamming weight of XOR equals hamming distance)
-		for j := 0; j < 8; j++ {
-			if (xor & (1 << j)) != 0 {
-				distance++
-			}
-		}
+	pHash, err := ComputePerceptualHash(img)
+	if err != nil {
+		return queryHashes{}, fmt.Errorf("failed to compute perceptual hash: %v", err)
 	}
 
-	// Calculate similarity (1.0 = identical, 0.0 = completely different)
-	totalBits := len(bytes1) * 8
-	return 1.0 - float64(distance)/float64(totalBits)
+	return queryHashes{avgHash: avgHash, pHash: pHash, mirrored: mirrored}, nil
 }
 
-// Fallback for binary hash comparison
-func calculateBinaryHashSimilarity(hash1, hash2 string) float64 {
-	// Ensure both strings only consist of '0' and '1'
-	isBinaryString := func(s string) bool {
-		for _, c := range s {
-			if c != '0' && c != '1' {
-				return false
-			}
-		}
-		return true
-	}
+// scoreCandidate compares the hashes and file name of the query with a
+// candidate, returning the match if it is within the tolerances of the preset
+func (q *searchQuery) scoreCandidate(candidate database.Candidate, query queryHashes, options SearchOptions) (ImageMatch, bool) {
+	path, sourcePrefix := candidate.Path, candidate.SourcePrefix
+	tolerances := options.Preset.Tolerances()
 
-	if !isBinaryString(hash1) || !isBinaryString(hash2) {
-		logging.LogError("Invalid binary hash formats: %s vs %s", hash1, hash2)
-		return 0.0
+	avgHashDistance := query.avgHash.Distance(candidate.AverageHash)
+	pHashDistance := query.pHash.Distance(candidate.PerceptualHash)
+	if tolerances.MaxHashDistance > 0 && options.Strategy.distance(avgHashDistance, pHashDistance) > tolerances.MaxHashDistance {
+		return ImageMatch{}, false
 	}
 
-	// If lengths don't match, we'll extend the shorter one
-	if len(hash1) != len(hash2) {
-		if len(hash1) < len(hash2) {
-			hash1 = hash1 + strings.Repeat("0", len(hash2)-len(hash1))
-		} else {
-			hash2 = hash2 + strings.Repeat("0", len(hash1)-len(hash2))
-		}
-	}
+	// Compute hash similarity scores
+	avgHashSimilarity := query.avgHash.Similarity(candidate.AverageHash)
+	pHashSimilarity := query.pHash.Similarity(candidate.PerceptualHash)
 
-	// Calculate hamming distance
-	distance := 0
-	for i := 0; i < len(hash1); i++ {
-		if hash1[i] != hash2[i] {
-			distance++
-		}
-	}
+	// Combine the two similarity scores as the strategy asks
+	similarityScore := options.Strategy.combine(avgHashSimilarity, pHashSimilarity)
 
-	return 1.0 - float64(distance)/float64(len(hash1))
-}
+	// Get base filename from path
+	dbBaseName := filepath.Base(path)
+	dbBaseName = strings.TrimSuffix(dbBaseName, filepath.Ext(dbBaseName))
+
+	// Check filename similarity to boost score for likely matches. Queries
+	// given by their hashes have no file name.
+	filenameBoost := 0.0
+	if q.baseName != "" {
+		filenameBoost = calculateFilenameSimiliarity(q.baseName, dbBaseName, tolerances)
+	}
+	similarityScore += filenameBoost
+
+	// RAW files and their JPEGs are rendered differently, so their hashes
+	// differ more than those of two JPEGs. Queries given by their hashes have
+	// no known format.
+	threshold := options.Threshold
+	if q.hasImage && q.raw != IsRawFormat(path) {
+		threshold -= tolerances.RawLeniency
+	}
 
-// hexToBinary converts a hexadecimal string to binary string
-func hexToBinary(hexStr string) string {
-	hexBytes, _ := hex.DecodeString(hexStr)
-	var binBuilder strings.Builder
-
-	for _, b := range hexBytes {
-		for i := 7; i >= 0; i-- {
-			if (b & (1 << i)) != 0 {
-				binBuilder.WriteRune('1')
-			} else {
-				binBuilder.WriteRune('0')
+	// If the similarity score is above the threshold, it's a match
+	if similarityScore >= threshold {
+		if options.DebugMode {
+			logging.DebugLog("Match found: %s (score: %.4f, avgHash: %.4f, pHash: %.4f, filenameBoost: %.4f, mirrored: %v)",
+				path, similarityScore, avgHashSimilarity, pHashSimilarity, filenameBoost, query.mirrored)
+		}
+		match := ImageMatch{
+			Path:         path,
+			SourcePrefix: sourcePrefix,
+			SSIMScore:    similarityScore,
+			HashScore:    similarityScore,
+			Mirrored:     query.mirrored,
+		}
+		if options.Explain {
+			match.Explanation = &MatchExplanation{
+				AverageHashDistance:    avgHashDistance,
+				PerceptualHashDistance: pHashDistance,
+				FilenameBoost:          filenameBoost,
 			}
 		}
+		return match, true
 	}
 
-	return binBuilder.String()
+	if options.DebugMode && (avgHashSimilarity > 0.5 || pHashSimilarity > 0.5) {
+		// Log near-misses for debugging
+		logging.DebugLog("Near miss: %s (score: %.4f, avgHash: %.4f, pHash: %.4f, filenameBoost: %.4f)",
+			path, similarityScore, avgHashSimilarity, pHashSimilarity, filenameBoost)
+	}
+	return ImageMatch{}, false
 }
 
 // calculateFilenameSimiliarity returns a similarity boost based on filename comparison
-// Returns a value between 0.0 (no similarity) and 0.15 (highly similar)
-func calculateFilenameSimiliarity(filename1, filename2 string) float64 {
+// Returns a value between 0.0 (no similarity) and the filename boost of the tolerances (highly similar)
+func calculateFilenameSimiliarity(filename1, filename2 string, tolerances Tolerances) float64 {
 	// Convert to lowercase for comparison
 	name1 := strings.ToLower(filename1)
 	name2 := strings.ToLower(filename2)
@@ -301,7 +451,7 @@ func calculateFilenameSimiliarity(filename1, filename2 string) float64 {
 	// Check for direct name containment
 	if strings.Contains(name1, name2) || strings.Contains(name2, name1) {
 		// If one name fully contains the other, high boost
-		return 0.15
+		return tolerances.FilenameBoost
 	}
 
 	// Check for partial match
@@ -332,24 +482,12 @@ func calculateFilenameSimiliarity(filename1, filename2 string) float64 {
 
 	// Scale boost based on number of matches
 	if matches > 0 {
-		return math.Min(0.1, float64(matches)*0.03)
+		return tolerances.partialFilenameBoost(matches)
 	}
 
 	return 0.0
 }
 
-// Helper to check if a file is in RAW format
-func isRawFormat(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	rawFormats := []string{".dng", ".raf", ".arw", ".nef", ".cr2", ".cr3", ".nrw", ".srf"}
-	for _, format := range rawFormats {
-		if ext == format {
-			return true
-		}
-	}
-	return false
-}
-
 // Helper to check if a file is in TIF format
 func isTifFormat(path string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
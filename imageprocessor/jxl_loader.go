@@ -0,0 +1,87 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"imagefinder/logging"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
+)
+
+// JxlImageLoader handles JPEG XL images. OpenCV only reads JXL when built
+// against libjxl, so the djxl reference decoder is used as a fallback.
+type JxlImageLoader struct {
+	BaseImageLoader
+	TempDir string
+}
+
+// NewJxlImageLoader creates a new loader for JPEG XL files
+func NewJxlImageLoader() *JxlImageLoader {
+	return &JxlImageLoader{
+		BaseImageLoader: BaseImageLoader{
+			SupportedFormats: []FormatType{FormatJXL},
+		},
+		TempDir: utils.TempDir(),
+	}
+}
+
+// LoadImage loads a JPEG XL image
+func (l *JxlImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	logging.LogInfo("Loading JPEG XL image: %s", path)
+
+	// OpenCV 4.11+ decodes JXL natively when built with libjxl
+	img := readGray(ctx, path)
+	if !img.Empty() {
+		return img, nil
+	}
+	img.Close()
+
+	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("jxl_conv_%d.png", time.Now().UnixNano()))
+	defer os.Remove(tempFilename)
+
+	methods := []func(context.Context, string, string) error{
+		convertWithDjxl,
+		convertWithImageMagick,
+	}
+
+	for _, method := range methods {
+		if err := method(ctx, path, tempFilename); err != nil || !hasFileContent(tempFilename) {
+			continue
+		}
+
+		img := readGray(ctx, tempFilename)
+		if !img.Empty() {
+			return img, nil
+		}
+		img.Close()
+		logging.LogWarning("JXL conversion produced an unreadable file for %s, trying next method", path)
+	}
+
+	return gocv.NewMat(), newImageLoadError("failed to decode JPEG XL image (install libjxl tools to enable JXL support)", path)
+}
+
+// convertWithDjxl decodes a JPEG XL file with the libjxl djxl tool
+func convertWithDjxl(ctx context.Context, path string, tempFilename string) error {
+	if !hasTool("djxl") {
+		return os.ErrNotExist
+	}
+
+	cmd := toolCommand(ctx, "djxl", path, tempFilename)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		logging.LogWarning("djxl conversion failed: %v, stderr: %s", err, stderr.String())
+		return err
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package imageprocessor
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -13,7 +14,7 @@ type ImageLoader interface {
 	CanLoad(path string) bool
 	
 	// LoadImage loads an image and returns the gocv.Mat representation
-	LoadImage(path string) (gocv.Mat, error)
+	LoadImage(ctx context.Context, path string) (gocv.Mat, error)
 }
 
 // BaseImageLoader provides common functionality for all image loaders
@@ -38,8 +39,8 @@ func (l *BaseImageLoader) CanLoad(path string) bool {
 
 // DefaultLoadImage provides a standard image loading implementation
 // This can be used by loaders that support standard formats
-func (l *BaseImageLoader) DefaultLoadImage(path string) (gocv.Mat, error) {
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+func (l *BaseImageLoader) DefaultLoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	img := readGray(ctx, path)
 	if img.Empty() {
 		return img, fmt.Errorf("failed to load image: %s", path)
 	}
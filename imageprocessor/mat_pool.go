@@ -0,0 +1,53 @@
+package imageprocessor
+
+import (
+	"gocv.io/x/gocv"
+)
+
+// MatPool keeps working Mats for reuse, so each image doesn't allocate fresh
+// OpenCV buffers for the same processing steps. OpenCV reuses a Mat's buffer
+// when an operation writes a result of the same size and type into it.
+type MatPool struct {
+	mats chan gocv.Mat
+}
+
+// NewMatPool creates a pool that keeps up to size idle Mats
+func NewMatPool(size int) *MatPool {
+	return &MatPool{mats: make(chan gocv.Mat, size)}
+}
+
+// Get returns an idle Mat, or a new one if none is idle
+func (p *MatPool) Get() gocv.Mat {
+	select {
+	case mat := <-p.mats:
+		return mat
+	default:
+		return gocv.NewMat()
+	}
+}
+
+// Put returns a Mat to the pool, closing it if the pool is full. The Mat must
+// not be used afterwards.
+func (p *MatPool) Put(mat gocv.Mat) {
+	select {
+	case p.mats <- mat:
+	default:
+		mat.Close()
+	}
+}
+
+// Close releases the idle Mats
+func (p *MatPool) Close() {
+	for {
+		select {
+		case mat := <-p.mats:
+			mat.Close()
+		default:
+			return
+		}
+	}
+}
+
+// workMats holds the Mats used for hashing and thumbnails, which are needed
+// for every image and shared by all workers
+var workMats = NewMatPool(64)
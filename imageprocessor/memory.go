@@ -0,0 +1,100 @@
+package imageprocessor
+
+import (
+	"context"
+	"image"
+	_ "image/gif"
+	"os"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Factors applied to the file size when an image's dimensions can't be read
+// from its header
+const (
+	rawDecodeFactor   = 6  // dcraw output is 16-bit RGB, about 6 bytes per sensor pixel
+	tiffDecodeFactor  = 2  // TIFF files are mostly uncompressed
+	otherDecodeFactor = 10 // Typical JPEG/HEIC compression ratio
+)
+
+// EstimateDecodeMemory estimates the memory needed to decode an image and work
+// on it. The dimensions are read from the header of JPEG, PNG and GIF files;
+// other formats are estimated from the file size.
+func EstimateDecodeMemory(path string, fileSize int64) int64 {
+	if file, err := os.Open(path); err == nil {
+		config, _, err := image.DecodeConfig(file)
+		file.Close()
+		if err == nil {
+			// Decoders may hold a full-colour copy next to the grayscale Mat
+			return int64(config.Width) * int64(config.Height) * 4
+		}
+	}
+
+	switch {
+	case IsRawFormat(path):
+		return fileSize * rawDecodeFactor
+	case IsTiffFormat(path):
+		return fileSize * tiffDecodeFactor
+	default:
+		return fileSize * otherDecodeFactor
+	}
+}
+
+// MemoryBudget limits the estimated memory of the images decoded at the same
+// time. A nil budget doesn't limit anything.
+type MemoryBudget struct {
+	limit int64
+	sem   *semaphore.Weighted
+}
+
+// NewMemoryBudget creates a budget of limit bytes, or returns nil when limit
+// is 0 or less
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &MemoryBudget{limit: limit, sem: semaphore.NewWeighted(limit)}
+}
+
+// Acquire waits until size bytes fit in the budget and returns the function
+// that gives them back. Waiters are served in order, and an image larger than
+// the whole budget runs once every other decode has finished.
+func (b *MemoryBudget) Acquire(ctx context.Context, size int64) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	size = min(max(size, 1), b.limit)
+	if err := b.sem.Acquire(ctx, size); err != nil {
+		return nil, err
+	}
+	return func() { b.sem.Release(size) }, nil
+}
+
+// ConversionLimit limits the RAW files converted at the same time, since
+// dcraw and the RAW developers use far more memory than other decoders. A nil
+// limit doesn't limit anything.
+type ConversionLimit struct {
+	sem *semaphore.Weighted
+}
+
+// NewConversionLimit allows n conversions at once, or returns nil when n is
+// 0 or less
+func NewConversionLimit(n int) *ConversionLimit {
+	if n <= 0 {
+		return nil
+	}
+	return &ConversionLimit{sem: semaphore.NewWeighted(int64(n))}
+}
+
+// Acquire waits for a free conversion slot and returns the function that
+// gives it back
+func (l *ConversionLimit) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if err := l.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { l.sem.Release(1) }, nil
+}
@@ -0,0 +1,184 @@
+package imageprocessor
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"imagefinder/logging"
+
+	"github.com/barasher/go-exiftool"
+)
+
+// ImageMetadata holds the EXIF fields stored alongside the image hashes
+type ImageMetadata struct {
+	Latitude    *float64
+	Longitude   *float64
+	CapturedAt  *time.Time
+	CameraMake  string
+	CameraModel string
+
+	ColorProfile string // Embedded ICC profile, or the EXIF color space
+	BitDepth     int    // Bits per color channel, 0 when unknown
+}
+
+// MetadataExtractor reads EXIF metadata through a single long-running exiftool process
+type MetadataExtractor struct {
+	et *exiftool.Exiftool
+}
+
+// NewMetadataExtractor starts exiftool if it is available.
+// Without exiftool the extractor is still usable but returns empty metadata.
+func NewMetadataExtractor() *MetadataExtractor {
+	if !hasExiftool() {
+		logging.LogWarning("exiftool not found, EXIF metadata will not be stored")
+		return &MetadataExtractor{}
+	}
+
+	// Numeric output gives signed decimal coordinates instead of formatted strings
+	et, err := exiftool.NewExiftool(exiftool.NoPrintConversion())
+	if err != nil {
+		logging.LogWarning("Failed to start exiftool for metadata extraction: %v", err)
+		return &MetadataExtractor{}
+	}
+
+	return &MetadataExtractor{et: et}
+}
+
+// Extract returns the metadata found in the file, leaving unknown fields empty
+func (m *MetadataExtractor) Extract(path string) ImageMetadata {
+	var metadata ImageMetadata
+	if m == nil || m.et == nil {
+		return metadata
+	}
+
+	fileInfos := m.et.ExtractMetadata(path)
+	if len(fileInfos) == 0 {
+		return metadata
+	}
+
+	fileInfo := fileInfos[0]
+	if fileInfo.Err != nil {
+		logging.LogWarning("Failed to extract metadata from %s: %v", path, fileInfo.Err)
+		return metadata
+	}
+
+	metadata.Latitude, metadata.Longitude = extractGPSCoordinates(fileInfo)
+	metadata.CapturedAt = extractCaptureTime(fileInfo)
+	metadata.CameraMake, _ = fileInfo.GetString("Make")
+	metadata.CameraModel, _ = fileInfo.GetString("Model")
+	metadata.CameraMake = strings.TrimSpace(metadata.CameraMake)
+	metadata.CameraModel = strings.TrimSpace(metadata.CameraModel)
+	metadata.ColorProfile = extractColorProfile(fileInfo)
+	metadata.BitDepth = extractBitDepth(fileInfo)
+
+	return metadata
+}
+
+// Close stops the exiftool process
+func (m *MetadataExtractor) Close() {
+	if m == nil || m.et == nil {
+		return
+	}
+
+	if err := m.et.Close(); err != nil {
+		logging.LogWarning("Failed to close exiftool: %v", err)
+	}
+	m.et = nil
+}
+
+// extractGPSCoordinates reads the GPS position as signed decimal degrees
+func extractGPSCoordinates(fileInfo exiftool.FileMetadata) (*float64, *float64) {
+	lat, err := fileInfo.GetFloat("GPSLatitude")
+	if err != nil {
+		return nil, nil
+	}
+	lon, err := fileInfo.GetFloat("GPSLongitude")
+	if err != nil {
+		return nil, nil
+	}
+
+	// The EXIF values are unsigned; the reference tags carry the hemisphere
+	if ref, err := fileInfo.GetString("GPSLatitudeRef"); err == nil && strings.HasPrefix(strings.ToUpper(ref), "S") && lat > 0 {
+		lat = -lat
+	}
+	if ref, err := fileInfo.GetString("GPSLongitudeRef"); err == nil && strings.HasPrefix(strings.ToUpper(ref), "W") && lon > 0 {
+		lon = -lon
+	}
+
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, nil
+	}
+
+	return &lat, &lon
+}
+
+// extractColorProfile reads the description of the embedded ICC profile.
+// Files without one fall back to the EXIF color space, where Adobe RGB is
+// also marked by the R03 interoperability index.
+func extractColorProfile(fileInfo exiftool.FileMetadata) string {
+	if profile, err := fileInfo.GetString("ProfileDescription"); err == nil && strings.TrimSpace(profile) != "" {
+		return strings.TrimSpace(profile)
+	}
+
+	if index, err := fileInfo.GetString("InteropIndex"); err == nil && strings.HasPrefix(index, "R03") {
+		return "Adobe RGB"
+	}
+	colorSpace, err := fileInfo.GetInt("ColorSpace")
+	if err != nil {
+		return ""
+	}
+	switch colorSpace {
+	case 1:
+		return "sRGB"
+	case 2:
+		return "Adobe RGB"
+	}
+	return ""
+}
+
+// extractBitDepth reads the bits of a color channel. TIFF files list them
+// per channel ("16 16 16"); PNG files report BitDepth instead.
+func extractBitDepth(fileInfo exiftool.FileMetadata) int {
+	for _, tag := range []string{"BitsPerSample", "BitDepth"} {
+		value, err := fileInfo.GetString(tag)
+		if err != nil {
+			continue
+		}
+		if fields := strings.Fields(value); len(fields) > 0 {
+			if bits, err := strconv.Atoi(fields[0]); err == nil && bits > 0 {
+				return bits
+			}
+		}
+	}
+	return 0
+}
+
+// exifDateLayouts lists the date formats exiftool reports for capture dates
+var exifDateLayouts = []string{
+	"2006:01:02 15:04:05.999999999-07:00",
+	"2006:01:02 15:04:05-07:00",
+	"2006:01:02 15:04:05.999999999Z",
+	"2006:01:02 15:04:05Z",
+	"2006:01:02 15:04:05.999999999",
+	"2006:01:02 15:04:05",
+}
+
+// extractCaptureTime reads the time the photo was taken.
+// Dates without a zone are interpreted in local time, like the search date flags.
+func extractCaptureTime(fileInfo exiftool.FileMetadata) *time.Time {
+	for _, tag := range []string{"DateTimeOriginal", "CreateDate", "DateCreated"} {
+		value, err := fileInfo.GetString(tag)
+		if err != nil || strings.HasPrefix(value, "0000") {
+			continue
+		}
+
+		for _, layout := range exifDateLayouts {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local); err == nil {
+				return &t
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+)
+
+// TIFF tags read to orient an image
+const (
+	tagImageWidth  = 256
+	tagImageLength = 257
+	tagOrientation = 274
+)
+
+// rafJPEGOffset is where a Fujifilm RAF header stores the offset of the
+// embedded JPEG, which carries the EXIF data of the file
+const rafJPEGOffset = 84
+
+// imageLayout is the EXIF orientation of a file and the size of the image as
+// stored, before the orientation is applied
+type imageLayout struct {
+	orientation   int // 1 to 8, 0 when unknown
+	width, height int // 0 when unknown
+}
+
+// ReadOrientation returns the EXIF orientation of a JPEG, TIFF-based RAW or
+// RAF file, from 1 (upright) to 8, or 0 when the file has none
+func ReadOrientation(path string) int {
+	return readImageLayout(path).orientation
+}
+
+// OrientImage turns a decoded image upright according to the EXIF
+// orientation of its file, so a phone JPEG stored sideways hashes like the
+// upright RAW file or export of the same shot. The decoders read the pixels
+// as stored, but RAW converters such as dcraw rotate their output
+// themselves, so a quarter turn is only applied while the image still has
+// the aspect of the stored one. Flips and half turns are only applied to
+// files other than RAW, where no converter is involved. The image is
+// returned as is, or closed and replaced by the turned one.
+func OrientImage(img gocv.Mat, path string) gocv.Mat {
+	layout := readImageLayout(path)
+	if layout.orientation < 2 || layout.orientation > 8 || img.Empty() {
+		return img
+	}
+
+	if layout.orientation >= 5 {
+		// Camera sensors are wider than tall when the stored size is unknown
+		storedLandscape := layout.width == 0 || layout.width >= layout.height
+		if img.Cols() != img.Rows() && (img.Cols() > img.Rows()) != storedLandscape {
+			return img
+		}
+	} else if IsRawFormat(path) {
+		return img
+	}
+
+	oriented := gocv.NewMat()
+	var err error
+	switch layout.orientation {
+	case 2:
+		err = gocv.Flip(img, &oriented, 1)
+	case 3:
+		err = gocv.Rotate(img, &oriented, gocv.Rotate180Clockwise)
+	case 4:
+		err = gocv.Flip(img, &oriented, 0)
+	case 5:
+		err = gocv.Transpose(img, &oriented)
+	case 6:
+		err = gocv.Rotate(img, &oriented, gocv.Rotate90Clockwise)
+	case 7:
+		transposed := gocv.NewMat()
+		defer transposed.Close()
+		if err = gocv.Transpose(img, &transposed); err == nil {
+			err = gocv.Rotate(transposed, &oriented, gocv.Rotate180Clockwise)
+		}
+	case 8:
+		err = gocv.Rotate(img, &oriented, gocv.Rotate90CounterClockwise)
+	}
+	if err != nil || oriented.Empty() {
+		logging.LogWarning("Failed to apply EXIF orientation %d to %s: %v", layout.orientation, path, err)
+		oriented.Close()
+		return img
+	}
+
+	logging.DebugLog("Applied EXIF orientation %d to %s", layout.orientation, path)
+	img.Close()
+	return oriented
+}
+
+// readImageLayout reads the orientation and stored size of a file, leaving
+// what can't be read at 0
+func readImageLayout(path string) imageLayout {
+	file, err := os.Open(path)
+	if err != nil {
+		return imageLayout{}
+	}
+	defer file.Close()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return imageLayout{}
+	}
+	switch {
+	case header[0] == 0xFF && header[1] == 0xD8:
+		return readJPEGLayout(file, 0)
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")):
+		offsetBytes := make([]byte, 4)
+		if _, err := file.ReadAt(offsetBytes, rafJPEGOffset); err != nil {
+			return imageLayout{}
+		}
+		return readJPEGLayout(file, int64(binary.BigEndian.Uint32(offsetBytes)))
+	default:
+		return readTIFFLayout(file)
+	}
+}
+
+// readJPEGLayout reads the size from the frame header of the JPEG stream at
+// offset, and the orientation from its EXIF segment
+func readJPEGLayout(r io.ReaderAt, offset int64) imageLayout {
+	var layout imageLayout
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker[:2], offset); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return layout
+	}
+
+	position := offset + 2
+	for range 64 {
+		if _, err := r.ReadAt(marker, position); err != nil || marker[0] != 0xFF {
+			return layout
+		}
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		switch {
+		case marker[1] == 0xE1 && layout.orientation == 0:
+			// APP1 holds "Exif\0\0" followed by a TIFF structure
+			exifHeader := make([]byte, 6)
+			if _, err := r.ReadAt(exifHeader, position+4); err == nil && string(exifHeader) == "Exif\x00\x00" {
+				exif := io.NewSectionReader(r, position+10, length-8)
+				layout.orientation = readTIFFLayout(exif).orientation
+			}
+		case marker[1] >= 0xC0 && marker[1] <= 0xCF && marker[1] != 0xC4 && marker[1] != 0xC8 && marker[1] != 0xCC:
+			// The frame header gives the height, then the width
+			frame := make([]byte, 5)
+			if _, err := r.ReadAt(frame, position+4); err == nil {
+				layout.height = int(binary.BigEndian.Uint16(frame[1:]))
+				layout.width = int(binary.BigEndian.Uint16(frame[3:]))
+			}
+			return layout
+		case marker[1] == 0xDA || marker[1] == 0xD9:
+			return layout
+		}
+		position += 2 + length
+	}
+	return layout
+}
+
+// readTIFFLayout reads the orientation and size from the first directory of
+// a TIFF structure. ORF and RW2 files replace the TIFF magic number with
+// their own.
+func readTIFFLayout(r io.ReaderAt) imageLayout {
+	var layout imageLayout
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return layout
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return layout
+	}
+	switch order.Uint16(header[2:]) {
+	case 42, 0x4F52, 0x5352, 0x55: // TIFF, ORF, RW2
+	default:
+		return layout
+	}
+
+	offset := int64(order.Uint32(header[4:]))
+	countBytes := make([]byte, 2)
+	if _, err := r.ReadAt(countBytes, offset); err != nil {
+		return layout
+	}
+	count := int(order.Uint16(countBytes))
+	if count == 0 || count > maxTiffIFDEntries {
+		return layout
+	}
+	entries := make([]byte, count*12)
+	if _, err := r.ReadAt(entries, offset+2); err != nil {
+		return layout
+	}
+
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : i*12+12]
+		tag := order.Uint16(entry)
+		if tag != tagOrientation && tag != tagImageWidth && tag != tagImageLength {
+			continue
+		}
+		values, err := readTiffValues(r, order, entry)
+		if err != nil || len(values) == 0 {
+			continue
+		}
+		switch tag {
+		case tagOrientation:
+			layout.orientation = int(values[0])
+		case tagImageWidth:
+			layout.width = int(values[0])
+		case tagImageLength:
+			layout.height = int(values[0])
+		}
+	}
+	return layout
+}
@@ -0,0 +1,185 @@
+package imageprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"imagefinder/logging"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
+)
+
+// PDFPageSeparator joins a PDF path and a page number into the path stored
+// for that page, e.g. "scans/report.pdf#page=3"
+const PDFPageSeparator = "#page="
+
+// pdfRenderDPI is the resolution pages are rasterized at. Hashes are computed
+// on heavily downscaled images, so a moderate resolution keeps rendering fast.
+const pdfRenderDPI = 150
+
+// pdfPageObjectPattern matches page objects when counting pages without pdfinfo
+var pdfPageObjectPattern = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// PDFPagePath returns the index path for a page of a PDF (pages start at 1)
+func PDFPagePath(path string, page int) string {
+	return fmt.Sprintf("%s%s%d", path, PDFPageSeparator, page)
+}
+
+// SplitPDFPagePath splits a PDF page path into the PDF file and page number
+func SplitPDFPagePath(path string) (string, int, bool) {
+	index := strings.LastIndex(path, PDFPageSeparator)
+	if index < 0 {
+		return path, 0, false
+	}
+
+	page, err := strconv.Atoi(path[index+len(PDFPageSeparator):])
+	if err != nil || page < 1 {
+		return path, 0, false
+	}
+	return path[:index], page, true
+}
+
+// IsPDFFormat checks if a path refers to a PDF file or one of its pages
+func IsPDFFormat(path string) bool {
+	return GetFileFormat(path) == FormatPDF
+}
+
+// CountPDFPages returns the number of pages in a PDF file
+func CountPDFPages(path string) (int, error) {
+	// pdfinfo (poppler) reads the page tree properly, including compressed object streams
+	if hasTool("pdfinfo") {
+		output, err := toolCommand(context.Background(), "pdfinfo", path).Output()
+		if err == nil {
+			scanner := bufio.NewScanner(bytes.NewReader(output))
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "Pages:") {
+					pages, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Pages:")))
+					if err == nil && pages > 0 {
+						return pages, nil
+					}
+				}
+			}
+		}
+		logging.LogWarning("pdfinfo could not read page count for %s: %v", path, err)
+	}
+
+	// Fall back to counting page objects, which works for uncompressed page trees
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read PDF %s: %v", path, err)
+	}
+	pages := len(pdfPageObjectPattern.FindAll(data, -1))
+	if pages == 0 {
+		return 0, fmt.Errorf("cannot determine page count for %s", path)
+	}
+	return pages, nil
+}
+
+// PdfImageLoader rasterizes single PDF pages so they can be hashed like images
+type PdfImageLoader struct {
+	TempDir string
+}
+
+// NewPdfImageLoader creates a new loader for PDF pages
+func NewPdfImageLoader() *PdfImageLoader {
+	return &PdfImageLoader{
+		TempDir: utils.TempDir(),
+	}
+}
+
+// CanLoad checks if the path is a PDF file or page whose document exists
+func (l *PdfImageLoader) CanLoad(path string) bool {
+	return IsPDFFormat(path) && fileExists(SourceFile(path))
+}
+
+// LoadImage renders the page named by path ("doc.pdf#page=N"); a plain PDF
+// path renders the first page
+func (l *PdfImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	file, page, ok := SplitPDFPagePath(path)
+	if !ok {
+		page = 1
+	}
+	logging.LogInfo("Rendering PDF page %d of %s", page, file)
+
+	workDir, err := os.MkdirTemp(l.TempDir, "pdf_page_")
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to create temp directory for PDF rendering: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	methods := []func(context.Context, string, int, string) (string, error){
+		renderPDFPageWithPdftoppm,
+		renderPDFPageWithMutool,
+	}
+
+	for _, method := range methods {
+		outputPath, err := method(ctx, file, page, workDir)
+		if err != nil {
+			logging.DebugLog("PDF rendering method failed for %s: %v", path, err)
+			continue
+		}
+
+		img := readGray(ctx, outputPath)
+		if !img.Empty() {
+			return img, nil
+		}
+		img.Close()
+	}
+
+	return gocv.NewMat(), newImageLoadError("failed to render PDF page (install poppler-utils or mupdf-tools)", path)
+}
+
+// renderPDFPageWithPdftoppm renders a page with poppler's pdftoppm
+func renderPDFPageWithPdftoppm(ctx context.Context, file string, page int, workDir string) (string, error) {
+	if !hasTool("pdftoppm") {
+		return "", fmt.Errorf("pdftoppm not available")
+	}
+
+	outputPrefix := filepath.Join(workDir, "page")
+	pageArg := strconv.Itoa(page)
+	cmd := toolCommand(ctx, "pdftoppm", "-f", pageArg, "-l", pageArg, "-r", strconv.Itoa(pdfRenderDPI),
+		"-gray", "-png", "-singlefile", file, outputPrefix)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pdftoppm failed: %v, stderr: %s", err, stderr.String())
+	}
+
+	outputPath := outputPrefix + ".png"
+	if !hasFileContent(outputPath) {
+		return "", fmt.Errorf("pdftoppm produced no output")
+	}
+	return outputPath, nil
+}
+
+// renderPDFPageWithMutool renders a page with MuPDF's mutool
+func renderPDFPageWithMutool(ctx context.Context, file string, page int, workDir string) (string, error) {
+	if !hasTool("mutool") {
+		return "", fmt.Errorf("mutool not available")
+	}
+
+	outputPath := filepath.Join(workDir, "page.png")
+	cmd := toolCommand(ctx, "mutool", "draw", "-q", "-r", strconv.Itoa(pdfRenderDPI), "-c", "gray",
+		"-o", outputPath, file, strconv.Itoa(page))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("mutool failed: %v, stderr: %s", err, stderr.String())
+	}
+
+	if !hasFileContent(outputPath) {
+		return "", fmt.Errorf("mutool produced no output")
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,80 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preset names a curated set of the tolerances that decide how alike a
+// candidate must be to the query to match
+type Preset string
+
+const (
+	// PresetStrict only matches near copies of the query
+	PresetStrict Preset = "strict"
+	// PresetNormal balances missed originals against false matches
+	PresetNormal Preset = "normal"
+	// PresetLoose also matches heavily edited copies and RAW files whose
+	// rendering differs from the query's
+	PresetLoose Preset = "loose"
+)
+
+// Presets lists the accepted presets
+var Presets = []string{string(PresetStrict), string(PresetNormal), string(PresetLoose)}
+
+// Tolerances are the values a preset stands for
+type Tolerances struct {
+	Threshold       float64 // Smallest hash score of a match, unless the search gives one
+	MaxHashDistance int     // Most bits the hashes deciding a match may differ in, 0 for no limit
+	MinScore        float64 // Smallest verification score a verified match keeps
+	RawLeniency     float64 // Taken off the threshold when only one of the query and the candidate is a RAW file
+	FilenameBoost   float64 // Added to the hash score when one file name contains the other
+}
+
+// presetTolerances holds the tolerances of each preset. Those of
+// PresetNormal are the ones searches always used before presets.
+var presetTolerances = map[Preset]Tolerances{
+	PresetStrict: {
+		Threshold:       0.9,
+		MaxHashDistance: 8,
+		MinScore:        0.85,
+		FilenameBoost:   0.05,
+	},
+	PresetNormal: {
+		Threshold:     0.8,
+		FilenameBoost: 0.15,
+	},
+	PresetLoose: {
+		Threshold:     0.7,
+		RawLeniency:   0.08,
+		FilenameBoost: 0.2,
+	},
+}
+
+// ParsePreset reads a preset name, defaulting to PresetNormal when empty
+func ParsePreset(name string) (Preset, error) {
+	if name == "" {
+		return PresetNormal, nil
+	}
+	for _, preset := range Presets {
+		if strings.EqualFold(name, preset) {
+			return Preset(preset), nil
+		}
+	}
+	return "", fmt.Errorf("invalid preset '%s', expected %s", name, strings.Join(Presets, ", "))
+}
+
+// Tolerances returns the values of the preset, those of PresetNormal for the
+// empty preset
+func (p Preset) Tolerances() Tolerances {
+	if tolerances, ok := presetTolerances[p]; ok {
+		return tolerances
+	}
+	return presetTolerances[PresetNormal]
+}
+
+// partialFilenameBoost is the boost for each name part two files share,
+// which together add up to at most two thirds of the full boost
+func (t Tolerances) partialFilenameBoost(sharedParts int) float64 {
+	return min(t.FilenameBoost*2/3, float64(sharedParts)*t.FilenameBoost/5)
+}
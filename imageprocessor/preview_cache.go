@@ -0,0 +1,204 @@
+package imageprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+)
+
+// PreviewCacheVersion identifies the RAW conversion pipeline that produced a
+// cached preview. Bump it whenever loader changes would alter decoded pixels
+// so stale previews are ignored instead of reused.
+const PreviewCacheVersion = 2
+
+// previewCacheExt is the file type used for cached previews. PNG is lossless,
+// so hashes computed from a cached preview match a fresh conversion.
+const previewCacheExt = ".png"
+
+// PreviewCache stores decoded RAW previews on disk keyed by file checksum
+type PreviewCache struct {
+	Dir string
+}
+
+// DefaultPreviewCacheDir returns the per-user directory used for cached previews
+func DefaultPreviewCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "imagefinder", "previews")
+	}
+	return filepath.Join(cacheDir, "imagefinder", "previews")
+}
+
+// NewPreviewCache opens a preview cache rooted at dir, creating it if needed
+func NewPreviewCache(dir string) (*PreviewCache, error) {
+	if dir == "" {
+		dir = DefaultPreviewCacheDir()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create preview cache directory: %v", err)
+	}
+	return &PreviewCache{Dir: dir}, nil
+}
+
+// Key returns the cache key for a file: its SHA-256 checksum combined with the
+// preview cache version
+func (c *PreviewCache) Key(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-v%d", hex.EncodeToString(hasher.Sum(nil)), PreviewCacheVersion), nil
+}
+
+// entryPath returns where the preview for key is stored, sharded by the first
+// two checksum characters to keep directories small
+func (c *PreviewCache) entryPath(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+previewCacheExt)
+}
+
+// Load returns the cached preview stored under key, if any
+func (c *PreviewCache) Load(key string) (gocv.Mat, bool) {
+	entry := c.entryPath(key)
+	if !hasFileContent(entry) {
+		return gocv.NewMat(), false
+	}
+
+	img := gocv.IMRead(entry, gocv.IMReadGrayScale)
+	if img.Empty() {
+		img.Close()
+		logging.LogWarning("Discarding unreadable cached preview: %s", entry)
+		os.Remove(entry)
+		return gocv.NewMat(), false
+	}
+	return img, true
+}
+
+// Store saves a decoded preview under key
+func (c *PreviewCache) Store(key string, img gocv.Mat) error {
+	entry := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return fmt.Errorf("failed to create preview cache directory: %v", err)
+	}
+
+	// Write to a temporary name first so concurrent readers never see a partial file
+	tempEntry := fmt.Sprintf("%s.%d.tmp%s", strings.TrimSuffix(entry, previewCacheExt), os.Getpid(), previewCacheExt)
+	if !gocv.IMWrite(tempEntry, img) {
+		os.Remove(tempEntry)
+		return fmt.Errorf("failed to write cached preview: %s", entry)
+	}
+	if err := os.Rename(tempEntry, entry); err != nil {
+		os.Remove(tempEntry)
+		return fmt.Errorf("failed to store cached preview: %v", err)
+	}
+	return nil
+}
+
+// Clean removes every cached preview and returns the number of files and bytes freed
+func (c *PreviewCache) Clean() (int, int64, error) {
+	var files int
+	var bytes int64
+
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("failed to read preview cache: %v", err)
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(c.Dir, entry.Name())
+		err := filepath.Walk(entryPath, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				files++
+				bytes += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return files, bytes, fmt.Errorf("failed to measure preview cache: %v", err)
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			return files, bytes, fmt.Errorf("failed to remove cached previews: %v", err)
+		}
+	}
+
+	return files, bytes, nil
+}
+
+// CachingImageLoader wraps a loader so its decoded images are reused across runs
+type CachingImageLoader struct {
+	Loader ImageLoader
+	Cache  *PreviewCache
+
+	// variant keeps the previews of loaders that decode a different image of
+	// the same file apart, such as those of each RAW mode or DNG minimum
+	// preview size
+	variant string
+}
+
+// NewCachingImageLoader wraps loader with the given preview cache
+func NewCachingImageLoader(loader ImageLoader, cache *PreviewCache) *CachingImageLoader {
+	caching := &CachingImageLoader{
+		Loader: loader,
+		Cache:  cache,
+	}
+	if rawLoader, ok := loader.(*RawModeImageLoader); ok {
+		caching.variant = string(rawLoader.Mode)
+	}
+	if _, ok := loader.(*DNGImageLoader); ok {
+		caching.variant = fmt.Sprintf("min%d", dngMinPreview.Load())
+	}
+	return caching
+}
+
+// CanLoad defers to the wrapped loader
+func (l *CachingImageLoader) CanLoad(path string) bool {
+	return l.Loader.CanLoad(path)
+}
+
+// LoadImage returns the cached preview when present, otherwise loads the image
+// with the wrapped loader and caches the result
+func (l *CachingImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	key, err := l.Cache.Key(path)
+	if err != nil {
+		logging.LogWarning("Cannot compute preview cache key for %s: %v", path, err)
+		return l.Loader.LoadImage(ctx, path)
+	}
+	if l.variant != "" {
+		key += "-" + l.variant
+	}
+
+	// Cached previews are grayscale, so a color copy needs a fresh conversion
+	if !capturingColor(ctx) {
+		if img, ok := l.Cache.Load(key); ok {
+			logging.DebugLog("Using cached preview for %s", path)
+			return img, nil
+		}
+	}
+
+	img, err := l.Loader.LoadImage(ctx, path)
+	if err != nil || img.Empty() {
+		return img, err
+	}
+
+	if err := l.Cache.Store(key, img); err != nil {
+		logging.LogWarning("Cannot cache preview for %s: %v", path, err)
+	}
+	return img, nil
+}
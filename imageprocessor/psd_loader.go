@@ -0,0 +1,262 @@
+package imageprocessor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"imagefinder/logging"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
+)
+
+// Color modes of a PSD file that the Go reader decodes
+const (
+	psdModeGrayscale = 1
+	psdModeRGB       = 3
+	psdModeCMYK      = 4
+)
+
+// maxPSDPixels keeps a corrupt header from allocating an image of any size
+const maxPSDPixels = 1 << 29
+
+// PsdImageLoader decodes the flattened composite image that Photoshop stores
+// after the layers of PSD and PSB files. Grayscale, RGB and CMYK files of 8
+// or 16 bits are read in Go; other files are converted with ImageMagick.
+type PsdImageLoader struct {
+	TempDir string
+}
+
+// NewPsdImageLoader creates a new loader for PSD files
+func NewPsdImageLoader() *PsdImageLoader {
+	return &PsdImageLoader{
+		TempDir: utils.TempDir(),
+	}
+}
+
+// CanLoad checks if the path is an existing PSD file
+func (l *PsdImageLoader) CanLoad(path string) bool {
+	return GetFileFormat(path) == FormatPSD && fileExists(path)
+}
+
+// LoadImage decodes the composite image of a PSD file
+func (l *PsdImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	composite, err := readPSDComposite(path)
+	if err == nil {
+		return grayFromGoImage(ctx, composite, path)
+	}
+	logging.DebugLog("Go PSD reader failed for %s: %v", path, err)
+
+	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("psd_conv_%d.png", time.Now().UnixNano()))
+	defer os.Remove(tempFilename)
+
+	// The first image ImageMagick reads from a PSD file is the composite
+	if convertWithImageMagick(ctx, path, tempFilename) == nil && hasFileContent(tempFilename) {
+		img := readGray(ctx, tempFilename)
+		if !img.Empty() {
+			return img, nil
+		}
+		img.Close()
+	}
+
+	return gocv.NewMat(), fmt.Errorf("failed to decode PSD composite (%v; ImageMagick can convert other PSD files): %s", err, path)
+}
+
+// readPSDComposite reads the composite image of a PSD or PSB file
+func readPSDComposite(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 26)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "8BPS" {
+		return nil, errors.New("not a PSD file")
+	}
+	version := binary.BigEndian.Uint16(header[4:])
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported PSD version %d", version)
+	}
+	channels := int(binary.BigEndian.Uint16(header[12:]))
+	height := int(binary.BigEndian.Uint32(header[14:]))
+	width := int(binary.BigEndian.Uint32(header[18:]))
+	depth := int(binary.BigEndian.Uint16(header[22:]))
+	mode := int(binary.BigEndian.Uint16(header[24:]))
+
+	var planes int
+	switch mode {
+	case psdModeGrayscale:
+		planes = 1
+	case psdModeRGB:
+		planes = 3
+	case psdModeCMYK:
+		planes = 4
+	default:
+		return nil, fmt.Errorf("unsupported color mode %d", mode)
+	}
+	if depth != 8 && depth != 16 {
+		return nil, fmt.Errorf("unsupported bit depth %d", depth)
+	}
+	if channels < planes || width <= 0 || height <= 0 || width*height > maxPSDPixels {
+		return nil, fmt.Errorf("invalid header (%d channels, %dx%d)", channels, width, height)
+	}
+
+	// Skip the color mode data, image resources, and layers; the length of
+	// the layer section takes 8 bytes in PSB files
+	for section := 0; section < 3; section++ {
+		lengthBytes := make([]byte, 8)
+		size := 4
+		if section == 2 && version == 2 {
+			size = 8
+		}
+		if _, err := io.ReadFull(file, lengthBytes[:size]); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(lengthBytes))
+		if size == 8 {
+			length = int64(binary.BigEndian.Uint64(lengthBytes))
+		}
+		if _, err := file.Seek(length, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := bufio.NewReader(file)
+	compressionBytes := make([]byte, 2)
+	if _, err := io.ReadFull(reader, compressionBytes); err != nil {
+		return nil, err
+	}
+
+	// Channels are stored one after the other; extra ones such as alpha
+	// follow the color channels and are not read
+	rowBytes := width * depth / 8
+	data := make([][]byte, planes)
+	switch compression := binary.BigEndian.Uint16(compressionBytes); compression {
+	case 0:
+		for c := range data {
+			data[c] = make([]byte, rowBytes*height)
+			if _, err := io.ReadFull(reader, data[c]); err != nil {
+				return nil, err
+			}
+		}
+	case 1:
+		// PackBits, after the byte count of each row of each channel
+		countSize := 2
+		if version == 2 {
+			countSize = 4
+		}
+		counts := make([]byte, channels*height*countSize)
+		if _, err := io.ReadFull(reader, counts); err != nil {
+			return nil, err
+		}
+		var packed []byte
+		for c := range data {
+			data[c] = make([]byte, rowBytes*height)
+			for row := 0; row < height; row++ {
+				index := (c*height + row) * countSize
+				count := int(binary.BigEndian.Uint16(counts[index:]))
+				if countSize == 4 {
+					count = int(binary.BigEndian.Uint32(counts[index:]))
+				}
+				if cap(packed) < count {
+					packed = make([]byte, count)
+				}
+				packed = packed[:count]
+				if _, err := io.ReadFull(reader, packed); err != nil {
+					return nil, err
+				}
+				if err := unpackBits(packed, data[c][row*rowBytes:(row+1)*rowBytes]); err != nil {
+					return nil, fmt.Errorf("channel %d, row %d: %v", c, row, err)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", compression)
+	}
+
+	return psdImage(data, width, height, depth, mode), nil
+}
+
+// unpackBits decodes a PackBits row into dst, which it must fill exactly
+func unpackBits(src, dst []byte) error {
+	written := 0
+	for i := 0; i < len(src); {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) || written+n+1 > len(dst) {
+				return errors.New("literal run out of bounds")
+			}
+			written += copy(dst[written:], src[i:i+n+1])
+			i += n + 1
+		case n != -128:
+			if i >= len(src) || written+1-n > len(dst) {
+				return errors.New("repeat run out of bounds")
+			}
+			for range 1 - n {
+				dst[written] = src[i]
+				written++
+			}
+			i++
+		}
+	}
+	if written != len(dst) {
+		return fmt.Errorf("row has %d bytes, not %d", written, len(dst))
+	}
+	return nil
+}
+
+// psdImage assembles the channels of a composite into an image. CMYK values
+// are stored inverted, 0 being full ink, so each color is the product of its
+// inverted ink and black.
+func psdImage(data [][]byte, width, height, depth, mode int) image.Image {
+	bounds := image.Rect(0, 0, width, height)
+	if mode == psdModeGrayscale {
+		if depth == 8 {
+			return &image.Gray{Pix: data[0], Stride: width, Rect: bounds}
+		}
+		return &image.Gray16{Pix: data[0], Stride: width * 2, Rect: bounds}
+	}
+
+	size := width * height
+	if depth == 8 {
+		img := image.NewRGBA(bounds)
+		for i := 0; i < size; i++ {
+			for c := 0; c < 3; c++ {
+				value := data[c][i]
+				if mode == psdModeCMYK {
+					value = uint8(uint32(value) * uint32(data[3][i]) / 255)
+				}
+				img.Pix[i*4+c] = value
+			}
+			img.Pix[i*4+3] = 255
+		}
+		return img
+	}
+
+	img := image.NewRGBA64(bounds)
+	for i := 0; i < size; i++ {
+		for c := 0; c < 3; c++ {
+			value := binary.BigEndian.Uint16(data[c][i*2:])
+			if mode == psdModeCMYK {
+				value = uint16(uint32(value) * uint32(binary.BigEndian.Uint16(data[3][i*2:])) / 65535)
+			}
+			binary.BigEndian.PutUint16(img.Pix[i*8+c*2:], value)
+		}
+		binary.BigEndian.PutUint16(img.Pix[i*8+6:], 65535)
+	}
+	return img
+}
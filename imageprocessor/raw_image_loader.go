@@ -2,16 +2,17 @@ package imageprocessor
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	_ "image/jpeg"
 	_ "image/png"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"imagefinder/logging"
+	"imagefinder/utils"
 
 	"gocv.io/x/gocv"
 )
@@ -23,27 +24,22 @@ type RawImageLoader struct {
 
 // NewRawImageLoader creates a new loader for RAW files
 func NewRawImageLoader() *RawImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &RawImageLoader{
 		TempDir: tempDir,
 	}
 }
 
 func (l *RawImageLoader) CanLoad(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	// Explicitly include all requested formats: DNG, RAF, ARW, NEF, CR2, CR3
-	rawFormats := []string{".dng", ".raf", ".arw", ".nef", ".cr2", ".cr3", ".nrw", ".srf"}
-	for _, format := range rawFormats {
-		if ext == format {
-			// Check if file exists and is readable
-			_, err := os.Stat(path)
-			return err == nil
-		}
+	if !IsRawFormat(path) {
+		return false
 	}
-	return false
+	// Check if file exists and is readable
+	_, err := os.Stat(path)
+	return err == nil
 }
 
-func (l *RawImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *RawImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	logging.LogInfo("Loading RAW image: %s", path)
 
 	// Create a unique temporary filename for the converted image
@@ -53,7 +49,7 @@ func (l *RawImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	// Check if it's a CR3 file specifically
 	if strings.ToLower(filepath.Ext(path)) == ".cr3" {
 		logging.LogInfo("Detected CR3 format, using specialized loader")
-		if success, img := l.tryCR3(path, tempFilename); success {
+		if success, img := l.tryCR3(ctx, path, tempFilename); success {
 			return img, nil
 		}
 	}
@@ -62,32 +58,38 @@ func (l *RawImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	if strings.ToLower(filepath.Ext(path)) == ".raf" {
 		logging.LogInfo("Detected RAF format, using specialized RAF loader")
 		rafLoader := NewRAFImageLoader()
-		return rafLoader.LoadImage(path)
+		return rafLoader.LoadImage(ctx, path)
+	}
+
+	// Olympus, Panasonic and Pentax files have their own loader that knows
+	// where each camera stores its embedded JPEG
+	if format := GetFileFormat(path); rawFormatMethods[format] != nil {
+		return NewRawFormatLoader(format).LoadImage(ctx, path)
 	}
 
 	// First try with dcraw
 	logging.LogInfo("Trying to load RAW with dcraw")
-	if success, img := l.tryDcraw(path, tempFilename); success {
+	if success, img := l.tryDcraw(ctx, path, tempFilename); success {
 		return img, nil
 	}
 
 	// If dcraw fails, try libraw fallback
 	logging.LogInfo("Trying to load RAW with libraw")
-	if success, img := l.tryLibRaw(path, tempFilename); success {
+	if success, img := l.tryLibRaw(ctx, path, tempFilename); success {
 		return img, nil
 	}
 
 	// Check if exiftool is available and try extracting preview
 	logging.LogInfo("Trying to extract preview with exiftool")
 	if hasExiftool() {
-		if success, img := l.tryExtractPreview(path, tempFilename); success {
+		if success, img := l.tryExtractPreview(ctx, path, tempFilename); success {
 			return img, nil
 		}
 	}
 
 	// Final fallback - try direct load (unlikely to work for most RAW formats)
 	logging.LogInfo("All conversion methods failed, attempting direct load as last resort")
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if img.Empty() {
 		// Last resort - try to use standard Go image packages which might support some RAW formats
 		logging.LogInfo("Direct load failed, trying Go standard image packages")
@@ -103,8 +105,8 @@ func (l *RawImageLoader) LoadImage(path string) (gocv.Mat, error) {
 }
 
 // Try to extract preview image with exiftool
-func (l *RawImageLoader) tryExtractPreview(path string, tempFilename string) (bool, gocv.Mat) {
-	cmd := exec.Command("exiftool", "-b", "-PreviewImage", path)
+func (l *RawImageLoader) tryExtractPreview(ctx context.Context, path string, tempFilename string) (bool, gocv.Mat) {
+	cmd := toolCommand(ctx, "exiftool", "-b", "-PreviewImage", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
@@ -120,7 +122,7 @@ func (l *RawImageLoader) tryExtractPreview(path string, tempFilename string) (bo
 		// Check if file has content
 		info, err := os.Stat(tempFilename)
 		if err == nil && info.Size() > 0 {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+			img := readGray(ctx, tempFilename)
 			if !img.Empty() {
 				return true, img
 			}
@@ -130,10 +132,9 @@ func (l *RawImageLoader) tryExtractPreview(path string, tempFilename string) (bo
 	return false, gocv.NewMat()
 }
 
-func (l *RawImageLoader) tryDcraw(path string, tempFilename string) (bool, gocv.Mat) {
+func (l *RawImageLoader) tryDcraw(ctx context.Context, path string, tempFilename string) (bool, gocv.Mat) {
 	// Check if dcraw is available
 	if !hasDcraw() {
-		logging.LogWarning("dcraw not found on system, skipping dcraw conversion")
 		return false, gocv.NewMat()
 	}
 
@@ -142,7 +143,7 @@ func (l *RawImageLoader) tryDcraw(path string, tempFilename string) (bool, gocv.
 	// -c = output to stdout (we redirect to file)
 	// -w = use camera white balance
 	// -q 3 = use high-quality interpolation
-	cmd := exec.Command("dcraw", "-T", "-c", "-w", "-q", "3", path)
+	cmd := toolCommand(ctx, "dcraw", "-T", "-c", "-w", "-q", "3", path)
 
 	// Create the output file
 	outFile, err := os.Create(tempFilename)
@@ -167,7 +168,7 @@ func (l *RawImageLoader) tryDcraw(path string, tempFilename string) (bool, gocv.
 	}
 
 	// Load the converted TIFF
-	img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+	img := readGray(ctx, tempFilename)
 	if img.Empty() {
 		return false, gocv.NewMat()
 	}
@@ -175,10 +176,13 @@ func (l *RawImageLoader) tryDcraw(path string, tempFilename string) (bool, gocv.
 	return true, img
 }
 
-func (l *RawImageLoader) tryLibRaw(path string, tempFilename string) (bool, gocv.Mat) {
+func (l *RawImageLoader) tryLibRaw(ctx context.Context, path string, tempFilename string) (bool, gocv.Mat) {
 	// Try with rawtherapee-cli as an alternative for RAW conversion
 	// Example: rawtherapee-cli -o /tmp/output.jpg -c /path/to/raw/file.CR2
-	cmd := exec.Command("rawtherapee-cli", "-o", tempFilename, "-c", path)
+	if !hasTool("rawtherapee-cli") {
+		return false, gocv.NewMat()
+	}
+	cmd := toolCommand(ctx, "rawtherapee-cli", "-o", tempFilename, "-c", path)
 
 	// Capture stderr for error reporting
 	var stderr bytes.Buffer
@@ -190,7 +194,7 @@ func (l *RawImageLoader) tryLibRaw(path string, tempFilename string) (bool, gocv
 		return false, gocv.NewMat()
 	}
 
-	img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+	img := readGray(ctx, tempFilename)
 	if img.Empty() {
 		return false, gocv.NewMat()
 	}
@@ -198,38 +202,23 @@ func (l *RawImageLoader) tryLibRaw(path string, tempFilename string) (bool, gocv
 	return true, img
 }
 
-func (l *RawImageLoader) tryCR3(path string, tempFilename string) (bool, gocv.Mat) {
+func (l *RawImageLoader) tryCR3(ctx context.Context, path string, tempFilename string) (bool, gocv.Mat) {
 	// CR3 files often need different handling
 
 	// Try with exiftool to extract preview image (often works for CR3)
-	cmd := exec.Command("exiftool", "-b", "-PreviewImage", path)
-
-	outFile, err := os.Create(tempFilename)
-	if err != nil {
-		logging.LogWarning("Failed to create temp file for CR3 conversion: %v", err)
-		return false, gocv.NewMat()
-	}
-	defer outFile.Close()
-
-	cmd.Stdout = outFile
-	err = cmd.Run()
-
-	if err == nil {
-		// Check if file has content
-		info, err := os.Stat(tempFilename)
-		if err == nil && info.Size() > 0 {
-			img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
-			if !img.Empty() {
-				return true, img
-			}
+	if hasExiftool() {
+		if success, img := l.tryExtractPreview(ctx, path, tempFilename); success {
+			return true, img
 		}
 	}
 
 	// If extracting preview failed, try alternative approach using libraw
-	cmd = exec.Command("libraw_unpack", "-O", tempFilename, path)
-	err = cmd.Run()
-	if err == nil {
-		img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+	if !hasTool("libraw_unpack") {
+		return false, gocv.NewMat()
+	}
+	cmd := toolCommand(ctx, "libraw_unpack", "-O", tempFilename, path)
+	if err := cmd.Run(); err == nil {
+		img := readGray(ctx, tempFilename)
 		if !img.Empty() {
 			return true, img
 		}
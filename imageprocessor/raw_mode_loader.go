@@ -0,0 +1,137 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"imagefinder/logging"
+	"imagefinder/types"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
+)
+
+// RawModeImageLoader decodes RAW files with only the methods of one RAW
+// mode, so a file is always hashed from the same kind of image. It fails
+// rather than fall back to a method of another mode.
+type RawModeImageLoader struct {
+	Mode    types.RawMode
+	TempDir string
+}
+
+// NewRawModeImageLoader creates a loader for RAW files decoded in mode,
+// which must not be RawModeAuto
+func NewRawModeImageLoader(mode types.RawMode) *RawModeImageLoader {
+	return &RawModeImageLoader{Mode: mode, TempDir: utils.TempDir()}
+}
+
+// CanLoad accepts the RAW formats
+func (l *RawModeImageLoader) CanLoad(path string) bool {
+	return IsRawFormat(path) && fileExists(path)
+}
+
+// LoadImage decodes the image of the file chosen by the loader's mode
+func (l *RawModeImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	if l.Mode == types.RawModeEmbeddedLarge {
+		// TIFF-based RAW files are read without starting exiftool
+		if img, ok := largestEmbeddedJPEG(ctx, path); ok {
+			return img, nil
+		}
+	}
+
+	var methods []func(context.Context, string, string) error
+	switch l.Mode {
+	case types.RawModePreview:
+		methods = append(methods, func(ctx context.Context, path, outputPath string) error {
+			return extractExiftoolImage(ctx, path, "PreviewImage", outputPath)
+		})
+	case types.RawModeEmbeddedLarge:
+		methods = append(methods, func(ctx context.Context, path, outputPath string) error {
+			return extractExiftoolImage(ctx, path, "LargestImagePreview", outputPath)
+		})
+	case types.RawModeFullDecode:
+		methods = append(methods, tryDcrawConversionStandard, convertWithRawtherapee)
+	default:
+		return gocv.NewMat(), fmt.Errorf("unsupported RAW mode '%s'", l.Mode)
+	}
+
+	tempFilename := filepath.Join(l.TempDir, fmt.Sprintf("raw_mode_%d.tiff", time.Now().UnixNano()))
+	defer os.Remove(tempFilename)
+
+	for _, method := range methods {
+		if err := ctx.Err(); err != nil {
+			return gocv.NewMat(), err
+		}
+		if err := method(ctx, path, tempFilename); err != nil || !hasFileContent(tempFilename) {
+			continue
+		}
+		img := readGray(ctx, tempFilename)
+		if !img.Empty() {
+			return img, nil
+		}
+		img.Close()
+	}
+
+	return gocv.NewMat(), fmt.Errorf("failed to decode RAW image with --raw-mode=%s (%s): %s", l.Mode, rawModeTools(l.Mode), path)
+}
+
+// largestEmbeddedJPEG decodes the largest JPEG embedded in a TIFF-based RAW
+// file, reporting false for other files
+func largestEmbeddedJPEG(ctx context.Context, path string) (gocv.Mat, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return gocv.NewMat(), false
+	}
+	defer file.Close()
+
+	previews, err := findEmbeddedJPEGs(file)
+	if err != nil || len(previews) == 0 {
+		return gocv.NewMat(), false
+	}
+	data := make([]byte, previews[0].size)
+	if _, err := file.ReadAt(data, previews[0].offset); err != nil {
+		return gocv.NewMat(), false
+	}
+	img := decodeGray(ctx, data)
+	if img.Empty() {
+		img.Close()
+		return gocv.NewMat(), false
+	}
+	return img, true
+}
+
+// extractExiftoolImage writes the binary image stored in an exiftool tag
+// of path to outputPath
+func extractExiftoolImage(ctx context.Context, path string, tag string, outputPath string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer outFile.Close()
+
+	cmd := toolCommand(ctx, "exiftool", "-b", "-"+tag, path)
+	cmd.Stdout = outFile
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logging.LogWarning("exiftool %s extraction failed for %s: %v, stderr: %s", tag, path, err, stderr.String())
+		return err
+	}
+	return nil
+}
+
+// rawModeTools names the tools a RAW mode needs, for error messages
+func rawModeTools(mode types.RawMode) string {
+	if mode == types.RawModeFullDecode {
+		return "needs dcraw or rawtherapee-cli"
+	}
+	return "needs exiftool"
+}
@@ -0,0 +1,262 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// Metric selects how a match is verified against the query image
+type Metric string
+
+const (
+	// MetricSSIM is the structural similarity index over Gaussian windows
+	MetricSSIM Metric = "ssim"
+	// MetricAbsDiff is 1 minus the mean absolute pixel difference, the
+	// metric earlier versions reported as SSIM
+	MetricAbsDiff Metric = "absdiff"
+	// MetricMSSSIM is SSIM combined over five successively halved scales
+	MetricMSSSIM Metric = "ms-ssim"
+)
+
+// Metrics lists the accepted verification metrics
+var Metrics = []string{string(MetricSSIM), string(MetricMSSSIM), string(MetricAbsDiff)}
+
+// ParseMetric reads a metric name, defaulting to MetricSSIM when empty
+func ParseMetric(name string) (Metric, error) {
+	if name == "" {
+		return MetricSSIM, nil
+	}
+	for _, metric := range Metrics {
+		if strings.EqualFold(name, metric) {
+			return Metric(metric), nil
+		}
+	}
+	return "", fmt.Errorf("invalid metric '%s', expected %s", name, strings.Join(Metrics, ", "))
+}
+
+// comparisonSize is the edge, in pixels, both images are scaled to before
+// they are compared. It matches the default thumbnail size, so stored
+// thumbnails are not scaled up.
+const comparisonSize = DefaultThumbnailSize
+
+// SSIM parameters from Wang et al. (2004): an 11x11 Gaussian window with
+// sigma 1.5 and the stabilizing constants for 8-bit images
+const (
+	ssimRadius = 5
+	ssimSigma  = 1.5
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// msssimWeights are the per-scale exponents of MS-SSIM from Wang et al.
+// (2003), finest scale first
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// plane is a grayscale image with one float per pixel
+type plane struct {
+	width, height int
+	pix           []float64
+}
+
+func (p plane) at(x, y int) float64 {
+	return p.pix[y*p.width+x]
+}
+
+// CompareImages scores how similar two images look, from 0 to 1. Both are
+// converted to grayscale and scaled to the same size first.
+func CompareImages(a, b gocv.Mat, metric Metric) (float64, error) {
+	planeA, err := comparisonPlane(a)
+	if err != nil {
+		return 0, err
+	}
+	planeB, err := comparisonPlane(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return comparePlanes(planeA, planeB, metric), nil
+}
+
+// comparePlanes measures the similarity of two comparison planes
+func comparePlanes(a, b plane, metric Metric) float64 {
+	switch metric {
+	case MetricAbsDiff:
+		return absDiffSimilarity(a, b)
+	case MetricMSSSIM:
+		return msssim(a, b)
+	default:
+		return ssim(a, b)
+	}
+}
+
+// comparisonPlane scales an image to the comparison size in grayscale
+func comparisonPlane(img gocv.Mat) (plane, error) {
+	if img.Empty() {
+		return plane{}, fmt.Errorf("cannot compare empty image")
+	}
+
+	gray := workMats.Get()
+	defer workMats.Put(gray)
+	if img.Channels() != 1 {
+		gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
+	} else {
+		img.CopyTo(&gray)
+	}
+
+	resized := workMats.Get()
+	defer workMats.Put(resized)
+	if err := gocv.Resize(gray, &resized, image.Point{X: comparisonSize, Y: comparisonSize}, 0, 0, gocv.InterpolationArea); err != nil {
+		return plane{}, fmt.Errorf("failed to resize image for comparison: %v", err)
+	}
+
+	// 16-bit images are scaled down so the SSIM constants apply
+	if resized.Type() != gocv.MatTypeCV8U {
+		converted := workMats.Get()
+		defer workMats.Put(converted)
+		resized.ConvertToWithParams(&converted, gocv.MatTypeCV8U, 1.0/256, 0)
+		resized = converted
+	}
+
+	data := resized.ToBytes()
+	p := plane{width: comparisonSize, height: comparisonSize, pix: make([]float64, len(data))}
+	for i, value := range data {
+		p.pix[i] = float64(value)
+	}
+	return p, nil
+}
+
+// absDiffSimilarity returns 1 minus the mean absolute difference of the pixels
+func absDiffSimilarity(a, b plane) float64 {
+	var sum float64
+	for i := range a.pix {
+		sum += math.Abs(a.pix[i] - b.pix[i])
+	}
+	return 1 - sum/float64(len(a.pix))/255
+}
+
+// ssim returns the mean structural similarity of two planes of the same
+// size
+func ssim(a, b plane) float64 {
+	similarity, _ := ssimComponents(a, b)
+	return similarity
+}
+
+// ssimComponents returns the mean SSIM of two planes of the same size and the
+// mean of its contrast-structure term alone. Local means, variances and
+// covariance are taken over a Gaussian window, and only windows that fit
+// inside the image are averaged.
+func ssimComponents(a, b plane) (float64, float64) {
+	product := func(x, y plane) plane {
+		p := plane{width: x.width, height: x.height, pix: make([]float64, len(x.pix))}
+		for i := range p.pix {
+			p.pix[i] = x.pix[i] * y.pix[i]
+		}
+		return p
+	}
+
+	kernel := gaussianKernel(ssimRadius, ssimSigma)
+	muA, muB := blur(a, kernel), blur(b, kernel)
+	sigmaAA, sigmaBB, sigmaAB := blur(product(a, a), kernel), blur(product(b, b), kernel), blur(product(a, b), kernel)
+
+	var sum, csSum float64
+	var count int
+	for y := ssimRadius; y < a.height-ssimRadius; y++ {
+		for x := ssimRadius; x < a.width-ssimRadius; x++ {
+			meanA, meanB := muA.at(x, y), muB.at(x, y)
+			varianceA := sigmaAA.at(x, y) - meanA*meanA
+			varianceB := sigmaBB.at(x, y) - meanB*meanB
+			covariance := sigmaAB.at(x, y) - meanA*meanB
+
+			luminance := (2*meanA*meanB + ssimC1) / (meanA*meanA + meanB*meanB + ssimC1)
+			cs := (2*covariance + ssimC2) / (varianceA + varianceB + ssimC2)
+			sum += luminance * cs
+			csSum += cs
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return sum / float64(count), csSum / float64(count)
+}
+
+// msssim returns the multi-scale SSIM of two planes of the same size. The
+// contrast-structure term is taken at each scale and the full SSIM at the
+// coarsest one, so detail lost when one image was much smaller weighs less
+// than in single-scale SSIM.
+func msssim(a, b plane) float64 {
+	result := 1.0
+	for scale, weight := range msssimWeights {
+		similarity, cs := ssimComponents(a, b)
+		if scale == len(msssimWeights)-1 {
+			cs = similarity
+		}
+		// Negative terms would make fractional powers undefined
+		result *= math.Pow(max(cs, 0), weight)
+
+		a, b = downsample(a), downsample(b)
+	}
+	return result
+}
+
+// downsample halves a plane by averaging 2x2 blocks
+func downsample(p plane) plane {
+	half := plane{width: p.width / 2, height: p.height / 2}
+	half.pix = make([]float64, half.width*half.height)
+	for y := 0; y < half.height; y++ {
+		for x := 0; x < half.width; x++ {
+			half.pix[y*half.width+x] = (p.at(2*x, 2*y) + p.at(2*x+1, 2*y) + p.at(2*x, 2*y+1) + p.at(2*x+1, 2*y+1)) / 4
+		}
+	}
+	return half
+}
+
+// gaussianKernel returns a normalized 1D Gaussian of the given radius
+func gaussianKernel(radius int, sigma float64) []float64 {
+	kernel := make([]float64, 2*radius+1)
+	var total float64
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		total += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= total
+	}
+	return kernel
+}
+
+// blur convolves a plane with a separable kernel, clamping at the edges
+func blur(p plane, kernel []float64) plane {
+	radius := len(kernel) / 2
+	clamp := func(v, limit int) int {
+		return min(max(v, 0), limit-1)
+	}
+
+	rows := plane{width: p.width, height: p.height, pix: make([]float64, len(p.pix))}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			var sum float64
+			for k, weight := range kernel {
+				sum += weight * p.at(clamp(x+k-radius, p.width), y)
+			}
+			rows.pix[y*p.width+x] = sum
+		}
+	}
+
+	result := plane{width: p.width, height: p.height, pix: make([]float64, len(p.pix))}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			var sum float64
+			for k, weight := range kernel {
+				sum += weight * rows.at(x, clamp(y+k-radius, p.height))
+			}
+			result.pix[y*p.width+x] = sum
+		}
+	}
+	return result
+}
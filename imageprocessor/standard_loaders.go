@@ -2,13 +2,14 @@ package imageprocessor
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"gocv.io/x/gocv"
 	"imagefinder/logging"
+	"imagefinder/utils"
 )
 
 // StandardImageLoader handles common image formats like JPEG, PNG, etc.
@@ -32,8 +33,8 @@ func NewStandardImageLoader() *StandardImageLoader {
 }
 
 // LoadImage loads a standard image format
-func (l *StandardImageLoader) LoadImage(path string) (gocv.Mat, error) {
-	return l.DefaultLoadImage(path)
+func (l *StandardImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	return l.DefaultLoadImage(ctx, path)
 }
 
 // TiffImageLoader specializes in TIFF format loading
@@ -51,13 +52,13 @@ func NewTiffImageLoader() *TiffImageLoader {
 }
 
 // LoadImage implements specialized loading for TIFF images
-func (l *TiffImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *TiffImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	// Standard OpenCV loading works for most TIFF files
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGrayAnyDepth(ctx, path)
 	if !img.Empty() {
 		return img, nil
 	}
-	
+
 	// If standard loading failed, could implement specialized TIFF processing here
 	// For now, just return the empty mat with an error
 	return img, newImageLoadError("failed to load TIFF image", path)
@@ -80,35 +81,41 @@ func NewSimpleRawImageLoader() *SimpleRawImageLoader {
 				FormatNEF,
 				FormatARW,
 				FormatDNG,
+				FormatORF,
+				FormatRW2,
+				FormatPEF,
 			},
 		},
 	}
 }
 
 // LoadImage provides a simple implementation for RAW image loading
-func (l *SimpleRawImageLoader) LoadImage(path string) (gocv.Mat, error) {
-	// Use a temporary file for the converted image
-	tempPath := filepath.Join(os.TempDir(), filepath.Base(path)+".jpg")
-	
+func (l *SimpleRawImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
+	// Use a temporary file for the converted image, removed even when a
+	// conversion fails or is interrupted halfway
+	tempPath := filepath.Join(utils.TempDir(), filepath.Base(path)+".jpg")
+	defer os.Remove(tempPath)
+
 	// Try multiple approaches for RAW conversion, starting with extraction of embedded preview
-	methods := []func(string, string) error{
-		tryExiftoolPreviewExtraction,   // Try extracting preview with exiftool first
-		tryDcrawConversionStandard,     // Standard dcraw conversion
-		tryDcrawConversionWithOptions,  // Try dcraw with different options
-		tryLibRawConversion,            // Try libraw-based conversion if available
+	methods := []func(context.Context, string, string) error{
+		tryExiftoolPreviewExtraction,  // Try extracting preview with exiftool first
+		tryDcrawConversionStandard,    // Standard dcraw conversion
+		tryDcrawConversionWithOptions, // Try dcraw with different options
+		tryLibRawConversion,           // Try libraw-based conversion if available
 	}
-	
+
 	// Try each method in order until one succeeds
 	for _, method := range methods {
-		err := method(path, tempPath)
+		if ctx.Err() != nil {
+			return gocv.NewMat(), ctx.Err()
+		}
+		err := method(ctx, path, tempPath)
 		if err == nil {
 			// Check if the file exists and has content
 			if hasFileContent(tempPath) {
 				// Try to load the converted image
-				img := gocv.IMRead(tempPath, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempPath)
 				if !img.Empty() {
-					// Clean up the temp file when done
-					defer os.Remove(tempPath)
 					return img, nil
 				}
 			}
@@ -117,87 +124,85 @@ func (l *SimpleRawImageLoader) LoadImage(path string) (gocv.Mat, error) {
 			logging.LogWarning("Method produced output file for %s but OpenCV couldn't read it, trying next method", path)
 		}
 	}
-	
+
 	// If all methods failed, try direct loading as a last resort
 	logging.LogWarning("All RAW conversion methods failed for %s, attempting direct load", path)
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGray(ctx, path)
 	if !img.Empty() {
 		return img, nil
 	}
-	
+
 	// If we get here, all methods failed
 	return gocv.NewMat(), newImageLoadError("failed to load RAW image after trying all methods", path)
 }
 
 // tryExiftoolPreviewExtraction tries to extract embedded preview image with exiftool
-func tryExiftoolPreviewExtraction(path, outputPath string) error {
+func tryExiftoolPreviewExtraction(ctx context.Context, path, outputPath string) error {
 	// Check if exiftool is available
-	_, err := exec.LookPath("exiftool")
-	if err != nil {
-		return fmt.Errorf("exiftool not available: %v", err)
+	if !hasExiftool() {
+		return fmt.Errorf("exiftool not available")
 	}
-	
+
 	// First try to extract the largest preview image
-	cmd := exec.Command("exiftool", "-b", "-LargestImagePreview", path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-LargestImagePreview", path)
 	outFile, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %v", err)
 	}
 	defer outFile.Close()
-	
+
 	cmd.Stdout = outFile
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	
+
 	err = cmd.Run()
 	if err != nil || !hasFileContent(outputPath) {
 		// If the largest preview extraction failed, try the standard preview
 		logging.LogWarning("Largest preview extraction failed for %s, trying standard preview", path)
-		cmd = exec.Command("exiftool", "-b", "-PreviewImage", path)
+		cmd = toolCommand(ctx, "exiftool", "-b", "-PreviewImage", path)
 		outFile, err = os.Create(outputPath)
 		if err != nil {
 			return fmt.Errorf("failed to create output file: %v", err)
 		}
 		defer outFile.Close()
-		
+
 		cmd.Stdout = outFile
 		cmd.Stderr = &stderr
-		
+
 		err = cmd.Run()
 		if err != nil || !hasFileContent(outputPath) {
 			// If standard preview failed, try thumbnail
 			logging.LogWarning("Standard preview extraction failed for %s, trying thumbnail", path)
-			cmd = exec.Command("exiftool", "-b", "-ThumbnailImage", path)
+			cmd = toolCommand(ctx, "exiftool", "-b", "-ThumbnailImage", path)
 			outFile, err = os.Create(outputPath)
 			if err != nil {
 				return fmt.Errorf("failed to create output file: %v", err)
 			}
 			defer outFile.Close()
-			
+
 			cmd.Stdout = outFile
 			cmd.Stderr = &stderr
-			
+
 			err = cmd.Run()
 			if err != nil || !hasFileContent(outputPath) {
 				return fmt.Errorf("all exiftool preview extraction methods failed: %v", err)
 			}
 		}
 	}
-	
+
 	return nil
 }
 
 // tryDcrawConversionStandard tries standard dcraw conversion
-func tryDcrawConversionStandard(path, outputPath string) error {
+func tryDcrawConversionStandard(ctx context.Context, path, outputPath string) error {
 	// Check if dcraw is available
-	_, err := exec.LookPath("dcraw")
-	if err != nil {
-		return fmt.Errorf("dcraw not available: %v", err)
+	if !hasDcraw() {
+		return fmt.Errorf("dcraw not available")
 	}
-	
+
 	// Use dcraw to convert the RAW file directly to a temp file
-	cmd := exec.Command("dcraw", "-c", "-b", "8", path)
-	
+	cmd := toolCommand(ctx, "dcraw", "-c", "-b", "8", path)
+
 	// Create the temporary file
 	tempFile, err := os.Create(outputPath)
 	if err != nil {
@@ -205,82 +210,80 @@ func tryDcrawConversionStandard(path, outputPath string) error {
 		return fmt.Errorf("failed to create temp file: %v", err)
 	}
 	defer tempFile.Close()
-	
+
 	// Redirect dcraw output to the temp file
 	cmd.Stdout = tempFile
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	
+
 	err = cmd.Run()
 	if err != nil {
 		logging.LogWarning("Standard dcraw conversion failed for %s: %v\nStderr: %s", path, err, stderr.String())
 		return err
 	}
-	
+
 	return nil
 }
 
 // tryDcrawConversionWithOptions tries dcraw with different options
-func tryDcrawConversionWithOptions(path, outputPath string) error {
+func tryDcrawConversionWithOptions(ctx context.Context, path, outputPath string) error {
 	// Check if dcraw is available
-	_, err := exec.LookPath("dcraw")
-	if err != nil {
-		return fmt.Errorf("dcraw not available: %v", err)
+	if !hasDcraw() {
+		return fmt.Errorf("dcraw not available")
 	}
-	
+
 	// Different sets of options to try
 	optionSets := [][]string{
-		{"-c", "-a", "-q", "0", path},               // Auto-brightness, low quality (faster)
-		{"-c", "-w", "-q", "0", path},               // Camera white balance, low quality
-		{"-c", "-w", "-a", "-q", "0", path},         // Camera WB + auto brightness, low quality
-		{"-c", "-h", path},                          // Half-size, faster
-		{"-c", "-o", "0", path},                     // Linear (no colorspace conversion)
-		{"-e", path},                                // Extract embedded thumbnail
+		{"-c", "-a", "-q", "0", path},       // Auto-brightness, low quality (faster)
+		{"-c", "-w", "-q", "0", path},       // Camera white balance, low quality
+		{"-c", "-w", "-a", "-q", "0", path}, // Camera WB + auto brightness, low quality
+		{"-c", "-h", path},                  // Half-size, faster
+		{"-c", "-o", "0", path},             // Linear (no colorspace conversion)
+		{"-e", path},                        // Extract embedded thumbnail
 	}
-	
+
 	// Try each set of options
 	for _, options := range optionSets {
-		cmd := exec.Command("dcraw", options...)
-		
+		cmd := toolCommand(ctx, "dcraw", options...)
+
 		// Create the output file
 		tempFile, err := os.Create(outputPath)
 		if err != nil {
 			logging.LogWarning("Failed to create output file: %v", err)
 			continue
 		}
-		
+
 		cmd.Stdout = tempFile
 		var stderr bytes.Buffer
 		cmd.Stderr = &stderr
-		
+
 		err = cmd.Run()
 		tempFile.Close()
-		
+
 		if err == nil && hasFileContent(outputPath) {
 			logging.LogInfo("Successfully converted RAW with options: %v", options)
 			return nil
 		}
-		
+
 		logging.LogWarning("dcraw with options %v failed: %v", options, err)
 	}
-	
+
 	return fmt.Errorf("all dcraw option sets failed")
 }
 
 // tryLibRawConversion tries to use libraw or other available tools
-func tryLibRawConversion(path, outputPath string) error {
+func tryLibRawConversion(ctx context.Context, path, outputPath string) error {
 	// Check for alternative RAW conversion tools
 	tools := map[string][]string{
-		"darktable-cli": {path, outputPath, "--width", "1024", "--height", "1024"},
+		"darktable-cli":   {path, outputPath, "--width", "1024", "--height", "1024"},
 		"rawtherapee-cli": {"-o", outputPath, "-c", path},
-		"ufraw-batch": {"--out-type=jpg", "--output=" + outputPath, path},
+		"ufraw-batch":     {"--out-type=jpg", "--output=" + outputPath, path},
 	}
-	
+
 	for tool, args := range tools {
-		_, err := exec.LookPath(tool)
-		if err == nil {
-			cmd := exec.Command(tool, args...)
-			err = cmd.Run()
+		if hasTool(tool) {
+			cmd := toolCommand(ctx, tool, args...)
+			err := cmd.Run()
 			if err == nil && hasFileContent(outputPath) {
 				logging.LogInfo("Successfully converted RAW with %s", tool)
 				return nil
@@ -288,13 +291,12 @@ func tryLibRawConversion(path, outputPath string) error {
 			logging.LogWarning("%s conversion failed: %v", tool, err)
 		}
 	}
-	
+
 	return fmt.Errorf("no alternative RAW conversion tools available or all failed")
 }
 
 // checkExiftoolCommandAvailable checks if exiftool command is available
 func checkExiftoolCommandAvailable() bool {
 	// Check if exiftool is available for specialized CR3 loading
-	_, err := exec.LookPath("exiftool")
-	return err == nil
-}
\ No newline at end of file
+	return hasExiftool()
+}
@@ -0,0 +1,72 @@
+package imageprocessor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strategy selects which stored hashes decide whether a candidate matches
+type Strategy string
+
+const (
+	// StrategyAHash scores candidates by their average hash only
+	StrategyAHash Strategy = "ahash"
+	// StrategyPHash scores candidates by their perceptual hash only
+	StrategyPHash Strategy = "phash"
+	// StrategyBoth weighs both hashes, favouring the perceptual hash
+	StrategyBoth Strategy = "both"
+	// StrategyAny scores candidates by whichever hash is closer
+	StrategyAny Strategy = "any"
+)
+
+// Strategies lists the accepted search strategies
+var Strategies = []string{string(StrategyAHash), string(StrategyPHash), string(StrategyBoth), string(StrategyAny)}
+
+// Weights of the two hashes under StrategyBoth. pHash is generally more
+// reliable, so it is weighted higher.
+const (
+	pHashWeight   = 0.7
+	avgHashWeight = 0.3
+)
+
+// ParseStrategy reads a strategy name, defaulting to StrategyBoth when empty
+func ParseStrategy(name string) (Strategy, error) {
+	if name == "" {
+		return StrategyBoth, nil
+	}
+	for _, strategy := range Strategies {
+		if strings.EqualFold(name, strategy) {
+			return Strategy(strategy), nil
+		}
+	}
+	return "", fmt.Errorf("invalid strategy '%s', expected %s", name, strings.Join(Strategies, ", "))
+}
+
+// combine returns the hash similarity of a candidate under the strategy
+func (s Strategy) combine(avgHashSimilarity, pHashSimilarity float64) float64 {
+	switch s {
+	case StrategyAHash:
+		return avgHashSimilarity
+	case StrategyPHash:
+		return pHashSimilarity
+	case StrategyAny:
+		return max(avgHashSimilarity, pHashSimilarity)
+	default:
+		return (pHashSimilarity * pHashWeight) + (avgHashSimilarity * avgHashWeight)
+	}
+}
+
+// distance returns the bits in which the hashes deciding a match under the
+// strategy differ from the query's
+func (s Strategy) distance(avgHashDistance, pHashDistance int) int {
+	switch s {
+	case StrategyAHash:
+		return avgHashDistance
+	case StrategyPHash:
+		return pHashDistance
+	case StrategyAny:
+		return min(avgHashDistance, pHashDistance)
+	default:
+		return max(avgHashDistance, pHashDistance)
+	}
+}
@@ -0,0 +1,71 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"imagefinder/types"
+
+	"gocv.io/x/gocv"
+)
+
+// DefaultThumbnailSize is the longest edge, in pixels, of generated thumbnails
+const DefaultThumbnailSize = 256
+
+// thumbnailJPEGQuality keeps thumbnails small while staying legible
+const thumbnailJPEGQuality = 80
+
+// GenerateThumbnail scales the image so its longest edge is at most maxSize
+// pixels and returns it encoded as JPEG. The thumbnail is built from the
+// already decoded Mat so RAW files are not converted a second time.
+func GenerateThumbnail(img gocv.Mat, maxSize int) ([]byte, error) {
+	if img.Empty() {
+		return nil, fmt.Errorf("cannot create thumbnail for empty image")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultThumbnailSize
+	}
+
+	width, height := img.Cols(), img.Rows()
+	thumb := workMats.Get()
+	defer workMats.Put(thumb)
+
+	if width > maxSize || height > maxSize {
+		// Preserve the aspect ratio, scaling the longest edge down to maxSize
+		scale := float64(maxSize) / float64(max(width, height))
+		size := image.Point{
+			X: max(1, int(float64(width)*scale)),
+			Y: max(1, int(float64(height)*scale)),
+		}
+		if err := gocv.Resize(img, &thumb, size, 0, 0, gocv.InterpolationArea); err != nil {
+			return nil, fmt.Errorf("failed to resize thumbnail: %v", err)
+		}
+	} else {
+		img.CopyTo(&thumb)
+	}
+
+	buf, err := gocv.IMEncodeWithParams(gocv.JPEGFileExt, thumb, []int{gocv.IMWriteJpegQuality, thumbnailJPEGQuality})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	defer buf.Close()
+
+	// The buffer's bytes live in C memory, so copy them before closing it
+	data := make([]byte, buf.Len())
+	copy(data, buf.GetBytes())
+
+	return data, nil
+}
+
+// ThumbnailFromFile loads the image at path, which may be inside an archive
+// or a document page, and returns its JPEG thumbnail. It is used for images
+// indexed without a stored thumbnail.
+func ThumbnailFromFile(ctx context.Context, path string, maxSize int, cache *PreviewCache) ([]byte, error) {
+	img, err := loadSearchImage(ctx, path, cache, types.RawModeAuto)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+	return GenerateThumbnail(img, maxSize)
+}
@@ -1,13 +1,14 @@
 package imageprocessor
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
 	"imagefinder/logging"
+	"imagefinder/utils"
 
 	"gocv.io/x/gocv"
 )
@@ -20,7 +21,7 @@ type EnhancedTiffImageLoader struct {
 
 // NewEnhancedTiffImageLoader creates a new enhanced loader for TIFF files
 func NewEnhancedTiffImageLoader() *EnhancedTiffImageLoader {
-	tempDir := os.TempDir()
+	tempDir := utils.TempDir()
 	return &EnhancedTiffImageLoader{
 		BaseImageLoader: BaseImageLoader{
 			SupportedFormats: []FormatType{FormatTIFF},
@@ -30,12 +31,12 @@ func NewEnhancedTiffImageLoader() *EnhancedTiffImageLoader {
 }
 
 // LoadImage loads a TIFF image with advanced methods
-func (l *EnhancedTiffImageLoader) LoadImage(path string) (gocv.Mat, error) {
+func (l *EnhancedTiffImageLoader) LoadImage(ctx context.Context, path string) (gocv.Mat, error) {
 	logging.LogInfo("Loading TIFF image with specialized loader: %s", path)
 
 	// First try direct loading with OpenCV
 	// This works for many standard TIFF files
-	img := gocv.IMRead(path, gocv.IMReadGrayScale)
+	img := readGrayAnyDepth(ctx, path)
 	if !img.Empty() {
 		logging.LogInfo("Successfully loaded TIFF using direct load: %s", path)
 		return img, nil
@@ -46,18 +47,18 @@ func (l *EnhancedTiffImageLoader) LoadImage(path string) (gocv.Mat, error) {
 	defer os.Remove(tempFilename) // Clean up temp file when done
 
 	// Try different methods for TIFF conversion in order of preference
-	methods := []func(string, string) error{
+	methods := []func(context.Context, string, string) error{
 		l.convertTiffWithImageMagick,
 		l.convertTiffWithVips,
 		l.convertTiffWithGdal,
 	}
 
 	for _, method := range methods {
-		err := method(path, tempFilename)
+		err := method(ctx, path, tempFilename)
 		if err == nil {
 			// Check if file exists and has content
 			if hasFileContent(tempFilename) {
-				img := gocv.IMRead(tempFilename, gocv.IMReadGrayScale)
+				img := readGray(ctx, tempFilename)
 				if !img.Empty() {
 					return img, nil
 				}
@@ -78,34 +79,31 @@ func (l *EnhancedTiffImageLoader) LoadImage(path string) (gocv.Mat, error) {
 // Check if file has content using the utility function
 
 // convertTiffWithImageMagick converts a TIFF file to JPEG using ImageMagick
-func (l *EnhancedTiffImageLoader) convertTiffWithImageMagick(path, outputPath string) error {
-	_, err := exec.LookPath("convert")
-	if err != nil {
+func (l *EnhancedTiffImageLoader) convertTiffWithImageMagick(ctx context.Context, path, outputPath string) error {
+	if !hasTool("convert") {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("convert", path, outputPath)
+	cmd := toolCommand(ctx, "convert", path, outputPath)
 	return cmd.Run()
 }
 
 // convertTiffWithVips converts a TIFF file to JPEG using libvips
-func (l *EnhancedTiffImageLoader) convertTiffWithVips(path, outputPath string) error {
-	_, err := exec.LookPath("vips")
-	if err != nil {
+func (l *EnhancedTiffImageLoader) convertTiffWithVips(ctx context.Context, path, outputPath string) error {
+	if !hasTool("vips") {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("vips", "copy", path, outputPath)
+	cmd := toolCommand(ctx, "vips", "copy", path, outputPath)
 	return cmd.Run()
 }
 
 // convertTiffWithGdal converts a TIFF file to JPEG using GDAL (good for geospatial TIFFs)
-func (l *EnhancedTiffImageLoader) convertTiffWithGdal(path, outputPath string) error {
-	_, err := exec.LookPath("gdal_translate")
-	if err != nil {
+func (l *EnhancedTiffImageLoader) convertTiffWithGdal(ctx context.Context, path, outputPath string) error {
+	if !hasTool("gdal_translate") {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("gdal_translate", "-of", "JPEG", "-co", "QUALITY=90", path, outputPath)
+	cmd := toolCommand(ctx, "gdal_translate", "-of", "JPEG", "-co", "QUALITY=90", path, outputPath)
 	return cmd.Run()
 }
\ No newline at end of file
@@ -0,0 +1,140 @@
+package imageprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"imagefinder/logging"
+
+	"gocv.io/x/gocv"
+)
+
+// ExternalTool is a program the loaders run for formats OpenCV can't decode
+type ExternalTool struct {
+	Name    string
+	Purpose string
+}
+
+// ExternalTools lists the programs the loaders look for in PATH, unless
+// DetectTools was given their binary, in the order they are tried for each
+// format
+var ExternalTools = []ExternalTool{
+	{"exiftool", "RAW previews and metadata"},
+	{"dcraw", "RAW conversion"},
+	{"rawtherapee-cli", "RAW conversion"},
+	{"heif-dec", "HEIC/HEIF and CR3 decoding"},
+	{"heif-convert", "HEIC/HEIF and CR3 decoding"},
+	{"sips", "HEIC/HEIF decoding (macOS)"},
+	{"djxl", "JPEG XL decoding"},
+	{"magick", "TIFF, HEIC/HEIF, PSD and SVG conversion, animated WebP frames"},
+	{"convert", "TIFF, HEIC/HEIF, PSD and SVG conversion, animated WebP frames (ImageMagick 6)"},
+	{"vips", "TIFF conversion"},
+	{"gdal_translate", "TIFF conversion"},
+	{"rsvg-convert", "SVG rasterization"},
+	{"pdftoppm", "PDF pages"},
+	{"pdfinfo", "PDF page count"},
+	{"mutool", "PDF pages"},
+	{"jpegtran", "Repairing extracted CR3 previews"},
+}
+
+// Path returns the binary used for the tool, or "" if it wasn't found
+func (t ExternalTool) Path() string {
+	return toolPath(t.Name)
+}
+
+// Where each external tool was found, resolved once by DetectTools. A tool
+// mapped to "" is missing, and the loader methods that run it are skipped.
+var (
+	toolsMu   sync.Mutex
+	toolPaths map[string]string
+)
+
+// DetectTools looks up the external tools once, before any file is loaded.
+// Tools in binaries are run from the given file instead of being searched
+// for in PATH. Later calls replace the earlier detection.
+func DetectTools(binaries map[string]string) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	detectTools(binaries)
+}
+
+// detectTools resolves every external tool; toolsMu must be held
+func detectTools(binaries map[string]string) {
+	toolPaths = make(map[string]string, len(ExternalTools))
+	var missing []string
+	for _, tool := range ExternalTools {
+		name := tool.Name
+		if binary := binaries[tool.Name]; binary != "" {
+			name = binary
+		}
+		path, err := exec.LookPath(name)
+		if err != nil {
+			missing = append(missing, tool.Name)
+			if name != tool.Name {
+				logging.LogWarning("Configured %s binary is unusable, %s is disabled: %v", tool.Name, tool.Purpose, err)
+			}
+		}
+		toolPaths[tool.Name] = path
+	}
+	if len(missing) > 0 {
+		logging.DebugLog("External tools not found, their loader methods are skipped: %s", strings.Join(missing, ", "))
+	}
+}
+
+// toolPath returns the binary of an external tool, or "" if it is missing.
+// Tools are detected on first use when DetectTools wasn't called.
+func toolPath(name string) string {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if toolPaths == nil {
+		detectTools(nil)
+	}
+	path, ok := toolPaths[name]
+	if !ok {
+		// Tools outside ExternalTools are only tried opportunistically
+		path, _ = exec.LookPath(name)
+		toolPaths[name] = path
+	}
+	return path
+}
+
+// toolsFingerprint is a short digest of which external tools were found,
+// which changes when a tool is installed or removed
+func toolsFingerprint() string {
+	hasher := sha256.New()
+	for _, tool := range ExternalTools {
+		if hasTool(tool.Name) {
+			io.WriteString(hasher, tool.Name+"\n")
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:8]
+}
+
+// hasTool reports whether an external tool was found
+func hasTool(name string) bool {
+	return toolPath(name) != ""
+}
+
+// toolCommand prepares to run an external tool from the binary detected
+// for it. Callers check hasTool first; a missing tool fails to start.
+func toolCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if path := toolPath(name); path != "" {
+		return exec.CommandContext(ctx, path, args...)
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// GoCVVersion returns the version of the gocv bindings
+func GoCVVersion() string {
+	return gocv.Version()
+}
+
+// OpenCVVersion returns the version of the OpenCV library linked in
+func OpenCVVersion() string {
+	return gocv.OpenCVVersion()
+}
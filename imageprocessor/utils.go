@@ -2,9 +2,9 @@ package imageprocessor
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"os"
-	"os/exec"
 
 	"imagefinder/logging"
 
@@ -15,14 +15,12 @@ import (
 
 // Check if exiftool is available on the system
 func hasExiftool() bool {
-	_, err := exec.LookPath("exiftool")
-	return err == nil
+	return hasTool("exiftool")
 }
 
 // Check if dcraw is available on the system
 func hasDcraw() bool {
-	_, err := exec.LookPath("dcraw")
-	return err == nil
+	return hasTool("dcraw")
 }
 
 // Try to load an image using Go's standard image packages
@@ -77,12 +75,12 @@ func hasFileContentLegacy(path string) bool {
 }
 
 // Extract preview image with exiftool
-func extractPreviewWithExiftool(path string, tempFilename string) error {
+func extractPreviewWithExiftool(ctx context.Context, path string, tempFilename string) error {
 	if !hasExiftool() {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("exiftool", "-b", "-PreviewImage", path)
+	cmd := toolCommand(ctx, "exiftool", "-b", "-PreviewImage", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
@@ -105,13 +103,42 @@ func extractPreviewWithExiftool(path string, tempFilename string) error {
 	return nil
 }
 
+// Extract the embedded full-size JPEG with exiftool
+func extractJpgFromRawWithExiftool(ctx context.Context, path string, tempFilename string) error {
+	if !hasExiftool() {
+		return os.ErrNotExist
+	}
+
+	cmd := toolCommand(ctx, "exiftool", "-b", "-JpgFromRaw", path)
+
+	outFile, err := os.Create(tempFilename)
+	if err != nil {
+		logging.LogWarning("Failed to create temp file for exiftool JpgFromRaw: %v", err)
+		return err
+	}
+	defer outFile.Close()
+
+	cmd.Stdout = outFile
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		logging.LogWarning("exiftool JpgFromRaw extraction failed: %v, stderr: %s", err, stderr.String())
+		return err
+	}
+
+	return nil
+}
+
 // Convert with dcraw using auto-brightness
-func convertWithDcrawAutoBright(path string, tempFilename string) error {
+func convertWithDcrawAutoBright(ctx context.Context, path string, tempFilename string) error {
 	if !hasDcraw() {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("dcraw", "-c", "-a", "-q", "3", path)
+	cmd := toolCommand(ctx, "dcraw", "-c", "-a", "-q", "3", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
@@ -135,12 +162,12 @@ func convertWithDcrawAutoBright(path string, tempFilename string) error {
 }
 
 // Convert with dcraw using camera white balance
-func convertWithDcrawCameraWB(path string, tempFilename string) error {
+func convertWithDcrawCameraWB(ctx context.Context, path string, tempFilename string) error {
 	if !hasDcraw() {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("dcraw", "-c", "-w", "-q", "3", path)
+	cmd := toolCommand(ctx, "dcraw", "-c", "-w", "-q", "3", path)
 
 	outFile, err := os.Create(tempFilename)
 	if err != nil {
@@ -164,18 +191,17 @@ func convertWithDcrawCameraWB(path string, tempFilename string) error {
 }
 
 // Convert with rawtherapee
-func convertWithRawtherapee(path string, tempFilename string) error {
-	_, err := exec.LookPath("rawtherapee-cli")
-	if err != nil {
+func convertWithRawtherapee(ctx context.Context, path string, tempFilename string) error {
+	if !hasTool("rawtherapee-cli") {
 		return os.ErrNotExist
 	}
 
-	cmd := exec.Command("rawtherapee-cli", "-o", tempFilename, "-c", path)
+	cmd := toolCommand(ctx, "rawtherapee-cli", "-o", tempFilename, "-c", path)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		logging.LogWarning("rawtherapee conversion failed: %v, stderr: %s", err, stderr.String())
 		return err
@@ -183,3 +209,35 @@ func convertWithRawtherapee(path string, tempFilename string) error {
 
 	return nil
 }
+
+// imageMagick returns the ImageMagick command installed, magick or convert
+// for ImageMagick 6, or "" when there is none
+func imageMagick() string {
+	for _, candidate := range []string{"magick", "convert"} {
+		if hasTool(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// Convert the first frame with ImageMagick (magick, or convert for ImageMagick 6)
+func convertWithImageMagick(ctx context.Context, path string, tempFilename string) error {
+	tool := imageMagick()
+	if tool == "" {
+		return os.ErrNotExist
+	}
+
+	cmd := toolCommand(ctx, tool, path+"[0]", tempFilename)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		logging.LogWarning("%s conversion failed: %v, stderr: %s", tool, err, stderr.String())
+		return err
+	}
+
+	return nil
+}
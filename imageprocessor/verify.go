@@ -0,0 +1,150 @@
+package imageprocessor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"imagefinder/database"
+	"imagefinder/logging"
+	"imagefinder/types"
+
+	"gocv.io/x/gocv"
+)
+
+// loadSearchImage loads a query or candidate image with the loader for its
+// format, decoding RAW files in rawMode, and turns it upright like scans do.
+// Images inside archives are extracted to a temporary file first.
+func loadSearchImage(ctx context.Context, path string, cache *PreviewCache, rawMode types.RawMode) (gocv.Mat, error) {
+	localPath, cleanup, err := LocalCopy(path)
+	if err != nil {
+		return gocv.NewMat(), fmt.Errorf("failed to extract %s: %v", path, err)
+	}
+	defer cleanup()
+
+	img, err := loadLocalSearchImage(ctx, localPath, path, cache, rawMode)
+	if err != nil {
+		return img, err
+	}
+	return OrientImage(img, localPath), nil
+}
+
+// loadLocalSearchImage loads the local copy of a search image at path
+func loadLocalSearchImage(ctx context.Context, localPath string, path string, cache *PreviewCache, rawMode types.RawMode) (gocv.Mat, error) {
+	switch {
+	case IsRawFormat(path):
+		var rawLoader ImageLoader = NewRawImageLoader()
+		if rawMode != types.RawModeAuto && rawMode != "" {
+			rawLoader = NewRawModeImageLoader(rawMode)
+		}
+		if cache != nil {
+			rawLoader = NewCachingImageLoader(rawLoader, cache)
+		}
+		if _, modal := rawLoader.(*RawModeImageLoader); modal {
+			// Fallbacks would decode another image of the file
+			return rawLoader.LoadImage(ctx, localPath)
+		}
+		return NewImageLoaderRegistry().loadWithFallbacks(ctx, localPath, rawLoader)
+	case isTifFormat(path):
+		return NewImageLoaderRegistry().loadWithFallbacks(ctx, localPath, NewTiffImageLoader())
+	default:
+		return LoadImage(ctx, localPath)
+	}
+}
+
+// verifyMatches compares each match with the query image using the search
+// metric and stores the result as the match's score. A match is compared with
+// its stored thumbnail when there is one, otherwise with the image file.
+// Matches whose image can't be read keep their hash score.
+func verifyMatches(ctx context.Context, db *sql.DB, queryImg gocv.Mat, matches []ImageMatch, options SearchOptions) error {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	// Mirrored matches are compared with the flipped query
+	var flipped gocv.Mat
+	if options.Mirror {
+		flipped = gocv.NewMat()
+		defer flipped.Close()
+		if err := gocv.Flip(queryImg, &flipped, 1); err != nil {
+			return fmt.Errorf("failed to mirror query image: %v", err)
+		}
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(matches)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				match := &matches[index]
+				query := queryImg
+				if match.Mirrored {
+					query = flipped
+				}
+
+				score, source, err := verifyMatch(ctx, db, query, *match, options)
+				if err != nil {
+					logging.LogWarning("Cannot verify %s, keeping its hash score: %v", match.Path, err)
+					continue
+				}
+				match.SSIMScore, match.Verified = score, true
+				if match.Explanation != nil {
+					match.Explanation.VerifiedWith = source
+				}
+			}
+		}()
+	}
+
+	for i := range matches {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// verifyMatch compares one match with the query image, reporting whether its
+// "thumbnail" or its "file" was compared
+func verifyMatch(ctx context.Context, db *sql.DB, query gocv.Mat, match ImageMatch, options SearchOptions) (float64, string, error) {
+	var candidate gocv.Mat
+	source := "thumbnail"
+
+	thumbnail, err := database.GetThumbnail(ctx, db, match.Path, match.SourcePrefix)
+	if err != nil {
+		return 0, "", err
+	}
+	if thumbnail != nil {
+		candidate, err = gocv.IMDecode(thumbnail, gocv.IMReadGrayScale)
+		if err != nil {
+			return 0, "", fmt.Errorf("cannot decode thumbnail: %v", err)
+		}
+	} else {
+		// Without a thumbnail the file has to be read, which only works for local files
+		if _, err := os.Stat(SourceFile(match.Path)); err != nil {
+			return 0, "", fmt.Errorf("no thumbnail stored and file not readable: %v", err)
+		}
+		candidate, err = loadSearchImage(ctx, match.Path, options.PreviewCache, options.RawMode)
+		if err != nil {
+			return 0, "", err
+		}
+		source = "file"
+	}
+	defer candidate.Close()
+
+	score, err := CompareImages(query, candidate, options.Metric)
+	return score, source, err
+}
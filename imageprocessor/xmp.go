@@ -0,0 +1,127 @@
+package imageprocessor
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"imagefinder/logging"
+)
+
+// XMP namespaces of the fields read from sidecars
+const (
+	xmpNamespace = "http://ns.adobe.com/xap/1.0/"
+	dcNamespace  = "http://purl.org/dc/elements/1.1/"
+	rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// Sidecar holds the fields read from an XMP sidecar file
+type Sidecar struct {
+	Rating   int      // 0 to 5 stars, -1 for rejected
+	Label    string   // Color label such as "Red"
+	Keywords []string // dc:subject entries
+}
+
+// SidecarPath returns the XMP sidecar next to an image, or an empty string
+// if there is none. Both photo.xmp (Lightroom, Capture One) and
+// photo.NEF.xmp (darktable, digiKam) are recognized.
+func SidecarPath(path string) string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, candidate := range []string{base + ".xmp", base + ".XMP", path + ".xmp", path + ".XMP"} {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// ReadSidecar reads the rating, label and keywords from the XMP sidecar of
+// an image. It reports false when the image has no readable sidecar.
+func ReadSidecar(path string) (Sidecar, bool) {
+	sidecarPath := SidecarPath(path)
+	if sidecarPath == "" {
+		return Sidecar{}, false
+	}
+
+	file, err := os.Open(sidecarPath)
+	if err != nil {
+		logging.LogWarning("Cannot read XMP sidecar %s: %v", sidecarPath, err)
+		return Sidecar{}, false
+	}
+	defer file.Close()
+
+	sidecar, err := parseXMP(file)
+	if err != nil {
+		logging.LogWarning("Invalid XMP sidecar %s: %v", sidecarPath, err)
+		return Sidecar{}, false
+	}
+	return sidecar, true
+}
+
+// parseXMP extracts the sidecar fields from an XMP packet. Writers store
+// simple properties either as attributes of rdf:Description or as child
+// elements, so both forms are accepted.
+func parseXMP(r io.Reader) (Sidecar, error) {
+	var sidecar Sidecar
+	decoder := xml.NewDecoder(r)
+
+	setProperty := func(name xml.Name, value string) {
+		if name.Space != xmpNamespace {
+			return
+		}
+		value = strings.TrimSpace(value)
+		switch name.Local {
+		case "Rating":
+			// Ratings are integers, but some tools write them as "3.0"
+			if rating, err := strconv.ParseFloat(value, 64); err == nil {
+				sidecar.Rating = int(rating)
+			}
+		case "Label":
+			sidecar.Label = value
+		}
+	}
+
+	inSubject := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return sidecar, nil
+		}
+		if err != nil {
+			return sidecar, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			for _, attr := range element.Attr {
+				setProperty(attr.Name, attr.Value)
+			}
+
+			switch {
+			case element.Name.Space == dcNamespace && element.Name.Local == "subject":
+				inSubject = true
+			case element.Name.Space == rdfNamespace && element.Name.Local == "li" && inSubject:
+				var keyword string
+				if err := decoder.DecodeElement(&keyword, &element); err != nil {
+					return sidecar, err
+				}
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					sidecar.Keywords = append(sidecar.Keywords, keyword)
+				}
+			case element.Name.Space == xmpNamespace:
+				var value string
+				if err := decoder.DecodeElement(&value, &element); err != nil {
+					return sidecar, err
+				}
+				setProperty(element.Name, value)
+			}
+		case xml.EndElement:
+			if element.Name.Space == dcNamespace && element.Name.Local == "subject" {
+				inSubject = false
+			}
+		}
+	}
+}
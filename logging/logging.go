@@ -1,20 +1,156 @@
 package logging
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Log levels, from most to least verbose
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+// levelNames maps the names accepted by SetLevel to log levels
+var levelNames = map[string]int{
+	"debug":   LevelDebug,
+	"info":    LevelInfo,
+	"warning": LevelWarning,
+	"warn":    LevelWarning,
+	"error":   LevelError,
+}
+
+// slogLevels maps log levels to slog levels
+var slogLevels = map[int]slog.Level{
+	LevelDebug:   slog.LevelDebug,
+	LevelInfo:    slog.LevelInfo,
+	LevelWarning: slog.LevelWarn,
+	LevelError:   slog.LevelError,
+}
+
+// Output formats accepted by SetFormat
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Console verbosity, set with SetVerbosity
+const (
+	VerbosityQuiet   = -1 // Only errors reach the console
+	VerbosityNormal  = 0  // Only info messages meant for the console, when there is no log file
+	VerbosityVerbose = 1  // Info, warnings and errors reach the console (-v)
+	VerbosityDebug   = 2  // Debug messages also reach the console (-vv)
+)
+
+// modulePrefix is the import path prefix stripped from package names when
+// deriving a record's module
+const modulePrefix = "imagefinder/"
+
 var (
-	debugLogger *log.Logger
-	logFile     *os.File
-	mu          sync.Mutex
-	isSetup     bool
+	level        = LevelDebug
+	moduleLevels map[string]int
+	outputFormat = FormatText
+	verbosity    = VerbosityNormal
+	fileLogger   *slog.Logger
+	logFile      *os.File
+	mu           sync.Mutex
+	isSetup      bool
 )
 
+// LevelSpec is a parsed log level setting: a default level and optional
+// overrides for modules, written as "info,scanner=debug,imageprocessor=error"
+type LevelSpec struct {
+	Default int
+	Modules map[string]int
+}
+
+// ParseLevelSpec parses a log level setting. Modules are package paths below
+// the imagefinder module ("scanner", "scanner/processor"); a module's level
+// also applies to the packages below it.
+func ParseLevelSpec(spec string) (LevelSpec, error) {
+	parsed := LevelSpec{Default: LevelInfo, Modules: make(map[string]int)}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		module, name, scoped := strings.Cut(part, "=")
+		if !scoped {
+			name = part
+		}
+		value, ok := levelNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return LevelSpec{}, fmt.Errorf("unknown log level: %s", name)
+		}
+
+		if scoped {
+			parsed.Modules[strings.Trim(strings.TrimSpace(module), "/")] = value
+		} else {
+			parsed.Default = value
+		}
+	}
+	return parsed, nil
+}
+
+// SetLevel sets the least severe level that is logged from a level setting
+// such as "warning" or "info,scanner=debug" (see ParseLevelSpec)
+func SetLevel(spec string) error {
+	parsed, err := ParseLevelSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	level = parsed.Default
+	moduleLevels = parsed.Modules
+	return nil
+}
+
+// SetFormat selects text (key=value) or JSON records. It must be called before
+// SetupLogger.
+func SetFormat(name string) error {
+	name = strings.ToLower(name)
+	if name != FormatText && name != FormatJSON {
+		return fmt.Errorf("unknown log format: %s (expected text or json)", name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	outputFormat = name
+	return nil
+}
+
+// SetVerbosity sets which records are shown on the console (standard error),
+// independently of the log file. Records below the log level are never shown.
+func SetVerbosity(v int) {
+	mu.Lock()
+	defer mu.Unlock()
+	verbosity = v
+}
+
+// newHandler creates a slog handler in the selected format
+func newHandler(w io.Writer) slog.Handler {
+	// Filtering happens in logRecord, where the module is known
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if outputFormat == FormatJSON {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
 // SetupLogger initializes the debug logger with the specified log file
 func SetupLogger(logFilePath string) error {
 	mu.Lock()
@@ -32,11 +168,10 @@ func SetupLogger(logFilePath string) error {
 		return fmt.Errorf("failed to open log file: %v", err)
 	}
 
-	// Create logger with timestamp prefix
-	debugLogger = log.New(logFile, "", log.LstdFlags)
+	fileLogger = slog.New(newHandler(logFile))
 
 	// Log startup information
-	debugLogger.Printf("--- ImageFinder Debug Log Started at %s ---\n", time.Now().Format(time.RFC3339))
+	fileLogger.Info("ImageFinder debug log started", "started_at", time.Now().Format(time.RFC3339))
 
 	isSetup = true
 	return nil
@@ -48,66 +183,132 @@ func CloseLogger() {
 	defer mu.Unlock()
 
 	if logFile != nil {
-		debugLogger.Printf("--- ImageFinder Debug Log Closed at %s ---\n", time.Now().Format(time.RFC3339))
+		fileLogger.Info("ImageFinder debug log closed", "closed_at", time.Now().Format(time.RFC3339))
 		logFile.Close()
 		logFile = nil
+		fileLogger = nil
 		isSetup = false
 	}
 }
 
-// LogInfo logs an information message (added to match usage in format_loader_implementations.go)
-func LogInfo(format string, args ...interface{}) {
+// callerModule returns the module of the function skip frames up the stack,
+// e.g. "scanner/processor" for code in imagefinder/scanner/processor
+func callerModule(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	function := runtime.FuncForPC(pc)
+	if function == nil {
+		return ""
+	}
+
+	// Function names look like "imagefinder/scanner.processAndStoreImage"
+	name := function.Name()
+	lastSlash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[lastSlash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	return strings.TrimPrefix(name[:lastSlash+1+dot], modulePrefix)
+}
+
+// levelFor returns the level configured for a module, using the most
+// specific module override that applies
+func levelFor(module string) int {
+	if len(moduleLevels) == 0 {
+		return level
+	}
+
+	var scopes []string
+	for scope := range moduleLevels {
+		if module == scope || strings.HasPrefix(module, scope+"/") {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return level
+	}
+	sort.Slice(scopes, func(i, j int) bool { return len(scopes[i]) > len(scopes[j]) })
+	return moduleLevels[scopes[0]]
+}
+
+// logRecord writes a record tagged with the calling module to the log file,
+// and to the console as allowed by the verbosity. At normal verbosity and
+// without a log file, only info messages marked for the console are shown, on
+// the standard logger's output.
+func logRecord(recordLevel int, module string, console bool, msg string, attrs ...any) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if debugLogger != nil {
-		debugLogger.Printf("INFO: "+format, args...)
-	} else {
+	if recordLevel < levelFor(module) {
+		return
+	}
+
+	attrs = append([]any{"module", module}, attrs...)
+	if fileLogger != nil {
+		fileLogger.Log(context.Background(), slogLevels[recordLevel], msg, attrs...)
+	}
+
+	switch {
+	case verbosity == VerbosityQuiet:
+		if recordLevel >= LevelError {
+			writeConsole(os.Stderr, recordLevel, msg, attrs)
+		}
+	case verbosity >= VerbosityVerbose:
+		if recordLevel >= LevelInfo || verbosity >= VerbosityDebug {
+			writeConsole(os.Stderr, recordLevel, msg, attrs)
+		}
+	case fileLogger == nil && console && recordLevel == LevelInfo:
 		// Fallback to standard output if logger is not set up
-		log.Printf("INFO: "+format, args...)
+		writeConsole(log.Writer(), recordLevel, msg, attrs)
 	}
 }
 
-// DebugLog logs a message if debug mode is enabled
-func DebugLog(format string, args ...interface{}) {
-	mu.Lock()
-	defer mu.Unlock()
+// consoleLabels names the levels in text console output
+var consoleLabels = map[int]string{
+	LevelDebug:   "DEBUG",
+	LevelInfo:    "INFO",
+	LevelWarning: "WARNING",
+	LevelError:   "ERROR",
+}
 
-	if debugLogger != nil {
-		debugLogger.Printf(format, args...)
+// writeConsole writes a record for the console, as "LEVEL: message" or as JSON
+func writeConsole(w io.Writer, recordLevel int, msg string, attrs []any) {
+	if outputFormat == FormatJSON {
+		slog.New(newHandler(w)).Log(context.Background(), slogLevels[recordLevel], msg, attrs...)
+		return
 	}
+	log.New(w, "", log.Flags()).Printf("%s: %s", consoleLabels[recordLevel], msg)
+}
+
+// LogInfo logs an information message
+func LogInfo(format string, args ...interface{}) {
+	logRecord(LevelInfo, callerModule(1), true, fmt.Sprintf(format, args...))
+}
+
+// DebugLog logs a message if debug mode is enabled
+func DebugLog(format string, args ...interface{}) {
+	logRecord(LevelDebug, callerModule(1), false, fmt.Sprintf(format, args...))
 }
 
 // LogError logs an error message
 func LogError(format string, args ...interface{}) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if debugLogger != nil {
-		debugLogger.Printf("ERROR: "+format, args...)
-	}
+	logRecord(LevelError, callerModule(1), false, fmt.Sprintf(format, args...))
 }
 
 // LogWarning logs a warning message
 func LogWarning(format string, args ...interface{}) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if debugLogger != nil {
-		debugLogger.Printf("WARNING: "+format, args...)
-	}
+	logRecord(LevelWarning, callerModule(1), false, fmt.Sprintf(format, args...))
 }
 
-// LogImageProcessed logs when an image is processed
+// LogImageProcessed logs when an image is processed, with the path and error
+// as separate fields so they can be filtered on
 func LogImageProcessed(path string, success bool, errMsg string) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if debugLogger != nil {
-		if success {
-			debugLogger.Printf("PROCESSED: %s", path)
-		} else {
-			debugLogger.Printf("FAILED: %s - Error: %s", path, errMsg)
-		}
+	module := callerModule(1)
+	if success {
+		logRecord(LevelInfo, module, false, "image processed", "path", path)
+	} else {
+		logRecord(LevelError, module, false, "image failed", "path", path, "error", errMsg)
 	}
 }
@@ -1,142 +1,166 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
+	"imagefinder/config"
 	"imagefinder/database"
 	"imagefinder/imageprocessor"
 	"imagefinder/logging"
-	"imagefinder/scanner"
+	"imagefinder/pkg/index"
+	"imagefinder/pkg/search"
 	"imagefinder/signalhandler"
+	"imagefinder/types"
 	"imagefinder/utils"
 )
 
+// quiet suppresses progress and summary output (--quiet)
+var quiet bool
+
+// statusf prints progress and summary messages unless --quiet was given.
+// Errors and the results a command was asked for are printed regardless.
+func statusf(format string, args ...interface{}) {
+	if !quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// fatalf prints an error like log.Fatalf, then exits with status 1 through
+// signalhandler.Exit, so temporary files are removed and profiles finished
+func fatalf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	signalhandler.Exit(1)
+}
+
 func main() {
-	// Set up proper signal handling
-	signalhandler.SetupHandler()
+	// Cancel running work on Ctrl-C or SIGTERM
+	ctx := signalhandler.SetupHandler()
 
 	// Set the optimal number of CPUs to use
 	runtime.GOMAXPROCS(signalhandler.GetOptimalProcs()) // <-- Change this function call
 
-	// Parse command line arguments into a map
-	args := utils.ParseArguments()
-
-	// Get the command (scan or search)
-	command, hasCommand := args["command"]
+	// Parse the command and its flags
+	cmd, positional, args := runCommandLine(newCommands(), os.Args[1:])
 
-	// Set default database path
-	dbPath := utils.GetDefaultDatabasePath()
-	if customDB, ok := args["database"]; ok && customDB != "" {
-		dbPath = customDB
-	} else if customDB, ok := args["db"]; ok && customDB != "" {
-		// Allow --db as an alias for --database
-		dbPath = customDB
+	// Resolve shared settings from flags, environment and config file
+	settings, err := config.Load(args)
+	if err != nil {
+		fatalf("Error loading configuration: %v", err)
+	}
+	settings.ApplyToolPaths()
+	if settings.TempDir != "" {
+		if err := utils.SetTempBase(settings.TempDir); err != nil {
+			fatalf("Error setting up the temp folder: %v", err)
+		}
+	}
+	// Temporary files are removed however the command ends, except on a
+	// crash; cache clean removes what crashed runs left
+	signalhandler.OnExit(utils.RemoveTempDir)
+	if err := logging.SetLevel(settings.LogLevel); err != nil {
+		fatalf("Error setting log level: %v", err)
+	}
+	if err := logging.SetFormat(settings.LogFormat); err != nil {
+		fatalf("Error setting log format: %v", err)
+	}
+	quiet = settings.Quiet
+	if quiet {
+		logging.SetVerbosity(logging.VerbosityQuiet)
+	} else {
+		logging.SetVerbosity(settings.Verbose)
 	}
 
 	// Setup debug logging if enabled
-	debugMode := false
-	if _, ok := args["debug"]; ok {
-		debugMode = true
-		logPath := "imagefinder.log"
-		if customLogPath, ok := args["logfile"]; ok && customLogPath != "" {
-			logPath = customLogPath
-		}
+	if settings.Debug {
+		logPath := settings.LogFile
 		if err := logging.SetupLogger(logPath); err != nil {
 			fmt.Printf("Warning: Failed to setup logging: %v\n", err)
 		} else {
-			fmt.Printf("Debug mode enabled. Logging to: %s\n", logPath)
+			statusf("Debug mode enabled. Logging to: %s\n", logPath)
 		}
 	}
-
-	// Check if required arguments are missing
-	showUsage := !hasCommand
-
-	if hasCommand && command == "scan" && args["folder"] == "" {
-		showUsage = true
+	if settings.ConfigFile != "" {
+		logging.DebugLog("Loaded configuration from %s", settings.ConfigFile)
 	}
+	imageprocessor.DetectTools(settings.ToolBinaries())
+	imageprocessor.SetDNGMinPreview(settings.DNGMinPreview)
+	stopProfiling := startProfiling(settings)
 
-	if hasCommand && command == "search" && args["image"] == "" {
-		showUsage = true
-	}
-
-	// Show usage if required arguments are missing
-	if showUsage {
-		utils.PrintUsage()
-		os.Exit(1)
-	}
+	cmd.run(ctx, positional, settings)
+	stopProfiling()
+	utils.RemoveTempDir()
+}
 
-	switch command {
-	case "scan":
-		handleScanCommand(args, dbPath, debugMode)
-	case "search":
-		handleSearchCommand(args, dbPath, debugMode)
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		utils.PrintUsage()
-		os.Exit(1)
+// exitIfInterrupted closes db, prints message and exits with the conventional
+// status for SIGINT when err was caused by cancelling ctx
+func exitIfInterrupted(ctx context.Context, err error, db *sql.DB, message string) {
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// os.Exit skips deferred calls, so close the database here
+		db.Close()
+		fmt.Fprintln(os.Stderr, message)
+		signalhandler.Exit(130)
 	}
 }
 
-func handleScanCommand(args map[string]string, dbPath string, debugMode bool) {
-	// Setup proper signal handling
-	signalhandler.SetupHandler()
+func handleScanCommand(ctx context.Context, flags *scanFlags, settings *config.Settings) {
+	dbPath := settings.Database
 
 	// Set optimal GOMAXPROCS
 	runtime.GOMAXPROCS(signalhandler.GetOptimalProcs())
 
-	// Get folder path with validation
-	folderPath, hasFolder := args["folder"]
-	if !hasFolder {
-		fmt.Println("Error: Missing folder path (use --folder=PATH)")
-		os.Exit(1)
+	folderPath := flags.folder
+	sourcePrefix := flags.prefix
+	forceRewrite := flags.force
+
+	// --resume continues the last interrupted scan with its settings
+	if flags.resume {
+		session := lastIncompleteSession(ctx, dbPath)
+		folderPath, sourcePrefix, forceRewrite = session.Folder, session.Prefix, session.Force
 	}
 
-	// Verify folder path exists and is accessible
-	folderInfo, err := os.Stat(folderPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Fatalf("Folder path does not exist: %s", folderPath)
-		} else {
-			log.Fatalf("Cannot access folder path: %s (%v)", folderPath, err)
+	// Listed files are scanned from the folder holding them all
+	var files []string
+	if flags.fromFile != "" {
+		var err error
+		files, err = readScanList(flags.fromFile)
+		if err != nil {
+			fatalf("Error reading the list of files: %v", err)
 		}
-	}
-	if !folderInfo.IsDir() {
-		log.Fatalf("Path is not a directory: %s", folderPath)
+		if len(files) == 0 {
+			fatalf("Error: no files to scan in %s", flags.fromFile)
+		}
+		folderPath = commonFolder(files)
+		statusf("Scanning %d listed files in %s\n", len(files), folderPath)
 	}
 
-	// Get source prefix (with empty default)
-	sourcePrefix := ""
-	if prefix, ok := args["prefix"]; ok {
-		sourcePrefix = prefix
-	}
+	// Report how the scan ends to the webhook, if one is configured
+	webhook := newScanWebhook(settings.Webhook, folderPath, sourcePrefix, dbPath)
 
-	// Get force rewrite flag
-	forceRewrite := false
-	if _, ok := args["force"]; ok {
-		forceRewrite = true
-	}
+	minSize, maxSize := parseSizeRange(flags.minSize, flags.maxSize)
 
 	// Get log file path if provided
-	logPath := ""
-	if path, ok := args["logfile"]; ok {
-		logPath = path
+	if settings.IsSet(config.KeyLogFile) {
+		logPath := settings.LogFile
 		// Set up file-based logging if logfile is specified
 		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err != nil {
-			log.Fatalf("Failed to open log file: %v", err)
+			fatalf("Failed to open log file: %v", err)
 		}
 		defer logFile.Close()
 
 		// Use MultiWriter to write logs to both stdout and file
-		if debugMode {
+		if settings.Debug {
 			log.SetOutput(io.MultiWriter(os.Stdout, logFile))
 		} else {
 			log.SetOutput(logFile)
@@ -147,10 +171,11 @@ func handleScanCommand(args map[string]string, dbPath string, debugMode bool) {
 	startTime := time.Now()
 
 	// Initialize database with retry logic
-	var db *sql.DB
+	var indexer *index.Indexer
+	var err error
 	const maxRetries = 3
 	for i := 0; i < maxRetries; i++ {
-		db, err = database.InitDatabase(dbPath)
+		indexer, err = index.Open(dbPath)
 		if err == nil {
 			break
 		}
@@ -160,166 +185,634 @@ func handleScanCommand(args map[string]string, dbPath string, debugMode bool) {
 				i+1, maxRetries, err)
 			time.Sleep(time.Second * time.Duration(i+1))
 		} else {
-			log.Fatalf("Error initializing database after %d attempts: %v", maxRetries, err)
+			webhook.send(ctx, err)
+			fatalf("Error initializing database after %d attempts: %v", maxRetries, err)
 		}
 	}
-	defer db.Close()
+	defer indexer.Close()
 
-	// Count total image files for progress tracking
-	var totalImages int
-	var rawCount, tifCount int
-	err = filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files that can't be accessed
-		}
-		if !info.IsDir() {
-			ext := strings.ToLower(filepath.Ext(path))
-			if scanner.IsImageFile(ext) {
-				totalImages++
-				if scanner.IsRawFormat(ext) {
-					rawCount++
-				} else if scanner.IsTiffFormat(ext) {
-					tifCount++
-				}
-			}
-		}
-		return nil
+	if minSize > 0 || maxSize > 0 {
+		statusf("File size: %s\n", formatSizeRange(flags.minSize, flags.maxSize))
+	}
+	if flags.minDimension > 0 {
+		statusf("Minimum dimension: %d px\n", flags.minDimension)
+	}
+
+	previewCacheDir := ""
+	if cache := openPreviewCache(flags.cacheDir); cache != nil {
+		previewCacheDir = cache.Dir
+	}
+
+	result, err := indexer.Scan(ctx, folderPath, index.ScanOptions{
+		Prefix:      sourcePrefix,
+		Force:       forceRewrite,
+		Incremental: flags.incremental,
+		Reconvert:   flags.reconvert,
+
+		Workers:    settings.Workers,
+		MaxMemory:  settings.MaxMemory,
+		RawWorkers: settings.RawWorkers,
+
+		IORetries:   settings.IORetries,
+		IOSkipAfter: settings.IOSkipAfter,
+
+		Include:        settings.Include,
+		Exclude:        settings.Exclude,
+		IncludeHidden:  flags.includeHidden,
+		MinSize:        minSize,
+		MaxSize:        maxSize,
+		MaxDepth:       flags.maxDepth,
+		MinDimension:   flags.minDimension,
+		FollowSymlinks: flags.followSymlinks,
+		Archives:       flags.archives,
+		SVG:            flags.svg,
+		Files:          files,
+
+		Thumbnails:    flags.thumbnails,
+		ThumbnailSize: flags.thumbnailSize,
+		Colors:        flags.colors,
+		Faces:         flags.faces.set,
+		FaceModel:     flags.faces.value,
+
+		PreviewCacheDir: previewCacheDir,
+		RawMode:         string(settings.RawMode),
+
+		Debug:      settings.Debug,
+		Verbose:    !quiet,
+		OnProgress: webhook.onProgress,
 	})
 	if err != nil {
-		log.Printf("Warning: Could not count all files: %v", err)
-	}
-
-	fmt.Printf("Starting image indexing...\n")
-	fmt.Printf("Total image files to process: %d (including %d RAW files and %d TIF files)\n",
-		totalImages, rawCount, tifCount)
-	fmt.Printf("Force rewrite mode: %v\n", forceRewrite)
-	fmt.Printf("Source prefix: %s\n", sourcePrefix)
-	fmt.Printf("Debug mode: %s\n", map[bool]string{true: "enabled", false: "disabled"}[debugMode])
-
-	// Create scan options with all parameters
-	scanOptions := scanner.ScanOptions{
-		FolderPath:   folderPath,
-		SourcePrefix: sourcePrefix,
-		ForceRewrite: forceRewrite,
-		DebugMode:    debugMode,
-		DbPath:       dbPath,
-		LogPath:      logPath,
-		TotalImages:  totalImages,
-		MaxWorkers:   signalhandler.GetOptimalProcs(),
-	}
-
-	// Run scanner with graceful shutdown handling
-	errChan := make(chan error, 1)
-	doneChan := make(chan bool, 1)
-
-	go func() {
-		err := scanner.ScanAndStoreFolder(db, scanOptions)
-		if err != nil {
-			errChan <- err
-		} else {
-			doneChan <- true
-		}
-	}()
-
-	// Wait for completion or error
-	select {
-	case err := <-errChan:
-		log.Fatalf("Error scanning folder: %v", err)
-	case <-doneChan:
-		// Print execution time
-		duration := time.Since(startTime)
-		fmt.Printf("\nScan completed successfully!\n")
-		fmt.Printf("Total execution time: %v\n", duration)
-		fmt.Printf("Database: %s\n", dbPath)
-
-		// Print summary statistics if available
-		stats, err := database.GetScanStats(db, sourcePrefix)
-		if err == nil && stats != nil {
-			fmt.Printf("\nSummary:\n")
-			fmt.Printf("- Total images processed: %d\n", stats.TotalImages)
-			fmt.Printf("- Total errors: %d\n", stats.ErrorCount)
-			fmt.Printf("- Unique image hashes: %d\n", stats.UniqueHashes)
+		webhook.send(ctx, err)
+		resumeHint := fmt.Sprintf("Run '%s scan --resume' to continue where it stopped.", os.Args[0])
+		if files != nil {
+			resumeHint = "Scan the list again to index the rest; unchanged files are skipped."
 		}
+		exitIfInterrupted(ctx, err, indexer.DB(), "Scan interrupted. "+resumeHint)
+		fatalf("Error scanning folder: %v", err)
+	}
+	webhook.send(ctx, nil)
+
+	// Print execution time
+	duration := time.Since(startTime)
+	statusf("\nScan completed successfully!\n")
+	statusf("Total execution time: %v\n", duration)
+	statusf("Database: %s\n", dbPath)
+	if flags.incremental {
+		statusf("Unchanged folders skipped: %d\n", result.SkippedFolders)
+	}
+
+	// Print summary statistics if available
+	stats, err := indexer.Stats(ctx, sourcePrefix)
+	if err == nil {
+		statusf("\nSummary:\n")
+		statusf("- Total images processed: %d\n", stats.Images)
+		statusf("- Total errors: %d\n", stats.Errors)
+		statusf("- Unique image hashes: %d\n", stats.UniqueHashes)
+	}
+}
+
+// lastIncompleteSession returns the scan session to continue with --resume,
+// exiting if there is none
+func lastIncompleteSession(ctx context.Context, dbPath string) *index.Session {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	indexer, err := index.Open(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer indexer.Close()
+
+	session, err := indexer.InterruptedScan(ctx)
+	if err != nil {
+		fatalf("Error reading scan sessions: %v", err)
 	}
+	if session == nil {
+		fatalf("No interrupted scan to resume in %s", dbPath)
+	}
+	return session
 }
-func handleSearchCommand(args map[string]string, dbPath string, debugMode bool) {
-	// Get query image path
-	queryPath, hasQuery := args["image"]
-	if !hasQuery {
-		fmt.Println("Error: Missing query image path (use --image=PATH)")
-		os.Exit(1)
-	}
-
-	// Set custom threshold if provided
-	threshold := 0.8 // Default threshold
-	if thresholdStr, ok := args["threshold"]; ok {
-		parsedThreshold, err := utils.ParseThreshold(thresholdStr)
+
+func handleSearchCommand(ctx context.Context, flags *searchFlags, hasLocation bool, settings *config.Settings) {
+	dbPath := settings.Database
+
+	// Only the rendered matches go to stdout, for pipelines
+	if flags.tmpl != nil {
+		quiet = true
+	}
+
+	queryPaths := searchQueryPaths(flags)
+
+	// --prefix may be repeated or list several prefixes separated by commas
+	var sourcePrefixes []string
+	for _, value := range flags.prefixes {
+		for _, prefix := range strings.Split(value, ",") {
+			prefix = strings.TrimSpace(prefix)
+			if prefix != "" && !slices.Contains(sourcePrefixes, prefix) {
+				sourcePrefixes = append(sourcePrefixes, prefix)
+			}
+		}
+	}
+
+	// Get optional geographic constraint
+	var location *search.Location
+	if hasLocation {
+		lat, lon, err := utils.ParseLocation(flags.near)
 		if err != nil {
-			fmt.Printf("Warning: %v\n", err)
-		} else {
-			threshold = parsedThreshold
+			fatalf("Error: %v", err)
+		}
+		if flags.radius <= 0 {
+			fatalf("Error: invalid radius '%v', expected a positive number of kilometers", flags.radius)
 		}
+
+		location = &search.Location{Latitude: lat, Longitude: lon, RadiusKm: flags.radius}
+	}
+
+	// Get optional capture date range
+	var after, before time.Time
+	if flags.after != "" {
+		parsed, err := utils.ParseDateBound(flags.after, false)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		after = parsed
+	}
+	if flags.before != "" {
+		parsed, err := utils.ParseDateBound(flags.before, true)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		before = parsed
+	}
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		fatalf("Error: --after must be earlier than --before")
 	}
 
-	// Get source prefix for filtering
-	var sourcePrefix string
-	if prefix, ok := args["prefix"]; ok {
-		sourcePrefix = prefix
+	// Get optional camera model filter
+	camera := strings.TrimSpace(flags.camera)
+
+	if flags.colorDiff <= 0 {
+		fatalf("Error: --color-tolerance must be positive")
 	}
 
+	// Get optional file size range
+	minSize, maxSize := parseSizeRange(flags.minSize, flags.maxSize)
+
 	// Verify paths exist
-	if _, err := os.Stat(queryPath); os.IsNotExist(err) {
-		log.Fatalf("Query image does not exist: %s", queryPath)
+	for _, queryPath := range queryPaths {
+		if _, err := os.Stat(imageprocessor.SourceFile(queryPath)); os.IsNotExist(err) {
+			fatalf("Query image does not exist: %s", queryPath)
+		}
 	}
 
 	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		log.Fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
 	}
 
 	startTime := time.Now()
 
-	// Open database
-	db, err := database.OpenDatabase(dbPath)
+	// Open database, bringing the schema of older databases up to date
+	db, err := database.InitDatabase(dbPath)
 	if err != nil {
-		log.Fatalf("Error opening database: %v", err)
+		fatalf("Error opening database: %v", err)
 	}
 	defer db.Close()
+	searcher := search.New(db)
+
+	statusf("Searching for similar images...\n")
+	if !strings.EqualFold(flags.preset, string(imageprocessor.PresetNormal)) {
+		statusf("Using the %s preset\n", strings.ToLower(flags.preset))
+	}
+	if len(sourcePrefixes) > 0 {
+		statusf("Filtering by source prefix: %s\n", strings.Join(sourcePrefixes, ", "))
+	}
+	if !after.IsZero() || !before.IsZero() {
+		statusf("Filtering by capture date: from %s, before %s\n", formatDateBound(after), formatDateBound(before))
+	}
+	if camera != "" {
+		statusf("Filtering by camera: %s\n", camera)
+	}
+	if minSize > 0 || maxSize > 0 {
+		statusf("Filtering by file size: %s\n", formatSizeRange(flags.minSize, flags.maxSize))
+	}
+	if flags.color != "" {
+		statusf("Filtering by dominant color: within %.1f of %s\n", flags.colorDiff, flags.color)
+	}
+	if location != nil {
+		statusf("Filtering by location: within %.1f km of %.5f,%.5f\n",
+			location.RadiusKm, location.Latitude, location.Longitude)
+	}
+
+	collection := strings.TrimSpace(flags.scope)
+	if collection != "" {
+		statusf("Searching collection: %s\n", collection)
+	}
+
+	previewCacheDir := ""
+	if cache := openPreviewCache(flags.cacheDir); cache != nil {
+		previewCacheDir = cache.Dir
+	}
 
-	fmt.Println("Searching for similar images...")
-	if sourcePrefix != "" {
-		fmt.Printf("Filtering by source prefix: %s\n", sourcePrefix)
+	// A threshold given anywhere overrides that of the preset
+	threshold := settings.Threshold
+	if !settings.IsSet(config.KeyThreshold) {
+		threshold = 0
 	}
 
-	// Find similar images
-	searchOptions := imageprocessor.SearchOptions{
-		QueryPath:    queryPath,
-		Threshold:    threshold,
-		SourcePrefix: sourcePrefix,
-		DebugMode:    debugMode,
+	// Find similar images, comparing candidates on one worker per usable CPU
+	// unless configured
+	searchOptions := search.Options{
+		Threshold:       threshold,
+		Preset:          flags.preset,
+		Prefixes:        sourcePrefixes,
+		Near:            location,
+		After:           after,
+		Before:          before,
+		Camera:          camera,
+		MinRating:       flags.minRating,
+		MinFaces:        flags.minFaces,
+		Color:           flags.color,
+		ColorTolerance:  flags.colorDiff,
+		MinSize:         minSize,
+		MaxSize:         maxSize,
+		Label:           strings.TrimSpace(flags.label),
+		Keyword:         strings.TrimSpace(flags.keyword),
+		Tag:             strings.TrimSpace(flags.tag),
+		Collection:      collection,
+		Strategy:        flags.strategy,
+		Metric:          flags.metric,
+		Mirror:          flags.mirror,
+		HashOnly:        flags.noSSIM,
+		Explain:         flags.explain,
+		Prefilter:       flags.prefilter,
+		Workers:         settings.Workers,
+		PreviewCacheDir: previewCacheDir,
+		RawMode:         string(settings.RawMode),
+		Debug:           settings.Debug,
 	}
 
-	matches, err := imageprocessor.FindSimilarImages(db, searchOptions)
+	// Several queries share the candidates, which are read once
+	if len(queryPaths) > 1 {
+		failed := searchBatch(ctx, db, searcher, queryPaths, searchOptions, flags)
+		statusf("\nTotal search time: %v\n", time.Since(startTime))
+		if failed > 0 {
+			db.Close()
+			signalhandler.Exit(1)
+		}
+		return
+	}
+
+	// A query given by its hashes replaces the query image
+	byHash := flags.phash != "" || flags.ahash != ""
+	var matches []search.Match
+	if byHash {
+		matches, err = searcher.SimilarToHashes(ctx, search.Hashes{Perceptual: flags.phash, Average: flags.ahash}, searchOptions)
+	} else {
+		matches, err = searcher.Similar(ctx, queryPaths[0], searchOptions)
+	}
 	if err != nil {
-		log.Fatalf("Error finding similar images: %v", err)
+		exitIfInterrupted(ctx, err, db, "Search interrupted")
+		fatalf("Error finding similar images: %v", err)
+	}
+
+	if flags.tmpl != nil {
+		query := ""
+		if !byHash {
+			query = queryPaths[0]
+		}
+		printMatchTemplate(flags.tmpl, matches, query, flags.offset, flags.limit)
+	} else {
+		printMatches(ctx, db, matches, byHash, flags.offset, flags.limit)
+	}
+
+	if saveTo := strings.TrimSpace(flags.saveTo); saveTo != "" {
+		images := make([]database.CollectionImage, len(matches))
+		for i, match := range matches {
+			images[i] = database.CollectionImage{Path: match.Path, SourcePrefix: match.SourcePrefix}
+		}
+		saveCollection(ctx, db, saveTo, images)
+	}
+	if flags.copyTo != "" {
+		materializeMatches(matches, flags.copyTo, false)
+	}
+	if flags.linkTo != "" {
+		materializeMatches(matches, flags.linkTo, true)
+	}
+	if flags.openCount > 0 {
+		openMatches(matches, flags.openCount)
 	}
 
-	// Print top matches
-	fmt.Println("\nTop Matches:")
-	limit := 5 // Show top 5 matches
+	// Print execution time
+	duration := time.Since(startTime)
+	statusf("\nTotal search time: %v\n", duration)
+
+	if flags.assert && !hasMatchReaching(matches, flags.minScore) {
+		fmt.Fprintf(os.Stderr, "Assertion failed: no match with a score of at least %.2f\n", flags.minScore)
+		db.Close()
+		signalhandler.Exit(1)
+	}
+}
+
+// hasMatchReaching reports whether a match has a score of at least minScore:
+// its verification score, or its hash score when it wasn't verified
+func hasMatchReaching(matches []search.Match, minScore float64) bool {
+	for _, match := range matches {
+		if match.SSIMScore >= minScore {
+			return true
+		}
+	}
+	return false
+}
+
+// printMatches prints a page of the matches of a search: limit matches
+// after the first offset, or all the rest when limit is 0. Matches found by
+// hash have no SSIM score.
+func printMatches(ctx context.Context, db *sql.DB, matches []search.Match, byHash bool, offset, limit int) {
+	if offset == 0 {
+		fmt.Println("\nTop Matches:")
+	} else {
+		fmt.Println("\nMatches:")
+	}
 
 	if len(matches) == 0 {
 		fmt.Println("No matches found.")
+		return
+	}
+	page := utils.Page(matches, offset, limit)
+	if len(page) == 0 {
+		fmt.Printf("No matches after the first %d; there are %d.\n", offset, len(matches))
+		return
+	}
+	for i, match := range page {
+		fmt.Printf("%d. Image: %s\n", offset+i+1, match.Path)
+		if archive, _, ok := imageprocessor.SplitArchivePath(match.Path); ok {
+			fmt.Printf("   Archive: %s\n", archive)
+		}
+		if match.SourcePrefix != "" {
+			fmt.Printf("   Source: %s\n", match.SourcePrefix)
+		}
+		if match.Verified {
+			fmt.Printf("   SSIM Score: %.4f\n", match.SSIMScore)
+		} else if !byHash {
+			fmt.Printf("   SSIM Score: unavailable, image not readable\n")
+		}
+		fmt.Printf("   Hash Score: %.4f\n", match.HashScore)
+		if profile, bitDepth, err := database.GetColorInfo(ctx, db, match.Path, match.SourcePrefix); err != nil {
+			logging.LogWarning("%v", err)
+		} else if color := describeColor(profile, bitDepth); color != "" {
+			fmt.Printf("   Color: %s\n", color)
+		}
+		if match.Mirrored {
+			fmt.Printf("   Mirrored: yes\n")
+		}
+		if match.Explanation != nil {
+			printExplanation(match)
+		}
+	}
+	if len(page) < len(matches) {
+		fmt.Printf("\nShowing matches %d to %d of %d\n", offset+1, offset+len(page), len(matches))
+	}
+}
+
+// printExplanation prints the signals behind the scores of a match
+func printExplanation(match search.Match) {
+	explanation := match.Explanation
+	fmt.Printf("   Average Hash Distance: %s\n", describeHashDistance(explanation.AverageHashDistance))
+	fmt.Printf("   Perceptual Hash Distance: %s\n", describeHashDistance(explanation.PerceptualHashDistance))
+	fmt.Printf("   Filename Boost: %+.4f\n", explanation.FilenameBoost)
+	if match.Verified {
+		fmt.Printf("   Verified With: %s\n", explanation.VerifiedWith)
 	} else {
-		for i := 0; i < limit && i < len(matches); i++ {
-			fmt.Printf("%d. Image: %s\n", i+1, matches[i].Path)
-			if matches[i].SourcePrefix != "" {
-				fmt.Printf("   Source: %s\n", matches[i].SourcePrefix)
-			}
-			fmt.Printf("   SSIM Score: %.4f\n", matches[i].SSIMScore)
+		fmt.Printf("   Verified With: nothing, ranked by hash score\n")
+	}
+	if explanation.Loader != "" {
+		fmt.Printf("   Loader: %s\n", explanation.Loader)
+	}
+}
+
+// describeHashDistance renders the Hamming distance of two 64-bit hashes
+func describeHashDistance(distance int) string {
+	if distance < 0 {
+		return "unknown, not in the query"
+	}
+	return fmt.Sprintf("%d of 64 bits", distance)
+}
+
+// describeColor renders a color profile and bit depth, leaving out what is
+// unknown
+func describeColor(profile string, bitDepth int) string {
+	var parts []string
+	if profile != "" {
+		parts = append(parts, profile)
+	}
+	if bitDepth > 0 {
+		parts = append(parts, fmt.Sprintf("%d-bit", bitDepth))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatDateBound renders an optional date filter bound for display
+func formatDateBound(t time.Time) string {
+	if t.IsZero() {
+		return "any"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseSizeRange reads the --min-size and --max-size flags, where an empty
+// value is no limit
+func parseSizeRange(minFlag string, maxFlag string) (int64, int64) {
+	var minSize, maxSize int64
+	var err error
+	if minFlag != "" {
+		if minSize, err = utils.ParseByteSize(minFlag); err != nil {
+			fatalf("Error: --min-size: %v", err)
+		}
+	}
+	if maxFlag != "" {
+		if maxSize, err = utils.ParseByteSize(maxFlag); err != nil {
+			fatalf("Error: --max-size: %v", err)
+		}
+		if maxSize == 0 {
+			fatalf("Error: --max-size must be larger than 0")
 		}
 	}
+	if maxSize > 0 && minSize > maxSize {
+		fatalf("Error: --min-size must not be larger than --max-size")
+	}
+	return minSize, maxSize
+}
 
-	// Print execution time
-	duration := time.Since(startTime)
-	fmt.Printf("\nTotal search time: %v\n", duration)
+// formatSizeRange renders the --min-size and --max-size flags for display
+func formatSizeRange(minFlag string, maxFlag string) string {
+	switch {
+	case maxFlag == "":
+		return "at least " + minFlag
+	case minFlag == "":
+		return "at most " + maxFlag
+	default:
+		return minFlag + " to " + maxFlag
+	}
+}
+
+func handleExportCommand(ctx context.Context, flags *exportFlags, dbPath string) {
+	outputPath := flags.output
+	sourcePrefix := flags.prefix
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// Write to stdout when requested so exports can be piped
+	var out io.Writer = os.Stdout
+	if outputPath != "-" {
+		outFile, err := os.Create(outputPath)
+		if err != nil {
+			fatalf("Cannot create output file: %v", err)
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+
+	writer := bufio.NewWriter(out)
+	encoder := json.NewEncoder(writer)
+
+	count := 0
+	err = database.ForEachImage(ctx, db, sourcePrefix, func(info types.ImageInfo) error {
+		info.IsRawFormat = imageprocessor.IsRawFormat(info.Path)
+		count++
+		return encoder.Encode(info)
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Export interrupted")
+		fatalf("Error exporting index: %v", err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		fatalf("Error writing export: %v", err)
+	}
+
+	if outputPath != "-" {
+		statusf("Exported %d images to %s\n", count, outputPath)
+	}
+}
+
+func handleImportCommand(ctx context.Context, flags *importFlags, dbPath string) {
+	inputPath := flags.input
+	forceRewrite := flags.force
+
+	var in io.Reader = os.Stdin
+	if inputPath != "-" {
+		inFile, err := os.Open(inputPath)
+		if err != nil {
+			fatalf("Cannot open input file: %v", err)
+		}
+		defer inFile.Close()
+		in = inFile
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+
+	// Records can be large once more metadata is exported, so raise the line limit
+	lineScanner := bufio.NewScanner(in)
+	lineScanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	// An interruption stops the import between records, so the record being
+	// written is always stored completely
+	storeCtx := context.WithoutCancel(ctx)
+
+	imported, failed, lineNum := 0, 0, 0
+	for ctx.Err() == nil && lineScanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(lineScanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var info types.ImageInfo
+		if err := json.Unmarshal([]byte(line), &info); err != nil {
+			log.Printf("Skipping line %d: invalid JSON: %v", lineNum, err)
+			failed++
+			continue
+		}
+
+		if info.Path == "" {
+			log.Printf("Skipping line %d: missing path", lineNum)
+			failed++
+			continue
+		}
+
+		if err := database.ImportImageInfo(storeCtx, db, info, forceRewrite); err != nil {
+			log.Printf("Skipping line %d: %v", lineNum, err)
+			failed++
+			continue
+		}
+		imported++
+	}
+
+	if err := lineScanner.Err(); err != nil {
+		fatalf("Error reading import file: %v", err)
+	}
+	if ctx.Err() != nil {
+		db.Close()
+		fmt.Fprintf(os.Stderr, "Import interrupted after %d images (%d skipped)\n", imported, failed)
+		signalhandler.Exit(130)
+	}
+
+	statusf("Imported %d images into %s (%d skipped)\n", imported, dbPath, failed)
+}
+
+// openPreviewCache returns the RAW preview cache selected with --cache-dir, or
+// nil when caching was not requested. A bare --cache-dir uses the default location.
+func openPreviewCache(cacheDir optionalFlag) *imageprocessor.PreviewCache {
+	if !cacheDir.set {
+		return nil
+	}
+
+	cache, err := imageprocessor.NewPreviewCache(cacheDir.value)
+	if err != nil {
+		log.Printf("Warning: preview cache disabled: %v", err)
+		return nil
+	}
+	statusf("Using preview cache: %s\n", cache.Dir)
+	return cache
+}
+
+func handleCacheCommand(action string, flags *cacheFlags) {
+	dir := flags.cacheDir.value
+	if dir == "" {
+		dir = imageprocessor.DefaultPreviewCacheDir()
+	}
+	cache := &imageprocessor.PreviewCache{Dir: dir}
+
+	switch action {
+	case "clean":
+		files, bytes, err := cache.Clean()
+		if err != nil {
+			fatalf("Error cleaning preview cache: %v", err)
+		}
+		statusf("Removed %d cached previews (%.1f MB) from %s\n", files, float64(bytes)/(1024*1024), dir)
+
+		// Workspaces of runs that crashed or were killed
+		tempBase := utils.TempBase()
+		folders, bytes, err := utils.CleanStaleTempDirs(tempBase)
+		if err != nil {
+			fatalf("Error removing leftover temporary files: %v", err)
+		}
+		statusf("Removed %d leftover temp folders (%.1f MB) from %s\n", folders, float64(bytes)/(1024*1024), tempBase)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cache action: %s (expected clean)\n", action)
+		signalhandler.Exit(2)
+	}
 }
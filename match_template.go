@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"imagefinder/pkg/search"
+	"imagefinder/utils"
+)
+
+// searchFormats lists the accepted values of search --format
+var searchFormats = []string{"text", "template"}
+
+// templateEscapes turns the escapes a shell leaves in --template into the
+// characters they stand for
+var templateEscapes = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\\`, `\`)
+
+// templateMatch is what --template renders for each match: the fields of
+// search.Match, such as .Path and .SSIMScore, with its rank and query
+type templateMatch struct {
+	search.Match
+	Rank  int    // Position in the results, from 1
+	Query string // Query image the match was found for; empty when searching by hash
+}
+
+// parseMatchTemplate parses the text of --template. Rendering it for an
+// empty match reports misspelled fields before the search runs.
+func parseMatchTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("match").Parse(templateEscapes.Replace(text))
+	if err != nil {
+		return nil, err
+	}
+	sample := templateMatch{Match: search.Match{Explanation: &search.Explanation{}}}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// printMatchTemplate renders tmpl for each match of the page selected by
+// offset and limit, ending every match with a newline unless the template
+// does
+func printMatchTemplate(tmpl *template.Template, matches []search.Match, query string, offset, limit int) {
+	writer := bufio.NewWriter(os.Stdout)
+	for i, match := range utils.Page(matches, offset, limit) {
+		var line strings.Builder
+		if err := tmpl.Execute(&line, templateMatch{Match: match, Rank: offset + i + 1, Query: query}); err != nil {
+			writer.Flush()
+			fmt.Fprintf(os.Stderr, "Error: cannot render %s with --template: %v\n", match.Path, err)
+			continue
+		}
+		text := line.String()
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		writer.WriteString(text)
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing matches: %v\n", err)
+	}
+}
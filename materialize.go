@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/imageprocessor"
+	"imagefinder/pkg/search"
+)
+
+// materializeMatches copies or symlinks the matched files into dir, named by
+// rank and score so the folder sorts like the results, e.g.
+// 001_0.9734_IMG_0412.CR2. Existing files in dir are left alone.
+func materializeMatches(matches []search.Match, dir string, link bool) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot create %s: %v\n", dir, err)
+		return
+	}
+
+	verb := "Copied"
+	if link {
+		verb = "Linked"
+	}
+	width := max(3, len(fmt.Sprint(len(matches))))
+	done := 0
+	for i, match := range matches {
+		// PDF pages and animation frames are materialized as their file
+		source := imageprocessor.SourceFile(match.Path)
+		_, _, inArchive := imageprocessor.SplitArchivePath(match.Path)
+		if strings.Contains(match.Path, "://") {
+			fmt.Fprintf(os.Stderr, "Skipped %s: not a local file\n", match.Path)
+			continue
+		}
+		if inArchive && link {
+			fmt.Fprintf(os.Stderr, "Skipped %s: files inside archives can't be linked\n", match.Path)
+			continue
+		}
+
+		name := filepath.Base(source)
+		if inArchive {
+			_, entry, _ := imageprocessor.SplitArchivePath(match.Path)
+			name = filepath.Base(filepath.FromSlash(entry))
+		}
+		target := filepath.Join(dir, fmt.Sprintf("%0*d_%.4f_%s", width, i+1, match.SSIMScore, name))
+		if _, err := os.Lstat(target); err == nil {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %s already exists\n", match.Path, target)
+			continue
+		}
+
+		if err := materializeMatch(match.Path, source, target, link); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot materialize %s: %v\n", match.Path, err)
+			continue
+		}
+		done++
+	}
+	statusf("%s %d of %d matches to %s\n", verb, done, len(matches), dir)
+}
+
+// materializeMatch copies or links one matched file to target. Images inside
+// archives are extracted first.
+func materializeMatch(path string, source string, target string, link bool) error {
+	if link {
+		if _, err := os.Stat(source); err != nil {
+			return err
+		}
+		absolute, err := filepath.Abs(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(absolute, target)
+	}
+
+	localPath, cleanup, err := imageprocessor.LocalCopy(path)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if localPath == path {
+		localPath = source
+	}
+	if err := copyFile(localPath, target); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"imagefinder/config"
+)
+
+// mcpProtocolVersions lists the Model Context Protocol versions the mcp
+// command speaks, latest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// mcpTool describes a tool offered to MCP clients
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+// mcpTools are the operations on the index offered as tools. Their
+// arguments are the parameters of the rpc methods of the same name.
+var mcpTools = []mcpTool{
+	{
+		Name: "search",
+		Description: "Find images in the local image index that look like an image file, such as the original RAW " +
+			"file or full-size photo a screenshot, crop, resized copy or edited export was made from. Returns the " +
+			"matches, best first, with their path and a similarity score from 0 to 1, and the total number of matches.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"image": {"type": "string", "description": "Path of the query image on this machine"},
+				"threshold": {"type": "number", "minimum": 0, "maximum": 1, "description": "Smallest similarity of a match (default: the configured threshold, or that of the preset)"},
+				"preset": {"type": "string", "enum": ["strict", "normal", "loose"], "description": "Tolerances of matches: strict for near copies, loose for edited copies and RAW files matched with JPEGs"},
+				"prefixes": {"type": "array", "items": {"type": "string"}, "description": "Only search images with one of these source prefixes"},
+				"strategy": {"type": "string", "enum": ["ahash", "phash", "both", "any"], "description": "Hashes that decide a match"},
+				"metric": {"type": "string", "enum": ["ssim", "ms-ssim", "absdiff"], "description": "How matches are verified against the query"},
+				"mirror": {"type": "boolean", "description": "Also find mirror images of the query"},
+				"hash_only": {"type": "boolean", "description": "Rank matches by hash score without reading the matched images"},
+				"prefilter": {"type": "boolean", "description": "Only compare images sharing a pHash band with the query (faster, may miss weak matches)"},
+				"explain": {"type": "boolean", "description": "Add the hash distances, filename boost and loader behind each score"},
+				"offset": {"type": "integer", "minimum": 0, "description": "Number of best matches to skip, to page through the results"},
+				"limit": {"type": "integer", "minimum": 0, "description": "Largest number of matches returned (default: all)"}
+			},
+			"required": ["image"]
+		}`),
+	},
+	{
+		Name: "compare",
+		Description: "Compare two image files on this machine, indexed or not, by their perceptual hashes and " +
+			"structural similarity. Scores range from 0 (unrelated) to 1 (identical).",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"a": {"type": "string", "description": "Path of the first image"},
+				"b": {"type": "string", "description": "Path of the second image"},
+				"strategy": {"type": "string", "enum": ["ahash", "phash", "both", "any"], "description": "How the hash similarities are combined"},
+				"metric": {"type": "string", "enum": ["ssim", "ms-ssim", "absdiff"], "description": "How the images are compared"}
+			},
+			"required": ["a", "b"]
+		}`),
+	},
+	{
+		Name: "stats",
+		Description: "Count the images in the local image index by format and source prefix, with their total " +
+			"size, RAW+JPEG pairs, hash collisions and files that failed to index.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"prefix": {"type": "string", "description": "Only count images with this source prefix"}
+			}
+		}`),
+	},
+}
+
+// mcpToolCall are the parameters of a tools/call request
+type mcpToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// mcpContent is a block of the result of a tool
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of a tools/call request. Failures of the tool
+// are reported in the result, so the model sees them, rather than as errors
+// of the request.
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// handleMCPCommand serves the search, compare and stats tools over the Model
+// Context Protocol on stdin and stdout, until stdin is closed or the command
+// is interrupted
+func handleMCPCommand(ctx context.Context, flags *rpcFlags, settings *config.Settings) {
+	session := openRPCSession(ctx, flags, settings)
+	defer session.db.Close()
+	answerLines(ctx, session.db, session.handleMCP)
+}
+
+// handleMCP answers one MCP message, reporting false for notifications
+func (s *rpcSession) handleMCP(ctx context.Context, line []byte) (rpcResponse, bool) {
+	request, failure := parseRPCRequest(line)
+	if failure != nil {
+		return rpcFailure(request.ID, failure), len(request.ID) > 0 || failure.Code == rpcParseError
+	}
+
+	var result any
+	var err error
+	switch {
+	case request.Method == "initialize":
+		result, err = mcpInitialize(request.Params)
+	case request.Method == "ping":
+		result = struct{}{}
+	case request.Method == "tools/list":
+		result = map[string][]mcpTool{"tools": mcpTools}
+	case request.Method == "tools/call":
+		result, err = s.callTool(ctx, request.Params)
+	case strings.HasPrefix(request.Method, "notifications/"):
+		// Such as notifications/initialized and notifications/cancelled,
+		// which need nothing done since requests are answered in turn
+	default:
+		err = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method '%s'", request.Method)}
+	}
+	return rpcReply(request, result, err)
+}
+
+// mcpInitialize answers the handshake, agreeing to the protocol version of
+// the client when it is supported and offering the latest one otherwise
+func mcpInitialize(raw json.RawMessage) (any, error) {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, invalidParams("invalid params: %v", err)
+		}
+	}
+	protocolVersion := mcpProtocolVersions[0]
+	if slices.Contains(mcpProtocolVersions, params.ProtocolVersion) {
+		protocolVersion = params.ProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{"tools": map[string]any{}},
+		"serverInfo":      map[string]string{"name": "imagefinder", "version": currentBuildInfo().Version},
+		"instructions": "Searches a local index of photos built with imagefinder scan. Paths are paths on this " +
+			"machine. Use search to find the indexed originals of an image, compare to check two files, and " +
+			"stats to see what the index holds.",
+	}, nil
+}
+
+// callTool runs a tool, reporting its failures in the result
+func (s *rpcSession) callTool(ctx context.Context, raw json.RawMessage) (any, error) {
+	var call mcpToolCall
+	if err := json.Unmarshal(raw, &call); err != nil || call.Name == "" {
+		return nil, invalidParams("expected the name and arguments of a tool")
+	}
+	method, ok := s.methods()[call.Name]
+	if !ok {
+		return nil, invalidParams("unknown tool '%s'", call.Name)
+	}
+
+	result, err := method(ctx, call.Arguments)
+	if err != nil {
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return nil, err
+		}
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+	text, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(text)}}}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"imagefinder/imageprocessor"
+	"imagefinder/pkg/search"
+)
+
+// openMatches opens the top count matches with the default viewer of the
+// platform. Remote images and images inside archives have no file of their
+// own to open and are left out.
+func openMatches(matches []search.Match, count int) {
+	opened := 0
+	for _, match := range matches {
+		if opened == count {
+			break
+		}
+		// PDF pages and animation frames are opened as their file
+		source := imageprocessor.SourceFile(match.Path)
+		if _, _, inArchive := imageprocessor.SplitArchivePath(match.Path); inArchive {
+			fmt.Fprintf(os.Stderr, "Skipped %s: files inside archives can't be opened\n", match.Path)
+			continue
+		}
+		if strings.Contains(match.Path, "://") {
+			fmt.Fprintf(os.Stderr, "Skipped %s: not a local file\n", match.Path)
+			continue
+		}
+		if err := openFile(source); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot open %s: %v\n", source, err)
+			return
+		}
+		opened++
+	}
+	if opened > 0 {
+		statusf("Opened %d of %d matches\n", opened, len(matches))
+	}
+}
+
+// openFile hands path to the default viewer without waiting for it to close
+func openFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", absolute)
+	case "windows":
+		// Unlike cmd /c start, this passes the path without a shell
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", absolute)
+	default:
+		cmd = exec.Command("xdg-open", absolute)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,308 @@
+// Package index builds an imagefinder index: a SQLite database holding the
+// hashes and metadata of the images in scanned folders, which package search
+// queries.
+//
+//	indexer, err := index.Open("images.db")
+//	if err != nil {
+//		return err
+//	}
+//	defer indexer.Close()
+//	result, err := indexer.Scan(ctx, "/photos", index.ScanOptions{Prefix: "nas"})
+package index
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/scanner"
+	"imagefinder/signalhandler"
+	"imagefinder/source"
+	"imagefinder/types"
+)
+
+// DefaultThumbnailSize is the longest thumbnail edge, in pixels, used when
+// ScanOptions.ThumbnailSize is 0
+const DefaultThumbnailSize = imageprocessor.DefaultThumbnailSize
+
+// Indexer adds the images of folders to an index database. Its methods may
+// be called from several goroutines.
+type Indexer struct {
+	db *sql.DB
+}
+
+// Open opens the index database at path, creating it if it doesn't exist and
+// bringing the schema of older databases up to date
+func Open(path string) (*Indexer, error) {
+	db, err := database.InitDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Indexer{db: db}, nil
+}
+
+// Close closes the index database
+func (ix *Indexer) Close() error {
+	return ix.db.Close()
+}
+
+// DB returns the index database, e.g. to search it with search.New while
+// scanning
+func (ix *Indexer) DB() *sql.DB {
+	return ix.db
+}
+
+// ScanOptions are the options of a scan. The zero value indexes every
+// supported image of the folder, quietly, with a worker count adjusted
+// during the scan.
+type ScanOptions struct {
+	Prefix      string // Source prefix the images are indexed under, e.g. the name of a removable drive
+	Force       bool   // Hash images again even if they haven't changed
+	Incremental bool   // Skip the files of local folders unchanged since the last incremental scan
+	Reconvert   bool   // Decode every file again instead of reusing the hashes cached for the same content
+
+	Workers    int   // Images processed at once; 0 adjusts the count to the CPU and I/O load
+	MaxMemory  int64 // Largest estimated memory, in bytes, of the images decoded at once; 0 sets no limit
+	RawWorkers int   // RAW files converted at once; 0 sets no limit besides Workers
+
+	// IORetries is how often a local file failing with an I/O error is read
+	// again. Once IOSkipAfter files in a row failed with I/O errors, as on a
+	// dead network mount, the next ones are recorded as failed without being
+	// read; 0 never skips them.
+	IORetries   int
+	IOSkipAfter int
+
+	Include        []string // File name or relative path patterns to index; empty indexes all files
+	Exclude        []string // File name or relative path patterns to skip
+	IncludeHidden  bool     // Also index dotfiles and the metadata and recycle bin folders of NAS systems
+	MinSize        int64    // Smallest file size in bytes to index
+	MaxSize        int64    // Largest file size in bytes to index, 0 for no limit
+	MinDimension   int      // Smallest width and height in pixels of indexed images, checked once decoded
+	MaxDepth       int      // Folder levels scanned, counting the folder itself as 1; 0 scans every level
+	FollowSymlinks bool     // Descend into symlinked folders
+	Archives       bool     // Index images inside ZIP and TAR archives
+	SVG            bool     // Index SVG files, rasterized at a fixed size
+
+	// Files, if set, are the local files indexed instead of walking the
+	// folder, which must hold them all. The include, exclude and size filters
+	// still apply. Such scans aren't recorded as sessions to resume, since
+	// scanning the folder wouldn't continue them.
+	Files []string
+
+	Thumbnails    bool   // Store a JPEG thumbnail of each image
+	ThumbnailSize int    // Longest thumbnail edge in pixels; 0 uses DefaultThumbnailSize
+	Colors        bool   // Store the dominant colors of each image
+	Faces         bool   // Count the faces in each image
+	FaceModel     string // Face model file; empty uses the frontal face cascade of OpenCV
+
+	PreviewCacheDir string // Folder caching converted RAW previews; empty caches nothing
+	RawMode         string // Image of RAW files hashed: "preview", "embedded-large", "full-decode" or "auto" (empty, the first that succeeds)
+
+	Debug   bool // Log the processing of each file
+	Verbose bool // Print the progress line and the scan summary to standard output
+
+	// OnProgress is called with the running totals after each file is
+	// processed. Calls come from a single goroutine, one at a time.
+	OnProgress func(Progress)
+}
+
+// Progress is the state of a scan after a file was processed
+type Progress struct {
+	Path      string
+	Error     error // Why Path couldn't be indexed, or nil
+	Processed int
+	Errors    int
+	Total     int // Files to process; grows while the files are still being counted
+}
+
+// ScanResult describes a finished or interrupted scan
+type ScanResult struct {
+	SessionID      int64 // Scan session recorded in the history, 0 for scans of listed files
+	Resumed        bool  // The scan continued an interrupted scan of the folder
+	Processed      int
+	Errors         int
+	Total          int
+	SkippedFolders int // Unchanged folders skipped by an incremental scan
+}
+
+// Session is a scan recorded in the index
+type Session struct {
+	ID        int64
+	Folder    string
+	Prefix    string
+	Force     bool
+	StartedAt string // RFC 3339
+}
+
+// Scan indexes the images of folder, a local path or a URL of a supported
+// remote source such as s3://bucket/prefix. A scan of a folder whose last
+// scan was interrupted continues it.
+//
+// Cancelling ctx interrupts the scan: images already hashed are stored and
+// Scan returns the result so far with ctx.Err(). The rest are indexed by the
+// next scan of the folder.
+func (ix *Indexer) Scan(ctx context.Context, folder string, options ScanOptions) (*ScanResult, error) {
+	src, err := source.Open(folder)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open scan source: %v", err)
+	}
+	if options.Incremental && !src.IsLocal() {
+		return nil, fmt.Errorf("incremental scans need a local folder, %s has no folder modification times", folder)
+	}
+	if options.Files != nil && (options.Incremental || !src.IsLocal()) {
+		return nil, fmt.Errorf("only local files can be listed, and listed files are scanned in full")
+	}
+	if options.Incremental && options.Force {
+		return nil, fmt.Errorf("a forced scan rehashes every file, which an incremental scan would skip")
+	}
+	if options.MaxDepth < 0 {
+		return nil, fmt.Errorf("invalid max depth: %d", options.MaxDepth)
+	}
+	rawMode, err := types.ParseRawMode(options.RawMode)
+	if err != nil {
+		return nil, err
+	}
+	thumbnailSize := options.ThumbnailSize
+	if thumbnailSize == 0 {
+		thumbnailSize = DefaultThumbnailSize
+	}
+	if thumbnailSize < 0 {
+		return nil, fmt.Errorf("invalid thumbnail size: %d", thumbnailSize)
+	}
+
+	// Without a count, start with one worker per usable CPU and adjust
+	workers := options.Workers
+	adaptiveWorkers := workers == 0
+	if adaptiveWorkers {
+		workers = signalhandler.GetOptimalProcs()
+	}
+
+	// Load the face model before scanning so a missing model is reported early
+	var faceDetector *imageprocessor.FaceDetector
+	if options.Faces {
+		faceDetector, err = imageprocessor.NewFaceDetector(options.FaceModel, workers)
+		if err != nil {
+			return nil, fmt.Errorf("cannot enable face detection: %v", err)
+		}
+		defer faceDetector.Close()
+	}
+
+	var previewCache *imageprocessor.PreviewCache
+	if options.PreviewCacheDir != "" {
+		if previewCache, err = imageprocessor.NewPreviewCache(options.PreviewCacheDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Record the scan so it can be resumed if interrupted
+	session, resumed := &database.ScanSession{}, false
+	if options.Files == nil {
+		session, resumed, err = database.StartScanSession(ctx, ix.db, folder, options.Prefix, options.Force)
+		if err != nil {
+			return nil, fmt.Errorf("cannot start scan session: %v", err)
+		}
+	}
+	if resumed && options.Verbose {
+		fmt.Printf("Resuming interrupted scan of %s started %s\n", folder, session.StartedAt)
+	}
+
+	// Incremental scans skip the files of folders that didn't change
+	var folders *scanner.FolderTimes
+	if options.Incremental {
+		folders, err = scanner.LoadFolderTimes(ctx, ix.db, options.Prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ScanResult{SessionID: session.ID, Resumed: resumed}
+	err = scanner.ScanAndStoreFolder(ctx, ix.db, scanner.ScanOptions{
+		FolderPath:      folder,
+		SourcePrefix:    options.Prefix,
+		ForceRewrite:    options.Force,
+		Reconvert:       options.Reconvert,
+		DebugMode:       options.Debug,
+		MaxWorkers:      workers,
+		AdaptiveWorkers: adaptiveWorkers,
+		MaxMemory:       options.MaxMemory,
+		RawWorkers:      options.RawWorkers,
+		IORetries:       options.IORetries,
+		IOSkipAfter:     options.IOSkipAfter,
+		Thumbnails:      options.Thumbnails,
+		ThumbnailSize:   thumbnailSize,
+		PreviewCache:    previewCache,
+		RawMode:         rawMode,
+		FaceDetector:    faceDetector,
+		Colors:          options.Colors,
+		Archives:        options.Archives,
+		SVG:             options.SVG,
+		Include:         options.Include,
+		Exclude:         options.Exclude,
+		IncludeHidden:   options.IncludeHidden,
+		MinSize:         options.MinSize,
+		MaxSize:         options.MaxSize,
+		MinDimension:    options.MinDimension,
+		Source:          src,
+		Files:           options.Files,
+		MaxDepth:        options.MaxDepth,
+		FollowSymlinks:  options.FollowSymlinks,
+		Folders:         folders,
+		Quiet:           !options.Verbose,
+		SessionID:       session.ID,
+		OnProgress: func(progress scanner.Progress) {
+			result.Processed, result.Errors, result.Total = progress.Processed, progress.Errors, progress.Total
+			if options.OnProgress != nil {
+				options.OnProgress(Progress(progress))
+			}
+		},
+	})
+	if folders != nil {
+		result.SkippedFolders = folders.Skipped()
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if session.ID == 0 {
+		return result, nil
+	}
+	if err := database.FinishScanSession(ctx, ix.db, session.ID); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// InterruptedScan returns the last scan that didn't complete, or nil if
+// every scan completed. Scanning its folder with its prefix continues it.
+func (ix *Indexer) InterruptedScan(ctx context.Context) (*Session, error) {
+	session, err := database.LastIncompleteSession(ctx, ix.db)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	return &Session{
+		ID:        session.ID,
+		Folder:    session.Folder,
+		Prefix:    session.SourcePrefix,
+		Force:     session.Force,
+		StartedAt: session.StartedAt,
+	}, nil
+}
+
+// Stats counts the indexed images
+type Stats struct {
+	Images       int // Indexed images
+	UniqueHashes int // Distinct average hashes among them
+	Errors       int // Files that failed to index
+}
+
+// Stats counts the images indexed under prefix, or in the whole index if
+// prefix is empty
+func (ix *Indexer) Stats(ctx context.Context, prefix string) (*Stats, error) {
+	stats, err := database.GetScanStats(ctx, ix.db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{Images: stats.TotalImages, UniqueHashes: stats.UniqueHashes, Errors: stats.ErrorCount}, nil
+}
@@ -0,0 +1,391 @@
+// Package search finds the images of an imagefinder index, built with
+// package index, that look like a query image.
+//
+//	searcher, err := search.Open("images.db")
+//	if err != nil {
+//		return err
+//	}
+//	defer searcher.Close()
+//	matches, err := searcher.Similar(ctx, "query.jpg", search.Options{Threshold: 0.9})
+package search
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/types"
+)
+
+// Defaults used for the zero values of Options. DefaultThreshold is the
+// threshold of the normal preset.
+const (
+	DefaultThreshold      = 0.8
+	DefaultColorTolerance = 20
+)
+
+// Searcher searches an index database. Its methods may be called from
+// several goroutines.
+type Searcher struct {
+	db     *sql.DB
+	closes bool // The database was opened by Open
+}
+
+// Open opens the index database at path, which must exist
+func Open(path string) (*Searcher, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cannot open index: %v", err)
+	}
+	db, err := database.InitDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Searcher{db: db, closes: true}, nil
+}
+
+// New returns a Searcher for an index database opened by the caller, such as
+// the one of an index.Indexer. Close leaves it open.
+func New(db *sql.DB) *Searcher {
+	return &Searcher{db: db}
+}
+
+// Close closes the index database if Open opened it
+func (s *Searcher) Close() error {
+	if !s.closes {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Options are the options of a search. The zero value compares the query with
+// every indexed image, weighing both hashes, and verifies matches with SSIM.
+type Options struct {
+	Threshold float64 // Smallest similarity, from 0 to 1, of a match; 0 uses that of Preset
+	Preset    string  // Tolerances of matches: "strict", "normal" (the default) or "loose"
+
+	Prefixes       []string  // Only images with one of these source prefixes, empty for all
+	Near           *Location // Only images taken near a place
+	After          time.Time // Only images captured at or after this time
+	Before         time.Time // Only images captured before this time
+	Camera         string    // Only images taken with a matching camera model
+	MinRating      int       // Only images rated at least this in their XMP sidecar
+	MinFaces       int       // Only images with at least this many faces
+	Color          string    // Only images with a dominant color close to this one, written as #rrggbb or #rgb
+	ColorTolerance float64   // Largest CIE ΔE from Color; 0 uses DefaultColorTolerance
+	MinSize        int64     // Only files of at least this many bytes
+	MaxSize        int64     // Only files of at most this many bytes, 0 for no limit
+	Label          string    // Only images with this XMP color label
+	Keyword        string    // Only images with this XMP keyword
+	Tag            string    // Only images with this tag
+	Collection     string    // Only images in this collection
+
+	Strategy  string // Hashes that decide a match: "ahash", "phash", "both" (weighted, the default) or "any"
+	Metric    string // How matches are verified: "ssim" (the default), "ms-ssim" or "absdiff"
+	Mirror    bool   // Also match mirror images of the query
+	HashOnly  bool   // Rank matches by hash score without reading the matched images
+	Prefilter bool   // Only compare images sharing a pHash band with the query (faster, may miss weak matches)
+	Workers   int    // Candidates compared at once; 0 uses one per CPU
+	Explain   bool   // Fill in the Explanation of each match
+
+	PreviewCacheDir string // Folder caching converted RAW previews; empty caches nothing
+	RawMode         string // Image of RAW queries and matches decoded, as for index.ScanOptions.RawMode
+	Debug           bool   // Log the scores of the candidates
+}
+
+// Location is a circle on the map
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+// Hashes is a query given by the hashes of an image instead of the image,
+// as 16 hexadecimal digits. Either may be empty.
+type Hashes struct {
+	Perceptual string
+	Average    string
+}
+
+// Match is an indexed image similar to the query
+type Match struct {
+	Path         string
+	SourcePrefix string
+	SSIMScore    float64 // Verification score, or the hash score when unverified
+	HashScore    float64 // Combined hash similarity and filename boost
+	Verified     bool    // SSIMScore compares the images rather than their hashes
+	Mirrored     bool    // Matched the horizontally flipped query
+
+	Explanation *Explanation // The signals behind the scores, with Options.Explain
+}
+
+// Explanation holds the signals that make up the scores of a match
+type Explanation struct {
+	AverageHashDistance    int     // Bits of the 64-bit average hashes that differ, -1 when the query has none
+	PerceptualHashDistance int     // Bits of the 64-bit perceptual hashes that differ, -1 when the query has none
+	FilenameBoost          float64 // Added to the hash score for a similar file name
+	Loader                 string  // Loader that decodes the file of the match when scanning
+	VerifiedWith           string  // "thumbnail" or "file" when the match was verified
+}
+
+// Similar returns the indexed images similar to the image at queryPath, best
+// matches first
+func (s *Searcher) Similar(ctx context.Context, queryPath string, options Options) ([]Match, error) {
+	searchOptions, err := s.searchOptions(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	searchOptions.QueryPath = queryPath
+	matches, err := imageprocessor.FindSimilarImages(ctx, s.db, searchOptions)
+	if err != nil {
+		return nil, err
+	}
+	return newMatches(matches), nil
+}
+
+// SimilarToHashes returns the indexed images whose hashes are similar to
+// hashes, ranked by hash score since there is no image to verify them with.
+// The strategy may only use the hashes given, and Mirror is not supported.
+func (s *Searcher) SimilarToHashes(ctx context.Context, hashes Hashes, options Options) ([]Match, error) {
+	searchOptions, err := s.searchOptions(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var query imageprocessor.HashQuery
+	if hashes.Perceptual != "" {
+		hash, err := types.ParseHash(hashes.Perceptual)
+		if err != nil {
+			return nil, fmt.Errorf("invalid perceptual hash: %v", err)
+		}
+		query.PerceptualHash = &hash
+	}
+	if hashes.Average != "" {
+		hash, err := types.ParseHash(hashes.Average)
+		if err != nil {
+			return nil, fmt.Errorf("invalid average hash: %v", err)
+		}
+		query.AverageHash = &hash
+	}
+
+	matches, err := imageprocessor.FindImagesByHash(ctx, s.db, query, searchOptions)
+	if err != nil {
+		return nil, err
+	}
+	return newMatches(matches), nil
+}
+
+// Batch searches several query images with the same options. The indexed
+// images are read once, when the batch is created, which makes each search
+// much faster than Similar.
+type Batch struct {
+	db         *sql.DB
+	options    imageprocessor.SearchOptions
+	candidates *imageprocessor.CandidateSet
+}
+
+// NewBatch reads the indexed images passing the filters of options
+func (s *Searcher) NewBatch(ctx context.Context, options Options) (*Batch, error) {
+	searchOptions, err := s.searchOptions(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := imageprocessor.LoadCandidates(ctx, s.db, searchOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{db: s.db, options: searchOptions, candidates: candidates}, nil
+}
+
+// Len returns the number of indexed images the queries are compared with
+func (b *Batch) Len() int {
+	return b.candidates.Len()
+}
+
+// Similar returns the images of the batch similar to the image at queryPath,
+// best matches first
+func (b *Batch) Similar(ctx context.Context, queryPath string) ([]Match, error) {
+	options := b.options
+	options.QueryPath = queryPath
+	matches, err := imageprocessor.FindSimilarImagesIn(ctx, b.db, b.candidates, options)
+	if err != nil {
+		return nil, err
+	}
+	return newMatches(matches), nil
+}
+
+// SimilarWith is Similar with the threshold, preset, strategy, metric,
+// Mirror, HashOnly, Prefilter and Explain of options instead of those the batch was created
+// with, and with its Workers when not 0. The filters of the batch still
+// apply; those of options are ignored.
+func (b *Batch) SimilarWith(ctx context.Context, queryPath string, options Options) ([]Match, error) {
+	searchOptions := b.options
+	searchOptions.QueryPath = queryPath
+	var err error
+	if searchOptions.Preset, err = imageprocessor.ParsePreset(options.Preset); err != nil {
+		return nil, err
+	}
+	searchOptions.Threshold = options.Threshold
+	if searchOptions.Threshold == 0 {
+		searchOptions.Threshold = searchOptions.Preset.Tolerances().Threshold
+	}
+	if searchOptions.Strategy, err = imageprocessor.ParseStrategy(options.Strategy); err != nil {
+		return nil, err
+	}
+	if searchOptions.Metric, err = imageprocessor.ParseMetric(options.Metric); err != nil {
+		return nil, err
+	}
+	searchOptions.Mirror = options.Mirror
+	searchOptions.HashOnly = options.HashOnly
+	searchOptions.Prefilter = options.Prefilter
+	searchOptions.Explain = options.Explain
+	if options.Workers != 0 {
+		searchOptions.Workers = options.Workers
+	}
+
+	matches, err := imageprocessor.FindSimilarImagesIn(ctx, b.db, b.candidates, searchOptions)
+	if err != nil {
+		return nil, err
+	}
+	return newMatches(matches), nil
+}
+
+// Comparison is how alike two images are
+type Comparison struct {
+	AverageHash    float64 // Similarity of the average hashes, from 0 to 1
+	PerceptualHash float64 // Similarity of the perceptual hashes, from 0 to 1
+	HashScore      float64 // The hash similarities combined by the strategy
+	Score          float64 // Similarity measured by the metric
+}
+
+// Compare compares the images at pathA and pathB, which need not be indexed,
+// with the strategy and metric of options, decoding RAW files in its RAW
+// mode. Their file names are not compared, unlike in searches.
+func (s *Searcher) Compare(ctx context.Context, pathA, pathB string, options Options) (Comparison, error) {
+	searchOptions, err := s.searchOptions(ctx, options)
+	if err != nil {
+		return Comparison{}, err
+	}
+	comparison, err := imageprocessor.CompareFiles(ctx, pathA, pathB, searchOptions)
+	return Comparison(comparison), err
+}
+
+// searchOptions checks options and converts them to those of the search
+// implementation
+func (s *Searcher) searchOptions(ctx context.Context, options Options) (imageprocessor.SearchOptions, error) {
+	searchOptions := imageprocessor.SearchOptions{
+		Threshold:      options.Threshold,
+		SourcePrefixes: options.Prefixes,
+		DebugMode:      options.Debug,
+		After:          options.After,
+		Before:         options.Before,
+		Camera:         options.Camera,
+		MinRating:      options.MinRating,
+		MinFaces:       options.MinFaces,
+		ColorDelta:     options.ColorTolerance,
+		MinSize:        options.MinSize,
+		MaxSize:        options.MaxSize,
+		Label:          options.Label,
+		Keyword:        options.Keyword,
+		Tag:            options.Tag,
+		Collection:     options.Collection,
+		Workers:        options.Workers,
+		Prefilter:      options.Prefilter,
+		Mirror:         options.Mirror,
+		HashOnly:       options.HashOnly,
+		Explain:        options.Explain,
+	}
+	var err error
+	if searchOptions.Preset, err = imageprocessor.ParsePreset(options.Preset); err != nil {
+		return searchOptions, err
+	}
+	if searchOptions.Threshold == 0 {
+		searchOptions.Threshold = searchOptions.Preset.Tolerances().Threshold
+	}
+	if searchOptions.ColorDelta == 0 {
+		searchOptions.ColorDelta = DefaultColorTolerance
+	}
+
+	if options.Near != nil {
+		if options.Near.RadiusKm <= 0 {
+			return searchOptions, fmt.Errorf("invalid radius %v, expected a positive number of kilometers", options.Near.RadiusKm)
+		}
+		searchOptions.Location = &database.LocationFilter{
+			Latitude:  options.Near.Latitude,
+			Longitude: options.Near.Longitude,
+			RadiusKm:  options.Near.RadiusKm,
+		}
+	}
+	if !options.After.IsZero() && !options.Before.IsZero() && !options.After.Before(options.Before) {
+		return searchOptions, fmt.Errorf("the capture date range ends before it starts")
+	}
+	if options.MaxSize > 0 && options.MinSize > options.MaxSize {
+		return searchOptions, fmt.Errorf("the smallest file size is larger than the largest")
+	}
+
+	if options.Color != "" {
+		color, err := types.ParseColor(options.Color)
+		if err != nil {
+			return searchOptions, fmt.Errorf("invalid color: %v", err)
+		}
+		searchOptions.Color = &color
+	}
+	if searchOptions.ColorDelta < 0 {
+		return searchOptions, fmt.Errorf("the color tolerance must be positive")
+	}
+
+	if searchOptions.Strategy, err = imageprocessor.ParseStrategy(options.Strategy); err != nil {
+		return searchOptions, err
+	}
+	if searchOptions.Metric, err = imageprocessor.ParseMetric(options.Metric); err != nil {
+		return searchOptions, err
+	}
+	if searchOptions.RawMode, err = types.ParseRawMode(options.RawMode); err != nil {
+		return searchOptions, err
+	}
+
+	if options.Collection != "" {
+		exists, err := database.CollectionExists(ctx, s.db, options.Collection)
+		if err != nil {
+			return searchOptions, err
+		}
+		if !exists {
+			return searchOptions, fmt.Errorf("there is no collection named '%s'", options.Collection)
+		}
+	}
+
+	if options.PreviewCacheDir != "" {
+		if searchOptions.PreviewCache, err = imageprocessor.NewPreviewCache(options.PreviewCacheDir); err != nil {
+			return searchOptions, err
+		}
+	}
+	return searchOptions, nil
+}
+
+// newMatches converts the matches of the search implementation
+func newMatches(matches []imageprocessor.ImageMatch) []Match {
+	converted := make([]Match, len(matches))
+	for i, match := range matches {
+		converted[i] = Match{
+			Path:         match.Path,
+			SourcePrefix: match.SourcePrefix,
+			SSIMScore:    match.SSIMScore,
+			HashScore:    match.HashScore,
+			Verified:     match.Verified,
+			Mirrored:     match.Mirrored,
+		}
+		if explanation := match.Explanation; explanation != nil {
+			converted[i].Explanation = &Explanation{
+				AverageHashDistance:    explanation.AverageHashDistance,
+				PerceptualHashDistance: explanation.PerceptualHashDistance,
+				FilenameBoost:          explanation.FilenameBoost,
+				Loader:                 explanation.Loader,
+				VerifiedWith:           explanation.VerifiedWith,
+			}
+		}
+	}
+	return converted
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/trace"
+	"sync"
+	"time"
+
+	"imagefinder/config"
+	"imagefinder/signalhandler"
+)
+
+// startProfiling serves the pprof endpoints and starts the execution trace
+// when the settings ask for them. The returned function ends the trace; it
+// also runs when the command exits through signalhandler.Exit.
+func startProfiling(settings *config.Settings) func() {
+	if settings.Pprof != "" {
+		servePprof(settings.Pprof)
+	}
+	if settings.Trace == "" {
+		return func() {}
+	}
+
+	file, err := os.Create(settings.Trace)
+	if err != nil {
+		fatalf("Error creating trace file: %v", err)
+	}
+	if err := trace.Start(file); err != nil {
+		fatalf("Error starting trace: %v", err)
+	}
+	// Status lines go to stderr, since rpc and mcp answer on stdout
+	fmt.Fprintf(os.Stderr, "Writing execution trace to %s\n", settings.Trace)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			trace.Stop()
+			if err := file.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing trace file: %v\n", err)
+			}
+		})
+	}
+	signalhandler.OnExit(stop)
+	return stop
+}
+
+// servePprof serves the pprof endpoints at addr for as long as the command
+// runs. A mux of its own keeps them off the listener of the serve command.
+func servePprof(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatalf("Error serving pprof: %v", err)
+	}
+
+	// Sample contention too, which the worker pools of scans mostly wait on
+	runtime.SetMutexProfileFraction(100)
+	runtime.SetBlockProfileRate(int(100 * time.Microsecond))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "pprof server stopped: %v\n", err)
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "Serving pprof at http://%s/debug/pprof/\n", listener.Addr())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/scanner"
+	"imagefinder/signalhandler"
+	"imagefinder/source"
+	"imagefinder/types"
+)
+
+// rehashGroup is the indexed files of one source prefix that are hashed
+// again together
+type rehashGroup struct {
+	prefix string
+	paths  []string
+}
+
+// handleRehashCommand hashes again the indexed local files whose EXIF
+// orientation turns the stored image, so entries indexed before images were
+// turned upright match their upright copies. With --all every indexed local
+// file is hashed again.
+func handleRehashCommand(ctx context.Context, flags *rehashFlags, settings *config.Settings) {
+	dbPath := settings.Database
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// Files in archives and documents and on remote sources can't be read
+	// without downloading or extracting them, so they are left to scan --force
+	var groups []*rehashGroup
+	byPrefix := make(map[string]*rehashGroup)
+	skipped, missing := 0, 0
+	err = database.ForEachImage(ctx, db, flags.prefix, func(info types.ImageInfo) error {
+		if flags.prefixSet && info.SourcePrefix != flags.prefix {
+			return nil
+		}
+		if imageprocessor.SourceFile(info.Path) != info.Path || source.IsRemote(info.Path) {
+			skipped++
+			return nil
+		}
+		if _, err := os.Stat(info.Path); err != nil {
+			missing++
+			return nil
+		}
+		orientation := imageprocessor.ReadOrientation(info.Path)
+		if !flags.all && orientation < 2 {
+			return nil
+		}
+
+		if flags.dryRun {
+			path := info.Path
+			if info.SourcePrefix != "" {
+				path += " [" + info.SourcePrefix + "]"
+			}
+			fmt.Printf("%s (orientation %d)\n", path, orientation)
+		}
+		group, ok := byPrefix[info.SourcePrefix]
+		if !ok {
+			group = &rehashGroup{prefix: info.SourcePrefix}
+			byPrefix[info.SourcePrefix] = group
+			groups = append(groups, group)
+		}
+		group.paths = append(group.paths, info.Path)
+		return nil
+	})
+	if err != nil {
+		fatalf("Error reading indexed images: %v", err)
+	}
+	if skipped > 0 {
+		statusf("Skipped %d remote, archive or document entries; use scan --force to hash them again\n", skipped)
+	}
+	if missing > 0 {
+		statusf("Skipped %d files that no longer exist\n", missing)
+	}
+	if len(groups) == 0 {
+		statusf("No images to hash again.\n")
+		return
+	}
+	if flags.dryRun {
+		return
+	}
+
+	maxWorkers := settings.Workers
+	adaptiveWorkers := maxWorkers == 0
+	if adaptiveWorkers {
+		maxWorkers = signalhandler.GetOptimalProcs()
+	}
+	thumbnailSize := flags.thumbnailSize
+	if thumbnailSize <= 0 {
+		fatalf("Invalid thumbnail size: %d", thumbnailSize)
+	}
+	previewCache := openPreviewCache(flags.cacheDir)
+
+	rehashed, failedAgain := 0, 0
+	for _, group := range groups {
+		// Paths are given to the scan, so the root of the source only has to
+		// exist
+		location := filepath.Dir(group.paths[0])
+		src, err := source.Open(location)
+		if err != nil {
+			log.Printf("Warning: skipping %d files in %s: %v", len(group.paths), location, err)
+			continue
+		}
+
+		if group.prefix != "" {
+			statusf("Hashing %d images again (source prefix: %s)\n", len(group.paths), group.prefix)
+		} else {
+			statusf("Hashing %d images again\n", len(group.paths))
+		}
+		err = scanner.ScanAndStoreFolder(ctx, db, scanner.ScanOptions{
+			FolderPath:    location,
+			SourcePrefix:  group.prefix,
+			ForceRewrite:  true,
+			Reconvert:     true,
+			DebugMode:     settings.Debug,
+			DbPath:        dbPath,
+			TotalImages:   len(group.paths),
+			MaxWorkers:    maxWorkers,
+			MaxMemory:     settings.MaxMemory,
+			RawWorkers:    settings.RawWorkers,
+			IORetries:     settings.IORetries,
+			IOSkipAfter:   settings.IOSkipAfter,
+			Thumbnails:    flags.thumbnails,
+			ThumbnailSize: thumbnailSize,
+			PreviewCache:  previewCache,
+			RawMode:       settings.RawMode,
+			Source:        src,
+			Paths:         group.paths,
+			Quiet:         quiet,
+
+			AdaptiveWorkers: adaptiveWorkers,
+		})
+		if err != nil {
+			exitIfInterrupted(ctx, err, db, "Rehash interrupted")
+			fatalf("Error hashing images again: %v", err)
+		}
+
+		failed, err := database.FailedPaths(ctx, db, group.prefix)
+		if err != nil {
+			fatalf("Error reading failed files: %v", err)
+		}
+		rehashed += len(group.paths)
+		for _, path := range group.paths {
+			if failed[path] {
+				failedAgain++
+			}
+		}
+	}
+
+	statusf("\nHashed %d of %d images again; %d failed (see retry-failed --dry-run).\n",
+		rehashed-failedAgain, rehashed, failedAgain)
+}
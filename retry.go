@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/scanner"
+	"imagefinder/signalhandler"
+	"imagefinder/source"
+)
+
+// retryGroup is the failed files of one source prefix and scan source, which
+// are retried together
+type retryGroup struct {
+	prefix   string
+	location string // Folder the source is opened with; empty for local files
+	paths    []string
+}
+
+// handleRetryFailedCommand indexes again the files recorded in the errors
+// table. Files that are indexed have their errors removed.
+func handleRetryFailedCommand(ctx context.Context, flags *retryFailedFlags, settings *config.Settings) {
+	dbPath := settings.Database
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	filter := database.ErrorFilter{Match: flags.match, Loader: flags.loader}
+	if flags.prefixSet {
+		filter.SourcePrefixes = []string{flags.prefix}
+	}
+	failures, err := database.ImageErrors(ctx, db, filter)
+	if err != nil {
+		fatalf("Error reading failed files: %v", err)
+	}
+	if len(failures) == 0 {
+		statusf("No failed files to retry.\n")
+		return
+	}
+
+	if flags.dryRun {
+		for _, failure := range failures {
+			path := failure.Path
+			if failure.SourcePrefix != "" {
+				path += " [" + failure.SourcePrefix + "]"
+			}
+			loader := failure.Loader
+			if loader == "" {
+				loader = "no loader"
+			}
+			fmt.Printf("%s: %s (%s, failed %d times, last %s)\n", path, failure.Error, loader, failure.Attempts, failure.FailedAt)
+		}
+		return
+	}
+
+	// Local files that were deleted since can't succeed, so their errors go.
+	// Relative paths are kept, as they may just be relative to another folder.
+	var groups []*retryGroup
+	byKey := make(map[string]*retryGroup)
+	forgotten := 0
+	for _, failure := range failures {
+		file := imageprocessor.SourceFile(failure.Path)
+		location := ""
+		if source.IsRemote(file) {
+			location = file[:strings.LastIndex(file, "/")]
+		} else if _, err := os.Stat(file); os.IsNotExist(err) && filepath.IsAbs(file) {
+			if err := database.ClearImageError(ctx, db, failure.Path, failure.SourcePrefix); err != nil {
+				fatalf("Error: %v", err)
+			}
+			forgotten++
+			continue
+		}
+
+		key := failure.SourcePrefix + "\x00" + location
+		group, ok := byKey[key]
+		if !ok {
+			group = &retryGroup{prefix: failure.SourcePrefix, location: location}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.paths = append(group.paths, failure.Path)
+	}
+	if forgotten > 0 {
+		statusf("Removed the errors of %d files that no longer exist\n", forgotten)
+	}
+
+	maxWorkers := settings.Workers
+	adaptiveWorkers := maxWorkers == 0
+	if adaptiveWorkers {
+		maxWorkers = signalhandler.GetOptimalProcs()
+	}
+	thumbnailSize := flags.thumbnailSize
+	if thumbnailSize <= 0 {
+		fatalf("Invalid thumbnail size: %d", thumbnailSize)
+	}
+	previewCache := openPreviewCache(flags.cacheDir)
+
+	retried, stillFailing := 0, 0
+	for _, group := range groups {
+		location := group.location
+		if location == "" {
+			// Paths are given to the scan, so the root of a local source only
+			// has to exist
+			location = filepath.Dir(imageprocessor.SourceFile(group.paths[0]))
+		}
+		src, err := source.Open(location)
+		if err != nil {
+			log.Printf("Warning: skipping %d failed files in %s: %v", len(group.paths), location, err)
+			continue
+		}
+
+		if group.prefix != "" {
+			statusf("Retrying %d failed files in %s (source prefix: %s)\n", len(group.paths), location, group.prefix)
+		} else {
+			statusf("Retrying %d failed files in %s\n", len(group.paths), location)
+		}
+		err = scanner.ScanAndStoreFolder(ctx, db, scanner.ScanOptions{
+			FolderPath:    location,
+			SourcePrefix:  group.prefix,
+			DebugMode:     settings.Debug,
+			DbPath:        dbPath,
+			TotalImages:   len(group.paths),
+			MaxWorkers:    maxWorkers,
+			MaxMemory:     settings.MaxMemory,
+			RawWorkers:    settings.RawWorkers,
+			IORetries:     settings.IORetries,
+			IOSkipAfter:   settings.IOSkipAfter,
+			Thumbnails:    flags.thumbnails,
+			ThumbnailSize: thumbnailSize,
+			PreviewCache:  previewCache,
+			RawMode:       settings.RawMode,
+			Source:        src,
+			Paths:         group.paths,
+			Quiet:         quiet,
+
+			AdaptiveWorkers: adaptiveWorkers,
+		})
+		if err != nil {
+			exitIfInterrupted(ctx, err, db, "Retry interrupted")
+			fatalf("Error retrying failed files: %v", err)
+		}
+
+		// Files still in the errors table failed again
+		failed, err := database.FailedPaths(ctx, db, group.prefix)
+		if err != nil {
+			fatalf("Error reading failed files: %v", err)
+		}
+		retried += len(group.paths)
+		for _, path := range group.paths {
+			if failed[path] {
+				stillFailing++
+			}
+		}
+	}
+
+	statusf("\nIndexed %d of %d previously failed files; %d still fail.\n",
+		retried-stillFailing, retried, stillFailing)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"imagefinder/imageprocessor"
+)
+
+// reviewHelp lists the commands of dedupe --interactive
+const reviewHelp = `Commands:
+  Enter      accept the marks and go to the next group
+  k N        keep file N
+  d N        mark file N for removal
+  o N        keep only file N, marking the others for removal
+  s          skip the group, keeping every file
+  b          go back to the previous group
+  q          stop reviewing; groups not reviewed yet are left alone
+  ?          show this help`
+
+// reviewGroup is a duplicate group with the files marked for removal
+type reviewGroup struct {
+	images   []imageprocessor.DuplicateImage // The suggested keeper first
+	remove   []bool
+	reviewed bool
+}
+
+// reviewDuplicates walks through the duplicate groups, letting the user mark
+// the files to keep and remove, and returns the groups with files to remove.
+// Each returned group's keeper is its first kept file. It reports false when
+// the user didn't confirm the removals.
+func reviewDuplicates(ctx context.Context, groups []imageprocessor.DuplicateGroup, action string, dryRun bool, in io.Reader) ([]imageprocessor.DuplicateGroup, bool) {
+	// Lines are read in the background so an interrupt isn't held up by a
+	// pending read
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	prompt := func(text string) (string, bool) {
+		fmt.Print(text)
+		select {
+		case line, ok := <-lines:
+			return strings.TrimSpace(line), ok
+		case <-ctx.Done():
+			fmt.Println()
+			return "", false
+		}
+	}
+
+	// The suggestion is to keep the keeper and remove its duplicates
+	review := make([]*reviewGroup, len(groups))
+	for i, group := range groups {
+		review[i] = &reviewGroup{
+			images: append([]imageprocessor.DuplicateImage{group.Keeper}, group.Duplicates...),
+			remove: make([]bool, len(group.Duplicates)+1),
+		}
+		for j := range group.Duplicates {
+			review[i].remove[j+1] = true
+		}
+	}
+
+	fmt.Printf("Reviewing %d duplicate groups. Type ? for help.\n", len(groups))
+	for i := 0; i < len(review) && ctx.Err() == nil; {
+		group := review[i]
+		printReviewGroup(i, len(review), group)
+
+		line, ok := prompt(fmt.Sprintf("Group %d/%d [Enter, k N, d N, o N, s, b, q, ?]: ", i+1, len(review)))
+		if !ok {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			group.reviewed = true
+			i++
+			continue
+		}
+
+		switch fields[0] {
+		case "s":
+			for j := range group.remove {
+				group.remove[j] = false
+			}
+			group.reviewed = true
+			i++
+		case "b":
+			if i > 0 {
+				i--
+			}
+		case "q":
+			i = len(review)
+		case "k", "d", "o":
+			file := 0
+			if len(fields) == 2 {
+				file, _ = strconv.Atoi(fields[1])
+			}
+			if file < 1 || file > len(group.images) {
+				fmt.Printf("Expected a file number from 1 to %d, as in %s 2\n", len(group.images), fields[0])
+				continue
+			}
+			marks := append([]bool(nil), group.remove...)
+			switch fields[0] {
+			case "k":
+				marks[file-1] = false
+			case "d":
+				marks[file-1] = true
+			case "o":
+				for j := range marks {
+					marks[j] = j != file-1
+				}
+			}
+			if countMarked(marks) == len(marks) {
+				fmt.Println("At least one file of a group must be kept")
+				continue
+			}
+			group.remove = marks
+		case "?", "h", "help":
+			fmt.Println(reviewHelp)
+		default:
+			fmt.Printf("Unknown command '%s'. Type ? for help.\n", line)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, false
+	}
+
+	// Only reviewed groups are acted on
+	var result []imageprocessor.DuplicateGroup
+	files := 0
+	var size int64
+	for _, group := range review {
+		if !group.reviewed || countMarked(group.remove) == 0 {
+			continue
+		}
+		var reviewed imageprocessor.DuplicateGroup
+		kept := false
+		for j, image := range group.images {
+			switch {
+			case group.remove[j]:
+				reviewed.Duplicates = append(reviewed.Duplicates, image)
+				files++
+				size += image.Size
+			case !kept:
+				reviewed.Keeper = image
+				kept = true
+			}
+		}
+		result = append(result, reviewed)
+	}
+
+	if files == 0 {
+		fmt.Println("\nNo files marked for removal.")
+		return nil, false
+	}
+	fmt.Printf("\n%d files marked in %d groups (%.1f MB).\n", files, len(result), float64(size)/(1024*1024))
+	if dryRun {
+		return result, true
+	}
+
+	questions := map[string]string{
+		"delete":   "Delete %d files? [y/N]: ",
+		"move":     "Move %d files to the target directory? [y/N]: ",
+		"hardlink": "Replace %d files with links to the kept file? [y/N]: ",
+	}
+	answer, ok := prompt(fmt.Sprintf(questions[action], files))
+	if !ok || !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+		fmt.Println("Nothing was changed.")
+		return nil, false
+	}
+	return result, true
+}
+
+// printReviewGroup shows the files of a group with their details and marks
+func printReviewGroup(index int, total int, group *reviewGroup) {
+	fmt.Printf("\nGroup %d of %d\n", index+1, total)
+	for j, image := range group.images {
+		mark := "keep  "
+		if group.remove[j] {
+			mark = "REMOVE"
+		}
+		fmt.Printf("  %d. [%s] %s\n", j+1, mark, describeDuplicate(image))
+
+		details := []string{"modified " + image.ModifiedAt}
+		if image.CapturedAt != "" {
+			details = append(details, "captured "+image.CapturedAt)
+		}
+		if image.Rating != 0 {
+			details = append(details, fmt.Sprintf("rating %d", image.Rating))
+		}
+		if image.FaceCount != nil {
+			details = append(details, fmt.Sprintf("%d faces", *image.FaceCount))
+		}
+		if j > 0 {
+			details = append(details, fmt.Sprintf("pHash distance %d", image.Distance))
+		}
+		fmt.Printf("     %s\n", strings.Join(details, ", "))
+
+		if !isLocalFile(image.Path) {
+			fmt.Printf("     Note: not a local file, it can't be removed\n")
+		} else if reason := changedSinceIndexing(image); reason != "" {
+			fmt.Printf("     Note: %s\n", reason)
+		}
+	}
+}
+
+// countMarked returns the number of files marked for removal
+func countMarked(marks []bool) int {
+	count := 0
+	for _, mark := range marks {
+		if mark {
+			count++
+		}
+	}
+	return count
+}
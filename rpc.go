@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/pkg/search"
+	"imagefinder/utils"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// rpcRequest is a JSON-RPC 2.0 request. Requests without an id are
+// notifications and get no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// rpcResponse carries either the result of a request or its error
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a failed request
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcSearchParams are the parameters of the search method
+type rpcSearchParams struct {
+	Image     string   `json:"image"`
+	Threshold float64  `json:"threshold"`
+	Preset    string   `json:"preset"`
+	Prefixes  []string `json:"prefixes"`
+	Strategy  string   `json:"strategy"`
+	Metric    string   `json:"metric"`
+	Mirror    bool     `json:"mirror"`
+	HashOnly  bool     `json:"hash_only"`
+	Prefilter bool     `json:"prefilter"`
+	Explain   bool     `json:"explain"`
+	Offset    int      `json:"offset"`
+	Limit     int      `json:"limit"`
+}
+
+// rpcSearchResult is the result of the search method: a page of the matches
+// and how many there are in all
+type rpcSearchResult struct {
+	Matches []rpcMatch `json:"matches"`
+	Total   int        `json:"total"`
+}
+
+// rpcMatch is a match of the search method
+type rpcMatch struct {
+	Path         string  `json:"path"`
+	SourcePrefix string  `json:"source_prefix,omitempty"`
+	Score        float64 `json:"score"`
+	HashScore    float64 `json:"hash_score"`
+	Verified     bool    `json:"verified"`
+	Mirrored     bool    `json:"mirrored,omitempty"`
+
+	Explanation *rpcExplanation `json:"explanation,omitempty"`
+}
+
+// rpcExplanation are the signals behind the scores of a match, given with
+// the explain parameter
+type rpcExplanation struct {
+	AverageHashDistance    int     `json:"ahash_distance"`
+	PerceptualHashDistance int     `json:"phash_distance"`
+	FilenameBoost          float64 `json:"filename_boost"`
+	Loader                 string  `json:"loader,omitempty"`
+	VerifiedWith           string  `json:"verified_with,omitempty"`
+}
+
+// rpcStatsParams are the parameters of the stats method
+type rpcStatsParams struct {
+	Prefix string `json:"prefix"`
+}
+
+// rpcCompareParams are the parameters of the compare method
+type rpcCompareParams struct {
+	A        string `json:"a"`
+	B        string `json:"b"`
+	Strategy string `json:"strategy"`
+	Metric   string `json:"metric"`
+}
+
+// rpcComparison is the result of the compare method
+type rpcComparison struct {
+	AverageHash    float64 `json:"average_hash"`
+	PerceptualHash float64 `json:"perceptual_hash"`
+	HashScore      float64 `json:"hash_score"`
+	Score          float64 `json:"score"`
+	Metric         string  `json:"metric"`
+}
+
+// rpcSession answers the requests of one rpc process. The hashes of the
+// indexed images are read once per set of source prefixes and kept until
+// reload is called.
+type rpcSession struct {
+	searcher *search.Searcher
+	db       *sql.DB
+	settings *config.Settings
+	options  search.Options // Settings shared by every search and comparison
+	batches  map[string]*search.Batch
+}
+
+// handleRPCCommand answers JSON-RPC requests read from stdin, one per line,
+// with responses written to stdout in the order of the requests, until stdin
+// is closed or the command is interrupted
+func handleRPCCommand(ctx context.Context, flags *rpcFlags, settings *config.Settings) {
+	session := openRPCSession(ctx, flags, settings)
+	defer session.db.Close()
+	answerLines(ctx, session.db, session.handle)
+}
+
+// openRPCSession opens the index for the rpc and mcp commands and reads the
+// hashes of every indexed image
+func openRPCSession(ctx context.Context, flags *rpcFlags, settings *config.Settings) *rpcSession {
+	dbPath := settings.Database
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	// Stdout only carries responses
+	quiet = true
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+
+	session := &rpcSession{
+		searcher: search.New(db),
+		db:       db,
+		settings: settings,
+		options: search.Options{
+			Workers: settings.Workers,
+			RawMode: string(settings.RawMode),
+			Debug:   settings.Debug,
+		},
+		batches: make(map[string]*search.Batch),
+	}
+	if cache := openPreviewCache(flags.cacheDir); cache != nil {
+		session.options.PreviewCacheDir = cache.Dir
+	}
+
+	// Searches of the whole index are the most common, so their hashes are
+	// read before the first request
+	batch, err := session.batch(ctx, nil)
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Interrupted")
+		db.Close()
+		fatalf("Error reading the index: %v", err)
+	}
+	if !settings.Quiet {
+		fmt.Fprintf(os.Stderr, "Loaded %d indexed images from %s, reading requests from stdin\n", batch.Len(), dbPath)
+	}
+	return session
+}
+
+// answerLines passes each line read from stdin to handle and writes the
+// responses it returns to stdout, one per line, until stdin is closed.
+// Interrupting the command exits.
+func answerLines(ctx context.Context, db *sql.DB, handle func(context.Context, []byte) (rpcResponse, bool)) {
+	// Lines are read on their own goroutine so an interrupt isn't held up
+	// by a blocked read
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-ctx.Done():
+			exitIfInterrupted(ctx, ctx.Err(), db, "Interrupted")
+		case err := <-readErr:
+			if !errors.Is(err, io.EOF) {
+				db.Close()
+				fatalf("Error reading requests: %v", err)
+			}
+			return
+		case line := <-lines:
+			response, ok := handle(ctx, line)
+			if ctx.Err() != nil {
+				exitIfInterrupted(ctx, ctx.Err(), db, "Interrupted")
+			}
+			if !ok {
+				continue
+			}
+			if err := encoder.Encode(response); err != nil {
+				db.Close()
+				fatalf("Error writing response: %v", err)
+			}
+		}
+	}
+}
+
+// handle answers one request, reporting false for notifications
+func (s *rpcSession) handle(ctx context.Context, line []byte) (rpcResponse, bool) {
+	request, failure := parseRPCRequest(line)
+	if failure != nil {
+		return rpcFailure(request.ID, failure), len(request.ID) > 0 || failure.Code == rpcParseError
+	}
+
+	var result any
+	var err error
+	if request.Method == "reload" {
+		clear(s.batches)
+		result = map[string]bool{"reloaded": true}
+	} else if method, ok := s.methods()[request.Method]; ok {
+		result, err = method(ctx, request.Params)
+	} else {
+		err = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method '%s', expected search, stats, compare or reload", request.Method)}
+	}
+	return rpcReply(request, result, err)
+}
+
+// methods returns the operations on the index, by name, that the rpc and mcp
+// commands offer. Each takes its named parameters as a JSON object.
+func (s *rpcSession) methods() map[string]func(context.Context, json.RawMessage) (any, error) {
+	return map[string]func(context.Context, json.RawMessage) (any, error){
+		"search":  s.search,
+		"stats":   s.stats,
+		"compare": s.compare,
+	}
+}
+
+// parseRPCRequest reads a JSON-RPC 2.0 request
+func parseRPCRequest(line []byte) (rpcRequest, *rpcError) {
+	var request rpcRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		return rpcRequest{}, &rpcError{Code: rpcParseError, Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return request, &rpcError{Code: rpcInvalidRequest, Message: `expected a JSON-RPC 2.0 request with "jsonrpc": "2.0" and a method`}
+	}
+	return request, nil
+}
+
+// rpcReply returns the response to request carrying result or err, and
+// false for notifications, which get no response
+func rpcReply(request rpcRequest, result any, err error) (rpcResponse, bool) {
+	if len(request.ID) == 0 {
+		return rpcResponse{}, false
+	}
+	if err != nil {
+		var failure *rpcError
+		if !errors.As(err, &failure) {
+			failure = &rpcError{Code: rpcServerError, Message: err.Error()}
+		}
+		return rpcFailure(request.ID, failure), true
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: request.ID, Result: result}, true
+}
+
+// rpcFailure returns the response reporting err; the id is null when the
+// request couldn't be read
+func rpcFailure(id json.RawMessage, err *rpcError) rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// decodeParams reads the named parameters of a request into params,
+// rejecting unknown ones so misspelled parameters aren't ignored
+func decodeParams(raw json.RawMessage, params any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(params); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	return nil
+}
+
+// invalidParams reports a parameter with a wrong value
+func invalidParams(format string, args ...any) error {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// batch returns the hashes of the images with one of the source prefixes,
+// read from the index on first use
+func (s *rpcSession) batch(ctx context.Context, prefixes []string) (*search.Batch, error) {
+	prefixes = slices.Clone(prefixes)
+	slices.Sort(prefixes)
+	prefixes = slices.Compact(prefixes)
+	key := strings.Join(prefixes, "\x00")
+	if batch, ok := s.batches[key]; ok {
+		return batch, nil
+	}
+
+	options := s.options
+	options.Prefixes = prefixes
+	batch, err := s.searcher.NewBatch(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	s.batches[key] = batch
+	return batch, nil
+}
+
+// search finds the indexed images similar to an image
+func (s *rpcSession) search(ctx context.Context, raw json.RawMessage) (any, error) {
+	var params rpcSearchParams
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	if params.Image == "" {
+		return nil, invalidParams("image is required")
+	}
+	if _, err := os.Stat(imageprocessor.SourceFile(params.Image)); err != nil {
+		return nil, invalidParams("query image %s: %v", params.Image, err)
+	}
+	// Without a threshold, the configured one applies, then that of the preset
+	if params.Threshold == 0 && s.settings.IsSet(config.KeyThreshold) {
+		params.Threshold = s.settings.Threshold
+	}
+	if params.Threshold < 0 || params.Threshold > 1 {
+		return nil, invalidParams("invalid threshold %v, expected a value from 0.0 to 1.0", params.Threshold)
+	}
+	if params.Limit < 0 {
+		return nil, invalidParams("invalid limit %d", params.Limit)
+	}
+	if params.Offset < 0 {
+		return nil, invalidParams("invalid offset %d", params.Offset)
+	}
+	if _, err := imageprocessor.ParsePreset(params.Preset); err != nil {
+		return nil, invalidParams("%v", err)
+	}
+	if _, err := imageprocessor.ParseStrategy(params.Strategy); err != nil {
+		return nil, invalidParams("%v", err)
+	}
+	if _, err := imageprocessor.ParseMetric(params.Metric); err != nil {
+		return nil, invalidParams("%v", err)
+	}
+
+	batch, err := s.batch(ctx, params.Prefixes)
+	if err != nil {
+		return nil, err
+	}
+	options := s.options
+	options.Threshold = params.Threshold
+	options.Preset = params.Preset
+	options.Strategy = params.Strategy
+	options.Metric = params.Metric
+	options.Mirror = params.Mirror
+	options.HashOnly = params.HashOnly
+	options.Prefilter = params.Prefilter
+	options.Explain = params.Explain
+	matches, err := batch.SimilarWith(ctx, params.Image, options)
+	if err != nil {
+		return nil, err
+	}
+	total := len(matches)
+	matches = utils.Page(matches, params.Offset, params.Limit)
+
+	result := make([]rpcMatch, len(matches))
+	for i, match := range matches {
+		result[i] = rpcMatch{
+			Path:         match.Path,
+			SourcePrefix: match.SourcePrefix,
+			Score:        match.SSIMScore,
+			HashScore:    match.HashScore,
+			Verified:     match.Verified,
+			Mirrored:     match.Mirrored,
+		}
+		if explanation := match.Explanation; explanation != nil {
+			result[i].Explanation = &rpcExplanation{
+				AverageHashDistance:    explanation.AverageHashDistance,
+				PerceptualHashDistance: explanation.PerceptualHashDistance,
+				FilenameBoost:          explanation.FilenameBoost,
+				Loader:                 explanation.Loader,
+				VerifiedWith:           explanation.VerifiedWith,
+			}
+		}
+	}
+	return rpcSearchResult{Matches: result, Total: total}, nil
+}
+
+// stats counts the indexed images as the stats command does
+func (s *rpcSession) stats(ctx context.Context, raw json.RawMessage) (any, error) {
+	var params rpcStatsParams
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	return database.GetIndexStats(ctx, s.db, params.Prefix, imageprocessor.RawFormatNames())
+}
+
+// compare compares two image files, indexed or not
+func (s *rpcSession) compare(ctx context.Context, raw json.RawMessage) (any, error) {
+	var params rpcCompareParams
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	for name, path := range map[string]string{"a": params.A, "b": params.B} {
+		if path == "" {
+			return nil, invalidParams("%s is required", name)
+		}
+		if _, err := os.Stat(imageprocessor.SourceFile(path)); err != nil {
+			return nil, invalidParams("image %s: %v", path, err)
+		}
+	}
+	metric, err := imageprocessor.ParseMetric(params.Metric)
+	if err != nil {
+		return nil, invalidParams("%v", err)
+	}
+	if _, err := imageprocessor.ParseStrategy(params.Strategy); err != nil {
+		return nil, invalidParams("%v", err)
+	}
+
+	options := s.options
+	options.Strategy = params.Strategy
+	options.Metric = params.Metric
+	comparison, err := s.searcher.Compare(ctx, params.A, params.B, options)
+	if err != nil {
+		return nil, err
+	}
+	return rpcComparison{
+		AverageHash:    comparison.AverageHash,
+		PerceptualHash: comparison.PerceptualHash,
+		HashScore:      comparison.HashScore,
+		Score:          comparison.Score,
+		Metric:         string(metric),
+	}, nil
+}
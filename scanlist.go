@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readScanList reads the files to scan from listPath, one per line, or from
+// stdin for -. Blank lines are skipped. Paths are made absolute, so the index
+// doesn't depend on the folder the list was read in, and files that can't be
+// read are reported and left out.
+func readScanList(listPath string) ([]string, error) {
+	var reader io.Reader = os.Stdin
+	if listPath != "-" {
+		file, err := os.Open(listPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	lines := bufio.NewScanner(reader)
+	lines.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "://") {
+			return nil, fmt.Errorf("%s is not a local file; scan remote sources with --folder", line)
+		}
+		path, err := filepath.Abs(line)
+		if err != nil {
+			return nil, err
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %v\n", line, err)
+			continue
+		}
+		if info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Skipped %s: a folder, scan it with --folder\n", line)
+			continue
+		}
+		files = append(files, path)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// commonFolder returns the deepest folder holding all the files
+func commonFolder(files []string) string {
+	folder := filepath.Dir(files[0])
+	for _, file := range files[1:] {
+		for !strings.HasPrefix(file, folder+string(filepath.Separator)) && folder != filepath.Dir(folder) {
+			folder = filepath.Dir(folder)
+		}
+	}
+	return folder
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package scanner
+
+import "time"
+
+// processCPUTime reports that CPU time isn't measured on this system, which
+// keeps the worker count fixed
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
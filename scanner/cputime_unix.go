@@ -0,0 +1,19 @@
+//go:build unix
+
+package scanner
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the CPU time used by the process and by the external
+// tools it ran to completion
+func processCPUTime() (time.Duration, bool) {
+	var self, children syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &self) != nil || syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children) != nil {
+		return 0, false
+	}
+	total := self.Utime.Nano() + self.Stime.Nano() + children.Utime.Nano() + children.Stime.Nano()
+	return time.Duration(total), true
+}
@@ -1,18 +1,22 @@
 package scanner
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"time"
 
 	"imagefinder/database"
+	"imagefinder/imageprocessor"
 	"imagefinder/logging"
 )
 
 // checkAndSkipIfUnchanged checks if an image can be skipped because it hasn't changed
-func checkAndSkipIfUnchanged(db *sql.DB, path string, sourcePrefix string, options ScanOptions) *ProcessImageResult {
-	exists, storedModTime, err := database.CheckImageExists(db, path, sourcePrefix)
+func checkAndSkipIfUnchanged(ctx context.Context, db *sql.DB, path string, sourcePrefix string, options ScanOptions) *ProcessImageResult {
+	exists, storedModTime, err := database.CheckImageExists(ctx, db, path, sourcePrefix)
 	if err != nil {
 		return &ProcessImageResult{
 			Path:    path,
@@ -21,9 +25,10 @@ func checkAndSkipIfUnchanged(db *sql.DB, path string, sourcePrefix string, optio
 		}
 	}
 
-	if exists {
+	// Images seeded from another catalog have no modification time until hashed
+	if exists && storedModTime != "" {
 		// Image already indexed, check if it needs update
-		fileInfo, err := os.Stat(path)
+		fileInfo, err := options.Source.Stat(imageprocessor.SourceFile(path))
 		if err != nil {
 			return &ProcessImageResult{
 				Path:    path,
@@ -43,7 +48,7 @@ func checkAndSkipIfUnchanged(db *sql.DB, path string, sourcePrefix string, optio
 		}
 
 		// If file hasn't been modified, skip processing
-		if !fileInfo.ModTime().After(storedTime) {
+		if !fileInfo.ModTime.After(storedTime) {
 			if options.DebugMode {
 				logging.DebugLog("Skipping unchanged image: %s", path)
 			}
@@ -56,3 +61,31 @@ func checkAndSkipIfUnchanged(db *sql.DB, path string, sourcePrefix string, optio
 
 	return nil
 }
+
+// findMovedImage recognizes a file that was moved or renamed since it was
+// indexed: a path not in the index whose checksum matches an indexed image
+// whose file is gone. It returns the indexed path, whose entry can be moved
+// instead of hashing the image again, or "" if there is none. claim reserves
+// an indexed path, so that two files of a scan aren't moved onto one entry.
+func findMovedImage(ctx context.Context, db *sql.DB, path string, sourcePrefix string, checksum string, claim func(oldPath string) bool) string {
+	exists, _, err := database.CheckImageExists(ctx, db, path, sourcePrefix)
+	if err != nil || exists {
+		return ""
+	}
+
+	oldPaths, err := database.ImagesWithChecksum(ctx, db, checksum, sourcePrefix)
+	if err != nil {
+		logging.LogWarning("%v", err)
+		return ""
+	}
+	for _, oldPath := range oldPaths {
+		// Copies are indexed separately; only a file that is gone has moved
+		if _, err := os.Stat(imageprocessor.SourceFile(oldPath)); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if claim(oldPath) {
+			return oldPath
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+
+	"imagefinder/database"
+	"imagefinder/logging"
+)
+
+// recordOutcome keeps the error of a file that failed to index in the
+// database, and forgets the error of a file that failed before once it is
+// indexed
+func recordOutcome(ctx context.Context, db *sql.DB, options ScanOptions, result ProcessImageResult) {
+	// Results are recorded even while the scan is being interrupted
+	ctx = context.WithoutCancel(ctx)
+
+	if result.Success {
+		if !options.failed[result.Path] {
+			return
+		}
+		if err := database.ClearImageError(ctx, db, result.Path, options.SourcePrefix); err != nil {
+			logging.LogWarning("%v", err)
+		}
+		return
+	}
+
+	message := "unknown error"
+	if result.Error != nil {
+		message = result.Error.Error()
+	}
+	err := database.StoreImageError(ctx, db, result.Path, options.SourcePrefix, message, result.Loader)
+	if err != nil {
+		logging.LogWarning("%v", err)
+	}
+}
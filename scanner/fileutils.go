@@ -2,6 +2,7 @@ package scanner
 
 import (
 	"imagefinder/imageprocessor"
+	"path"
 	"strings"
 )
 
@@ -20,6 +21,127 @@ func IsTiffFormat(path string) bool {
 	return imageprocessor.IsTiffFormat(path)
 }
 
+// IsArchiveFile checks if a file is a ZIP or TAR archive
+func IsArchiveFile(path string) bool {
+	return imageprocessor.IsArchiveFile(path)
+}
+
+// HiddenPatterns are the exclude patterns applied unless hidden files are
+// included: dotfiles and dot folders (.thumbnails, .Trash, macOS ._ resource
+// forks), and the metadata, snapshot and recycle bin folders of NAS systems
+// and Windows
+var HiddenPatterns = []string{
+	".*",
+	"@eaDir", "#recycle", "#snapshot", // Synology
+	"@Recycle", "@Recently-Snapshot", ".@__thumb", // QNAP
+	"$RECYCLE.BIN", "System Volume Information", // Windows
+	"lost+found",
+}
+
+// IsHidden reports whether a file or one of the folders above it, relative
+// to root, matches HiddenPatterns
+func IsHidden(filePath string, root string) bool {
+	return matchesAnyPattern(filePath, root, HiddenPatterns)
+}
+
+// IsExcluded reports whether a file matches one of the exclude patterns. A
+// pattern without a slash matches the file name or the name of any folder
+// above it, so "*.tmp" skips temporary files anywhere and ".thumbnails" skips
+// every .thumbnails folder. Other patterns match the path relative to root or
+// one of its parent folders, where "**" stands for any number of folders:
+// "cache/*" skips a top-level folder and "**/cache/**" any folder named cache.
+func IsExcluded(filePath string, root string, patterns []string) bool {
+	return matchesAnyPattern(filePath, root, patterns)
+}
+
+// IsIncluded reports whether a file matches one of the include patterns, which
+// work like the exclude patterns. Every file is included when there are none.
+func IsIncluded(filePath string, root string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) != "" {
+			return matchesAnyPattern(filePath, root, patterns)
+		}
+	}
+	return true
+}
+
+// IsFiltered reports whether the include and exclude patterns leave a file
+// out of the scan
+func IsFiltered(filePath string, root string, include []string, exclude []string) bool {
+	return !IsIncluded(filePath, root, include) || IsExcluded(filePath, root, exclude)
+}
+
+// IsOutsideSizeRange reports whether a file's size leaves it out of the scan.
+// A zero bound is no limit.
+func IsOutsideSizeRange(size int64, minSize int64, maxSize int64) bool {
+	return size < minSize || maxSize > 0 && size > maxSize
+}
+
+// matchesAnyPattern reports whether the path of a file relative to root, or
+// one of its parent folders, matches one of the patterns
+func matchesAnyPattern(filePath string, root string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	slashed := strings.ReplaceAll(filePath, "\\", "/")
+	relative := strings.TrimPrefix(strings.TrimPrefix(slashed, strings.ReplaceAll(root, "\\", "/")), "/")
+	parts := strings.Split(relative, "/")
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+
+		// Patterns without a slash match any single file or folder name
+		if !strings.Contains(pattern, "/") {
+			for _, part := range parts {
+				if matched, _ := path.Match(pattern, part); matched {
+					return true
+				}
+			}
+			continue
+		}
+
+		patternParts := strings.Split(pattern, "/")
+		for i := 1; i <= len(parts); i++ {
+			if matchSegments(patternParts, parts[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where a "**"
+// segment matches zero or more path segments
+func matchSegments(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
 // GetFileFormat returns the lowercase file extension without the dot
 func GetFileFormat(path string) string {
 	format := imageprocessor.GetFileFormat(path)
@@ -30,7 +152,7 @@ func GetFileFormat(path string) string {
 func SupportedRawFormats() []string {
 	// Get all supported extensions
 	allExtensions := imageprocessor.GetSupportedExtensions()
-	
+
 	// Filter to include only RAW formats
 	var rawFormats []string
 	for _, ext := range allExtensions {
@@ -38,6 +160,6 @@ func SupportedRawFormats() []string {
 			rawFormats = append(rawFormats, ext)
 		}
 	}
-	
+
 	return rawFormats
-}
\ No newline at end of file
+}
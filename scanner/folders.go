@@ -0,0 +1,83 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+)
+
+// FolderTimes lets an incremental scan skip the files of folders whose
+// modification time hasn't changed since the last complete incremental scan.
+// A folder's time changes when files are added, removed or renamed in it, but
+// not when a file is rewritten in place.
+type FolderTimes struct {
+	mu       sync.Mutex
+	previous map[string]int64
+	current  map[string]int64
+	skipped  map[string]bool
+	failed   map[string]bool
+}
+
+// LoadFolderTimes reads the folder times recorded for a source prefix
+func LoadFolderTimes(ctx context.Context, db *sql.DB, sourcePrefix string) (*FolderTimes, error) {
+	previous, err := database.FolderTimes(ctx, db, sourcePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &FolderTimes{
+		previous: previous,
+		current:  make(map[string]int64),
+		skipped:  make(map[string]bool),
+		failed:   make(map[string]bool),
+	}, nil
+}
+
+// SkipFiles notes the modification time of a walked folder and reports
+// whether it is unchanged since the last scan
+func (f *FolderTimes) SkipFiles(folder string, modTime time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.current[folder] = modTime.UnixNano()
+	previous, ok := f.previous[folder]
+	if ok && previous == modTime.UnixNano() {
+		f.skipped[folder] = true
+		return true
+	}
+	return false
+}
+
+// Skipped returns the number of folders whose files were skipped
+func (f *FolderTimes) Skipped() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.skipped)
+}
+
+// markFailed keeps the folder of an image that couldn't be indexed from being
+// recorded, so the next scan tries the image again
+func (f *FolderTimes) markFailed(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed[filepath.Dir(imageprocessor.SourceFile(path))] = true
+}
+
+// store records the times of the walked folders, except those with images
+// that failed
+func (f *FolderTimes) store(ctx context.Context, db *sql.DB, sourcePrefix string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	times := make(map[string]int64, len(f.current))
+	for folder, modTime := range f.current {
+		if !f.failed[folder] {
+			times[folder] = modTime
+		}
+	}
+	return database.StoreFolderTimes(ctx, db, sourcePrefix, times)
+}
@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+
+	"imagefinder/database"
+	"imagefinder/logging"
+)
+
+// sessionSettings are the scan options recorded in the scan history
+type sessionSettings struct {
+	Workers        int      `json:"workers,omitempty"`
+	Adaptive       bool     `json:"adaptive_workers,omitempty"`
+	MaxMemory      int64    `json:"max_memory,omitempty"`
+	RawWorkers     int      `json:"raw_workers,omitempty"`
+	Include        []string `json:"include,omitempty"`
+	Exclude        []string `json:"exclude,omitempty"`
+	IncludeHidden  bool     `json:"include_hidden,omitempty"`
+	MinSize        int64    `json:"min_size,omitempty"`
+	MaxSize        int64    `json:"max_size,omitempty"`
+	MinDimension   int      `json:"min_dimension,omitempty"`
+	MaxDepth       int      `json:"max_depth,omitempty"`
+	FollowSymlinks bool     `json:"follow_symlinks,omitempty"`
+	Archives       bool     `json:"archives,omitempty"`
+	SVG            bool     `json:"svg,omitempty"`
+	Thumbnails     bool     `json:"thumbnails,omitempty"`
+	ThumbnailSize  int      `json:"thumbnail_size,omitempty"`
+	Faces          string   `json:"faces,omitempty"` // Face model path
+	Colors         bool     `json:"colors,omitempty"`
+	Incremental    bool     `json:"incremental,omitempty"`
+}
+
+// settings returns the options to record in the scan history
+func (options ScanOptions) settings(workers int) sessionSettings {
+	settings := sessionSettings{
+		Workers:        workers,
+		Adaptive:       options.AdaptiveWorkers,
+		MaxMemory:      options.MaxMemory,
+		RawWorkers:     options.RawWorkers,
+		Include:        options.Include,
+		Exclude:        options.Exclude,
+		IncludeHidden:  options.IncludeHidden,
+		MinSize:        options.MinSize,
+		MaxSize:        options.MaxSize,
+		MinDimension:   options.MinDimension,
+		MaxDepth:       options.MaxDepth,
+		FollowSymlinks: options.FollowSymlinks,
+		Archives:       options.Archives,
+		SVG:            options.SVG,
+		Thumbnails:     options.Thumbnails,
+		Colors:         options.Colors,
+		Incremental:    options.Folders != nil,
+	}
+	if options.Thumbnails {
+		settings.ThumbnailSize = options.ThumbnailSize
+	}
+	if options.FaceDetector != nil {
+		settings.Faces = options.FaceDetector.ModelPath()
+	}
+	return settings
+}
+
+// recordSessionCounts adds the counts of this run to the scan history. They
+// are recorded even when the scan was interrupted.
+func recordSessionCounts(ctx context.Context, db *sql.DB, options ScanOptions, tracker *ProgressTracker) {
+	if options.SessionID == 0 {
+		return
+	}
+	tracker.mu.Lock()
+	total, processed, errors := tracker.totalFiles, tracker.processed, tracker.errors
+	tracker.mu.Unlock()
+
+	err := database.RecordSessionCounts(context.WithoutCancel(ctx), db, options.SessionID, total, processed, errors)
+	if err != nil {
+		logging.LogWarning("%v", err)
+	}
+}
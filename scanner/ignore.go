@@ -0,0 +1,136 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"imagefinder/logging"
+)
+
+// IgnoreFileName is the file whose patterns leave files of its folder and
+// the folders below it out of scans, with the syntax of .gitignore files
+const IgnoreFileName = ".imagefinderignore"
+
+// ignoreRule is a pattern line of an ignore file
+type ignoreRule struct {
+	segments []string // Pattern split at slashes
+	anchored bool     // Matched against the path relative to the ignore file's folder
+	dirOnly  bool     // Only matches folders (the pattern ended with a slash)
+	negate   bool     // Includes again what an earlier pattern ignored
+}
+
+// ignoreFiles reads the ignore files of a local folder tree on first use and
+// reports which files they leave out. It is safe for concurrent use.
+type ignoreFiles struct {
+	root  string
+	mu    sync.Mutex
+	rules map[string][]ignoreRule // By folder; nil when it has no ignore file
+}
+
+// newIgnoreFiles applies the ignore files found below root
+func newIgnoreFiles(root string) *ignoreFiles {
+	return &ignoreFiles{root: filepath.Clean(root), rules: make(map[string][]ignoreRule)}
+}
+
+// IsIgnored reports whether the ignore files of the folders from the root
+// down to the file leave it out. As with .gitignore, a file in an ignored
+// folder can't be included again.
+func (f *ignoreFiles) IsIgnored(filePath string) bool {
+	relative, err := filepath.Rel(f.root, filePath)
+	if err != nil || relative == "." || strings.HasPrefix(relative, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(relative), "/")
+
+	// Each folder on the way is checked first, then the file itself
+	for i := 1; i <= len(parts); i++ {
+		if f.matches(parts[:i], i < len(parts)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches applies the rules of the ignore files in the folders above the
+// relative path, the deepest and last matching rule deciding
+func (f *ignoreFiles) matches(parts []string, isDir bool) bool {
+	ignored := false
+	for depth := 0; depth < len(parts); depth++ {
+		folder := filepath.Join(append([]string{f.root}, parts[:depth]...)...)
+		for _, rule := range f.folderRules(folder) {
+			if rule.match(parts[depth:], isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// folderRules returns the rules of the ignore file in folder, reading it the
+// first time
+func (f *ignoreFiles) folderRules(folder string) []ignoreRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rules, ok := f.rules[folder]; ok {
+		return rules
+	}
+
+	data, err := os.ReadFile(filepath.Join(folder, IgnoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		logging.LogWarning("Cannot read %s: %v", filepath.Join(folder, IgnoreFileName), err)
+	}
+	rules := parseIgnoreRules(string(data))
+	if len(rules) > 0 {
+		logging.DebugLog("Applying %d patterns of %s", len(rules), filepath.Join(folder, IgnoreFileName))
+	}
+	f.rules[folder] = rules
+	return rules
+}
+
+// parseIgnoreRules reads the patterns of an ignore file, skipping blank lines
+// and # comments
+func parseIgnoreRules(content string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// A slash at the start or in the middle ties the pattern to the folder
+		// of the ignore file
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match reports whether the rule matches a path relative to the folder of
+// its ignore file
+func (r ignoreRule) match(parts []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return matchSegments(r.segments, parts[len(parts)-1:])
+	}
+	return matchSegments(r.segments, parts)
+}
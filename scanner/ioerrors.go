@@ -0,0 +1,144 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"imagefinder/logging"
+)
+
+// ioRetryBaseDelay is the wait before a file that failed with an I/O error is
+// read again; it doubles with each further retry
+const ioRetryBaseDelay = time.Second
+
+// ioProbeInterval is how often a scan skipping files after I/O errors reads
+// one file to check whether the folder is readable again
+const ioProbeInterval = 30 * time.Second
+
+// ioErrnos are the system errors of a failing disk or of a network mount
+// (NFS, SMB) whose server is unreachable
+var ioErrnos = []syscall.Errno{
+	syscall.EIO, syscall.ESTALE, syscall.EHOSTDOWN, syscall.EHOSTUNREACH,
+	syscall.ENOTCONN, syscall.ETIMEDOUT, syscall.ENETDOWN, syscall.ENETUNREACH,
+	syscall.ECONNRESET, syscall.ECONNABORTED,
+}
+
+// ioErrorMessages are the messages of ioErrnos, matched when an error was
+// wrapped as text and lost its cause
+var ioErrorMessages = []string{
+	"input/output error",
+	"stale file handle",
+	"stale nfs file handle",
+	"host is down",
+	"no route to host",
+	"is not connected",
+	"connection timed out",
+	"operation timed out",
+	"network is down",
+	"network is unreachable",
+	"connection reset by peer",
+	"connection aborted",
+}
+
+// isIOError reports whether err comes from the storage failing to read a file
+// rather than from the file's content, so reading it again may succeed
+func isIOError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, errno := range ioErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	message := strings.ToLower(err.Error())
+	for _, text := range ioErrorMessages {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitRetry waits before retry number attempt (from 1) of a file, and reports
+// false if ctx is done first
+func waitRetry(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(ioRetryBaseDelay << (attempt - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ioBreaker stops a scan from reading files once skipAfter files in a row
+// failed with I/O errors, as happens when a network mount goes away. While
+// tripped, one file is read every ioProbeInterval, and the first file that
+// reads without an I/O error lets the scan go on.
+type ioBreaker struct {
+	skipAfter int // 0 never trips
+
+	mu        sync.Mutex
+	failures  int       // Files in a row that failed with I/O errors
+	nextProbe time.Time // When the next file may be read while tripped
+}
+
+func newIOBreaker(skipAfter int) *ioBreaker {
+	return &ioBreaker{skipAfter: skipAfter}
+}
+
+// allow reports whether the next file should be read, or skipped because
+// the last files all failed with I/O errors
+func (b *ioBreaker) allow() bool {
+	if b.skipAfter == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.skipAfter {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.nextProbe) {
+		return false
+	}
+	b.nextProbe = now.Add(ioProbeInterval)
+	return true
+}
+
+// done records whether a file that was read failed with an I/O error
+func (b *ioBreaker) done(ioFailure bool) {
+	if b.skipAfter == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !ioFailure {
+		if b.failures >= b.skipAfter {
+			logging.LogWarning("Files are readable again after I/O errors, resuming the scan")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures == b.skipAfter {
+		logging.LogWarning("%d files in a row failed with I/O errors; skipping files, checking one every %v", b.failures, ioProbeInterval)
+	}
+	if b.failures >= b.skipAfter {
+		b.nextProbe = time.Now().Add(ioProbeInterval)
+	}
+}
+
+// skipped returns the error recorded for a file the breaker skipped
+func (b *ioBreaker) skipped() error {
+	return fmt.Errorf("skipped after %d files in a row failed with I/O errors", b.skipAfter)
+}
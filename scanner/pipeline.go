@@ -0,0 +1,238 @@
+package scanner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"imagefinder/imageprocessor"
+	"imagefinder/logging"
+	"imagefinder/scanner/processor"
+	"imagefinder/utils"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// statusInterval is how often the pipeline logs its state at the debug level
+const statusInterval = 10 * time.Second
+
+// pipeline indexes the files of a scan in three stages joined by bounded
+// channels: a producer lists the paths, workers hash the images, and a single
+// writer stores them, so the database is only written from one goroutine.
+type pipeline struct {
+	db           *sql.DB
+	options      ScanOptions
+	workers      *workerLimit
+	tuner        *workerTuner
+	tracker      *ProgressTracker
+	imgProcessor *processor.ImageProcessor
+	breaker      *ioBreaker
+
+	mu      sync.Mutex
+	claimed map[string]bool // Indexed paths a moved file was matched to
+}
+
+// runPipeline indexes the files of options and sends the result of each file
+// to results. Files are added to the totals of tracker as the walk finds
+// them, so the total grows until the walk is complete. Cancelling ctx stops the producer and the workers, and
+// interrupts the images in progress; images already hashed are still stored.
+// A failed walk stops the scan the same way. Every result has been sent when
+// it returns.
+func runPipeline(ctx context.Context, db *sql.DB, options ScanOptions, results chan<- ProcessImageResult, workers *workerLimit, tuner *workerTuner, tracker *ProgressTracker) error {
+	logging.DebugLog("Starting scan pipeline - folder: %s, source prefix: %s, force rewrite: %v, workers: %d",
+		options.FolderPath, options.SourcePrefix, options.ForceRewrite, workers.current())
+
+	imgProcessor := processor.NewImageProcessor(options.DebugMode)
+	defer imgProcessor.Close()
+	imgProcessor.UseRawMode(options.RawMode)
+	if options.PreviewCache != nil {
+		imgProcessor.UsePreviewCache(options.PreviewCache)
+	}
+
+	p := &pipeline{
+		db:           db,
+		options:      options,
+		workers:      workers,
+		tuner:        tuner,
+		tracker:      tracker,
+		imgProcessor: imgProcessor,
+		breaker:      newIOBreaker(options.IOSkipAfter),
+		claimed:      make(map[string]bool),
+	}
+
+	paths := make(chan string, workers.current())
+	processed := make(chan ProcessImageResult, workers.current())
+
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	defer stopMonitor()
+	go p.monitor(monitorCtx, paths, processed)
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		defer close(paths)
+		return p.produce(groupCtx, paths)
+	})
+	group.Go(func() error {
+		defer close(processed)
+		return p.process(groupCtx, paths, processed)
+	})
+	group.Go(func() error {
+		p.write(ctx, processed, results)
+		return nil
+	})
+
+	err := group.Wait()
+	if err != nil && ctx.Err() == nil {
+		logging.LogError("Error during directory scan: %v", err)
+	}
+	logging.DebugLog("Scan pipeline finished")
+	return err
+}
+
+// monitor logs how full the queues between the stages are, how many workers
+// are busy and the health of the process every statusInterval until ctx is
+// done, so a stalled scan shows which stage it waits on
+func (p *pipeline) monitor(ctx context.Context, paths chan string, processed chan ProcessImageResult) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logging.DebugLog("STATUS: Queues: paths=%d/%d hashed=%d/%d | Workers: %d busy of %d | Runtime: %s",
+				len(paths), cap(paths), len(processed), cap(processed),
+				p.workers.busy(), p.workers.current(), utils.ReadRuntimeHealth())
+		}
+	}
+}
+
+// produce walks the scanned folder and sends each path to index to paths as
+// it is found, counting it in the totals. The walk waits while the workers
+// are busy.
+func (p *pipeline) produce(ctx context.Context, paths chan<- string) error {
+	found := 0
+	scanStartTime := time.Now()
+	err := p.options.walk(ctx, imageprocessor.NewImageLoaderRegistry(), func(path string) error {
+		p.tracker.countFile(path)
+		select {
+		case paths <- path:
+			found++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	p.tracker.countingDone()
+	logging.DebugLog("Directory scan completed in %v, found %d files to process", time.Since(scanStartTime), found)
+	return nil
+}
+
+// process hashes the images of paths, running as many workers at once as the
+// worker limit allows, and sends their results to processed. Files not started
+// before ctx is done are left for the next scan.
+func (p *pipeline) process(ctx context.Context, paths <-chan string, processed chan<- ProcessImageResult) error {
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	for path := range paths {
+		if err := p.workers.acquire(ctx); err != nil {
+			return err
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			defer p.workers.release()
+
+			result := p.processFile(ctx, path)
+
+			// Images interrupted by cancellation aren't failures
+			if !result.Success && ctx.Err() != nil {
+				return
+			}
+			processed <- result
+		}()
+	}
+	return nil
+}
+
+// processFile hashes one image, turning a panic into a failed result. Files
+// of local folders failing with I/O errors are read again, and files are
+// skipped without reading them while the breaker is tripped.
+func (p *pipeline) processFile(ctx context.Context, path string) (result ProcessImageResult) {
+	isRawImage := imageprocessor.IsRawFormat(path)
+	isTifImage := imageprocessor.IsTiffFormat(path)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logging.LogError("Recovered from panic processing %s: %v\nStack: %s", path, r, string(debug.Stack()))
+			result = ProcessImageResult{
+				Path:   path,
+				Error:  fmt.Errorf("panic during image processing: %v", r),
+				Loader: p.imgProcessor.LoaderName(path),
+			}
+		}
+		result.IsRaw = isRawImage
+		result.IsTif = isTifImage
+	}()
+
+	if p.options.DebugMode {
+		logging.DebugLog("Processing file: %s", path)
+	}
+	if !p.breaker.allow() {
+		return ProcessImageResult{Path: path, Error: p.breaker.skipped()}
+	}
+	processStart := time.Now()
+	result = p.processImage(ctx, path)
+	// Remote sources retry their own requests
+	for attempt := 1; attempt <= p.options.IORetries && p.options.Source.IsLocal(); attempt++ {
+		if result.Success || !isIOError(result.Error) {
+			break
+		}
+		logging.LogWarning("I/O error reading %s (retry %d/%d in %v): %v", path, attempt, p.options.IORetries, ioRetryBaseDelay<<(attempt-1), result.Error)
+		if !waitRetry(ctx, attempt) {
+			break
+		}
+		result = p.processImage(ctx, path)
+	}
+	p.breaker.done(!result.Success && isIOError(result.Error))
+	p.tuner.fileDone(time.Since(processStart))
+	return result
+}
+
+// write stores the images the workers hashed, records the outcome of each
+// file and passes the results on. It returns once processed is closed.
+func (p *pipeline) write(ctx context.Context, processed <-chan ProcessImageResult, results chan<- ProcessImageResult) {
+	// Hashed images are stored even while the scan is being interrupted,
+	// rather than redone by the next scan
+	ctx = context.WithoutCancel(ctx)
+
+	for result := range processed {
+		if result.image != nil {
+			result = p.storeImage(ctx, result)
+		}
+		if !result.Success && p.options.Folders != nil {
+			p.options.Folders.markFailed(result.Path)
+		}
+		recordOutcome(ctx, p.db, p.options, result)
+		results <- result
+	}
+}
+
+// claimMovedImage reserves an indexed path for a file moved from it, and
+// reports whether no other file of the scan did so first
+func (p *pipeline) claimMovedImage(oldPath string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.claimed[oldPath] {
+		return false
+	}
+	p.claimed[oldPath] = true
+	return true
+}
@@ -1,11 +1,13 @@
 package processor
 
 import (
+	"context"
 	"fmt"
 	"runtime/debug"
 
 	"imagefinder/imageprocessor"
 	"imagefinder/logging"
+	"imagefinder/types"
 
 	"gocv.io/x/gocv"
 )
@@ -13,8 +15,9 @@ import (
 // ImageProcessor is an adapter that simplifies interactions between the scanner
 // and the imageprocessor package
 type ImageProcessor struct {
-	DebugMode    bool
-	registry     *imageprocessor.ImageLoaderRegistry
+	DebugMode bool
+	registry  *imageprocessor.ImageLoaderRegistry
+	metadata  *imageprocessor.MetadataExtractor
 }
 
 // NewImageProcessor creates a new ImageProcessor with appropriate configuration
@@ -22,11 +25,44 @@ func NewImageProcessor(debugMode bool) *ImageProcessor {
 	return &ImageProcessor{
 		DebugMode: debugMode,
 		registry:  imageprocessor.NewImageLoaderRegistry(),
+		metadata:  imageprocessor.NewMetadataExtractor(),
 	}
 }
 
-// ProcessImage loads and processes an image based on its type
-func (p *ImageProcessor) ProcessImage(path string, isRaw bool, isTiff bool) (gocv.Mat, error) {
+// Close releases the external tools held by the processor
+func (p *ImageProcessor) Close() {
+	p.metadata.Close()
+}
+
+// UseRawMode chooses which image of RAW files is decoded
+func (p *ImageProcessor) UseRawMode(mode types.RawMode) {
+	p.registry.UseRawMode(mode)
+}
+
+// UsePreviewCache makes RAW conversions reuse previews cached by earlier runs
+func (p *ImageProcessor) UsePreviewCache(cache *imageprocessor.PreviewCache) {
+	p.registry.UsePreviewCache(cache)
+}
+
+// LoaderName returns the name of the loader that reads the image at path
+func (p *ImageProcessor) LoaderName(path string) string {
+	return p.registry.LoaderName(path)
+}
+
+// LoaderIdentity names the loader of path and what else decides the pixels
+// its image is hashed from, to key cached conversions
+func (p *ImageProcessor) LoaderIdentity(path string) string {
+	return p.registry.LoaderIdentity(path)
+}
+
+// ExtractMetadata reads the EXIF metadata stored with the image
+func (p *ImageProcessor) ExtractMetadata(path string) imageprocessor.ImageMetadata {
+	return p.metadata.Extract(path)
+}
+
+// ProcessImage loads and processes an image based on its type. Cancelling ctx
+// stops the external tools used to convert RAW and other formats.
+func (p *ImageProcessor) ProcessImage(ctx context.Context, path string, isRaw bool, isTiff bool) (gocv.Mat, error) {
 	var img gocv.Mat
 	var err error
 
@@ -44,7 +80,7 @@ func (p *ImageProcessor) ProcessImage(path string, isRaw bool, isTiff bool) (goc
 	}()
 
 	// Load the image using the registry
-	img, err = p.registry.LoadImage(path)
+	img, err = p.registry.LoadImage(ctx, path)
 	if err != nil {
 		return gocv.NewMat(), fmt.Errorf("failed to load image %s: %v", path, err)
 	}
@@ -54,6 +90,9 @@ func (p *ImageProcessor) ProcessImage(path string, isRaw bool, isTiff bool) (goc
 		return img, fmt.Errorf("image is empty after loading: %s", path)
 	}
 
+	// Hash images as they are displayed, whatever way the camera stored them
+	img = imageprocessor.OrientImage(img, path)
+
 	// Log debug information if requested
 	if p.DebugMode {
 		format := "standard"
@@ -70,12 +109,12 @@ func (p *ImageProcessor) ProcessImage(path string, isRaw bool, isTiff bool) (goc
 
 // ComputeImageHashes computes both average and perceptual hashes for an image
 func (p *ImageProcessor) ComputeImageHashes(img gocv.Mat, path string, fileFormat string, isRaw bool, isTiff bool) (struct {
-	AvgHash string
-	PHash   string
+	AvgHash types.Hash
+	PHash   types.Hash
 }, error) {
 	var hashes struct {
-		AvgHash string
-		PHash   string
+		AvgHash types.Hash
+		PHash   types.Hash
 	}
 
 	// Compute average hash with improved error handling
@@ -114,4 +153,4 @@ func (p *ImageProcessor) IsRawFormat(path string) bool {
 // IsTiffFormat checks if the path is a TIFF format file
 func (p *ImageProcessor) IsTiffFormat(path string) bool {
 	return imageprocessor.IsTiffFormat(path)
-}
\ No newline at end of file
+}
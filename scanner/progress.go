@@ -7,18 +7,23 @@ import (
 	"imagefinder/logging"
 )
 
-// NewProgressTracker initializes the progress tracker
-func NewProgressTracker(stats FileStats, resultsChan chan ProcessImageResult) *ProgressTracker {
+// NewProgressTracker initializes the progress tracker. In quiet mode results
+// are still counted but no progress line is displayed; options.OnProgress is
+// called either way. The totals start at zero and grow with countFile.
+func NewProgressTracker(resultsChan chan ProcessImageResult, options ScanOptions) *ProgressTracker {
 	tracker := &ProgressTracker{
 		ticker:     time.NewTicker(500 * time.Millisecond),
 		done:       make(chan bool),
-		totalFiles: stats.totalFiles,
-		rawFiles:   stats.rawFiles,
-		tifFiles:   stats.tifFiles,
+		drained:    make(chan struct{}),
+		counting:   true,
+		quiet:      options.Quiet,
+		onProgress: options.OnProgress,
 	}
 
 	// Start progress display goroutine
-	go tracker.displayProgress()
+	if !tracker.quiet {
+		go tracker.displayProgress()
+	}
 
 	// Start result processor goroutine
 	go tracker.processResults(resultsChan)
@@ -34,12 +39,17 @@ func (p *ProgressTracker) displayProgress() {
 			return
 		case <-p.ticker.C:
 			p.mu.Lock()
+			// The total is marked as incomplete while files are being counted
+			total := fmt.Sprint(p.totalFiles)
+			if p.counting {
+				total += "+"
+			}
 			if p.errors > 0 {
-				fmt.Printf("\rProgress: %d/%d (Errors: %d, RAW: %d/%d, TIF: %d/%d)",
-					p.processed, p.totalFiles, p.errors, p.rawProcessed, p.rawFiles, p.tifProcessed, p.tifFiles)
+				fmt.Printf("\rProgress: %d/%s (Errors: %d, RAW: %d/%d, TIF: %d/%d)",
+					p.processed, total, p.errors, p.rawProcessed, p.rawFiles, p.tifProcessed, p.tifFiles)
 			} else {
-				fmt.Printf("\rProgress: %d/%d (RAW: %d/%d, TIF: %d/%d)",
-					p.processed, p.totalFiles, p.rawProcessed, p.rawFiles, p.tifProcessed, p.tifFiles)
+				fmt.Printf("\rProgress: %d/%s (RAW: %d/%d, TIF: %d/%d)",
+					p.processed, total, p.rawProcessed, p.rawFiles, p.tifProcessed, p.tifFiles)
 			}
 			p.mu.Unlock()
 		}
@@ -48,6 +58,7 @@ func (p *ProgressTracker) displayProgress() {
 
 // processResults updates the tracker state based on processing results
 func (p *ProgressTracker) processResults(resultsChan chan ProcessImageResult) {
+	defer close(p.drained)
 	for result := range resultsChan {
 		p.mu.Lock()
 		p.processed++
@@ -78,20 +89,61 @@ func (p *ProgressTracker) processResults(resultsChan chan ProcessImageResult) {
 			logging.LogImageProcessed(result.Path, true, "")
 		}
 
+		progress := Progress{Path: result.Path, Processed: p.processed, Errors: p.errors, Total: p.totalFiles}
+		if !result.Success {
+			progress.Error = result.Error
+		}
 		p.mu.Unlock()
+
+		if p.onProgress != nil {
+			p.onProgress(progress)
+		}
+	}
+}
+
+// countFile adds a file found by the count to the totals
+func (p *ProgressTracker) countFile(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totalFiles++
+	if IsRawFormat(path) {
+		p.rawFiles++
+	}
+	if IsTiffFormat(path) {
+		p.tifFiles++
 	}
 }
 
+// countingDone marks the totals as complete
+func (p *ProgressTracker) countingDone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counting = false
+	logging.DebugLog("Found %d image files to process (%d RAW files, %d TIF files)",
+		p.totalFiles, p.rawFiles, p.tifFiles)
+}
+
+// Wait blocks until the results channel is closed and every result sent on
+// it has been counted
+func (p *ProgressTracker) Wait() {
+	<-p.drained
+}
+
 // Stop ends the progress tracking
 func (p *ProgressTracker) Stop() {
 	p.ticker.Stop()
-	p.done <- true
+	if !p.quiet {
+		p.done <- true
+	}
 }
 
 // PrintStartupInfo displays information about the scan before starting
-func PrintStartupInfo(stats FileStats, options ScanOptions) {
-	fmt.Printf("Starting image indexing...\nTotal image files to process: %d (including %d RAW files and %d TIF files)\n",
-		stats.totalFiles, stats.rawFiles, stats.tifFiles)
+func PrintStartupInfo(options ScanOptions) {
+	if options.Quiet {
+		return
+	}
+
+	fmt.Printf("Starting image indexing...\n")
 	fmt.Printf("Force rewrite mode: %v\n", options.ForceRewrite)
 
 	if options.SourcePrefix != "" {
@@ -100,24 +152,38 @@ func PrintStartupInfo(stats FileStats, options ScanOptions) {
 
 	if options.DebugMode {
 		fmt.Printf("Debug mode: enabled\n")
-		logging.DebugLog("Found %d image files to process (%d RAW files, %d TIF files)",
-			stats.totalFiles, stats.rawFiles, stats.tifFiles)
 	}
 }
 
-// PrintCompletionStats displays statistics after scan completion
-func PrintCompletionStats(tracker *ProgressTracker, startTime time.Time, options ScanOptions) {
+// PrintCompletionStats displays statistics after the scan completes or is
+// interrupted
+func PrintCompletionStats(tracker *ProgressTracker, startTime time.Time, options ScanOptions, interrupted bool) {
 	elapsed := time.Since(startTime)
 
 	// Log final statistics
 	if options.DebugMode {
-		logging.DebugLog("Scan completed in %v. Processed: %d, Errors: %d, RAW files: %d, RAW errors: %d, TIF files: %d, TIF errors: %d",
-			elapsed, tracker.processed, tracker.errors, tracker.rawProcessed, tracker.rawErrors,
+		logging.DebugLog("Scan finished in %v (interrupted: %v). Processed: %d, Errors: %d, RAW files: %d, RAW errors: %d, TIF files: %d, TIF errors: %d",
+			elapsed, interrupted, tracker.processed, tracker.errors, tracker.rawProcessed, tracker.rawErrors,
 			tracker.tifProcessed, tracker.tifErrors)
 	}
 
-	fmt.Println("\nIndexing complete.")
-	fmt.Printf("Processed %d images in %v.\n", tracker.processed, elapsed.Round(time.Second))
+	if options.Quiet {
+		return
+	}
+
+	switch {
+	case interrupted && tracker.counting:
+		fmt.Println("\nIndexing interrupted.")
+		fmt.Printf("Processed %d images in %v; the rest are left for the next scan.\n",
+			tracker.processed, elapsed.Round(time.Second))
+	case interrupted:
+		fmt.Println("\nIndexing interrupted.")
+		fmt.Printf("Processed %d of %d images in %v; the rest are left for the next scan.\n",
+			tracker.processed, tracker.totalFiles, elapsed.Round(time.Second))
+	default:
+		fmt.Println("\nIndexing complete.")
+		fmt.Printf("Processed %d images in %v.\n", tracker.processed, elapsed.Round(time.Second))
+	}
 
 	if tracker.rawProcessed > 0 {
 		fmt.Printf("Successfully processed %d/%d RAW image files.\n",
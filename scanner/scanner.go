@@ -1,750 +1,537 @@
 package scanner
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
-	"path/filepath"
-	"runtime/debug"
-	"sync"
+	"slices"
 	"time"
 
 	"imagefinder/database"
 	"imagefinder/imageprocessor"
 	"imagefinder/logging"
-	"imagefinder/scanner/processor"
+	"imagefinder/source"
 	"imagefinder/types"
+	"imagefinder/utils"
+
+	"gocv.io/x/gocv"
 )
 
-// ScanAndStoreFolder scans a folder and stores image information in the database
-func ScanAndStoreFolder(db *sql.DB, options ScanOptions) error {
+// ScanAndStoreFolder scans a folder and stores image information in the
+// database. Cancelling ctx stops queueing files and interrupts the external
+// tools converting the images in progress; images already hashed are still
+// stored and counted before it returns ctx.Err().
+func ScanAndStoreFolder(ctx context.Context, db *sql.DB, options ScanOptions) error {
+	// Open the folder or bucket being scanned unless the caller already did
+	if options.Source == nil {
+		src, err := source.Open(options.FolderPath)
+		if err != nil {
+			return err
+		}
+		options.Source = src
+	}
+
+	// Folder owners can leave files out with ignore files in local folders
+	if options.Source.IsLocal() {
+		options.ignores = newIgnoreFiles(options.Source.Root())
+	}
+
+	// Files indexed by an interrupted run of this session aren't redone
+	if options.SessionID != 0 {
+		completed, err := database.CompletedSessionFiles(ctx, db, options.SessionID)
+		if err != nil {
+			return err
+		}
+		options.completed = completed
+		logging.DebugLog("Scan session %d has %d completed files", options.SessionID, len(completed))
+	}
+
+	// Errors of files that failed before are cleared once they are indexed
+	failed, err := database.FailedPaths(ctx, db, options.SourcePrefix)
+	if err != nil {
+		return err
+	}
+	options.failed = failed
+
+	options.memory = imageprocessor.NewMemoryBudget(options.MaxMemory)
+	options.conversions = imageprocessor.NewConversionLimit(options.RawWorkers)
+
 	// Determine concurrency limit
 	maxWorkers := 8 // Default
 	if options.MaxWorkers > 0 {
 		maxWorkers = options.MaxWorkers
 	}
 
+	// Keep the options of this run in the scan history
+	if options.SessionID != 0 {
+		if err := database.RecordSessionSettings(ctx, db, options.SessionID, options.settings(maxWorkers)); err != nil {
+			logging.LogWarning("%v", err)
+		}
+	}
+
 	// Initialize components for parallel processing
-	var wg sync.WaitGroup
-	resultsChan := make(chan ProcessImageResult, maxWorkers*2) // Buffer size increased
-	semaphore := make(chan struct{}, maxWorkers)
+	resultsChan := make(chan ProcessImageResult, maxWorkers*2)
+	workers := newWorkerLimit(maxWorkers)
 
-	// Count and classify files before processing
-	fileStats := countFilesToProcess(options)
+	// Adaptive scans start at maxWorkers and move from there
+	var tuner *workerTuner
+	if options.AdaptiveWorkers {
+		tuner = newWorkerTuner(workers)
+	}
+	if tuner != nil {
+		tuneCtx, stopTuning := context.WithCancel(ctx)
+		defer stopTuning()
+		go tuner.run(tuneCtx)
+	}
 
 	// Display initial information
-	PrintStartupInfo(fileStats, options)
+	PrintStartupInfo(options)
 
 	// Set up progress tracking
-	progressTracker := NewProgressTracker(fileStats, resultsChan)
+	progressTracker := NewProgressTracker(resultsChan, options)
 	defer progressTracker.Stop()
 
 	// Process files
 	startTime := time.Now()
-	err := walkAndProcessFiles(db, options, &wg, resultsChan, semaphore)
+	err = runPipeline(ctx, db, options, resultsChan, workers, tuner, progressTracker)
 
-	// Wait for all processing to complete
-	wg.Wait()
+	// Every result was sent once the pipeline returns; wait for the tracker
+	// to count those still buffered
 	close(resultsChan)
-
-	// Wait a short time for the result processor to finish
-	time.Sleep(100 * time.Millisecond)
-
-	// Clean up
-	close(semaphore)
+	progressTracker.Wait()
 
 	// Print final statistics
-	PrintCompletionStats(progressTracker, startTime, options)
+	PrintCompletionStats(progressTracker, startTime, options, ctx.Err() != nil)
+	if tuner != nil && !options.Quiet {
+		if lowest, highest, final := tuner.summary(); lowest != highest {
+			fmt.Printf("Workers were adjusted between %d and %d, ending with %d.\n", lowest, highest, final)
+		}
+	}
+	recordSessionCounts(ctx, db, options, progressTracker)
+
+	// Folder times are only recorded once every file in them was handled
+	if err == nil && ctx.Err() == nil && options.Folders != nil {
+		if err := options.Folders.store(ctx, db, options.SourcePrefix); err != nil {
+			logging.LogWarning("%v", err)
+		}
+	}
 
 	return err
 }
 
-// countFilesToProcess counts and classifies files to be processed
-func countFilesToProcess(options ScanOptions) FileStats {
-	stats := FileStats{}
-	loaderRegistry := imageprocessor.NewImageLoaderRegistry() // Use root registry directly
-
-	if options.DebugMode {
-		logging.DebugLog("Starting image scan on folder: %s", options.FolderPath)
-		logging.DebugLog("Force rewrite: %v, Source prefix: %s", options.ForceRewrite, options.SourcePrefix)
+// walk calls fn with each path to index: options.Paths if set, otherwise the
+// files of options.Files or those found by walking the source, with documents
+// contributing one entry per page and archives one per image. An error from
+// fn stops the walk.
+func (options ScanOptions) walk(ctx context.Context, loaderRegistry *imageprocessor.ImageLoaderRegistry, fn func(path string) error) error {
+	if options.Paths != nil {
+		for _, path := range options.Paths {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(path); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
-	filepath.Walk(options.FolderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			logging.LogError("Error accessing path %s: %v", path, err)
-			return nil
+	visit := func(info source.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-
-		if info == nil || info.IsDir() {
-			return nil
+		for _, path := range indexPaths(info, options, loaderRegistry) {
+			if err := fn(path); err != nil {
+				return err
+			}
 		}
-
-		// Check if this is an image file we can process
-		if loaderRegistry.CanLoadFile(path) || IsImageFile(path) {
-			stats.totalFiles++
-
-			// Check if it's a RAW file
-			if IsRawFormat(path) {
-				stats.rawFiles++
+		return nil
+	}
+	if options.Files != nil {
+		for _, file := range options.Files {
+			info, err := options.Source.Stat(file)
+			if err != nil {
+				logging.DebugLog("Skipping listed file %s: %v", file, err)
+				continue
 			}
-
-			// Check if it's a TIF file
-			if IsTiffFormat(path) {
-				stats.tifFiles++
+			if err := visit(info); err != nil {
+				return err
 			}
 		}
 		return nil
-	})
+	}
+	return options.Source.Walk(options.walkOptions(), visit)
+}
 
-	return stats
+// walkOptions returns the limits of the walk over the scanned folder
+func (options ScanOptions) walkOptions() source.WalkOptions {
+	walkOptions := source.WalkOptions{MaxDepth: options.MaxDepth, FollowSymlinks: options.FollowSymlinks}
+	if options.Folders != nil {
+		walkOptions.SkipFiles = options.Folders.SkipFiles
+	}
+	return walkOptions
 }
 
-func walkAndProcessFiles(db *sql.DB, options ScanOptions, wg *sync.WaitGroup, resultsChan chan ProcessImageResult, semaphore chan struct{}) error {
-	logging.DebugLog("Starting walkAndProcessFiles - folder: %s, debug: %t, semaphore capacity: %d",
-		options.FolderPath, options.DebugMode, cap(semaphore))
-
-	// Track statistics for reporting with enhanced semaphore tracking
-	stats := struct {
-		sync.Mutex
-		filesFound            int
-		filesProcessed        int
-		filesSkipped          int
-		errorCount            int
-		rawCount              int
-		tifCount              int
-		bufferOverflows       int
-		resultsSent           int
-		resultsForwarded      int
-		sendTimeouts          int
-		semaphoreAcquisitions int
-		semaphoreReleases     int
-		semaphoreTimeouts     int
-		semaphoreAbandonments int
-	}{}
-
-	// Create image processor from our new package
-	imgProcessor := processor.NewImageProcessor(options.DebugMode)
-	logging.DebugLog("Image processor created")
-
-	// Create registry to identify image files
-	loaderRegistry := imageprocessor.NewImageLoaderRegistry()
-
-	logging.DebugLog("Image loader registry created")
-
-	// Create a properly sized results buffer
-	bufferSize := 1000 // Moderate buffer size
-	resultsBuffer := make(chan ProcessImageResult, bufferSize)
-	logging.DebugLog("Created results buffer with size: %d", bufferSize)
-
-	// Create channels for coordination
-	forwarderDone := make(chan struct{})
-	resultsForwarderCompleted := make(chan struct{})
-
-	// Add a buffer status monitor
-	bufferMonitorDone := make(chan struct{})
-	go func() {
-		defer close(bufferMonitorDone)
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				bufferLen := len(resultsBuffer)
-				bufferCap := cap(resultsBuffer)
-				usage := float64(bufferLen) / float64(bufferCap) * 100
-
-				stats.Lock()
-				semaphoreDiff := stats.semaphoreAcquisitions - stats.semaphoreReleases
-				statsSnapshot := struct {
-					found, processed, sent, forwarded, semAcq, semRel, semDiff, semTimeouts, semAbandoned int
-				}{
-					stats.filesFound,
-					stats.filesProcessed,
-					stats.resultsSent,
-					stats.resultsForwarded,
-					stats.semaphoreAcquisitions,
-					stats.semaphoreReleases,
-					semaphoreDiff,
-					stats.semaphoreTimeouts,
-					stats.semaphoreAbandonments,
-				}
-				stats.Unlock()
-
-				logging.DebugLog("STATUS: Buffer: %d/%d (%.1f%%) | Files: found=%d processed=%d | Results: sent=%d forwarded=%d | Semaphore: acq=%d rel=%d diff=%d timeouts=%d abandoned=%d",
-					bufferLen, bufferCap, usage,
-					statsSnapshot.found, statsSnapshot.processed,
-					statsSnapshot.sent, statsSnapshot.forwarded,
-					statsSnapshot.semAcq, statsSnapshot.semRel, statsSnapshot.semDiff,
-					statsSnapshot.semTimeouts, statsSnapshot.semAbandoned)
-
-				// Check for potential leaks
-				if statsSnapshot.semDiff > 0 && statsSnapshot.semDiff >= cap(semaphore)/2 {
-					logging.LogError("WARNING: Potential semaphore leak detected - %d more acquisitions than releases",
-						statsSnapshot.semDiff)
-				}
-
-				if usage > 80 {
-					logging.LogError("WARNING: Results buffer is getting full (%d/%d, %.1f%%)",
-						bufferLen, bufferCap, usage)
-				}
-			case <-forwarderDone:
-				return
-			}
+// indexPaths returns the paths to index for a file listed by the scan source,
+// or nil if the file can't be processed
+func indexPaths(info source.FileInfo, options ScanOptions, loaderRegistry *imageprocessor.ImageLoaderRegistry) []string {
+	path := info.Path
+	if IsFiltered(path, options.Source.Root(), options.Include, options.Exclude) {
+		return nil
+	}
+	// Listed files were chosen by the caller
+	if options.Files == nil {
+		if !options.IncludeHidden && IsHidden(path, options.Source.Root()) {
+			return nil
 		}
-	}()
-
-	// Start improved result forwarder
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				stack := debug.Stack()
-				logging.LogError("Result forwarder goroutine panicked: %v\nStack: %s", r, string(stack))
-			}
-			logging.DebugLog("Result forwarder goroutine completed")
-			close(resultsForwarderCompleted)
-		}()
-
-		logging.DebugLog("Result forwarder goroutine started")
-		forwardCount := 0
-		timeoutCount := 0
-		consecutiveTimeouts := 0
-		maxConsecutiveTimeouts := 5
-
-		forwarderRunning := true
-		for forwarderRunning {
-			select {
-			case result, ok := <-resultsBuffer:
-				if !ok {
-					logging.DebugLog("Results buffer channel closed, exiting forwarder")
-					forwarderRunning = false
-					break
-				}
-
-				logging.DebugLog("Attempting to forward result #%d for: %s", forwardCount+1, result.Path)
-
-				// Use tiered timeout approach for forwarding
-				sendStart := time.Now()
-				sendSuccess := false
-
-				// Try a quick send first, then gradually increase timeout
-				for _, timeout := range []time.Duration{500 * time.Millisecond, 1 * time.Second, 2 * time.Second} {
-					select {
-					case resultsChan <- result:
-						consecutiveTimeouts = 0 // Reset on success
-						forwardCount++
-						stats.Lock()
-						stats.resultsForwarded++
-						stats.Unlock()
-						sendSuccess = true
-
-						logging.DebugLog("Successfully forwarded result #%d for: %s",
-							forwardCount, result.Path)
-						break
-					case <-time.After(timeout):
-						// Try next timeout level
-						logging.DebugLog("Forward timeout at %v for: %s, trying longer timeout",
-							timeout, result.Path)
-						continue
-					case <-forwarderDone:
-						logging.DebugLog("Received stop signal in forwarder while forwarding %s", result.Path)
-						forwarderRunning = false
-						break
-					}
-
-					if sendSuccess || !forwarderRunning {
-						break
-					}
-				}
-
-				if !sendSuccess && forwarderRunning {
-					timeoutCount++
-					consecutiveTimeouts++
-					stats.Lock()
-					stats.sendTimeouts++
-					stats.Unlock()
-
-					logging.LogError("TIMEOUT (#%d) sending result for %s after %v: result channel blocked",
-						timeoutCount, result.Path, time.Since(sendStart))
-
-					if consecutiveTimeouts >= maxConsecutiveTimeouts {
-						logging.LogError("CRITICAL: %d consecutive timeouts in forwarder - potential deadlock",
-							consecutiveTimeouts)
-					}
-				}
-
-			case <-forwarderDone:
-				logging.DebugLog("Received stop signal in forwarder")
-				forwarderRunning = false
-				break
-			}
+		if options.ignores != nil && options.ignores.IsIgnored(path) {
+			return nil
 		}
+	}
+	if !options.SVG && imageprocessor.IsSVGFormat(path) {
+		return nil
+	}
 
-		logging.DebugLog("Result forwarder finished. Forwarded: %d, Timeouts: %d", forwardCount, timeoutCount)
-	}()
-
-	// Track worker goroutine status
-	workerStatus := struct {
-		sync.Mutex
-		active   int
-		complete int
-		failed   int
-	}{}
+	// The size range applies to image files; archives hold images of any size
+	isArchive := options.Archives && imageprocessor.IsArchiveFile(path)
+	if !isArchive && IsOutsideSizeRange(info.Size, options.MinSize, options.MaxSize) {
+		return nil
+	}
 
-	// Create a WaitGroup specific for file processing workers
-	var fileWorkersWg sync.WaitGroup
+	// Remote files are only downloaded when processed, so documents and
+	// archives, which must be read to list their pages and entries, are skipped
+	if !options.Source.IsLocal() {
+		if imageprocessor.IsImageFile(path) && !imageprocessor.IsPDFFormat(path) {
+			return []string{path}
+		}
+		return nil
+	}
 
-	// Collect all files first before processing
-	var filesToProcess []string
-	logging.DebugLog("Starting directory scan to collect files: %s", options.FolderPath)
-	scanStartTime := time.Now()
+	if !isArchive && !loaderRegistry.CanLoadFile(path) && !imageprocessor.IsImageFile(path) {
+		return nil
+	}
+	paths := imageprocessor.ExpandImagePaths(path)
+	if isArchive && !options.SVG {
+		paths = slices.DeleteFunc(paths, imageprocessor.IsSVGFormat)
+	}
+	return paths
+}
 
-	err := filepath.Walk(options.FolderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if options.DebugMode {
-				logging.DebugLog("Failed to access path %s: %v", path, err)
-				logging.LogError("Failed to access path %s: %v", path, err)
-			}
+// processImage hashes a single image for the writer to store. Files that
+// need no update succeed without an image to store.
+func (p *pipeline) processImage(ctx context.Context, path string) ProcessImageResult {
+	db, options, sourcePrefix := p.db, p.options, p.options.SourcePrefix
+	result := ProcessImageResult{
+		Path:    path,
+		Success: false,
+	}
 
-			stats.Lock()
-			stats.errorCount++
-			stats.Unlock()
+	// Skip files the scan session completed before it was interrupted
+	if options.completed[path] {
+		result.Success = true
+		return result
+	}
 
-			return nil // Continue with other files
+	// Skip processing if the image already exists and hasn't been modified
+	if !options.ForceRewrite {
+		if skipResult := checkAndSkipIfUnchanged(ctx, db, path, sourcePrefix, options); skipResult != nil {
+			return *skipResult
 		}
+	}
 
-		// Skip directories
-		if info == nil || info.IsDir() {
-			return nil
-		}
+	// Get file info and format (PDF pages and archive entries share the
+	// modification time of the file that contains them)
+	fileInfo, err := options.Source.Stat(imageprocessor.SourceFile(path))
+	if err != nil {
+		result.Error = fmt.Errorf("cannot stat file %s: %v", path, err)
+		return result
+	}
+	fileSize := fileInfo.Size
+
+	// Remote objects are downloaded first
+	fetchedPath, release, err := options.Source.Fetch(path)
+	if err != nil {
+		result.Error = fmt.Errorf("cannot fetch %s: %v", path, err)
+		return result
+	}
+	defer release()
 
-		// Add to found files counter
-		stats.Lock()
-		stats.filesFound++
-		currentFound := stats.filesFound
-		stats.Unlock()
+	// Archive entries are streamed to a temporary file that the loaders can read
+	localPath, cleanup, err := imageprocessor.LocalCopy(fetchedPath)
+	if err != nil {
+		result.Error = fmt.Errorf("cannot extract %s: %v", path, err)
+		return result
+	}
+	defer cleanup()
+	if localPath != fetchedPath {
+		if localInfo, err := os.Stat(localPath); err == nil {
+			fileSize = localInfo.Size()
+		}
+	}
 
-		// Log progress periodically
-		if options.DebugMode && currentFound%1000 == 0 {
-			logging.DebugLog("Found %d files so far during scan", currentFound)
+	// PDF pages share their document's file, so only whole files get a checksum
+	checksum := ""
+	if imageprocessor.SourceFile(localPath) == localPath {
+		checksum, err = utils.FileChecksum(localPath)
+		if err != nil {
+			result.Error = fmt.Errorf("cannot compute checksum of %s: %v", path, err)
+			return result
 		}
+	}
 
-		// Skip files that we can't handle
-		if !loaderRegistry.CanLoadFile(path) && !imageprocessor.IsImageFile(path) {
-			if options.DebugMode {
-				logging.DebugLog("Skipping non-image file: %s", path)
+	// A file moved or renamed within a local folder keeps its index entry
+	if checksum != "" && !options.ForceRewrite && options.Source.IsLocal() {
+		if oldPath := findMovedImage(ctx, db, path, sourcePrefix, checksum, p.claimMovedImage); oldPath != "" {
+			result.Success = true
+			result.image = &indexedImage{
+				info: types.ImageInfo{
+					Path:         path,
+					SourcePrefix: sourcePrefix,
+					ModifiedAt:   fileInfo.ModTime.Format(time.RFC3339),
+					Size:         fileSize,
+				},
+				movedFrom: oldPath,
 			}
-
-			stats.Lock()
-			stats.filesSkipped++
-			stats.Unlock()
-
-			return nil
+			return result
 		}
+	}
 
-		// Add path to the list
-		filesToProcess = append(filesToProcess, path)
-
-		return nil
-	})
-
-	scanDuration := time.Since(scanStartTime)
-	logging.DebugLog("Directory scan completed in %v, found %d files to process",
-		scanDuration, len(filesToProcess))
+	fileFormat := string(imageprocessor.GetFileFormat(path))
+	isRawImage := imageprocessor.IsRawFormat(path)
+	isTifImage := imageprocessor.IsTiffFormat(path)
+	result.Loader = p.imgProcessor.LoaderName(localPath)
 
-	if err != nil {
-		logging.LogError("Error during directory scan: %v", err)
-		return err
+	// Content decoded before by the same loader, under another prefix or by
+	// an earlier scan, reuses that conversion unless its pixels are needed
+	loaderIdentity := ""
+	if checksum != "" {
+		loaderIdentity = p.imgProcessor.LoaderIdentity(localPath)
+	}
+	conversion, converted := database.Conversion{}, false
+	if loaderIdentity != "" && !options.Reconvert && !options.needsPixels() {
+		cached, found, err := database.LookupConversion(ctx, db, checksum, loaderIdentity)
+		if err != nil {
+			logging.LogWarning("%v", err)
+		}
+		if found {
+			logging.DebugLog("Reusing the conversion of %s by %s", path, loaderIdentity)
+			conversion, converted = cached, true
+			loaderIdentity = "" // Nothing new to cache
+		}
 	}
 
-	// Process files in chunks to control concurrency
-	chunkSize := 100 // Process this many files at a time
-	totalFiles := len(filesToProcess)
-
-	logging.DebugLog("Processing %d files in chunks of %d", totalFiles, chunkSize)
+	img := gocv.NewMat()
+	defer img.Close()
+	var colors *imageprocessor.ColorCapture
+	if !converted {
+		// RAW conversions first wait for one of their own slots, so
+		// a waiting RAW file holds no memory budget
+		if isRawImage {
+			releaseConversion, err := options.conversions.Acquire(ctx)
+			if err != nil {
+				result.Error = err
+				return result
+			}
+			defer releaseConversion()
+		}
 
-	// Process files in chunks
-	for chunkStart := 0; chunkStart < totalFiles; chunkStart += chunkSize {
-		chunkEnd := chunkStart + chunkSize
-		if chunkEnd > totalFiles {
-			chunkEnd = totalFiles
+		// Wait until the decoded image fits in the memory budget; it is given
+		// back once the image is closed
+		releaseMemory, err := options.memory.Acquire(ctx, imageprocessor.EstimateDecodeMemory(localPath, fileSize))
+		if err != nil {
+			result.Error = err
+			return result
 		}
+		defer releaseMemory()
 
-		currentChunk := filesToProcess[chunkStart:chunkEnd]
-		logging.DebugLog("Processing chunk %d-%d of %d files", chunkStart+1, chunkEnd, totalFiles)
+		// Loaders decode in grayscale, so dominant colors need a color copy
+		loadCtx := ctx
+		if options.Colors {
+			loadCtx, colors = imageprocessor.CaptureColor(ctx)
+			defer colors.Close()
+		}
 
-		// Create worker goroutines for this chunk
-		for fileIndex, filePath := range currentChunk {
-			// Add to main WaitGroup
-			wg.Add(1)
+		// Load and process the image
+		img.Close()
+		img, err = p.imgProcessor.ProcessImage(loadCtx, localPath, isRawImage, isTifImage)
+		if err != nil {
+			result.Error = fmt.Errorf("failed to load image %s: %v", path, err)
+			return result
+		}
 
-			// Also add to file workers WaitGroup
-			fileWorkersWg.Add(1)
+		// Skip empty images
+		if img.Empty() {
+			result.Error = fmt.Errorf("image is empty after loading: %s", path)
+			return result
+		}
 
-			// Track active workers
-			workerStatus.Lock()
-			workerStatus.active++
-			activeWorkers := workerStatus.active
-			workerStatus.Unlock()
+		// Compute hashes
+		imageHashes, err := p.imgProcessor.ComputeImageHashes(img, path, fileFormat, isRawImage, isTifImage)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		conversion = database.Conversion{
+			Width:          img.Cols(),
+			Height:         img.Rows(),
+			AverageHash:    imageHashes.AvgHash,
+			PerceptualHash: imageHashes.PHash,
+		}
+	}
 
-			if options.DebugMode && activeWorkers%10 == 0 {
-				logging.DebugLog("Currently %d active worker goroutines", activeWorkers)
+	// Icons and thumbnails are left out, but their conversion is kept so the
+	// next scan doesn't decode them again
+	if conversion.Width < options.MinDimension || conversion.Height < options.MinDimension {
+		logging.DebugLog("Skipping %s: %dx%d is below --min-dimension=%d", path, conversion.Width, conversion.Height, options.MinDimension)
+		result.Success = true
+		if loaderIdentity != "" {
+			result.image = &indexedImage{
+				info:           types.ImageInfo{Path: path, SourcePrefix: sourcePrefix, Checksum: checksum},
+				tooSmall:       true,
+				loaderIdentity: loaderIdentity,
+				conversion:     conversion,
 			}
+		}
+		return result
+	}
 
-			// Global file number (for logging)
-			fileNum := chunkStart + fileIndex + 1
-
-			// Launch worker goroutine with better timeout handling
-			go func(filePath string, fileNum int) {
-				startTime := time.Now()
-
-				// Mark worker as complete when done
-				defer func() {
-					workerStatus.Lock()
-					workerStatus.active--
-					workerStatus.complete++
-					completeCount := workerStatus.complete
-					workerStatus.Unlock()
-
-					if options.DebugMode && completeCount%100 == 0 {
-						logging.DebugLog("Completed %d/%d files so far", completeCount, totalFiles)
-					}
-
-					// Notify both WaitGroups when done
-					fileWorkersWg.Done()
-					wg.Done()
-				}()
-
-				if options.DebugMode {
-					logging.DebugLog("Starting worker for file #%d: %s", fileNum, filePath)
-				}
-
-				// Acquire semaphore with timeout to prevent deadlock
-				if options.DebugMode {
-					logging.DebugLog("Worker #%d waiting for semaphore", fileNum)
-				}
-
-				// Use timeout when acquiring semaphore to prevent deadlocks
-				semaphoreAcquired := false
-
-				// Track semaphore acquisition attempt
-				stats.Lock()
-				stats.Unlock()
-
-				for i := 0; i < 3; i++ { // Try 3 times
-					select {
-					case semaphore <- struct{}{}:
-						semaphoreAcquired = true
-
-						// Track successful acquisition
-						stats.Lock()
-						stats.semaphoreAcquisitions++
-						stats.Unlock()
-
-						if options.DebugMode {
-							logging.DebugLog("Worker #%d acquired semaphore", fileNum)
-						}
-						break
-					case <-time.After(3 * time.Second):
-						stats.Lock()
-						stats.semaphoreTimeouts++
-						stats.Unlock()
-
-						logging.LogError("Worker #%d timed out waiting for semaphore (attempt %d/3)",
-							fileNum, i+1)
-					}
-					if semaphoreAcquired {
-						break
-					}
-				}
-
-				if !semaphoreAcquired {
-					stats.Lock()
-					stats.semaphoreAbandonments++
-					stats.Unlock()
-
-					logging.LogError("Worker #%d FAILED to acquire semaphore after 3 attempts - ABANDONING", fileNum)
-					workerStatus.Lock()
-					workerStatus.failed++
-					workerStatus.Unlock()
-					return
-				}
-
-				// Ensure semaphore is released even if processing panics
-				defer func() {
-					<-semaphore
-
-					// Track release
-					stats.Lock()
-					stats.semaphoreReleases++
-					stats.Unlock()
-
-					if options.DebugMode {
-						logging.DebugLog("Worker #%d released semaphore", fileNum)
-					}
-				}()
-
-				// Check file type
-				isRawImage := imageprocessor.IsRawFormat(filePath)
-				isTifImage := imageprocessor.IsTiffFormat(filePath)
-
-				if isRawImage {
-					stats.Lock()
-					stats.rawCount++
-					stats.Unlock()
-				}
-
-				if isTifImage {
-					stats.Lock()
-					stats.tifCount++
-					stats.Unlock()
-				}
-
-				// Process image with panic recovery
-				var result ProcessImageResult
-
-				func() {
-					// Use defer to catch panics
-					defer func() {
-						if r := recover(); r != nil {
-							stack := debug.Stack()
-							err := fmt.Errorf("panic during image processing: %v", r)
-							result = ProcessImageResult{
-								Path:    filePath,
-								Success: false,
-								Error:   err,
-								IsRaw:   isRawImage,
-								IsTif:   isTifImage,
-							}
-							logging.LogError("Recovered from panic processing #%d %s: %v\nStack: %s",
-								fileNum, filePath, r, string(stack))
-
-							workerStatus.Lock()
-							workerStatus.failed++
-							workerStatus.Unlock()
-						}
-					}()
-
-					// Process the image
-					if options.DebugMode {
-						logging.DebugLog("Processing file #%d: %s", fileNum, filePath)
-					}
-
-					result = processAndStoreImage(db, filePath, options.SourcePrefix, options, imgProcessor)
-					result.IsRaw = isRawImage
-					result.IsTif = isTifImage
-
-					if options.DebugMode {
-						logging.DebugLog("Completed processing file #%d: %s, success: %t",
-							fileNum, filePath, result.Success)
-					}
-
-					// Log the processed image result
-					if result.Success {
-						logging.LogImageProcessed(filePath, true, "")
-					} else if result.Error != nil {
-						logging.LogImageProcessed(filePath, false, result.Error.Error())
-					}
-				}()
-
-				// Track statistics
-				stats.Lock()
-				stats.filesProcessed++
-				stats.Unlock()
-
-				// Use tiered retry approach for sending to buffer
-				if options.DebugMode {
-					logging.DebugLog("Worker #%d attempting to send result to buffer", fileNum)
-				}
-
-				sendStartTime := time.Now()
-				sendSuccess := false
-
-				// Try multiple times with increasing timeouts
-				for attemptNum, timeout := range []time.Duration{
-					100 * time.Millisecond,
-					300 * time.Millisecond,
-					500 * time.Millisecond,
-					1 * time.Second,
-				} {
-					select {
-					case resultsBuffer <- result:
-						sendSuccess = true
-
-						if options.DebugMode {
-							elapsed := time.Since(sendStartTime)
-							logging.DebugLog("Worker #%d successfully sent to buffer (attempt %d, after %v)",
-								fileNum, attemptNum+1, elapsed)
-						}
-
-						stats.Lock()
-						stats.resultsSent++
-						sentCount := stats.resultsSent
-						stats.Unlock()
-
-						if options.DebugMode && sentCount%100 == 0 {
-							logging.DebugLog("Sent %d results to buffer so far", sentCount)
-						}
-						break
-					case <-time.After(timeout):
-						if options.DebugMode {
-							logging.DebugLog("Worker #%d timeout #%d (%v) sending to buffer - retrying...",
-								fileNum, attemptNum+1, timeout)
-						}
-						continue
-					}
-
-					if sendSuccess {
-						break
-					}
-				}
-
-				if !sendSuccess {
-					stats.Lock()
-					stats.bufferOverflows++
-					stats.Unlock()
-
-					logging.LogError("Worker #%d FAILED to send to buffer after multiple retries - DROPPING RESULT",
-						fileNum)
-				}
-
-				elapsed := time.Since(startTime)
-				if options.DebugMode {
-					logging.DebugLog("Worker for file #%d: %s completed in %v", fileNum, filePath, elapsed)
-				}
-			}(filePath, fileNum)
-		}
-
-		// Wait for all workers in this chunk to complete
-		chunkWaitStart := time.Now()
-		logging.DebugLog("Waiting for chunk workers to complete...")
-		fileWorkersWg.Wait()
-		logging.DebugLog("Chunk processing completed in %v", time.Since(chunkWaitStart))
-	}
-
-	logging.DebugLog("All file processors completed")
-
-	// Signal the forwarder to stop and wait for it to finish
-	logging.DebugLog("Signaling result forwarder to stop")
-	close(forwarderDone)
-
-	// Close the results buffer - this will cause the forwarder to exit
-	logging.DebugLog("Closing results buffer")
-	close(resultsBuffer)
-
-	// Wait for the forwarder to complete with timeout
-	logging.DebugLog("Waiting for result forwarder to complete")
-	select {
-	case <-resultsForwarderCompleted:
-		logging.DebugLog("Result forwarder completed normally")
-	case <-time.After(30 * time.Second):
-		logging.LogError("TIMEOUT: Result forwarder did not complete within timeout - continuing anyway")
-	}
-
-	// Wait for buffer monitor to complete
-	<-bufferMonitorDone
-
-	// Final stats
-	stats.Lock()
-	semaphoreDiff := stats.semaphoreAcquisitions - stats.semaphoreReleases
-	statsSnapshot := struct {
-		found, processed, skipped, errors, raw, tif        int
-		sent, forwarded, timeouts, bufferOverflows         int
-		semAcq, semRel, semDiff, semTimeouts, semAbandoned int
-	}{
-		stats.filesFound, stats.filesProcessed, stats.filesSkipped, stats.errorCount,
-		stats.rawCount, stats.tifCount,
-		stats.resultsSent, stats.resultsForwarded, stats.sendTimeouts, stats.bufferOverflows,
-		stats.semaphoreAcquisitions, stats.semaphoreReleases, semaphoreDiff,
-		stats.semaphoreTimeouts, stats.semaphoreAbandonments,
-	}
-	stats.Unlock()
-
-	logging.DebugLog("Walk completed with stats:\n"+
-		"  Files: Found=%d, Processed=%d, Skipped=%d, Errors=%d\n"+
-		"  Types: RAW=%d, TIF=%d\n"+
-		"  Results: Sent=%d, Forwarded=%d, Timeouts=%d, Dropped=%d\n"+
-		"  Semaphore: Acquired=%d, Released=%d, Diff=%d, Timeouts=%d, Abandonments=%d",
-		statsSnapshot.found, statsSnapshot.processed, statsSnapshot.skipped, statsSnapshot.errors,
-		statsSnapshot.raw, statsSnapshot.tif,
-		statsSnapshot.sent, statsSnapshot.forwarded, statsSnapshot.timeouts, statsSnapshot.bufferOverflows,
-		statsSnapshot.semAcq, statsSnapshot.semRel, statsSnapshot.semDiff,
-		statsSnapshot.semTimeouts, statsSnapshot.semAbandoned)
-
-	return err
-}
+	// Read EXIF metadata such as the GPS position, capture date, and camera
+	metadata := p.imgProcessor.ExtractMetadata(imageprocessor.SourceFile(localPath))
+	capturedAt := ""
+	if metadata.CapturedAt != nil {
+		capturedAt = metadata.CapturedAt.Format(time.RFC3339)
+	}
 
-// processAndStoreImage processes a single image and stores it in the database
-func processAndStoreImage(db *sql.DB, path string, sourcePrefix string, options ScanOptions, imgProcessor *processor.ImageProcessor) ProcessImageResult {
-	result := ProcessImageResult{
-		Path:    path,
-		Success: false,
+	// Ratings, labels and keywords come from an XMP sidecar next to the file
+	sidecar, _ := imageprocessor.ReadSidecar(localPath)
+
+	// Create the image info
+	image := &indexedImage{
+		info: types.ImageInfo{
+			Path:           path,
+			SourcePrefix:   sourcePrefix,
+			Format:         fileFormat,
+			Width:          conversion.Width,
+			Height:         conversion.Height,
+			ModifiedAt:     fileInfo.ModTime.Format(time.RFC3339),
+			CapturedAt:     capturedAt,
+			Size:           fileSize,
+			AverageHash:    conversion.AverageHash,
+			PerceptualHash: conversion.PerceptualHash,
+			IsRawFormat:    isRawImage,
+			Latitude:       metadata.Latitude,
+			Longitude:      metadata.Longitude,
+			CameraMake:     metadata.CameraMake,
+			CameraModel:    metadata.CameraModel,
+			ColorProfile:   metadata.ColorProfile,
+			BitDepth:       metadata.BitDepth,
+			Rating:         sidecar.Rating,
+			Label:          sidecar.Label,
+			Keywords:       sidecar.Keywords,
+			Checksum:       checksum,
+		},
+		loaderIdentity: loaderIdentity,
+		conversion:     conversion,
 	}
 
-	// Skip processing if the image already exists and hasn't been modified
-	if !options.ForceRewrite {
-		if skipResult := checkAndSkipIfUnchanged(db, path, sourcePrefix, options); skipResult != nil {
-			return *skipResult
+	// Thumbnails are a convenience; failing to create one doesn't fail the image
+	if options.Thumbnails {
+		image.thumbnail, err = imageprocessor.GenerateThumbnail(img, options.ThumbnailSize)
+		if err != nil {
+			logging.LogWarning("Failed to generate thumbnail for %s: %v", path, err)
+			image.thumbnail = nil
 		}
 	}
 
-	// Get file info and format
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		result.Error = fmt.Errorf("cannot stat file %s: %v", path, err)
-		return result
+	// Likewise, a failed face detection leaves the image without a face count
+	if options.FaceDetector != nil {
+		faces, err := options.FaceDetector.Detect(img)
+		if err != nil {
+			logging.LogWarning("Failed to detect faces in %s: %v", path, err)
+		} else {
+			image.faces, image.facesDetected = faces, true
+		}
+	}
+	if colors != nil {
+		dominant, err := colors.DominantColors()
+		if err != nil {
+			logging.LogWarning("Failed to find the colors of %s: %v", path, err)
+		} else {
+			image.colors, image.colorsFound = dominant, true
+		}
 	}
 
-	fileFormat := string(imageprocessor.GetFileFormat(path))
-	isRawImage := imageprocessor.IsRawFormat(path)
-	isTifImage := imageprocessor.IsTiffFormat(path)
+	result.Success = true
+	result.image = image
+	return result
+}
 
-	// Load and process the image
-	img, err := imgProcessor.ProcessImage(path, isRawImage, isTifImage)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to load image %s: %v", path, err)
+// storeImage writes an image hashed by processImage to the database. The
+// result fails if the image info can't be stored.
+func (p *pipeline) storeImage(ctx context.Context, result ProcessImageResult) ProcessImageResult {
+	db, options := p.db, p.options
+	image := result.image
+	info := image.info
+	result.image = nil
+
+	if image.movedFrom != "" {
+		moved, err := database.MoveImage(ctx, db, image.movedFrom, info.Path, info.SourcePrefix, info.ModifiedAt, info.Size)
+		if err == nil && !moved {
+			err = fmt.Errorf("cannot move %s to %s: it is no longer indexed", image.movedFrom, info.Path)
+		}
+		if err != nil {
+			result.Success = false
+			result.Error = err
+			return result
+		}
+		logging.LogInfo("Recognized %s as moved from %s", info.Path, image.movedFrom)
 		return result
 	}
-	defer img.Close()
 
-	// Skip empty images
-	if img.Empty() {
-		result.Error = fmt.Errorf("image is empty after loading: %s", path)
+	if image.tooSmall {
+		if err := database.StoreConversion(ctx, db, info.Checksum, image.loaderIdentity, image.conversion); err != nil {
+			logging.LogWarning("%v", err)
+		}
 		return result
 	}
 
-	// Compute hashes
-	imageHashes, err := imgProcessor.ComputeImageHashes(img, path, fileFormat, isRawImage, isTifImage)
-	if err != nil {
-		result.Error = err
+	if err := database.StoreImageInfo(ctx, db, info, options.ForceRewrite); err != nil {
+		result.Success = false
+		result.Error = fmt.Errorf("cannot store data for %s: %v", info.Path, err)
 		return result
 	}
 
-	// Create and store image info
-	imageInfo := types.ImageInfo{
-		Path:           path,
-		SourcePrefix:   sourcePrefix,
-		Format:         fileFormat,
-		Width:          img.Cols(),
-		Height:         img.Rows(),
-		ModifiedAt:     fileInfo.ModTime().Format(time.RFC3339),
-		Size:           fileInfo.Size(),
-		AverageHash:    imageHashes.AvgHash,
-		PerceptualHash: imageHashes.PHash,
-		IsRawFormat:    isRawImage,
+	// The extras of an image don't fail it either when they can't be stored
+	if image.loaderIdentity != "" {
+		if err := database.StoreConversion(ctx, db, info.Checksum, image.loaderIdentity, image.conversion); err != nil {
+			logging.LogWarning("%v", err)
+		}
 	}
-
-	// Store in database
-	err = database.StoreImageInfo(db, imageInfo, options.ForceRewrite)
-	if err != nil {
-		result.Error = fmt.Errorf("cannot store data for %s: %v", path, err)
-		return result
+	if image.thumbnail != nil {
+		if err := database.StoreThumbnail(ctx, db, info.Path, info.SourcePrefix, image.thumbnail); err != nil {
+			logging.LogWarning("%v", err)
+		}
+	}
+	if image.facesDetected {
+		if err := database.StoreFaces(ctx, db, info.Path, info.SourcePrefix, image.faces); err != nil {
+			logging.LogWarning("%v", err)
+		}
+	}
+	if image.colorsFound {
+		if err := database.StoreColors(ctx, db, info.Path, info.SourcePrefix, image.colors); err != nil {
+			logging.LogWarning("%v", err)
+		}
 	}
 
-	if options.DebugMode && (isRawImage || isTifImage) {
-		logging.DebugLog("Successfully indexed %s image: %s", fileFormat, path)
+	if options.SessionID != 0 {
+		if err := database.MarkSessionFileCompleted(ctx, db, options.SessionID, info.Path); err != nil {
+			logging.LogWarning("%v", err)
+		}
 	}
 
-	result.Success = true
+	if options.DebugMode && (result.IsRaw || result.IsTif) {
+		logging.DebugLog("Successfully indexed %s image: %s", info.Format, info.Path)
+	}
 	return result
 }
@@ -3,6 +3,11 @@ package scanner
 import (
 	"sync"
 	"time"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/source"
+	"imagefinder/types"
 )
 
 // ScanOptions defines the options for scanning
@@ -15,6 +20,94 @@ type ScanOptions struct {
 	LogPath      string
 	TotalImages  int // Optional pre-counted total
 	MaxWorkers   int // Optional worker limit
+
+	// AdaptiveWorkers starts with MaxWorkers and adds or removes workers
+	// during the scan, depending on whether they wait on I/O or the CPUs
+	// are saturated. It has no effect where CPU time can't be measured.
+	AdaptiveWorkers bool
+
+	// MaxMemory caps the estimated memory, in bytes, of the images decoded
+	// at the same time; workers wait for room before loading an image. 0 sets
+	// no limit.
+	MaxMemory int64
+	memory    *imageprocessor.MemoryBudget
+
+	// RawWorkers caps the RAW files converted at the same time, below
+	// MaxWorkers, while the other workers go on with cheaper files. 0 sets
+	// no separate limit.
+	RawWorkers  int
+	conversions *imageprocessor.ConversionLimit
+
+	// IORetries is how often a file of a local source that failed with an
+	// I/O error is read again, waiting longer each time. Once IOSkipAfter
+	// files in a row failed with I/O errors, the next files are skipped and
+	// recorded as failed, with one read now and then to check whether the
+	// folder came back. 0 never skips.
+	IORetries   int
+	IOSkipAfter int
+
+	Thumbnails    bool // Store a JPEG thumbnail for each image
+	ThumbnailSize int  // Longest thumbnail edge in pixels (0 uses the default)
+
+	PreviewCache *imageprocessor.PreviewCache // Optional cache for converted RAW previews
+	RawMode      types.RawMode                // Which image of RAW files is hashed (empty for auto)
+	FaceDetector *imageprocessor.FaceDetector // Optional; counts the faces in each image
+	Colors       bool                         // Store the dominant colors of each image
+
+	// Reconvert decodes every file again instead of reusing the hashes
+	// cached for the same content and loader
+	Reconvert bool
+
+	Archives      bool     // Index images inside ZIP and TAR archives
+	SVG           bool     // Index SVG files, rasterized at a fixed size
+	Include       []string // File name or relative path patterns to index; empty indexes all files
+	Exclude       []string // File name or relative path patterns to skip
+	IncludeHidden bool     // Also index files matching HiddenPatterns
+	MinSize       int64    // Smallest file size in bytes to index
+	MaxSize       int64    // Largest file size in bytes to index, 0 for no limit
+	MinDimension  int      // Smallest width and height in pixels to index, checked once decoded
+	ignores       *ignoreFiles
+
+	Source source.Source // Optional; opened from FolderPath when nil
+
+	// Paths, if set, are indexed instead of the files found by walking the
+	// source. They are paths as stored in the index, so documents and
+	// archives aren't expanded again, and the walk filters don't apply.
+	Paths []string
+
+	// Files, if set, are indexed instead of the files found by walking the
+	// source. Unlike Paths, they are filtered and expanded like walked files,
+	// but hidden files and ignore files don't leave them out, as the caller
+	// chose them.
+	Files []string
+
+	MaxDepth       int  // Folder levels scanned, counting the folder itself as 1; 0 scans every level
+	FollowSymlinks bool // Descend into symlinked folders of local sources
+
+	// Folders, if set, skips the files of local folders unchanged since the
+	// last incremental scan, and records the folder times once the scan
+	// completes
+	Folders *FolderTimes
+
+	Quiet bool // Suppress the progress line and scan summaries
+
+	// OnProgress is called with the running totals after each file is
+	// processed. Calls come from a single goroutine, one at a time.
+	OnProgress func(Progress)
+
+	// SessionID is the scan session recording completed files, or 0 to not
+	// track them. Files the session already completed are skipped, even when
+	// ForceRewrite is set.
+	SessionID int64
+	completed map[string]bool
+
+	failed map[string]bool // Paths with an error recorded by an earlier scan
+}
+
+// needsPixels reports whether the options use the decoded image itself, not
+// only its hashes
+func (options ScanOptions) needsPixels() bool {
+	return options.Thumbnails || options.FaceDetector != nil || options.Colors
 }
 
 // ProcessImageResult holds the result of processing an image
@@ -22,15 +115,36 @@ type ProcessImageResult struct {
 	Path    string
 	Success bool
 	Error   error
+	Loader  string // Loader the image was read with, if processing got that far
 	IsRaw   bool
 	IsTif   bool
+
+	image *indexedImage // What the writer stores for the file, if anything
+}
+
+// indexedImage is an image hashed by a worker, waiting to be stored
+type indexedImage struct {
+	info      types.ImageInfo
+	movedFrom string // Indexed path the file was moved from; only the entry is moved
+
+	thumbnail     []byte
+	faces         []types.Face
+	facesDetected bool // faces is stored, even if empty
+	colors        []types.DominantColor
+	colorsFound   bool
+
+	tooSmall       bool   // Below MinDimension; only the conversion is stored
+	loaderIdentity string // Loader that made conversion, or "" if it is already cached
+	conversion     database.Conversion
 }
 
-// FileStats tracks information about files to be processed
-type FileStats struct {
-	totalFiles int
-	rawFiles   int
-	tifFiles   int
+// Progress is the state of a scan after a file was processed
+type Progress struct {
+	Path      string
+	Error     error // Why Path couldn't be indexed, or nil
+	Processed int
+	Errors    int
+	Total     int // Files to process; grows while the files are still being counted
 }
 
 // ImageHashes contains computed hashes for an image
@@ -49,8 +163,12 @@ type ProgressTracker struct {
 	tifErrors    int
 	ticker       *time.Ticker
 	done         chan bool
+	drained      chan struct{} // Closed once every result has been counted
 	mu           sync.Mutex
 	totalFiles   int
 	rawFiles     int
 	tifFiles     int
+	counting     bool // The totals are still growing
+	quiet        bool // No progress line is displayed
+	onProgress   func(Progress)
 }
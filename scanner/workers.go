@@ -0,0 +1,225 @@
+package scanner
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"imagefinder/logging"
+)
+
+// workerLimit bounds the number of images processed at once. Unlike a
+// buffered channel, its limit can change while workers hold slots; lowering
+// it lets running workers finish and only holds back new ones.
+type workerLimit struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{} // Closed when a slot may have become free
+}
+
+func newWorkerLimit(limit int) *workerLimit {
+	return &workerLimit{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire takes a slot, waiting for one to become free unless ctx is done
+func (l *workerLimit) acquire(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release gives back a slot taken by acquire
+func (l *workerLimit) release() {
+	l.mu.Lock()
+	l.active--
+	l.notify()
+	l.mu.Unlock()
+}
+
+// setLimit changes the number of slots
+func (l *workerLimit) setLimit(limit int) {
+	l.mu.Lock()
+	l.limit = limit
+	l.notify()
+	l.mu.Unlock()
+}
+
+// current returns the number of slots
+func (l *workerLimit) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// busy returns the number of slots taken
+func (l *workerLimit) busy() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+// notify wakes the workers waiting for a slot. l.mu must be held.
+func (l *workerLimit) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Tuning of the adaptive worker count
+const (
+	tuneInterval   = 5 * time.Second
+	tuneCPUBusy    = 0.9 // Share of all CPUs in use above which workers are only added back
+	tuneIOBound    = 0.5 // CPU time per second of file processing below which workers mostly wait on I/O
+	tuneMinGain    = 0.95
+	tuneBackoff    = 6 // Intervals to wait after adding workers made the scan slower
+	maxWorkerRatio = 4 // Most workers per CPU
+)
+
+// workerTuner adjusts a worker limit during a scan. Each interval it compares
+// the CPU time the process and its conversion tools used with the time
+// workers spent on files: workers that mostly wait for a slow disk or network
+// are added to until the CPUs are busy or throughput stops improving, and
+// workers beyond the CPUs are removed once the CPUs are saturated.
+type workerTuner struct {
+	workers *workerLimit
+	min     int
+	max     int
+
+	mu       sync.Mutex
+	files    int
+	fileTime time.Duration // Wall time workers spent on the files
+
+	start    time.Time
+	startCPU time.Duration
+	lastRate float64 // Files per second in the previous interval
+	added    bool    // Workers were added after the previous interval
+	previous int     // Limit before workers were last added
+	backoff  int
+	lowest   int
+	highest  int
+}
+
+// newWorkerTuner returns a tuner for workers, or nil if the CPU time of the
+// process can't be measured on this system
+func newWorkerTuner(workers *workerLimit) *workerTuner {
+	cpu, ok := processCPUTime()
+	if !ok {
+		return nil
+	}
+	initial := workers.current()
+	return &workerTuner{
+		workers:  workers,
+		min:      1,
+		max:      max(initial, maxWorkerRatio*runtime.NumCPU()),
+		start:    time.Now(),
+		startCPU: cpu,
+		lowest:   initial,
+		highest:  initial,
+	}
+}
+
+// fileDone records the time a worker spent on a file
+func (t *workerTuner) fileDone(elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.files++
+	t.fileTime += elapsed
+	t.mu.Unlock()
+}
+
+// run adjusts the limit every interval until ctx is done
+func (t *workerTuner) run(ctx context.Context) {
+	ticker := time.NewTicker(tuneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.adjust()
+		}
+	}
+}
+
+// adjust changes the limit based on the files completed since the last change
+func (t *workerTuner) adjust() {
+	cpu, _ := processCPUTime()
+	now := time.Now()
+	limit := t.workers.current()
+
+	t.mu.Lock()
+	files, fileTime := t.files, t.fileTime
+	// Wait until each worker finished a file, so slow files don't skew the
+	// measurement
+	if files < limit || fileTime <= 0 {
+		t.mu.Unlock()
+		return
+	}
+	t.files, t.fileTime = 0, 0
+	t.mu.Unlock()
+
+	elapsed := now.Sub(t.start)
+	cpuTime := cpu - t.startCPU
+	t.start, t.startCPU = now, cpu
+
+	rate := float64(files) / elapsed.Seconds()
+	cpuLoad := cpuTime.Seconds() / (elapsed.Seconds() * float64(runtime.NumCPU()))
+	cpuPerFileSecond := cpuTime.Seconds() / fileTime.Seconds()
+
+	newLimit := limit
+	switch {
+	case t.added && rate < t.lastRate*tuneMinGain:
+		// More workers made things slower, e.g. a hard disk seeking between
+		// files, so go back and stay there for a while
+		newLimit = t.previous
+		t.backoff = tuneBackoff
+	case t.backoff > 0:
+		t.backoff--
+	case cpuLoad > tuneCPUBusy:
+		if limit > runtime.NumCPU() {
+			newLimit = limit - 1
+		}
+	case cpuPerFileSecond < tuneIOBound:
+		newLimit = limit + max(1, limit/4)
+	}
+	newLimit = min(max(newLimit, t.min), t.max)
+
+	t.added = newLimit > limit
+	if t.added {
+		t.previous = limit
+	}
+	t.lastRate = rate
+	if newLimit == limit {
+		return
+	}
+
+	t.workers.setLimit(newLimit)
+	t.lowest, t.highest = min(t.lowest, newLimit), max(t.highest, newLimit)
+	logging.DebugLog("Workers: %d -> %d (%.1f files/s, CPU load %.0f%%, %.2f CPU seconds per file second)",
+		limit, newLimit, rate, 100*cpuLoad, cpuPerFileSecond)
+}
+
+// summary describes the range the tuner moved the limit in
+func (t *workerTuner) summary() (lowest int, highest int, final int) {
+	return t.lowest, t.highest, t.workers.current()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"imagefinder/api"
+	"imagefinder/config"
+	"imagefinder/database"
+	"imagefinder/signalhandler"
+	"imagefinder/web"
+
+	"google.golang.org/grpc"
+)
+
+// handleServeCommand serves the gRPC API on the index, and the web UI when
+// --http is given, until interrupted.
+// Scans still running are stopped and left to be resumed.
+func handleServeCommand(ctx context.Context, flags *serveFlags, settings *config.Settings) {
+	dbPath := settings.Database
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+
+	listener, err := net.Listen("tcp", flags.listen)
+	if err != nil {
+		fatalf("Cannot listen on %s: %v", flags.listen, err)
+	}
+
+	// Work on one image per usable CPU unless configured
+	workers := settings.Workers
+	if workers == 0 {
+		workers = signalhandler.GetOptimalProcs()
+	}
+
+	previewCache := openPreviewCache(flags.cacheDir)
+	server := api.NewServer(ctx, db, api.Options{
+		DbPath:       dbPath,
+		Threshold:    settings.Threshold,
+		Workers:      workers,
+		MaxMemory:    settings.MaxMemory,
+		RawWorkers:   settings.RawWorkers,
+		IORetries:    settings.IORetries,
+		IOSkipAfter:  settings.IOSkipAfter,
+		PreviewCache: previewCache,
+		RawMode:      settings.RawMode,
+	})
+	grpcServer := grpc.NewServer()
+	api.RegisterImageFinderServer(grpcServer, server)
+
+	// The browser UI is only served when asked for
+	var httpServer *http.Server
+	if flags.http != "" {
+		httpListener, err := net.Listen("tcp", flags.http)
+		if err != nil {
+			fatalf("Cannot listen on %s: %v", flags.http, err)
+		}
+		httpServer = &http.Server{Handler: web.NewHandler(db, server, previewCache)}
+		go func() {
+			if err := httpServer.Serve(httpListener); err != nil && err != http.ErrServerClosed {
+				fatalf("Error serving the web UI: %v", err)
+			}
+		}()
+		statusf("Serving the web UI on http://%s/\n", httpListener.Addr())
+	}
+
+	// Interrupted scans send their final event before streams are closed
+	go func() {
+		<-ctx.Done()
+		server.Wait()
+		if httpServer != nil {
+			httpServer.Shutdown(context.Background())
+		}
+		grpcServer.GracefulStop()
+	}()
+
+	statusf("Serving the gRPC API for %s on %s\n", dbPath, listener.Addr())
+	if err := grpcServer.Serve(listener); err != nil {
+		fatalf("Error serving: %v", err)
+	}
+	statusf("Server stopped\n")
+}
@@ -1,29 +1,65 @@
 package signalhandler
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 )
 
-// SetupHandler configures signal handling for safer interaction with C libraries
-func SetupHandler() {
+// Functions run before the process exits through Exit or a second signal
+var (
+	exitMu    sync.Mutex
+	exitFuncs []func()
+)
+
+// OnExit registers fn to run when the process exits through Exit or is
+// killed by a second signal, such as removing temporary files
+func OnExit(fn func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitFuncs = append(exitFuncs, fn)
+}
+
+// Exit runs the functions registered with OnExit, then exits with code
+func Exit(code int) {
+	exitMu.Lock()
+	funcs := exitFuncs
+	exitFuncs = nil
+	exitMu.Unlock()
+	for _, fn := range funcs {
+		fn()
+	}
+	os.Exit(code)
+}
+
+// SetupHandler returns a context that is cancelled on SIGINT or SIGTERM, so
+// running commands can stop their workers and external tools, store the
+// results they already have and remove their temporary files instead of being
+// killed mid-write. A second signal exits immediately.
+func SetupHandler() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	// Create a channel to receive OS signals
-	sigChan := make(chan os.Signal, 1)
+	sigChan := make(chan os.Signal, 2)
 
 	// Register for specific signals
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Handle signals in a separate goroutine
 	go func() {
-		sig := <-sigChan
-		switch sig {
-		case syscall.SIGINT, syscall.SIGTERM:
-			// Clean shutdown
-			os.Exit(0)
-		}
+		<-sigChan
+		fmt.Fprintln(os.Stderr, "\nInterrupted, saving images in progress... (press Ctrl-C again to exit immediately)")
+		cancel()
+
+		<-sigChan
+		Exit(1)
 	}()
+
+	return ctx
 }
 
 // GetOptimalProcs returns the optimal number of worker goroutines for the system
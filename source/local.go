@@ -0,0 +1,161 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"imagefinder/logging"
+)
+
+// LocalSource scans a folder on the local filesystem
+type LocalSource struct {
+	root string
+}
+
+// OpenLocal opens a local folder as a scan source
+func OpenLocal(root string) (*LocalSource, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("folder path does not exist: %s", root)
+		}
+		return nil, fmt.Errorf("cannot access folder path: %s (%v)", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("path is not a directory: %s", root)
+	}
+	return &LocalSource{root: root}, nil
+}
+
+// Root returns the scanned folder
+func (s *LocalSource) Root() string {
+	return s.root
+}
+
+// Walk calls fn for every regular file below the folder, skipping paths that
+// can't be accessed
+func (s *LocalSource) Walk(options WalkOptions, fn func(info FileInfo) error) error {
+	if options.FollowSymlinks {
+		return s.walkFollowingLinks(options, fn)
+	}
+
+	skipped := make(map[string]bool)
+	return filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			logging.LogError("Error accessing path %s: %v", path, err)
+			return nil
+		}
+		if info == nil {
+			return nil
+		}
+		if info.IsDir() {
+			// Folders at the depth limit only hold files beyond it
+			if path != s.root && !options.withinDepth(s.relative(path)+"/") {
+				return filepath.SkipDir
+			}
+			if options.SkipFiles != nil && options.SkipFiles(filepath.Clean(path), info.ModTime()) {
+				skipped[filepath.Clean(path)] = true
+			}
+			return nil
+		}
+		if skipped[filepath.Dir(path)] {
+			return nil
+		}
+		return fn(FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// walkFollowingLinks walks the folder like Walk, but also descends into
+// symlinked folders and lists the targets of symlinked files. Folders are
+// tracked by their resolved path, so a folder reached through several links,
+// or through a link to one of its parents, is only walked once.
+func (s *LocalSource) walkFollowingLinks(options WalkOptions, fn func(info FileInfo) error) error {
+	visited := make(map[string]bool)
+
+	var walkFolder func(folder string) error
+	walkFolder = func(folder string) error {
+		realPath, err := filepath.EvalSymlinks(folder)
+		if err != nil {
+			logging.LogError("Error accessing path %s: %v", folder, err)
+			return nil
+		}
+		if visited[realPath] {
+			logging.DebugLog("Skipping %s: %s was already walked", folder, realPath)
+			return nil
+		}
+		visited[realPath] = true
+
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			logging.LogError("Error accessing path %s: %v", folder, err)
+			return nil
+		}
+
+		skipFiles := false
+		if options.SkipFiles != nil {
+			if info, err := os.Stat(folder); err == nil {
+				skipFiles = options.SkipFiles(filepath.Clean(folder), info.ModTime())
+			}
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(folder, entry.Name())
+
+			// Stat follows links, so a link reports its target
+			info, err := os.Stat(path)
+			if err != nil {
+				logging.LogError("Error accessing path %s: %v", path, err)
+				continue
+			}
+
+			if info.IsDir() {
+				if !options.withinDepth(s.relative(path) + "/") {
+					continue
+				}
+				if err := walkFolder(path); err != nil {
+					return err
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() || skipFiles {
+				continue
+			}
+
+			if err := fn(FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walkFolder(s.root)
+}
+
+// relative returns a path below the folder relative to it, with slashes
+func (s *LocalSource) relative(path string) string {
+	relative, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(relative)
+}
+
+// Stat returns the file's size and modification time
+func (s *LocalSource) Stat(path string) (FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Fetch returns the path unchanged since local files are read in place
+func (s *LocalSource) Fetch(path string) (string, func(), error) {
+	return path, func() {}, nil
+}
+
+// IsLocal reports true for local folders
+func (s *LocalSource) IsLocal() bool {
+	return true
+}
@@ -0,0 +1,38 @@
+package source
+
+import (
+	"fmt"
+	"time"
+
+	"imagefinder/logging"
+)
+
+// retryAttempts is how often a network operation is tried before giving up
+const retryAttempts = 4
+
+// retryBaseDelay is the wait after the first failed attempt; it doubles with
+// each further attempt
+const retryBaseDelay = 500 * time.Millisecond
+
+// withRetry runs fn until it succeeds, backing off exponentially between
+// attempts. Errors for which transient returns false are returned immediately.
+func withRetry(operation string, transient func(error) bool, fn func() error) error {
+	delay := retryBaseDelay
+	var err error
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !transient(err) {
+			return err
+		}
+		if attempt < retryAttempts {
+			logging.LogWarning("%s failed (attempt %d/%d): %v - retrying in %v",
+				operation, attempt, retryAttempts, err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("%s failed after %d attempts: %v", operation, retryAttempts, err)
+}
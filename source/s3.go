@@ -0,0 +1,192 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Source scans the objects below a prefix of an S3 bucket. Credentials,
+// region and endpoint come from the standard AWS configuration (environment
+// variables, ~/.aws/config and ~/.aws/credentials), so S3-compatible stores
+// work by setting AWS_ENDPOINT_URL.
+type S3Source struct {
+	root   string
+	bucket string
+	prefix string
+	client *s3.Client
+
+	// listed caches object info from Walk so Stat doesn't need a request per object
+	listed map[string]FileInfo
+	mu     sync.RWMutex
+}
+
+// OpenS3 opens an s3://bucket/prefix URL as a scan source
+func OpenS3(location string) (*S3Source, error) {
+	bucket, prefix, err := parseS3URL(location)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// Most self-hosted S3-compatible stores only support path-style requests
+		o.UsePathStyle = os.Getenv("AWS_S3_FORCE_PATH_STYLE") == "true"
+	})
+
+	return &S3Source{
+		root:   location,
+		bucket: bucket,
+		prefix: prefix,
+		client: client,
+		listed: make(map[string]FileInfo),
+	}, nil
+}
+
+// parseS3URL splits an s3://bucket/prefix URL into bucket and key prefix
+func parseS3URL(location string) (string, string, error) {
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 location '%s', expected s3://bucket/prefix", location)
+	}
+	return parsed.Host, strings.TrimPrefix(parsed.Path, "/"), nil
+}
+
+// objectURL returns the path stored in the index for an object key
+func (s *S3Source) objectURL(key string) string {
+	return "s3://" + s.bucket + "/" + key
+}
+
+// objectKey returns the object key for a path handed out by the source
+func (s *S3Source) objectKey(path string) (string, error) {
+	bucket, key, err := parseS3URL(path)
+	if err != nil {
+		return "", err
+	}
+	if bucket != s.bucket {
+		return "", fmt.Errorf("object %s is not in bucket %s", path, s.bucket)
+	}
+	return key, nil
+}
+
+// Root returns the s3:// URL the source was opened with
+func (s *S3Source) Root() string {
+	return s.root
+}
+
+// Walk lists every object below the prefix
+func (s *S3Source) Walk(options WalkOptions, fn func(info FileInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to list s3://%s/%s: %v", s.bucket, s.prefix, err)
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if strings.HasSuffix(key, "/") {
+				continue // Folder placeholder objects
+			}
+			if !options.withinDepth(strings.TrimPrefix(strings.TrimPrefix(key, s.prefix), "/")) {
+				continue
+			}
+
+			info := FileInfo{
+				Path:    s.objectURL(key),
+				Size:    aws.ToInt64(object.Size),
+				ModTime: aws.ToTime(object.LastModified),
+			}
+
+			s.mu.Lock()
+			s.listed[info.Path] = info
+			s.mu.Unlock()
+
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stat returns an object's size and modification time
+func (s *S3Source) Stat(path string) (FileInfo, error) {
+	s.mu.RLock()
+	info, ok := s.listed[path]
+	s.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	key, err := s.objectKey(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	head, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	return FileInfo{
+		Path:    path,
+		Size:    aws.ToInt64(head.ContentLength),
+		ModTime: aws.ToTime(head.LastModified),
+	}, nil
+}
+
+// Fetch downloads an object to a temporary file
+func (s *S3Source) Fetch(path string) (string, func(), error) {
+	key, err := s.objectKey(path)
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	object, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", func() {}, fmt.Errorf("failed to download %s: %v", path, err)
+	}
+	defer object.Body.Close()
+
+	tempFile, err := downloadTempFile(key)
+	if err != nil {
+		return "", func() {}, fmt.Errorf("failed to create temp file for %s: %v", path, err)
+	}
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, object.Body); err != nil {
+		os.Remove(tempFile.Name())
+		return "", func() {}, fmt.Errorf("failed to download %s: %v", path, err)
+	}
+
+	tempPath := tempFile.Name()
+	return tempPath, func() { os.Remove(tempPath) }, nil
+}
+
+// IsLocal reports false since objects must be downloaded before loading
+func (s *S3Source) IsLocal() bool {
+	return false
+}
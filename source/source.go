@@ -0,0 +1,94 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"imagefinder/utils"
+)
+
+// FileInfo describes a file listed by a source
+type FileInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Source is a location images are scanned from, such as a local folder or an
+// object storage bucket. Paths handed out by a source are the paths stored in
+// the index.
+type Source interface {
+	// Root returns the location the source was opened with
+	Root() string
+
+	// Walk calls fn for every file below the root
+	Walk(options WalkOptions, fn func(info FileInfo) error) error
+
+	// Stat returns the size and modification time of a file
+	Stat(path string) (FileInfo, error)
+
+	// Fetch returns a local path the image loaders can read. Remote files are
+	// downloaded to a temporary file that cleanup removes.
+	Fetch(path string) (string, func(), error)
+
+	// IsLocal reports whether paths refer to the local filesystem
+	IsLocal() bool
+}
+
+// WalkOptions limit the files a source walks
+type WalkOptions struct {
+	// MaxDepth is the number of folder levels walked, counting the root as
+	// level 1, so 1 lists only the files in the root itself. 0 walks every level.
+	MaxDepth int
+
+	// FollowSymlinks descends into symlinked folders of local sources. Each
+	// real folder is walked once, so links pointing back up the tree don't loop.
+	FollowSymlinks bool
+
+	// SkipFiles, if set, is called for each folder of a local source and
+	// reports whether the files directly in it are left out. Its subfolders
+	// are walked either way.
+	SkipFiles func(folder string, modTime time.Time) bool
+}
+
+// withinDepth reports whether a file at a slash-separated path relative to the
+// root is within the depth limit
+func (o WalkOptions) withinDepth(relative string) bool {
+	return o.MaxDepth <= 0 || strings.Count(relative, "/") < o.MaxDepth
+}
+
+// IsRemote reports whether a scan location is a URL rather than a local path
+func IsRemote(location string) bool {
+	return strings.Contains(location, "://")
+}
+
+// Open returns the source for a scan location. Plain paths open a local
+// folder, s3:// URLs open an S3 bucket prefix and webdav:// or webdavs://
+// URLs open a folder on a WebDAV server.
+func Open(location string) (Source, error) {
+	if !IsRemote(location) {
+		return OpenLocal(location)
+	}
+
+	scheme := strings.ToLower(location[:strings.Index(location, "://")])
+	switch scheme {
+	case "s3":
+		return OpenS3(location)
+	case "webdav", "webdavs":
+		return OpenWebDAV(location)
+	case "smb":
+		return nil, fmt.Errorf("SMB shares can't be scanned directly; mount the share or enable its WebDAV service")
+	default:
+		return nil, fmt.Errorf("unsupported scan source scheme: %s", scheme)
+	}
+}
+
+// downloadTempFile creates an empty temporary file that keeps the extension
+// of the remote path, so format detection works on the downloaded copy
+func downloadTempFile(remotePath string) (*os.File, error) {
+	ext := strings.ToLower(path.Ext(remotePath))
+	return utils.CreateTemp("remote_image_*" + ext)
+}
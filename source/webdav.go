@@ -0,0 +1,237 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"imagefinder/logging"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebDAVSource scans a folder on a WebDAV server, such as a NAS share, without
+// mounting it. webdav:// locations use HTTP and webdavs:// use HTTPS.
+// Credentials are taken from the URL or from the IMAGEFINDER_WEBDAV_USER and
+// IMAGEFINDER_WEBDAV_PASSWORD environment variables.
+type WebDAVSource struct {
+	root   string
+	scheme string
+	host   string
+	folder string
+	client *gowebdav.Client
+
+	// listed caches file info from Walk so Stat doesn't need a request per file
+	listed map[string]FileInfo
+	mu     sync.RWMutex
+}
+
+// OpenWebDAV opens a webdav:// or webdavs:// URL as a scan source
+func OpenWebDAV(location string) (*WebDAVSource, error) {
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "webdav" && parsed.Scheme != "webdavs") {
+		return nil, fmt.Errorf("invalid WebDAV location '%s', expected webdav://host/path or webdavs://host/path", location)
+	}
+
+	httpScheme := "http"
+	if parsed.Scheme == "webdavs" {
+		httpScheme = "https"
+	}
+
+	user := os.Getenv("IMAGEFINDER_WEBDAV_USER")
+	password := os.Getenv("IMAGEFINDER_WEBDAV_PASSWORD")
+	if parsed.User != nil {
+		user = parsed.User.Username()
+		if urlPassword, ok := parsed.User.Password(); ok {
+			password = urlPassword
+		}
+	}
+
+	folder := parsed.Path
+	if folder == "" {
+		folder = "/"
+	}
+
+	client := gowebdav.NewClient(httpScheme+"://"+parsed.Host, user, password)
+	s := &WebDAVSource{
+		scheme: parsed.Scheme,
+		host:   parsed.Host,
+		folder: folder,
+		client: client,
+		listed: make(map[string]FileInfo),
+	}
+	// Never store credentials in the index
+	s.root = s.fileURL(folder)
+
+	var info os.FileInfo
+	err = withRetry("WebDAV stat "+s.root, isTransientWebDAVError, func() error {
+		var statErr error
+		info, statErr = client.Stat(folder)
+		return statErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot access WebDAV folder %s: %v", s.root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("path is not a directory: %s", s.root)
+	}
+
+	return s, nil
+}
+
+// isTransientWebDAVError reports errors worth retrying; missing files and
+// rejected credentials won't go away by asking again
+func isTransientWebDAVError(err error) bool {
+	for _, code := range []int{http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden} {
+		if gowebdav.IsErrCode(err, code) {
+			return false
+		}
+	}
+	return true
+}
+
+// fileURL returns the path stored in the index for a server path
+func (s *WebDAVSource) fileURL(serverPath string) string {
+	return s.scheme + "://" + s.host + serverPath
+}
+
+// serverPath returns the server path for a path handed out by the source
+func (s *WebDAVSource) serverPath(location string) (string, error) {
+	prefix := s.scheme + "://" + s.host
+	if !strings.HasPrefix(location, prefix+"/") {
+		return "", fmt.Errorf("%s is not on WebDAV server %s", location, s.host)
+	}
+	return strings.TrimPrefix(location, prefix), nil
+}
+
+// Root returns the WebDAV URL the source was opened with, without credentials
+func (s *WebDAVSource) Root() string {
+	return s.root
+}
+
+// Walk lists every file below the folder, skipping folders that can't be read
+func (s *WebDAVSource) Walk(options WalkOptions, fn func(info FileInfo) error) error {
+	return s.walkFolder(s.folder, options, fn, 1)
+}
+
+// walkFolder lists a folder at the given depth, the root being at depth 1
+func (s *WebDAVSource) walkFolder(folder string, options WalkOptions, fn func(info FileInfo) error, depth int) error {
+	isRoot := depth == 1
+	var entries []os.FileInfo
+	err := withRetry("WebDAV listing of "+s.fileURL(folder), isTransientWebDAVError, func() error {
+		var readErr error
+		entries, readErr = s.client.ReadDir(folder)
+		return readErr
+	})
+	if err != nil {
+		if isRoot {
+			return fmt.Errorf("failed to list %s: %v", s.fileURL(folder), err)
+		}
+		logging.LogError("Error accessing path %s: %v", s.fileURL(folder), err)
+		return nil
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(folder, entry.Name())
+		if entry.IsDir() {
+			if options.MaxDepth > 0 && depth >= options.MaxDepth {
+				continue // Its files are beyond the depth limit
+			}
+			if err := s.walkFolder(entryPath, options, fn, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		info := FileInfo{
+			Path:    s.fileURL(entryPath),
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(),
+		}
+
+		s.mu.Lock()
+		s.listed[info.Path] = info
+		s.mu.Unlock()
+
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns a file's size and modification time
+func (s *WebDAVSource) Stat(location string) (FileInfo, error) {
+	s.mu.RLock()
+	info, ok := s.listed[location]
+	s.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	serverPath, err := s.serverPath(location)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	var stat os.FileInfo
+	err = withRetry("WebDAV stat "+location, isTransientWebDAVError, func() error {
+		var statErr error
+		stat, statErr = s.client.Stat(serverPath)
+		return statErr
+	})
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to stat %s: %v", location, err)
+	}
+
+	return FileInfo{Path: location, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// Fetch downloads a file to a temporary file, restarting interrupted downloads
+func (s *WebDAVSource) Fetch(location string) (string, func(), error) {
+	serverPath, err := s.serverPath(location)
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	tempFile, err := downloadTempFile(serverPath)
+	if err != nil {
+		return "", func() {}, fmt.Errorf("failed to create temp file for %s: %v", location, err)
+	}
+	defer tempFile.Close()
+	tempPath := tempFile.Name()
+
+	err = withRetry("WebDAV download of "+location, isTransientWebDAVError, func() error {
+		if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := tempFile.Truncate(0); err != nil {
+			return err
+		}
+
+		stream, err := s.client.ReadStream(serverPath)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		_, err = io.Copy(tempFile, stream)
+		return err
+	})
+	if err != nil {
+		os.Remove(tempPath)
+		return "", func() {}, fmt.Errorf("failed to download %s: %v", location, err)
+	}
+
+	return tempPath, func() { os.Remove(tempPath) }, nil
+}
+
+// IsLocal reports false since files must be downloaded before loading
+func (s *WebDAVSource) IsLocal() bool {
+	return false
+}
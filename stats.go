@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/utils"
+)
+
+// handleStatsCommand prints what the index holds
+func handleStatsCommand(ctx context.Context, flags *statsFlags, dbPath string) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	stats, err := database.GetIndexStats(ctx, db, flags.prefix, imageprocessor.RawFormatNames())
+	if err != nil {
+		fatalf("Error reading index statistics: %v", err)
+	}
+
+	if flags.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			fatalf("Error writing statistics: %v", err)
+		}
+		return
+	}
+
+	fmt.Printf("Images: %d (%s)\n", stats.TotalImages, utils.FormatByteSize(stats.TotalBytes))
+	fmt.Printf("Unique average hashes: %d\n", stats.UniqueHashes)
+
+	printGroupStats("By format:", stats.Formats)
+	printGroupStats("By source prefix:", stats.Prefixes)
+
+	fmt.Println()
+	if stats.RawImages > 0 {
+		fmt.Printf("RAW+JPEG pairs: %d of %d RAW images have a JPEG (%.1f%%)\n",
+			stats.RawWithJPEG, stats.RawImages, 100*float64(stats.RawWithJPEG)/float64(stats.RawImages))
+	} else {
+		fmt.Println("RAW+JPEG pairs: no RAW images")
+	}
+	fmt.Printf("pHash collisions: %d hashes shared by %d images\n",
+		stats.PerceptualCollisions.Hashes, stats.PerceptualCollisions.Images)
+	fmt.Printf("aHash collisions: %d hashes shared by %d images\n",
+		stats.AverageCollisions.Hashes, stats.AverageCollisions.Images)
+
+	fmt.Printf("Failed files: %d", stats.ErrorCount)
+	if len(stats.ErrorsByLoader) > 0 {
+		loaders := make([]string, 0, len(stats.ErrorsByLoader))
+		for loader := range stats.ErrorsByLoader {
+			loaders = append(loaders, loader)
+		}
+		sort.Strings(loaders)
+		for i, loader := range loaders {
+			loaders[i] = fmt.Sprintf("%s %d", loader, stats.ErrorsByLoader[loader])
+		}
+		fmt.Printf(" (%s)", strings.Join(loaders, ", "))
+	}
+	fmt.Println()
+}
+
+// printGroupStats prints the counts of each format or prefix as a table
+func printGroupStats(title string, groups []database.GroupStats) {
+	if len(groups) == 0 {
+		return
+	}
+
+	width := len("(none)")
+	for _, group := range groups {
+		width = max(width, len(group.Name))
+	}
+
+	fmt.Printf("\n%s\n", title)
+	for _, group := range groups {
+		name := group.Name
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Printf("  %-*s %10d %12s\n", width, name, group.Images, utils.FormatByteSize(group.Bytes))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"imagefinder/imageprocessor"
+	"imagefinder/utils"
+)
+
+// stdinQuery is the --image value that reads the query image from stdin
+const stdinQuery = "-"
+
+// readStdinImage saves the image piped to stdin in the run's temp workspace,
+// named stdin with the extension of the format its bytes start with, and
+// returns its path
+func readStdinImage() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return "", fmt.Errorf("--image=- reads the query image from stdin, which is a terminal or device; pipe the image in")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the query image from stdin: %v", err)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("no query image on stdin")
+	}
+	format := imageprocessor.DetectFormat(data)
+	if format == imageprocessor.FormatUnknown {
+		return "", fmt.Errorf("the data on stdin is not an image of a supported format")
+	}
+
+	dir, err := utils.MkdirTemp("stdin-*")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "stdin"+imageprocessor.FormatToExtension(format))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("cannot save the query image from stdin: %v", err)
+	}
+	return path, nil
+}
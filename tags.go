@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"imagefinder/database"
+	"imagefinder/signalhandler"
+)
+
+// tagActions are the actions of the tag command
+var tagActions = []string{"add", "remove", "list"}
+
+// handleTagCommand adds, removes or lists the tags of indexed images
+func handleTagCommand(ctx context.Context, action string, flags *tagFlags, dbPath string) {
+	var tags []string
+	for _, value := range flags.tags {
+		tags = append(tags, strings.Split(value, ",")...)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error initializing database: %v", err)
+	}
+	defer db.Close()
+
+	switch action {
+	case "add":
+		path := indexedPath(ctx, db, flags)
+		if path == "" {
+			fatalf("%s is not indexed", flags.path)
+		}
+		if err := database.AddTags(ctx, db, path, flags.prefix, tags); err != nil {
+			fatalf("Error adding tags: %v", err)
+		}
+		statusf("Tagged %s\n", path)
+	case "remove":
+		// Tags of images no longer indexed can still be removed by their path
+		path := indexedPath(ctx, db, flags)
+		if path == "" {
+			path = flags.path
+		}
+		removed, err := database.RemoveTags(ctx, db, path, flags.prefix, tags)
+		if err != nil {
+			fatalf("Error removing tags: %v", err)
+		}
+		statusf("Removed %d tags from %s\n", removed, path)
+	case "list":
+		switch {
+		case flags.path != "":
+			path := indexedPath(ctx, db, flags)
+			if path == "" {
+				path = flags.path
+			}
+			imageTags, err := database.ImageTags(ctx, db, path, flags.prefix)
+			if err != nil {
+				fatalf("Error listing tags: %v", err)
+			}
+			for _, tag := range imageTags {
+				fmt.Println(tag)
+			}
+		case len(tags) > 0:
+			for _, tag := range tags {
+				paths, err := database.TaggedImages(ctx, db, strings.TrimSpace(tag), flags.prefix)
+				if err != nil {
+					fatalf("Error listing tagged images: %v", err)
+				}
+				for _, path := range paths {
+					fmt.Println(path)
+				}
+			}
+		default:
+			counts, err := database.CountTags(ctx, db, flags.prefix)
+			if err != nil {
+				fatalf("Error listing tags: %v", err)
+			}
+			for _, count := range counts {
+				fmt.Printf("%s (%d)\n", count.Tag, count.Images)
+			}
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown tag action: %s (expected add, remove or list)\n", action)
+		signalhandler.Exit(2)
+	}
+}
+
+// indexedPath returns the path under which the image given with --path is
+// indexed, as written or made absolute, or an empty string if it is not
+func indexedPath(ctx context.Context, db *sql.DB, flags *tagFlags) string {
+	paths := []string{flags.path}
+	if absPath, err := filepath.Abs(flags.path); err == nil && absPath != flags.path {
+		paths = append(paths, absPath)
+	}
+	path, err := database.IndexedPath(ctx, db, flags.prefix, paths...)
+	if err != nil {
+		fatalf("Error looking up %s: %v", flags.path, err)
+	}
+	return path
+}
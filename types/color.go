@@ -0,0 +1,99 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Color is an sRGB color stored as 0xRRGGBB. It is written as #rrggbb for
+// display and in exports.
+type Color uint32
+
+// DominantColor is one of the main colors of an image
+type DominantColor struct {
+	Color    Color   `json:"color"`
+	Fraction float64 `json:"fraction"` // Share of the image's pixels, from 0 to 1
+}
+
+// ParseColor reads a color written as #rrggbb, rrggbb or #rgb
+func ParseColor(text string) (Color, error) {
+	digits := strings.TrimPrefix(strings.TrimSpace(text), "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+	if len(digits) != 6 {
+		return 0, fmt.Errorf("invalid color '%s': expected #rrggbb", text)
+	}
+	value, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid color '%s': %v", text, err)
+	}
+	return Color(value), nil
+}
+
+// RGBColor returns the color with the given channels
+func RGBColor(r, g, b uint8) Color {
+	return Color(uint32(r)<<16 | uint32(g)<<8 | uint32(b))
+}
+
+// RGB returns the red, green and blue channels
+func (c Color) RGB() (r, g, b uint8) {
+	return uint8(c >> 16), uint8(c >> 8), uint8(c)
+}
+
+// String returns the color as #rrggbb
+func (c Color) String() string {
+	return fmt.Sprintf("#%06x", uint32(c)&0xffffff)
+}
+
+// Lab returns the color in CIE L*a*b* (D65 white), where distances are
+// roughly proportional to perceived differences
+func (c Color) Lab() (l, a, b float64) {
+	red, green, blue := c.RGB()
+	linear := func(channel uint8) float64 {
+		v := float64(channel) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	r, g, bl := linear(red), linear(green), linear(blue)
+
+	x := (0.4124*r + 0.3576*g + 0.1805*bl) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*bl
+	z := (0.0193*r + 0.1192*g + 0.9505*bl) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// Distance returns the CIE76 color difference (ΔE) between two colors. A
+// difference of about 2 is just noticeable; black and white are 100 apart.
+func (c Color) Distance(other Color) float64 {
+	l1, a1, b1 := c.Lab()
+	l2, a2, b2 := other.Lab()
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+}
+
+// MarshalText writes the color as #rrggbb so JSON exports stay readable
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText reads a color written by MarshalText
+func (c *Color) UnmarshalText(text []byte) error {
+	value, err := ParseColor(string(text))
+	if err != nil {
+		return err
+	}
+	*c = value
+	return nil
+}
@@ -0,0 +1,57 @@
+package types
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+)
+
+// HashBits is the number of bits in an image hash
+const HashBits = 64
+
+// Hash is a 64-bit image hash (average or perceptual). The first pixel
+// compared is the most significant bit. It is written as 16 hex digits for
+// display and in exports.
+type Hash uint64
+
+// ParseHash reads a hash written as 16 hex digits
+func ParseHash(text string) (Hash, error) {
+	if len(text) != HashBits/4 {
+		return 0, fmt.Errorf("invalid hash '%s': expected %d hex digits", text, HashBits/4)
+	}
+	value, err := strconv.ParseUint(text, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash '%s': %v", text, err)
+	}
+	return Hash(value), nil
+}
+
+// String returns the hash as 16 hex digits
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Distance returns the Hamming distance between two hashes
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// Similarity returns the fraction of bits two hashes share, from 0 to 1
+func (h Hash) Similarity(other Hash) float64 {
+	return 1 - float64(h.Distance(other))/HashBits
+}
+
+// MarshalText writes the hash as hex so JSON exports stay readable
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText reads a hash written by MarshalText
+func (h *Hash) UnmarshalText(text []byte) error {
+	value, err := ParseHash(string(text))
+	if err != nil {
+		return err
+	}
+	*h = value
+	return nil
+}
@@ -0,0 +1,39 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawMode chooses which image of a RAW file is decoded for hashing. Each mode
+// other than RawModeAuto only uses methods that produce that image, so the
+// hashes of a file don't depend on which tools happen to be installed.
+type RawMode string
+
+const (
+	// RawModeAuto uses the first conversion method that succeeds
+	RawModeAuto RawMode = "auto"
+	// RawModePreview uses the camera's standard embedded preview (fast)
+	RawModePreview RawMode = "preview"
+	// RawModeEmbeddedLarge uses the largest embedded JPEG, usually full size
+	RawModeEmbeddedLarge RawMode = "embedded-large"
+	// RawModeFullDecode demosaics the sensor data with dcraw or a RAW
+	// developer (slow, but independent of the camera's JPEG processing)
+	RawModeFullDecode RawMode = "full-decode"
+)
+
+// RawModes lists the accepted RAW modes
+var RawModes = []string{string(RawModeAuto), string(RawModePreview), string(RawModeEmbeddedLarge), string(RawModeFullDecode)}
+
+// ParseRawMode reads a RAW mode name, defaulting to RawModeAuto when empty
+func ParseRawMode(name string) (RawMode, error) {
+	if name == "" {
+		return RawModeAuto, nil
+	}
+	for _, mode := range RawModes {
+		if strings.EqualFold(name, mode) {
+			return RawMode(mode), nil
+		}
+	}
+	return "", fmt.Errorf("invalid RAW mode '%s', expected %s", name, strings.Join(RawModes, ", "))
+}
@@ -10,10 +10,40 @@ type ImageInfo struct {
 	Height         int    `json:"height"`
 	CreatedAt      string `json:"created_at"`
 	ModifiedAt     string `json:"modified_at"`
+	CapturedAt     string `json:"captured_at,omitempty"`
 	Size           int64  `json:"size"`
-	AverageHash    string `json:"average_hash"`
-	PerceptualHash string `json:"perceptual_hash"`
+	AverageHash    Hash   `json:"average_hash"`
+	PerceptualHash Hash   `json:"perceptual_hash"`
 	IsRawFormat    bool   `json:"is_raw_format"`
+	Checksum       string `json:"sha256,omitempty"` // SHA-256 of the file content, hex
+
+	// GPS position in decimal degrees, nil when the image is not geotagged
+	Latitude  *float64 `json:"gps_latitude,omitempty"`
+	Longitude *float64 `json:"gps_longitude,omitempty"`
+
+	// Camera body that took the photo, empty when unknown
+	CameraMake  string `json:"camera_make,omitempty"`
+	CameraModel string `json:"camera_model,omitempty"`
+
+	// Embedded ICC profile or EXIF color space, and bits per color channel;
+	// empty or 0 when unknown
+	ColorProfile string `json:"color_profile,omitempty"`
+	BitDepth     int    `json:"bit_depth,omitempty"`
+
+	// Read from an XMP sidecar; Rating is -1 for rejected images
+	Rating   int      `json:"rating,omitempty"`
+	Label    string   `json:"label,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+
+	// Added with the tag command; kept across rescans
+	Tags []string `json:"tags,omitempty"`
+
+	// Found by scan --faces; FaceCount is nil when faces were not looked for
+	FaceCount *int   `json:"face_count,omitempty"`
+	Faces     []Face `json:"faces,omitempty"`
+
+	// Found by scan --colors, most common first
+	Colors []DominantColor `json:"colors,omitempty"`
 }
 
 // ImageMatch holds the similarity scores
@@ -22,3 +52,11 @@ type ImageMatch struct {
 	SourcePrefix string
 	SSIMScore    float64
 }
+
+// Face is the bounding box of a detected face, in pixels of the indexed image
+type Face struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
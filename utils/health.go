@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// RuntimeHealth is a snapshot of the state of the process, for telling a
+// stalled run from a slow one
+type RuntimeHealth struct {
+	Goroutines   int
+	HeapInUse    uint64        // Bytes of heap spans in use
+	GCCycles     uint32        // Garbage collections completed
+	LastGCPause  time.Duration // Pause of the latest garbage collection
+	GCPauseTotal time.Duration // Pauses of all garbage collections
+	TempFiles    int           // Files in the run's temp workspace
+}
+
+// ReadRuntimeHealth takes a snapshot of the state of the process. Reading
+// the memory statistics stops the world briefly, so call it every few
+// seconds at most.
+func ReadRuntimeHealth() RuntimeHealth {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	health := RuntimeHealth{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapInUse:    memStats.HeapInuse,
+		GCCycles:     memStats.NumGC,
+		GCPauseTotal: time.Duration(memStats.PauseTotalNs),
+		TempFiles:    tempFileCount(),
+	}
+	if memStats.NumGC > 0 {
+		health.LastGCPause = time.Duration(memStats.PauseNs[(memStats.NumGC+255)%256])
+	}
+	return health
+}
+
+// String renders the snapshot as one line for the debug log
+func (h RuntimeHealth) String() string {
+	return fmt.Sprintf("goroutines=%d heap=%s gc=%d (last pause %v, total %v) temp files=%d",
+		h.Goroutines, FormatByteSize(int64(h.HeapInUse)), h.GCCycles, h.LastGCPause, h.GCPauseTotal, h.TempFiles)
+}
+
+// tempFileCount counts the files in the run's temp workspace without
+// creating it. Files that pile up there are conversions or downloads that
+// were never cleaned up, or that are stuck.
+func tempFileCount() int {
+	tempMu.Lock()
+	dir := tempDir
+	tempMu.Unlock()
+	if dir == "" {
+		return 0
+	}
+
+	count := 0
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
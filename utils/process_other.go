@@ -0,0 +1,16 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// processRunning reports whether a process with the ID pid exists, which
+// os.FindProcess checks by opening it on these systems
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
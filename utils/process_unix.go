@@ -0,0 +1,15 @@
+//go:build unix
+
+package utils
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning reports whether a process with the ID pid exists. A process
+// of another user that can't be signalled still counts as running.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
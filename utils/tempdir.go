@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tempDirPrefix starts the name of each run's workspace, followed by the
+// process ID, so leftovers of runs that were killed can be recognized
+const tempDirPrefix = "imagefinder-run-"
+
+// The temporary files of a run (converted RAW images, downloads, archive
+// entries) are created in one workspace folder, removed as a whole when the
+// run ends
+var (
+	tempMu   sync.Mutex
+	tempBase string // Folder the workspace is created in; "" for os.TempDir()
+	tempDir  string // The workspace, created on first use
+)
+
+// SetTempBase chooses the folder the run's workspace is created in. It must
+// be called before any temporary file is created.
+func SetTempBase(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create temp folder %s: %v", dir, err)
+	}
+	tempMu.Lock()
+	defer tempMu.Unlock()
+	tempBase = dir
+	return nil
+}
+
+// TempBase returns the folder run workspaces are created in
+func TempBase() string {
+	tempMu.Lock()
+	defer tempMu.Unlock()
+	if tempBase == "" {
+		return os.TempDir()
+	}
+	return tempBase
+}
+
+// TempDir returns the run's workspace, creating it on first use. If it
+// can't be created, temporary files go to the temp folder itself.
+func TempDir() string {
+	tempMu.Lock()
+	defer tempMu.Unlock()
+	base := tempBase
+	if base == "" {
+		base = os.TempDir()
+	}
+	if tempDir == "" {
+		dir, err := os.MkdirTemp(base, fmt.Sprintf("%s%d-", tempDirPrefix, os.Getpid()))
+		if err != nil {
+			return base
+		}
+		tempDir = dir
+	}
+	return tempDir
+}
+
+// CreateTemp creates a temporary file in the run's workspace, like
+// os.CreateTemp
+func CreateTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(TempDir(), pattern)
+}
+
+// MkdirTemp creates a temporary folder in the run's workspace, like
+// os.MkdirTemp
+func MkdirTemp(pattern string) (string, error) {
+	return os.MkdirTemp(TempDir(), pattern)
+}
+
+// RemoveTempDir deletes the run's workspace with all the files left in it.
+// Files created afterwards go to a new workspace.
+func RemoveTempDir() {
+	tempMu.Lock()
+	defer tempMu.Unlock()
+	if tempDir != "" {
+		os.RemoveAll(tempDir)
+		tempDir = ""
+	}
+}
+
+// CleanStaleTempDirs removes the workspaces in base left by runs that are no
+// longer running, returning how many were removed and the bytes they held
+func CleanStaleTempDirs(base string) (int, int64, error) {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	removed := 0
+	var size int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, tempDirPrefix) {
+			continue
+		}
+		pidText, _, _ := strings.Cut(strings.TrimPrefix(name, tempDirPrefix), "-")
+		pid, err := strconv.Atoi(pidText)
+		if err != nil || pid == os.Getpid() || processRunning(pid) {
+			continue
+		}
+
+		dir := filepath.Join(base, name)
+		dirSize := folderSize(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, size, err
+		}
+		removed++
+		size += dirSize
+	}
+	return removed, size, nil
+}
+
+// folderSize returns the bytes of the files in dir and its subfolders
+func folderSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
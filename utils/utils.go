@@ -1,106 +1,154 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// ParseArguments converts command-line arguments into a map of flags and values
-func ParseArguments() map[string]string {
-	args := make(map[string]string)
-
-	// First, identify the command (scan/search)
-	command := ""
-	commandIndex := -1
-	for i := 1; i < len(os.Args); i++ {
-		if os.Args[i] == "scan" || os.Args[i] == "search" {
-			command = os.Args[i]
-			commandIndex = i
-			break
-		}
+// GetDefaultDatabasePath returns the default path for the database file
+func GetDefaultDatabasePath() string {
+	// Get the executable path
+	exePath, err := os.Executable()
+	if err != nil {
+		// Fallback to current directory if executable path can't be determined
+		return "images.db"
+	}
+
+	// Get the directory containing the executable
+	exeDir := filepath.Dir(exePath)
+
+	// Return the default database path in the same directory
+	return filepath.Join(exeDir, "images.db")
+}
+
+// DefaultRadiusKm is the search radius used when --near is given without --radius
+const DefaultRadiusKm = 10.0
+
+// ParseLocation parses a "LAT,LON" pair in decimal degrees
+func ParseLocation(locationStr string) (float64, float64, error) {
+	parts := strings.Split(locationStr, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid location '%s', expected LAT,LON", locationStr)
 	}
 
-	if command != "" {
-		args["command"] = command
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude '%s'", parts[0])
 	}
 
-	// Process all arguments, skipping the command
-	for i := 1; i < len(os.Args); i++ {
-		if i == commandIndex {
-			continue
-		}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude '%s'", parts[1])
+	}
 
-		arg := os.Args[i]
+	return lat, lon, nil
+}
 
-		// Handle flags with equals sign (--key=value)
-		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
-			parts := strings.SplitN(arg, "=", 2)
-			flagName := strings.TrimPrefix(parts[0], "--")
-			args[flagName] = parts[1]
-			continue
+// ParseDateBound parses a YYYY-MM-DD or RFC3339 date for the date-range filters.
+// For an upper bound, a plain date covers the whole day, so the returned
+// exclusive limit is the start of the following day.
+func ParseDateBound(dateStr string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, dateStr); err == nil {
+		if upper {
+			// Make the explicit instant inclusive
+			return t.Add(time.Second), nil
 		}
+		return t, nil
+	}
 
-		// Handle flags without equals sign (--key value)
-		if strings.HasPrefix(arg, "--") {
-			flagName := strings.TrimPrefix(arg, "--")
-
-			// Check if this is a boolean flag (no value)
-			if i+1 >= len(os.Args) || strings.HasPrefix(os.Args[i+1], "--") {
-				args[flagName] = "true"
-			} else {
-				// The next argument is the value
-				args[flagName] = os.Args[i+1]
-				i++ // Skip the value in the next iteration
-			}
-		}
+	t, err := time.ParseInLocation("2006-01-02", dateStr, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD or RFC3339", dateStr)
+	}
+
+	if upper {
+		return t.AddDate(0, 0, 1), nil
 	}
+	return t, nil
+}
 
-	return args
+// byteUnits maps size suffixes to their multiples of a byte. Both KB and KiB
+// mean 1024 bytes, as memory sizes are usually meant.
+var byteUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
 }
 
-// GetDefaultDatabasePath returns the default path for the database file
-func GetDefaultDatabasePath() string {
-	// Get the executable path
-	exePath, err := os.Executable()
-	if err != nil {
-		// Fallback to current directory if executable path can't be determined
-		return "images.db"
+// ParseByteSize parses a size such as "512MB", "4G" or "1.5GiB" into bytes. A
+// number without a unit is a number of bytes.
+func ParseByteSize(sizeStr string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(sizeStr))
+	trimmed = strings.TrimSuffix(strings.TrimSuffix(trimmed, "B"), "I")
+
+	number := strings.TrimRight(trimmed, "KMGT")
+	unit := strings.TrimSpace(trimmed[len(number):])
+	multiple, ok := byteUnits[unit]
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if !ok || err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected a number of bytes or a size such as 512MB or 4GB", sizeStr)
 	}
+	return int64(value * float64(multiple)), nil
+}
 
-	// Get the directory containing the executable
-	exeDir := filepath.Dir(exePath)
+// FormatByteSize formats a number of bytes with the largest unit that keeps
+// it at 1 or more, e.g. "1.5 GB". Units are powers of 1024, as in
+// ParseByteSize.
+func FormatByteSize(size int64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	unit := ""
+	for _, unit = range units {
+		value /= 1024
+		if value < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
 
-	// Return the default database path in the same directory
-	return filepath.Join(exeDir, "images.db")
+// FileChecksum returns the SHA-256 of a file's content as hex digits
+func FileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	checksum, err := ReaderChecksum(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %v", path, err)
+	}
+	return checksum, nil
 }
 
-// PrintUsage outputs the command-line usage instructions
-func PrintUsage() {
-	fmt.Printf("Usage:\n")
-	fmt.Printf("  %s scan --folder=PATH [--database=PATH] [--prefix=NAME] [--force] [--debug] [--logfile=PATH]\n", os.Args[0])
-	fmt.Printf("  %s search --image=PATH [--database=PATH] [--threshold=VALUE] [--prefix=NAME] [--debug] [--logfile=PATH]\n", os.Args[0])
-	fmt.Printf("\nParameters:\n")
-	fmt.Printf("  --folder      : Path to folder containing images to scan\n")
-	fmt.Printf("  --image       : Path to query image for search\n")
-	fmt.Printf("  --database    : Path to database file (default: %s)\n", GetDefaultDatabasePath())
-	fmt.Printf("  --prefix      : Source prefix for scanning/filtering results\n")
-	fmt.Printf("  --force       : Force rewrite existing entries during scan\n")
-	fmt.Printf("  --threshold   : Similarity threshold for search (0.0-1.0, default: 0.8)\n")
-	fmt.Printf("  --debug       : Enable debug mode (logs detailed information)\n")
-	fmt.Printf("  --logfile     : Specify custom log file path (default: imagefinder.log)\n")
-	fmt.Printf("\nExamples:\n")
-	fmt.Printf("  %s scan --folder=/path/to/images --prefix=ExternalDrive1 --debug\n", os.Args[0])
-	fmt.Printf("  %s search --image=/path/to/query.jpg --threshold=0.85\n", os.Args[0])
+// ReaderChecksum returns the SHA-256 of everything read from r as hex digits
+func ReaderChecksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// ParseThreshold parses and validates the threshold value from string
-func ParseThreshold(thresholdStr string) (float64, error) {
-	parsedThreshold, err := strconv.ParseFloat(thresholdStr, 64)
-	if err != nil || parsedThreshold < 0 || parsedThreshold > 1 {
-		return 0.8, fmt.Errorf("Invalid threshold value '%s', using default (0.8)", thresholdStr)
+// Page returns the page of results that starts after the first offset
+// items and holds at most limit of them, or all the rest when limit is 0
+func Page[T any](items []T, offset, limit int) []T {
+	items = items[min(offset, len(items)):]
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
 	}
-	return parsedThreshold, nil
+	return items
 }
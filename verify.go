@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"imagefinder/database"
+	"imagefinder/signalhandler"
+	"imagefinder/types"
+	"imagefinder/utils"
+)
+
+// verifyTotals counts the outcomes of a checksum verification
+type verifyTotals struct {
+	verified, mismatched, missing, changed, unchecked int
+	bytes                                             int64
+}
+
+// handleVerifyCommand re-reads indexed files and compares them with the
+// checksums stored when they were scanned
+func handleVerifyCommand(ctx context.Context, flags *verifyFlags, dbPath string) {
+	if flags.sample <= 0 || flags.sample > 100 {
+		fatalf("Error: invalid --sample %v, expected a percentage above 0 and up to 100", flags.sample)
+	}
+	var rate int64
+	if flags.rate != "" {
+		parsed, err := utils.ParseByteSize(flags.rate)
+		if err != nil || parsed <= 0 {
+			fatalf("Error: invalid --rate '%s', expected a size per second such as 50MB", flags.rate)
+		}
+		rate = parsed
+	}
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fatalf("Database does not exist: %s. Run scan command first.", dbPath)
+	}
+
+	db, err := database.InitDatabase(dbPath)
+	if err != nil {
+		fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	// The list is read up front so the database isn't held open while files
+	// are read, which may take hours
+	var images []types.ImageInfo
+	var totals verifyTotals
+	err = database.ForEachImage(ctx, db, flags.prefix, func(info types.ImageInfo) error {
+		if info.Checksum == "" || !isLocalFile(info.Path) {
+			totals.unchecked++
+			return nil
+		}
+		if flags.sample >= 100 || rand.Float64()*100 < flags.sample {
+			images = append(images, info)
+		}
+		return nil
+	})
+	if err != nil {
+		exitIfInterrupted(ctx, err, db, "Verification interrupted")
+		fatalf("Error reading index: %v", err)
+	}
+	db.Close()
+
+	statusf("Verifying %d files...\n", len(images))
+	throttle := &throttle{rate: rate, start: time.Now()}
+	startTime := time.Now()
+	for _, image := range images {
+		if ctx.Err() != nil {
+			printVerifyTotals(totals, startTime)
+			fmt.Fprintln(os.Stderr, "Verification interrupted")
+			signalhandler.Exit(130)
+		}
+		verifyChecksum(ctx, image, throttle, &totals)
+	}
+	printVerifyTotals(totals, startTime)
+
+	// Corruption fails the command so scheduled checks can alert on it
+	if totals.mismatched > 0 {
+		signalhandler.Exit(1)
+	}
+}
+
+// verifyChecksum re-reads one file and reports it if its content no longer
+// matches the stored checksum. Files whose size or modification time changed
+// were edited rather than corrupted and are only counted.
+func verifyChecksum(ctx context.Context, image types.ImageInfo, throttle *throttle, totals *verifyTotals) {
+	info, err := os.Stat(image.Path)
+	if err != nil {
+		fmt.Printf("MISSING  %s: %v\n", image.Path, err)
+		totals.missing++
+		return
+	}
+	if info.Size() != image.Size || info.ModTime().Format(time.RFC3339) != image.ModifiedAt {
+		totals.changed++
+		return
+	}
+
+	file, err := os.Open(image.Path)
+	if err != nil {
+		fmt.Printf("MISSING  %s: %v\n", image.Path, err)
+		totals.missing++
+		return
+	}
+	defer file.Close()
+
+	checksum, err := utils.ReaderChecksum(&throttledReader{ctx: ctx, r: file, throttle: throttle})
+	if ctx.Err() != nil {
+		return
+	}
+	totals.bytes += info.Size()
+	if err != nil {
+		fmt.Printf("UNREADABLE %s: %v\n", image.Path, err)
+		totals.mismatched++
+		return
+	}
+
+	totals.verified++
+	if !strings.EqualFold(checksum, image.Checksum) {
+		fmt.Printf("MISMATCH %s: indexed %s, now %s\n", image.Path, image.Checksum, checksum)
+		totals.mismatched++
+	}
+}
+
+// printVerifyTotals prints the summary of a verification
+func printVerifyTotals(totals verifyTotals, startTime time.Time) {
+	statusf("\nVerified %d files (%.1f MB) in %v\n", totals.verified, float64(totals.bytes)/(1024*1024), time.Since(startTime).Round(time.Second))
+	statusf("- Mismatches: %d\n", totals.mismatched)
+	statusf("- Missing: %d\n", totals.missing)
+	statusf("- Changed since indexed (rescan to update): %d\n", totals.changed)
+	statusf("- Without checksum or not local: %d\n", totals.unchecked)
+}
+
+// throttle spreads reads over time so that they average at most rate bytes
+// per second; a zero rate reads at full speed
+type throttle struct {
+	rate  int64
+	start time.Time
+	total int64
+}
+
+// wait sleeps until n more bytes fit within the rate
+func (t *throttle) wait(ctx context.Context, n int) {
+	if t.rate <= 0 {
+		return
+	}
+	t.total += int64(n)
+	due := t.start.Add(time.Duration(float64(t.total) / float64(t.rate) * float64(time.Second)))
+	if delay := time.Until(due); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// throttledReader reads through a throttle and stops when ctx is cancelled
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	throttle *throttle
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(p)
+	t.throttle.wait(t.ctx, n)
+	return n, err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"imagefinder/imageprocessor"
+)
+
+// Build information, set by the Makefile with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes the binary and the tools it found, for version
+type buildInfo struct {
+	Version   string     `json:"version"`
+	Commit    string     `json:"commit,omitempty"`
+	BuildDate string     `json:"build_date,omitempty"`
+	Go        string     `json:"go"`
+	Platform  string     `json:"platform"`
+	GoCV      string     `json:"gocv"`
+	OpenCV    string     `json:"opencv"`
+	Tools     []toolInfo `json:"tools"`
+}
+
+// toolInfo is an external tool and where it was found
+type toolInfo struct {
+	Name    string `json:"name"`
+	Purpose string `json:"purpose"`
+	Path    string `json:"path,omitempty"` // Empty if not found in PATH
+}
+
+// currentBuildInfo collects the build information. Binaries built without
+// the Makefile fall back to the module version and commit go build embeds.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		Go:        runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		GoCV:      imageprocessor.GoCVVersion(),
+		OpenCV:    imageprocessor.OpenCVVersion(),
+	}
+
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "dev" && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+			info.Version = embedded.Main.Version
+		}
+		modified := false
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && commit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+
+	for _, tool := range imageprocessor.ExternalTools {
+		info.Tools = append(info.Tools, toolInfo{Name: tool.Name, Purpose: tool.Purpose, Path: tool.Path()})
+	}
+	return info
+}
+
+// handleVersionCommand prints the version, build and library details, and
+// which external tools are installed
+func handleVersionCommand(jsonOutput bool) {
+	info := currentBuildInfo()
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			fatalf("Error writing version: %v", err)
+		}
+		return
+	}
+
+	fmt.Printf("goimagefinder %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("  Commit:  %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("  Built:   %s\n", info.BuildDate)
+	}
+	fmt.Printf("  Go:      %s %s\n", info.Go, info.Platform)
+	fmt.Printf("  GoCV:    %s\n", info.GoCV)
+	fmt.Printf("  OpenCV:  %s\n", info.OpenCV)
+
+	fmt.Println("\nExternal tools:")
+	width := 0
+	for _, tool := range info.Tools {
+		width = max(width, len(tool.Name))
+	}
+	for _, tool := range info.Tools {
+		path := tool.Path
+		if path == "" {
+			path = "not found"
+		}
+		fmt.Printf("  %-*s %-40s %s\n", width, tool.Name, path, tool.Purpose)
+	}
+}
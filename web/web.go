@@ -0,0 +1,306 @@
+// Package web serves the browser UI of the serve command: search results and
+// duplicate groups shown as thumbnails, with side-by-side comparison.
+package web
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"imagefinder/api"
+	"imagefinder/database"
+	"imagefinder/imageprocessor"
+	"imagefinder/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:embed static
+var static embed.FS
+
+// maxUploadSize bounds query images uploaded from the browser, which may be
+// RAW files
+const maxUploadSize = 256 << 20
+
+// maxPreviewSize is the largest edge, in pixels, of the previews shown when
+// comparing two images
+const maxPreviewSize = 2048
+
+// handler serves the UI and its JSON endpoints
+type handler struct {
+	db           *sql.DB
+	search       *api.Server
+	previewCache *imageprocessor.PreviewCache
+
+	// decoding limits the thumbnails and previews decoded at once
+	decoding chan struct{}
+}
+
+// NewHandler returns the handler of the UI. Searches go through server, so
+// they behave like the Search method of the gRPC API.
+func NewHandler(db *sql.DB, server *api.Server, previewCache *imageprocessor.PreviewCache) http.Handler {
+	h := &handler{
+		db:           db,
+		search:       server,
+		previewCache: previewCache,
+		decoding:     make(chan struct{}, runtime.NumCPU()),
+	}
+
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // The embedded directory is always there
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(files))
+	mux.HandleFunc("POST /api/search", h.handleSearch)
+	mux.HandleFunc("GET /api/duplicates", h.handleDuplicates)
+	mux.HandleFunc("GET /api/thumbnail", h.handleThumbnail)
+	return mux
+}
+
+// searchMatch is a search result as sent to the browser
+type searchMatch struct {
+	Path         string  `json:"path"`
+	SourcePrefix string  `json:"source_prefix,omitempty"`
+	Score        float64 `json:"score"`
+	HashScore    float64 `json:"hash_score"`
+	Verified     bool    `json:"verified"`
+	Mirrored     bool    `json:"mirrored,omitempty"`
+}
+
+// handleSearch searches for the image at the path given in the form, or for
+// an uploaded image
+func (h *handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeError(w, http.StatusBadRequest, "cannot read form: "+err.Error())
+		return
+	}
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+
+	req := &api.SearchRequest{
+		Image:        r.FormValue("image"),
+		SourcePrefix: r.FormValue("prefix"),
+		Collection:   r.FormValue("collection"),
+		Mirror:       r.FormValue("mirror") == "true",
+	}
+	if value := r.FormValue("threshold"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid threshold: "+value)
+			return
+		}
+		req.Threshold = threshold
+	}
+	if value := r.FormValue("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit: "+value)
+			return
+		}
+		req.Limit = int32(limit)
+	}
+	if value := r.FormValue("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid offset: "+value)
+			return
+		}
+		req.Offset = int32(offset)
+	}
+
+	// An uploaded image is searched from a temporary copy, keeping its
+	// extension so the right loader is used
+	if file, header, err := r.FormFile("upload"); err == nil {
+		defer file.Close()
+		path, err := saveUpload(file, filepath.Ext(header.Filename))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer os.Remove(path)
+		req.Image = path
+	}
+
+	response, err := h.search.Search(r.Context(), req)
+	if err != nil {
+		writeError(w, httpStatus(err), status.Convert(err).Message())
+		return
+	}
+
+	matches := []searchMatch{}
+	for _, match := range response.GetMatches() {
+		matches = append(matches, searchMatch{
+			Path:         match.GetPath(),
+			SourcePrefix: match.GetSourcePrefix(),
+			Score:        match.GetScore(),
+			HashScore:    match.GetHashScore(),
+			Verified:     match.GetVerified(),
+			Mirrored:     match.GetMirrored(),
+		})
+	}
+	writeJSON(w, map[string]interface{}{"matches": matches, "total": response.GetTotal()})
+}
+
+// saveUpload writes an uploaded query image to a temporary file
+func saveUpload(file io.Reader, ext string) (string, error) {
+	temp, err := utils.CreateTemp("imagefinder-query-*" + ext)
+	if err != nil {
+		return "", err
+	}
+	defer temp.Close()
+
+	if _, err := io.Copy(temp, file); err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// handleDuplicates returns the duplicate groups, like dedupe --json
+func (h *handler) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	maxDistance := imageprocessor.DefaultMaxDistance
+	if value := r.FormValue("max_distance"); value != "" {
+		distance, err := strconv.Atoi(value)
+		if err != nil || distance < 0 || distance > 64 {
+			writeError(w, http.StatusBadRequest, "invalid max_distance, expected 0 to 64 bits")
+			return
+		}
+		maxDistance = distance
+	}
+
+	groups, err := imageprocessor.FindDuplicateGroups(r.Context(), h.db, imageprocessor.DuplicateOptions{
+		SourcePrefix: r.FormValue("prefix"),
+		MaxDistance:  maxDistance,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if groups == nil {
+		groups = []imageprocessor.DuplicateGroup{}
+	}
+	writeJSON(w, map[string]interface{}{"groups": groups})
+}
+
+// handleThumbnail returns a JPEG of an image. Without a size, the thumbnail
+// stored by the scan is returned, or generated and stored when the image has
+// none. With a size, a preview of that size is generated for comparison.
+func (h *handler) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	path := r.FormValue("path")
+	sourcePrefix := r.FormValue("prefix")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+
+	size := 0
+	if value := r.FormValue("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxPreviewSize {
+			writeError(w, http.StatusBadRequest, "invalid size, expected 1 to "+strconv.Itoa(maxPreviewSize)+" pixels")
+			return
+		}
+		size = parsed
+	}
+
+	// Only indexed images are served, so the UI can't read other files
+	indexed, err := database.IndexedPath(r.Context(), h.db, sourcePrefix, path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if indexed == "" {
+		writeError(w, http.StatusNotFound, path+" is not indexed")
+		return
+	}
+
+	if size == 0 {
+		data, err := database.GetThumbnail(r.Context(), h.db, path, sourcePrefix)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if data != nil {
+			writeJPEG(w, data)
+			return
+		}
+	}
+
+	data, err := h.generate(r.Context(), path, size)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "cannot read "+path+": "+err.Error())
+		return
+	}
+	if size == 0 {
+		if err := database.StoreThumbnail(r.Context(), h.db, path, sourcePrefix, data); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	writeJPEG(w, data)
+}
+
+// generate decodes an image into a JPEG whose longest edge is size pixels,
+// or the default thumbnail size when size is 0
+func (h *handler) generate(ctx context.Context, path string, size int) ([]byte, error) {
+	if _, err := os.Stat(imageprocessor.SourceFile(path)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case h.decoding <- struct{}{}:
+		defer func() { <-h.decoding }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return imageprocessor.ThumbnailFromFile(ctx, path, size, h.previewCache)
+}
+
+// httpStatus returns the HTTP status matching the gRPC status of err
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.Canceled, codes.DeadlineExceeded:
+		return http.StatusRequestTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Warning: cannot write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func writeJPEG(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(data)
+}
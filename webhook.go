@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"time"
+
+	"imagefinder/pkg/index"
+)
+
+// webhookTimeout bounds the time a scan waits for its webhook to answer
+const webhookTimeout = 10 * time.Second
+
+// scanReport is the JSON summary POSTed to the webhook when a scan ends
+type scanReport struct {
+	Event           string  `json:"event"` // scan.completed, scan.failed or scan.interrupted
+	Text            string  `json:"text"`  // One-line summary for chat webhooks
+	Host            string  `json:"host"`
+	Folder          string  `json:"folder"`
+	SourcePrefix    string  `json:"source_prefix,omitempty"`
+	Database        string  `json:"database"`
+	FilesTotal      int     `json:"files_total"`
+	FilesProcessed  int     `json:"files_processed"`
+	Errors          int     `json:"errors"`
+	StartedAt       string  `json:"started_at"`
+	FinishedAt      string  `json:"finished_at"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// scanWebhook reports the end of a scan to the webhook URL, if one is set
+type scanWebhook struct {
+	url      string
+	report   scanReport
+	start    time.Time
+	progress index.Progress // Latest progress of the scan
+}
+
+func newScanWebhook(url, folder, sourcePrefix, dbPath string) *scanWebhook {
+	host, _ := os.Hostname()
+	return &scanWebhook{
+		url:   url,
+		start: time.Now(),
+		report: scanReport{
+			Host:         host,
+			Folder:       folder,
+			SourcePrefix: sourcePrefix,
+			Database:     dbPath,
+		},
+	}
+}
+
+// onProgress records the totals of the scan for the report
+func (w *scanWebhook) onProgress(progress index.Progress) {
+	w.progress = progress
+}
+
+// send POSTs the report of a scan that ended with err, which is nil for a
+// completed scan. A webhook that can't be reached is logged but doesn't
+// change the outcome of the scan.
+func (w *scanWebhook) send(ctx context.Context, err error) {
+	if w.url == "" {
+		return
+	}
+
+	report := w.report
+	finished := time.Now()
+	if w.progress.Total > 0 {
+		report.FilesTotal = w.progress.Total
+	}
+	report.FilesProcessed = w.progress.Processed
+	report.Errors = w.progress.Errors
+	report.StartedAt = w.start.Format(time.RFC3339)
+	report.FinishedAt = finished.Format(time.RFC3339)
+	report.DurationSeconds = finished.Sub(w.start).Round(time.Millisecond).Seconds()
+
+	outcome := "completed"
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		outcome = "interrupted"
+	default:
+		outcome = "failed"
+		report.Error = err.Error()
+	}
+	report.Event = "scan." + outcome
+	report.Text = fmt.Sprintf("imagefinder scan of %s on %s %s: %d of %d files processed, %d errors, in %v",
+		report.Folder, report.Host, outcome, report.FilesProcessed, report.FilesTotal, report.Errors,
+		finished.Sub(w.start).Round(time.Second))
+	if report.Error != "" {
+		report.Text += " (" + report.Error + ")"
+	}
+
+	if err := postJSON(w.url, report); err != nil {
+		log.Printf("Warning: webhook notification failed: %v", err)
+	}
+}
+
+// postJSON POSTs value as JSON to url and checks that it was accepted
+func postJSON(url string, value interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// The scan may have been interrupted, so the request has its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "imagefinder")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Leave out the URL, which often holds a secret token
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}